@@ -0,0 +1,232 @@
+// Package api serves the forum as JSON under /api/v1 for scripts and
+// mobile clients. It shares the App, and so the stores, with the HTML
+// handlers.
+//
+// Successful responses wrap their payload as {"data": ...}; failures are
+// {"error": {"code": ..., "message": ...}} with a matching HTTP status.
+// Clients authenticate with the session cookie or with the token returned
+// by POST /api/v1/session sent as "Authorization: Bearer <token>".
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"forum-go/handler"
+	"forum-go/middleware"
+	"forum-go/model"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Prefix is where the API is mounted.
+const Prefix = "/api/v1/"
+
+const maxBodyBytes = 1 << 20
+
+// server answers the API requests from the stores of the App.
+type server struct {
+	*handler.App
+	mw *middleware.Middleware
+	// limiter, when set, limits the writes of each client across the API
+	limiter *middleware.RateLimiter
+}
+
+// Handler returns the router for every /api/v1 endpoint, serving app's
+// data. limiter may be nil to leave writes unlimited.
+func Handler(app *handler.App, limiter *middleware.RateLimiter) http.Handler {
+	s := &server{App: app, mw: middleware.New(app.Users, app.Sessions), limiter: limiter}
+	mux := http.NewServeMux()
+
+	mux.Handle("/api/v1/posts", methods{http.MethodGet: s.listPosts})
+	mux.Handle("/api/v1/posts/{id}", methods{http.MethodGet: s.getPost})
+	mux.Handle("/api/v1/posts/{id}/comments", methods{
+		http.MethodGet:  s.listComments,
+		http.MethodPost: s.limit(s.requireUser(s.createComment)),
+	})
+	mux.Handle("/api/v1/posts/{id}/vote", methods{http.MethodPost: s.limit(s.requireUser(s.votePost))})
+	mux.Handle("/api/v1/comments/{id}/vote", methods{http.MethodPost: s.limit(s.requireUser(s.voteComment))})
+	mux.Handle("/api/v1/categories", methods{http.MethodGet: s.listCategories})
+	mux.Handle("/api/v1/users/me", methods{http.MethodGet: s.requireUser(s.getMe)})
+	mux.Handle("/api/v1/users/{id}", methods{http.MethodGet: s.getUser})
+	mux.Handle("/api/v1/session", methods{
+		http.MethodPost:   s.limit(s.login),
+		http.MethodDelete: s.limit(s.requireUser(s.logout)),
+	})
+
+	mux.HandleFunc(Prefix, func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusNotFound, "no such endpoint")
+	})
+	return mux
+}
+
+// methods routes a path by HTTP method so that a wrong method still gets
+// a JSON error instead of the mux's plain-text one.
+type methods map[string]http.HandlerFunc
+
+func (m methods) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h, ok := m[r.Method]; ok {
+		h(w, r)
+		return
+	}
+	allowed := make([]string, 0, len(m))
+	for method := range m {
+		allowed = append(allowed, method)
+	}
+	sort.Strings(allowed)
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+}
+
+// limit answers 429 when the client has used up its writes.
+func (s *server) limit(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.limiter != nil {
+			if ok, wait := s.limiter.Allow(s.mw.RateKey(r)); !ok {
+				w.Header().Set("Retry-After", middleware.RetryAfter(wait))
+				writeError(w, http.StatusTooManyRequests, "too many requests, slow down")
+				return
+			}
+		}
+		next(w, r)
+	}
+}
+
+// userHandler is an endpoint that needs a signed-in, non-banned user.
+type userHandler func(w http.ResponseWriter, r *http.Request, user *model.User)
+
+func (s *server) requireUser(next userHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := s.currentUser(r)
+		if err != nil {
+			log.Printf("Error fetching API user: %v", err)
+			writeError(w, http.StatusInternalServerError, "internal error")
+			return
+		}
+		if user == nil {
+			writeError(w, http.StatusUnauthorized, "authentication required")
+			return
+		}
+		if user.Banned() {
+			writeError(w, http.StatusForbidden, "this account has been banned")
+			return
+		}
+		next(w, r, user)
+	}
+}
+
+// currentUser returns the signed-in user, or nil for guests.
+func (s *server) currentUser(r *http.Request) (*model.User, error) {
+	token := sessionToken(r)
+	if token == "" {
+		return nil, nil
+	}
+	ok, userID := s.Sessions.SessionUserID(token)
+	if !ok {
+		return nil, nil
+	}
+	return s.Users.FetchUserById(userID)
+}
+
+// sessionToken reads a bearer token, or the session cookie of requests
+// without one. Bearer requests are exempt from CSRF checks, so they must
+// never fall back to the cookie.
+func sessionToken(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimSpace(strings.TrimPrefix(auth, "Bearer "))
+	}
+	if cookie, err := r.Cookie("session_token"); err == nil {
+		return cookie.Value
+	}
+	return ""
+}
+
+type dataResponse struct {
+	Data interface{} `json:"data"`
+}
+
+type errorResponse struct {
+	Error errorBody `json:"error"`
+}
+
+type errorBody struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// errorCodes are the machine-readable codes sent with each status.
+var errorCodes = map[int]string{
+	http.StatusBadRequest:           "bad_request",
+	http.StatusUnauthorized:         "unauthorized",
+	http.StatusForbidden:            "forbidden",
+	http.StatusNotFound:             "not_found",
+	http.StatusMethodNotAllowed:     "method_not_allowed",
+	http.StatusConflict:             "conflict",
+	http.StatusUnsupportedMediaType: "unsupported_media_type",
+	http.StatusTooManyRequests:      "too_many_requests",
+	http.StatusInternalServerError:  "internal",
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Error encoding API response: %v", err)
+	}
+}
+
+func writeData(w http.ResponseWriter, status int, data interface{}) {
+	writeJSON(w, status, dataResponse{Data: data})
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	code, ok := errorCodes[status]
+	if !ok {
+		code = strings.ToLower(strings.ReplaceAll(http.StatusText(status), " ", "_"))
+	}
+	writeJSON(w, status, errorResponse{Error: errorBody{Code: code, Message: message}})
+}
+
+// writeInternal logs err and sends a generic 500, so database details do
+// not leak to clients.
+func writeInternal(w http.ResponseWriter, context string, err error) {
+	log.Printf("API error %s: %v", context, err)
+	writeError(w, http.StatusInternalServerError, "internal error")
+}
+
+// decodeJSON reads a JSON request body into v. It writes the error
+// response itself and reports whether decoding worked.
+func decodeJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if ct := r.Header.Get("Content-Type"); !strings.HasPrefix(ct, "application/json") {
+		writeError(w, http.StatusUnsupportedMediaType, "request body must be application/json")
+		return false
+	}
+	err := json.NewDecoder(io.LimitReader(r.Body, maxBodyBytes)).Decode(v)
+	if err != nil {
+		var syntaxErr *json.SyntaxError
+		var typeErr *json.UnmarshalTypeError
+		switch {
+		case errors.As(err, &syntaxErr), errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, io.EOF):
+			writeError(w, http.StatusBadRequest, "malformed JSON body")
+		case errors.As(err, &typeErr):
+			writeError(w, http.StatusBadRequest, "wrong type for field "+typeErr.Field)
+		default:
+			writeError(w, http.StatusBadRequest, err.Error())
+		}
+		return false
+	}
+	return true
+}
+
+// pathID parses the {id} wildcard of the route.
+func pathID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id <= 0 {
+		writeError(w, http.StatusBadRequest, "invalid id")
+		return 0, false
+	}
+	return id, true
+}
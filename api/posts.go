@@ -0,0 +1,251 @@
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"forum-go/auth"
+	"forum-go/database"
+	"forum-go/model"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const maxPageSize = 50
+
+// listPosts serves GET /api/v1/posts with the same filters, sort orders
+// and cursors as the index page.
+func (s *server) listPosts(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	opts := database.PostListOptions{
+		Category: q.Get("category"),
+		Sort:     q.Get("sort"),
+		After:    q.Get("after"),
+		Before:   q.Get("before"),
+	}
+	if opts.Sort != "" && !database.ValidSort(opts.Sort) {
+		writeError(w, http.StatusBadRequest, "unknown sort order")
+		return
+	}
+	if raw := q.Get("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit < 1 || limit > maxPageSize {
+			writeError(w, http.StatusBadRequest, "limit must be between 1 and "+strconv.Itoa(maxPageSize))
+			return
+		}
+		opts.Limit = limit
+	}
+
+	page, err := s.Posts.FetchPostPage(opts)
+	if err != nil {
+		if errors.Is(err, database.ErrInvalidCursor) {
+			writeError(w, http.StatusBadRequest, "invalid page cursor")
+			return
+		}
+		writeInternal(w, "listing posts", err)
+		return
+	}
+
+	out := postPageJSON{
+		Posts:      make([]postJSON, 0, len(page.Posts)),
+		NextCursor: page.NextCursor,
+		PrevCursor: page.PrevCursor,
+	}
+	for i := range page.Posts {
+		out.Posts = append(out.Posts, newPostJSON(&page.Posts[i]))
+	}
+	writeData(w, http.StatusOK, out)
+}
+
+// getPost serves GET /api/v1/posts/{id}.
+func (s *server) getPost(w http.ResponseWriter, r *http.Request) {
+	post, _, ok := s.visiblePost(w, r)
+	if !ok {
+		return
+	}
+
+	comments, err := s.Comments.FetchCommentsByPostID(post.ID)
+	if err != nil {
+		writeInternal(w, "counting comments", err)
+		return
+	}
+	for _, c := range comments {
+		if !c.Deleted && !c.Hidden {
+			post.CommentCount++
+		}
+	}
+
+	writeData(w, http.StatusOK, newPostJSON(post))
+}
+
+// listComments serves GET /api/v1/posts/{id}/comments as a reply tree.
+func (s *server) listComments(w http.ResponseWriter, r *http.Request) {
+	post, canModerate, ok := s.visiblePost(w, r)
+	if !ok {
+		return
+	}
+
+	tree, err := s.Comments.FetchCommentTree(post.ID, s.MaxCommentDepth)
+	if err != nil {
+		writeInternal(w, "fetching comments", err)
+		return
+	}
+
+	out := make([]commentJSON, 0, len(tree))
+	for i := range tree {
+		out = append(out, newCommentJSON(&tree[i], canModerate))
+	}
+	writeData(w, http.StatusOK, out)
+}
+
+// createComment serves POST /api/v1/posts/{id}/comments. parent_id makes
+// the comment a reply.
+func (s *server) createComment(w http.ResponseWriter, r *http.Request, user *model.User) {
+	postID, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+
+	var body struct {
+		Content  string `json:"content"`
+		ParentID int    `json:"parent_id"`
+	}
+	if !decodeJSON(w, r, &body) {
+		return
+	}
+	if strings.TrimSpace(body.Content) == "" {
+		writeError(w, http.StatusBadRequest, "content is required")
+		return
+	}
+
+	commentID, err := s.Comments.CreateComment(postID, user.ID, body.ParentID, body.Content)
+	if err != nil {
+		switch {
+		case errors.Is(err, database.ErrParentNotFound):
+			writeError(w, http.StatusBadRequest, err.Error())
+		case errors.Is(err, sql.ErrNoRows):
+			writeError(w, http.StatusNotFound, "post not found")
+		case errors.Is(err, database.ErrPostLocked):
+			writeError(w, http.StatusForbidden, err.Error())
+		default:
+			writeInternal(w, "creating comment", err)
+		}
+		return
+	}
+
+	s.PublishComment(postID, commentID, body.ParentID)
+	// A failed notification should not fail the request
+	if err := s.Notifications.NotifyNewComment(int(commentID)); err != nil {
+		log.Printf("Error sending comment notifications: %v", err)
+	}
+
+	comment, err := s.Comments.FetchCommentByID(int(commentID))
+	if err != nil {
+		writeInternal(w, "fetching new comment", err)
+		return
+	}
+	writeData(w, http.StatusCreated, newCommentJSON(comment, false))
+}
+
+// votePost serves POST /api/v1/posts/{id}/vote with {"vote": 1} or
+// {"vote": -1}. Sending the current vote again takes it back.
+func (s *server) votePost(w http.ResponseWriter, r *http.Request, user *model.User) {
+	castVote(w, r, user, s.Votes.TogglePostVote, func(id int, result *database.VoteResult) {
+		s.PublishVote(result, 0)
+	}, func(id, vote int) error {
+		return s.Notifications.NotifyVote(user.ID, id, 0, vote)
+	})
+}
+
+// voteComment serves POST /api/v1/comments/{id}/vote like votePost.
+func (s *server) voteComment(w http.ResponseWriter, r *http.Request, user *model.User) {
+	castVote(w, r, user, s.Votes.ToggleCommentVote, func(id int, result *database.VoteResult) {
+		s.PublishVote(result, id)
+	}, func(id, vote int) error {
+		return s.Notifications.NotifyVote(user.ID, 0, id, vote)
+	})
+}
+
+// listCategories serves GET /api/v1/categories.
+func (s *server) listCategories(w http.ResponseWriter, r *http.Request) {
+	categories, err := s.Posts.FetchCategories()
+	if err != nil {
+		writeInternal(w, "fetching categories", err)
+		return
+	}
+
+	out := make([]categoryJSON, 0, len(categories))
+	for _, c := range categories {
+		out = append(out, categoryJSON{ID: c.ID, Name: c.Name, Emoji: c.Emoji})
+	}
+	writeData(w, http.StatusOK, out)
+}
+
+// visiblePost loads the post named in the path. Hidden posts are only
+// visible to moderators, like on the HTML pages.
+func (s *server) visiblePost(w http.ResponseWriter, r *http.Request) (*model.Post, bool, bool) {
+	postID, ok := pathID(w, r)
+	if !ok {
+		return nil, false, false
+	}
+
+	post, err := s.Posts.FetchPostByID(postID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, http.StatusNotFound, "post not found")
+		} else {
+			writeInternal(w, "fetching post", err)
+		}
+		return nil, false, false
+	}
+
+	user, err := s.currentUser(r)
+	if err != nil {
+		writeInternal(w, "fetching user", err)
+		return nil, false, false
+	}
+	canModerate := user != nil && !user.Banned() && user.HasRole(model.RoleModerator) && !auth.MustEnrollTwoFactor(s.TwoFactorRole, user)
+	if post.Hidden && !canModerate {
+		writeError(w, http.StatusNotFound, "post not found")
+		return nil, false, false
+	}
+	return post, canModerate, true
+}
+
+func castVote(w http.ResponseWriter, r *http.Request, user *model.User,
+	toggle func(userID, id, vote int) (*database.VoteResult, error),
+	publish func(id int, result *database.VoteResult), notify func(id, vote int) error) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+
+	var body struct {
+		Vote int `json:"vote"`
+	}
+	if !decodeJSON(w, r, &body) {
+		return
+	}
+
+	result, err := toggle(user.ID, id, body.Vote)
+	if err != nil {
+		switch {
+		case errors.Is(err, database.ErrInvalidVote):
+			writeError(w, http.StatusBadRequest, err.Error())
+		case errors.Is(err, sql.ErrNoRows):
+			writeError(w, http.StatusNotFound, "not found")
+		default:
+			writeInternal(w, "saving vote", err)
+		}
+		return
+	}
+
+	publish(id, result)
+	if result.Vote != 0 {
+		if err := notify(id, result.Vote); err != nil {
+			log.Printf("Error sending vote notification: %v", err)
+		}
+	}
+	writeData(w, http.StatusOK, voteJSON{Vote: result.Vote, Upvotes: result.Upvotes, Downvotes: result.Downvotes})
+}
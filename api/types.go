@@ -0,0 +1,147 @@
+package api
+
+import (
+	"forum-go/model"
+	"time"
+)
+
+// The JSON shapes of the API. They are kept apart from the model structs
+// so template-only fields never end up in responses.
+
+type postJSON struct {
+	ID           int        `json:"id"`
+	Title        string     `json:"title"`
+	Content      string     `json:"content"`
+	Author       string     `json:"author"`
+	AuthorID     int        `json:"author_id"`
+	Categories   []string   `json:"categories"`
+	Upvotes      int        `json:"upvotes"`
+	Downvotes    int        `json:"downvotes"`
+	CommentCount int        `json:"comment_count"`
+	Locked       bool       `json:"locked"`
+	Hidden       bool       `json:"hidden,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+	EditedAt     *time.Time `json:"edited_at,omitempty"`
+}
+
+type postPageJSON struct {
+	Posts      []postJSON `json:"posts"`
+	NextCursor string     `json:"next_cursor,omitempty"`
+	PrevCursor string     `json:"prev_cursor,omitempty"`
+}
+
+type commentJSON struct {
+	ID        int           `json:"id"`
+	PostID    int           `json:"post_id"`
+	ParentID  int           `json:"parent_id,omitempty"`
+	Author    string        `json:"author"`
+	AuthorID  int           `json:"author_id,omitempty"`
+	Content   string        `json:"content"`
+	Upvotes   int           `json:"upvotes"`
+	Downvotes int           `json:"downvotes"`
+	Deleted   bool          `json:"deleted,omitempty"`
+	Hidden    bool          `json:"hidden,omitempty"`
+	CreatedAt time.Time     `json:"created_at"`
+	EditedAt  *time.Time    `json:"edited_at,omitempty"`
+	Replies   []commentJSON `json:"replies"`
+}
+
+type categoryJSON struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Emoji string `json:"emoji"`
+}
+
+type userJSON struct {
+	ID          int       `json:"id"`
+	Username    string    `json:"username"`
+	Email       string    `json:"email,omitempty"` // only for the user themselves
+	Role        string    `json:"role"`
+	Banned      bool      `json:"banned"`
+	UnreadCount *int      `json:"unread_notifications,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type voteJSON struct {
+	Vote      int `json:"vote"`
+	Upvotes   int `json:"upvotes"`
+	Downvotes int `json:"downvotes"`
+}
+
+type sessionJSON struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+	User      userJSON  `json:"user"`
+}
+
+func newPostJSON(p *model.Post) postJSON {
+	categories := make([]string, 0, len(p.Categories))
+	for _, c := range p.Categories {
+		categories = append(categories, c.Name)
+	}
+	return postJSON{
+		ID:           p.ID,
+		Title:        p.Title,
+		Content:      p.Content,
+		Author:       p.Author,
+		AuthorID:     p.UserID,
+		Categories:   categories,
+		Upvotes:      p.Upvotes,
+		Downvotes:    p.Downvotes,
+		CommentCount: p.CommentCount,
+		Locked:       p.Locked,
+		Hidden:       p.Hidden,
+		CreatedAt:    p.CreatedAt,
+		EditedAt:     optionalTime(p.EditedAt),
+	}
+}
+
+// newCommentJSON converts a comment tree. Deleted comments, and hidden ones
+// unless showHidden is set, keep their place without author or text.
+func newCommentJSON(c *model.Comment, showHidden bool) commentJSON {
+	out := commentJSON{
+		ID:        c.ID,
+		PostID:    c.PostID,
+		ParentID:  c.ParentID,
+		Author:    c.Author,
+		AuthorID:  c.UserID,
+		Content:   c.Content,
+		Upvotes:   c.Upvotes,
+		Downvotes: c.Downvotes,
+		Deleted:   c.Deleted,
+		Hidden:    c.Hidden,
+		CreatedAt: c.CreatedAt,
+		EditedAt:  optionalTime(c.EditedAt),
+		Replies:   make([]commentJSON, 0, len(c.Replies)),
+	}
+	if c.Deleted || (c.Hidden && !showHidden) {
+		out.Author, out.AuthorID, out.Content = "", 0, ""
+	}
+	for i := range c.Replies {
+		out.Replies = append(out.Replies, newCommentJSON(&c.Replies[i], showHidden))
+	}
+	return out
+}
+
+func newUserJSON(u *model.User, self bool) userJSON {
+	out := userJSON{
+		ID:        u.ID,
+		Username:  u.Username,
+		Role:      u.Role,
+		Banned:    u.Banned(),
+		CreatedAt: u.CreatedAt,
+	}
+	if self {
+		out.Email = u.Email
+		unread := u.UnreadCount
+		out.UnreadCount = &unread
+	}
+	return out
+}
+
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
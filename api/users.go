@@ -0,0 +1,121 @@
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"forum-go/auth"
+	"forum-go/model"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// getUser serves GET /api/v1/users/{id} with the public profile.
+func (s *server) getUser(w http.ResponseWriter, r *http.Request) {
+	userID, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+
+	user, err := s.Users.FetchUserById(userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, http.StatusNotFound, "user not found")
+			return
+		}
+		writeInternal(w, "fetching user", err)
+		return
+	}
+	writeData(w, http.StatusOK, newUserJSON(user, false))
+}
+
+// getMe serves GET /api/v1/users/me, including e-mail and unread count.
+func (s *server) getMe(w http.ResponseWriter, r *http.Request, user *model.User) {
+	writeData(w, http.StatusOK, newUserJSON(user, true))
+}
+
+// login serves POST /api/v1/session. It sets the session cookie and also
+// returns the token for clients that prefer an Authorization header.
+// Accounts with two-factor login also send the code from their app, or a
+// recovery code, with the password.
+func (s *server) login(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+		Code     string `json:"code"`
+		Remember bool   `json:"remember"`
+	}
+	if !decodeJSON(w, r, &body) {
+		return
+	}
+
+	account, err := s.Logins.Authenticate(s.Accounts, body.Username, body.Password, auth.ClientIP(r))
+	if err != nil {
+		var locked *auth.LockedError
+		if errors.As(err, &locked) {
+			w.Header().Set("Retry-After", strconv.Itoa(locked.Seconds()))
+			writeError(w, http.StatusTooManyRequests, locked.Error())
+			return
+		}
+		if errors.Is(err, auth.ErrInvalidCredentials) {
+			writeError(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+		writeInternal(w, "checking credentials", err)
+		return
+	}
+
+	user, err := s.Users.FetchUserById(account.ID)
+	if err != nil {
+		writeInternal(w, "fetching user", err)
+		return
+	}
+	if user.Banned() {
+		writeError(w, http.StatusForbidden, "this account has been banned")
+		return
+	}
+	if !user.TwoFactorAt.IsZero() {
+		if body.Code == "" {
+			writeError(w, http.StatusUnauthorized, "two-factor code required")
+			return
+		}
+		err := s.Logins.SecondFactor(s.Accounts, user, auth.ClientIP(r), body.Code)
+		var locked *auth.LockedError
+		switch {
+		case errors.As(err, &locked):
+			w.Header().Set("Retry-After", strconv.Itoa(locked.Seconds()))
+			writeError(w, http.StatusTooManyRequests, locked.Error())
+			return
+		case errors.Is(err, auth.ErrInvalidCode):
+			writeError(w, http.StatusUnauthorized, err.Error())
+			return
+		case err != nil:
+			writeInternal(w, "checking two-factor code", err)
+			return
+		}
+	}
+
+	cookie, err := s.Sessions.NewSession(user.ID, r.UserAgent(), auth.ClientIP(r), body.Remember)
+	if err != nil {
+		writeInternal(w, "creating session", err)
+		return
+	}
+	http.SetCookie(w, cookie)
+
+	log.Printf("API login: User '%s'", user.Username)
+	writeData(w, http.StatusCreated, sessionJSON{
+		Token:     cookie.Value,
+		ExpiresAt: cookie.Expires,
+		User:      newUserJSON(user, true),
+	})
+}
+
+// logout serves DELETE /api/v1/session and ends the session it was sent with.
+func (s *server) logout(w http.ResponseWriter, r *http.Request, user *model.User) {
+	if err := s.Sessions.EndSession(sessionToken(r)); err != nil {
+		writeInternal(w, "ending session", err)
+		return
+	}
+	http.SetCookie(w, s.Sessions.SessionOptions().ExpiredCookie())
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -10,7 +10,10 @@ document.getElementById('login-form').addEventListener('submit', function(e) {
         body: formData
     })
     .then(response => {
-        if (response.ok) {
+        if (response.status === 202) {
+            // The password was right; the account also wants a two-factor code
+            window.location.href = response.headers.get('Location') || '/login/two-factor';
+        } else if (response.ok) {
             window.location.reload(); // "Redirects" to be on the same page
         } else {
             return response.text().then(text => {
@@ -1,30 +1,89 @@
+// Comment controls are delegated from the document, so comments that are
+// added to the page live behave like the ones rendered with it.
 document.addEventListener("DOMContentLoaded", () => {
-    const commentForm = document.getElementById("comment-form");
-
-    if (commentForm) {
-        commentForm.addEventListener("submit", function (e) {
-            e.preventDefault();
-            console.log("Comment form submitted.");
-
-            const formData = new FormData(this);
-
-            fetch("/submitComment", {
-                method: "POST",
-                body: formData
-            })
-            .then(response => {
-                if (response.ok) {
-                    window.location.reload();
-                } else {
-                    throw new Error("Comment submission failed");
-                }
-            })
-            .catch(error => {
-                console.error("Error:", error);
-                alert("Failed to submit comment. Please try again.");
-            });
+    // Guests have no top-level comment form
+    const loggedIn = document.getElementById("comment-form") !== null;
+
+    document.addEventListener("submit", e => {
+        const form = e.target;
+
+        if (form.matches(".delete-form")) {
+            if (!confirm(form.dataset.confirm)) {
+                e.preventDefault();
+            }
+            return;
+        }
+
+        if (!form.matches(".comment-form")) {
+            return;
+        }
+        e.preventDefault();
+
+        fetch(form.action, {
+            method: "POST",
+            body: new FormData(form)
+        })
+        .then(response => {
+            if (response.ok) {
+                window.location.reload();
+            } else if (response.status === 429) {
+                alert("You are commenting too fast. Please wait a moment.");
+            } else {
+                throw new Error("Comment submission failed");
+            }
+        })
+        .catch(error => {
+            console.error("Error:", error);
+            alert("Failed to submit comment. Please try again.");
         });
-    } else {
-        console.log("No comment form found.");
+    });
+
+    document.addEventListener("click", e => {
+        // Reply buttons open the form under their comment, or the login
+        // modal for guests
+        const reply = e.target.closest(".reply-button");
+        if (reply) {
+            if (!loggedIn) {
+                const loginModal = document.getElementById("login-modal");
+                if (loginModal) {
+                    loginModal.style.display = "flex";
+                }
+                return;
+            }
+            toggleForm(reply.closest(".comment-body").querySelector(".reply-form"));
+            return;
+        }
+
+        // Authors edit their comments in place
+        const edit = e.target.closest(".edit-button");
+        if (edit) {
+            toggleForm(edit.closest(".comment-body").querySelector(".edit-form"));
+            return;
+        }
+
+        // Report buttons open the report form of their post or comment
+        const report = e.target.closest(".report-button");
+        if (report) {
+            const scope = report.closest(".comment-body") || document.getElementById("post-container");
+            toggleForm(scope.querySelector(".report-form"));
+            return;
+        }
+
+        // Collapse or expand a comment together with all of its replies
+        const toggle = e.target.closest(".collapse-toggle");
+        if (toggle) {
+            const comment = toggle.closest(".comment");
+            const collapsed = comment.classList.toggle("collapsed");
+            toggle.textContent = collapsed ? "[+]" : "[–]";
+            toggle.title = collapsed ? "Expand thread" : "Collapse thread";
+        }
+    });
+
+    function toggleForm(form) {
+        form.hidden = !form.hidden;
+        const textarea = form.querySelector("textarea");
+        if (!form.hidden && textarea) {
+            textarea.focus();
+        }
     }
 });
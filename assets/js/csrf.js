@@ -0,0 +1,21 @@
+// Adds the page's anti-forgery token to every request a script sends to
+// this site that changes something. Forms carry it in a hidden field.
+(() => {
+    const meta = document.querySelector('meta[name="csrf-token"]');
+    if (!meta) {
+        return;
+    }
+    const token = meta.content;
+    const originalFetch = window.fetch;
+
+    window.fetch = (resource, options = {}) => {
+        const method = (options.method || "GET").toUpperCase();
+        const url = new URL(resource instanceof Request ? resource.url : resource, window.location.href);
+        if (method !== "GET" && method !== "HEAD" && url.origin === window.location.origin) {
+            const headers = new Headers(options.headers || {});
+            headers.set("X-CSRF-Token", token);
+            options = { ...options, headers };
+        }
+        return originalFetch(resource, options);
+    };
+})();
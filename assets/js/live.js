@@ -0,0 +1,71 @@
+// Live updates for the post page: new comments and vote counts arrive over
+// server-sent events. EventSource reconnects on its own and sends the last
+// event ID, so nothing published in between is lost.
+document.addEventListener("DOMContentLoaded", () => {
+    const list = document.getElementById("comments-list");
+    if (!list || !window.EventSource) {
+        return;
+    }
+    const maxDepth = parseInt(list.dataset.maxDepth, 10);
+    const source = new EventSource(`/events/post?id=${list.dataset.postId}`);
+
+    source.addEventListener("comment", event => {
+        const comment = JSON.parse(event.data);
+        if (document.getElementById(`comment-${comment.id}`)) {
+            return;
+        }
+
+        const template = document.createElement("template");
+        template.innerHTML = comment.html.trim();
+        const element = template.content.firstElementChild;
+
+        // Same nesting as the server: replies below the depth limit go
+        // under the deepest ancestor that still takes replies
+        let parent = comment.parent_id ? document.getElementById(`comment-${comment.parent_id}`) : null;
+        while (parent && parseInt(parent.dataset.depth, 10) >= maxDepth) {
+            parent = parent.parentElement.closest(".comment");
+        }
+
+        if (!parent) {
+            element.dataset.depth = 0;
+            const placeholder = list.querySelector(".no-comments");
+            if (placeholder) {
+                placeholder.remove();
+            }
+            list.appendChild(element);
+            return;
+        }
+
+        element.dataset.depth = parseInt(parent.dataset.depth, 10) + 1;
+        const body = parent.querySelector(":scope > .comment-body");
+        let replies = body.querySelector(":scope > .replies");
+        if (!replies) {
+            replies = document.createElement("div");
+            replies.className = "replies";
+            body.appendChild(replies);
+        }
+        replies.appendChild(element);
+    });
+
+    source.addEventListener("vote", event => {
+        const update = JSON.parse(event.data);
+        let like, dislike;
+        if (update.comment_id) {
+            like = document.querySelector(`.comment-like-button[data-comment-id="${update.comment_id}"]`);
+            dislike = document.querySelector(`.comment-dislike-button[data-comment-id="${update.comment_id}"]`);
+        } else {
+            like = document.querySelector(".like-button");
+            dislike = document.querySelector(".dislike-button");
+        }
+        if (like && dislike) {
+            like.querySelector(".count").textContent = update.upvotes;
+            dislike.querySelector(".count").textContent = update.downvotes;
+        }
+    });
+
+    // The events we missed are gone, so start over from a fresh page
+    source.addEventListener("reset", () => {
+        source.close();
+        window.location.reload();
+    });
+});
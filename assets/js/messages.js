@@ -0,0 +1,156 @@
+// Live private messages. A WebSocket delivers new messages as they are sent;
+// while it is down the page polls instead, and after every reconnect it
+// fetches whatever arrived in between.
+document.addEventListener("DOMContentLoaded", () => {
+    const conversation = document.getElementById("conversation");
+    const inbox = document.getElementById("inbox");
+    if (!conversation && !inbox) {
+        return;
+    }
+
+    const pollInterval = 5000;
+    let pollTimer = null;
+    let failures = 0;
+
+    // -- Conversation page -- //
+
+    const withName = conversation ? conversation.dataset.with : "";
+    const withId = conversation ? parseInt(conversation.dataset.withId, 10) : 0;
+    let lastId = conversation ? parseInt(conversation.dataset.lastId, 10) : 0;
+
+    function showMessage(message) {
+        if (message.id <= lastId || document.getElementById(`message-${message.id}`)) {
+            return;
+        }
+        lastId = message.id;
+
+        const list = conversation.querySelector(".message-list");
+        const empty = list.querySelector(".empty");
+        if (empty) {
+            empty.remove();
+        }
+
+        const element = document.createElement("div");
+        element.className = message.sender_id === withId ? "message" : "message mine";
+        element.id = `message-${message.id}`;
+        const body = document.createElement("p");
+        body.className = "message-body";
+        body.textContent = message.body;
+        const time = document.createElement("small");
+        time.textContent = new Date(message.created_at).toLocaleString();
+        element.append(body, time);
+        list.appendChild(element);
+        list.scrollTop = list.scrollHeight;
+    }
+
+    // Fetches the messages after the newest one shown; this also marks them
+    // read on the server
+    function catchUp() {
+        const query = conversation
+            ? `?with=${encodeURIComponent(withName)}&after=${lastId}`
+            : "";
+        return fetch(`/messages/poll${query}`, { headers: { "Accept": "application/json" } })
+            .then(response => response.ok ? response.json() : Promise.reject(response.status))
+            .then(data => {
+                if (conversation) {
+                    data.messages.forEach(showMessage);
+                } else if (data.unread !== parseInt(inbox.dataset.unread, 10)) {
+                    window.location.reload();
+                }
+            })
+            .catch(error => console.error("Error fetching messages:", error));
+    }
+
+    function received(message) {
+        if (!conversation) {
+            // The inbox order and counts change with every message
+            window.location.reload();
+            return;
+        }
+        if (message.sender_id !== withId && message.recipient_id !== withId) {
+            return;
+        }
+        showMessage(message);
+        if (message.sender_id === withId) {
+            const body = new URLSearchParams({ with: withName });
+            fetch("/messages/read", { method: "POST", body: body })
+                .catch(error => console.error("Error marking messages read:", error));
+        }
+    }
+
+    if (conversation) {
+        const list = conversation.querySelector(".message-list");
+        list.scrollTop = list.scrollHeight;
+
+        const form = conversation.querySelector(".message-form");
+        if (form) {
+            form.addEventListener("submit", e => {
+                e.preventDefault();
+                fetch(form.action, {
+                    method: "POST",
+                    headers: { "Accept": "application/json" },
+                    body: new URLSearchParams(new FormData(form))
+                })
+                .then(response => {
+                    if (response.status === 403) {
+                        window.location.reload(); // blocked since the page loaded
+                        return;
+                    }
+                    if (!response.ok) {
+                        throw new Error("Message could not be sent");
+                    }
+                    return response.json().then(message => {
+                        showMessage(message);
+                        form.reset();
+                    });
+                })
+                .catch(error => {
+                    console.error("Error:", error);
+                    alert("Failed to send your message. Please try again.");
+                });
+            });
+        }
+    }
+
+    // -- Delivery -- //
+
+    function startPolling() {
+        if (pollTimer === null) {
+            pollTimer = setInterval(catchUp, pollInterval);
+        }
+    }
+
+    function stopPolling() {
+        clearInterval(pollTimer);
+        pollTimer = null;
+    }
+
+    function connect() {
+        if (!window.WebSocket) {
+            startPolling();
+            return;
+        }
+
+        const scheme = window.location.protocol === "https:" ? "wss:" : "ws:";
+        const socket = new WebSocket(`${scheme}//${window.location.host}/messages/ws`);
+
+        socket.addEventListener("open", () => {
+            failures = 0;
+            stopPolling();
+            catchUp();
+        });
+        socket.addEventListener("message", event => {
+            const data = JSON.parse(event.data);
+            if (data.type === "message") {
+                received(data.message);
+            }
+        });
+        socket.addEventListener("close", () => {
+            startPolling();
+            failures++;
+            setTimeout(connect, Math.min(30000, 1000 * 2 ** failures));
+        });
+    }
+
+    connect();
+});
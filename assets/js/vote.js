@@ -24,6 +24,8 @@ document.addEventListener("DOMContentLoaded", () => {
             .then(response => {
                 if (response.ok) {
                     location.reload();
+                } else if (response.status === 429) {
+                    alert("You are voting too fast. Please wait a moment.");
                 } else {
                     alert("Error processing your vote.");
                 }
@@ -32,33 +34,38 @@ document.addEventListener("DOMContentLoaded", () => {
         });
     });
 
-    // Comment Voting
-    document.querySelectorAll(".comment-like-button, .comment-dislike-button").forEach(button => {
-        button.addEventListener("click", function () {
-            const commentId = this.dataset.commentId;
-            console.log("Comment Vote Clicked:", commentId); // ✅ Debugging
+    // Comment Voting. Delegated from the document so comments that arrive
+    // live on the post page get working buttons too.
+    document.addEventListener("click", event => {
+        const button = event.target.closest(".comment-like-button, .comment-dislike-button");
+        if (!button) {
+            return;
+        }
+        const commentId = button.dataset.commentId;
+        console.log("Comment Vote Clicked:", commentId); // ✅ Debugging
 
-            let vote;
+        let vote;
 
-            if (this.classList.contains("active")) {
-                vote = 0; // Neutral state (removing the vote)
+        if (button.classList.contains("active")) {
+            vote = 0; // Neutral state (removing the vote)
+        } else {
+            vote = button.classList.contains("comment-like-button") ? 1 : -1;
+        }
+        
+        fetch("/vote-comment", {
+            method: "POST",
+            headers: { "Content-Type": "application/x-www-form-urlencoded" },
+            body: `comment_id=${commentId}&vote=${vote}`
+        })
+        .then(response => {
+            if (response.ok) {
+                location.reload();
+            } else if (response.status === 429) {
+                alert("You are voting too fast. Please wait a moment.");
             } else {
-                vote = this.classList.contains("comment-like-button") ? 1 : -1;
+                alert("Error processing your vote.");
             }
-            
-            fetch("/vote-comment", {
-                method: "POST",
-                headers: { "Content-Type": "application/x-www-form-urlencoded" },
-                body: `comment_id=${commentId}&vote=${vote}`
-            })
-            .then(response => {
-                if (response.ok) {
-                    location.reload();
-                } else {
-                    alert("Error processing your vote.");
-                }
-            })
-            .catch(error => console.error("Error:", error));
-        });
+        })
+        .catch(error => console.error("Error:", error));
     });
 });
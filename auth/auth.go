@@ -9,9 +9,7 @@ import (
 	"golang.org/x/crypto/bcrypt"
 )
 
-var db *sql.DB
-
-func AddUser(db *sql.DB, username, email, password string) error {
+func (s *Store) AddUser(username, email, password string) error {
 	// Hash the password
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
@@ -19,7 +17,7 @@ func AddUser(db *sql.DB, username, email, password string) error {
 	}
 
 	// Prepare the SQL statement
-	stmt, err := db.Prepare("INSERT INTO users (username, email, password_hash) VALUES (?, ?, ?)")
+	stmt, err := s.db.Prepare("INSERT INTO users (username, email, password_hash) VALUES (?, ?, ?)")
 	if err != nil {
 		return fmt.Errorf("error preparing statement: %w", err)
 	}
@@ -61,15 +59,6 @@ func Logout(db *sql.DB, username string) error {
 	return nil
 }
 
-func UpdateCookie(db *sql.DB, username, newToken, expiration string) error {
-	_, err := db.Exec("UPDATE users SET session_token = ?, session_expiry = ? WHERE username = ?", newToken, expiration, username)
-	if err != nil {
-		return fmt.Errorf("error updating cookie: %w", err)
-	}
-	fmt.Printf("Cookie updated for user: %s\n", username)
-	return nil
-}
-
 func HashPassword(password string) (string, error) {
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
@@ -78,12 +67,12 @@ func HashPassword(password string) (string, error) {
 	return string(hashedPassword), nil
 }
 
-func GetUserInfo(db *sql.DB, submittedUsername string) (*model.User, error) {
+func (s *Store) GetUserInfo(submittedUsername string) (*model.User, error) {
 	var user model.User
-	if db == nil {
+	if s.db == nil {
 		return nil, fmt.Errorf("database connection is nil")
 	}
-	err := db.QueryRow("SELECT id, username, email, password_hash FROM users WHERE username = ?", submittedUsername).Scan(&user.ID, &user.Username, &user.Email, &user.Password)
+	err := s.db.QueryRow("SELECT id, username, email, password_hash FROM users WHERE username = ?", submittedUsername).Scan(&user.ID, &user.Username, &user.Email, &user.Password)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, errors.New("user not found")
@@ -93,11 +82,59 @@ func GetUserInfo(db *sql.DB, submittedUsername string) (*model.User, error) {
 	return &user, nil
 }
 
-func UserExists(db *sql.DB, username string) (string, error) {
+// ErrInvalidCredentials is returned by Authenticate for an unknown username
+// or a wrong password, without telling which.
+var ErrInvalidCredentials = errors.New("invalid username or password")
+
+// Authenticate checks a username and password and returns the account.
+func (s *Store) Authenticate(username, password string) (*model.User, error) {
+	user, err := s.GetUserInfo(username)
+	if err != nil {
+		if err.Error() == "user not found" {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, err
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); err != nil {
+		return nil, ErrInvalidCredentials
+	}
+	return user, nil
+}
+
+func (s *Store) UserExists(username string) (string, error) {
 	var userID string
-	err := db.QueryRow("SELECT id FROM users WHERE username = ?", username).Scan(&userID)
+	err := s.db.QueryRow("SELECT id FROM users WHERE username = ?", username).Scan(&userID)
 	if err != nil {
 		return "", err
 	}
 	return userID, nil
 }
+
+// CheckPassword confirms the password of a signed-in user before a
+// sensitive change. A wrong password returns ErrInvalidCredentials.
+func (s *Store) CheckPassword(userID int, password string) error {
+	var hash string
+	err := s.db.QueryRow("SELECT password_hash FROM users WHERE id = ?", userID).Scan(&hash)
+	if err != nil {
+		return fmt.Errorf("error fetching password: %w", err)
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)); err != nil {
+		return ErrInvalidCredentials
+	}
+	return nil
+}
+
+// ChangePassword replaces a user's password after checking the current one.
+func (s *Store) ChangePassword(userID int, current, password string) error {
+	if err := s.CheckPassword(userID, current); err != nil {
+		return err
+	}
+	hashed, err := HashPassword(password)
+	if err != nil {
+		return err
+	}
+	if _, err := s.db.Exec("UPDATE users SET password_hash = ? WHERE id = ?", hashed, userID); err != nil {
+		return fmt.Errorf("error updating password: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,248 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"forum-go/model"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrEmailNotVerified is returned when a provider does not vouch for
+	// the person's email address, which new accounts need.
+	ErrEmailNotVerified = errors.New("the provider did not share a verified email address")
+
+	// ErrEmailInUse is returned when an account already has the address
+	// but it cannot be linked automatically because the account's own
+	// address is unverified. Its owner can log in and link the provider
+	// from the settings page instead.
+	ErrEmailInUse = errors.New("an account already uses this email address")
+
+	// ErrIdentityTaken is returned when linking a provider account that
+	// already signs in to another forum account.
+	ErrIdentityTaken = errors.New("this account is already linked to another user")
+)
+
+// SignInWithIdentity finds or creates the forum account for someone who
+// signed in with a provider. The account linked to the identity is used
+// first; otherwise an account whose verified address matches the
+// provider's verified address is linked to it; otherwise a new account is
+// created, with a username based on the provider's suggestion and the
+// address marked verified. created reports the last case.
+func (s *Store) SignInWithIdentity(identity *Identity) (user *model.User, created bool, err error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, false, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	var userID int
+	err = tx.QueryRow(
+		"SELECT user_id FROM user_identities WHERE provider = ? AND subject = ?",
+		identity.Provider, identity.Subject,
+	).Scan(&userID)
+	switch {
+	case err == nil:
+		_, err = tx.Exec(
+			"UPDATE user_identities SET email = ?, last_used_at = ? WHERE provider = ? AND subject = ?",
+			identity.Email, now, identity.Provider, identity.Subject,
+		)
+		if err != nil {
+			return nil, false, fmt.Errorf("error updating identity: %w", err)
+		}
+
+	case err == sql.ErrNoRows:
+		if identity.Email == "" || !identity.EmailVerified {
+			return nil, false, ErrEmailNotVerified
+		}
+		var verifiedAt sql.NullTime
+		err = tx.QueryRow(
+			"SELECT id, email_verified_at FROM users WHERE lower(email) = lower(?) AND deleted_at IS NULL",
+			identity.Email,
+		).Scan(&userID, &verifiedAt)
+		switch {
+		case err == sql.ErrNoRows:
+			userID, err = createIdentityUser(tx, identity, now)
+			if err != nil {
+				return nil, false, err
+			}
+			created = true
+		case err != nil:
+			return nil, false, fmt.Errorf("error looking up email: %w", err)
+		case !verifiedAt.Valid:
+			// Whoever registered the address never proved they own it
+			return nil, false, ErrEmailInUse
+		}
+		if err := insertIdentity(tx, userID, identity, now); err != nil {
+			return nil, false, err
+		}
+
+	default:
+		return nil, false, fmt.Errorf("error looking up identity: %w", err)
+	}
+
+	var u model.User
+	err = tx.QueryRow("SELECT id, username, email FROM users WHERE id = ?", userID).Scan(&u.ID, &u.Username, &u.Email)
+	if err != nil {
+		return nil, false, fmt.Errorf("error fetching user: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, false, fmt.Errorf("error committing sign-in: %w", err)
+	}
+	return &u, created, nil
+}
+
+// LinkIdentity lets a signed-in user log in with a provider account from
+// now on.
+func (s *Store) LinkIdentity(userID int, identity *Identity) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var owner int
+	err = tx.QueryRow(
+		"SELECT user_id FROM user_identities WHERE provider = ? AND subject = ?",
+		identity.Provider, identity.Subject,
+	).Scan(&owner)
+	switch {
+	case err == nil && owner != userID:
+		return ErrIdentityTaken
+	case err == nil:
+		return nil
+	case err != sql.ErrNoRows:
+		return fmt.Errorf("error looking up identity: %w", err)
+	}
+
+	// One account per provider: linking another replaces it
+	if _, err := tx.Exec("DELETE FROM user_identities WHERE user_id = ? AND provider = ?", userID, identity.Provider); err != nil {
+		return fmt.Errorf("error replacing identity: %w", err)
+	}
+	if err := insertIdentity(tx, userID, identity, time.Now().UTC()); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing identity: %w", err)
+	}
+	return nil
+}
+
+// UnlinkIdentity stops a user from logging in with a provider. It returns
+// sql.ErrNoRows when the provider was not linked.
+func (s *Store) UnlinkIdentity(userID int, provider string) error {
+	result, err := s.db.Exec("DELETE FROM user_identities WHERE user_id = ? AND provider = ?", userID, provider)
+	if err != nil {
+		return fmt.Errorf("error unlinking identity: %w", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// FetchIdentities lists the providers a user can log in with.
+func (s *Store) FetchIdentities(userID int) ([]model.LinkedIdentity, error) {
+	rows, err := s.db.Query(
+		"SELECT provider, email, created_at, last_used_at FROM user_identities WHERE user_id = ? ORDER BY provider",
+		userID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error querying identities: %w", err)
+	}
+	defer rows.Close()
+
+	var identities []model.LinkedIdentity
+	for rows.Next() {
+		var li model.LinkedIdentity
+		var lastUsed sql.NullTime
+		if err := rows.Scan(&li.Provider, &li.Email, &li.CreatedAt, &lastUsed); err != nil {
+			return nil, fmt.Errorf("error scanning identity: %w", err)
+		}
+		li.LastUsedAt = lastUsed.Time
+		identities = append(identities, li)
+	}
+	return identities, rows.Err()
+}
+
+// -- Non-Global Functions : Only happens in this package -- //
+
+func insertIdentity(tx *connTx, userID int, identity *Identity, now time.Time) error {
+	_, err := tx.Exec(`INSERT INTO user_identities (user_id, provider, subject, email, created_at, last_used_at)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		userID, identity.Provider, identity.Subject, identity.Email, now, now)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return ErrIdentityTaken
+		}
+		return fmt.Errorf("error linking identity: %w", err)
+	}
+	return nil
+}
+
+// createIdentityUser adds an account for a provider identity. It gets a
+// random password: its owner signs in with the provider, or sets a
+// password through the reset link.
+func createIdentityUser(tx *connTx, identity *Identity, now time.Time) (int, error) {
+	hashed, err := HashPassword(NewOAuthState())
+	if err != nil {
+		return 0, err
+	}
+	username, err := freeUsername(tx, identity)
+	if err != nil {
+		return 0, err
+	}
+
+	var id int
+	err = tx.QueryRow(
+		"INSERT INTO users (username, email, password_hash, email_verified_at) VALUES (?, ?, ?, ?) RETURNING id",
+		username, identity.Email, hashed, now,
+	).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("error creating user: %w", err)
+	}
+	return id, nil
+}
+
+// freeUsername turns the provider's suggestion, or the start of the email
+// address, into an unused name that follows the registration rules.
+func freeUsername(tx *connTx, identity *Identity) (string, error) {
+	base := identity.Username
+	if base == "" {
+		base, _, _ = strings.Cut(identity.Email, "@")
+	}
+	base = strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == '-':
+			return r
+		case r == ' ' || r == '.':
+			return '_'
+		}
+		return -1
+	}, base)
+	if len(base) < 5 {
+		base = "user_" + base
+	}
+
+	for i := 1; i < 1000; i++ {
+		name := base
+		if i > 1 {
+			suffix := strconv.Itoa(i)
+			name = base[:min(len(base), 15-len(suffix))] + suffix
+		} else if len(name) > 15 {
+			name = name[:15]
+		}
+		var taken int
+		if err := tx.QueryRow("SELECT COUNT(*) FROM users WHERE lower(username) = lower(?)", name).Scan(&taken); err != nil {
+			return "", fmt.Errorf("error checking username: %w", err)
+		}
+		if taken == 0 {
+			return name, nil
+		}
+	}
+	return "", fmt.Errorf("error choosing a username for %s", identity.Email)
+}
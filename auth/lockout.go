@@ -0,0 +1,359 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"forum-go/model"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Login attempt outcomes stored in login_attempts.
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+	OutcomeLocked  = "locked"
+	// OutcomePassword is a right password for an account that still has
+	// to pass its second factor. It does not start the count over.
+	OutcomePassword = "password"
+)
+
+// LockoutPolicy controls how failed logins slow down further attempts.
+// Failures are counted per account since its last successful login and per
+// client IP, in both cases only within Window.
+type LockoutPolicy struct {
+	// Window is how far back failures are counted.
+	Window time.Duration
+	// FreeAttempts failures are allowed before any waiting; every further
+	// failure doubles the wait, starting at BaseDelay and capped at MaxDelay.
+	FreeAttempts int
+	BaseDelay    time.Duration
+	MaxDelay     time.Duration
+	// After LockoutAfter failures the account is locked for LockoutDuration,
+	// counted from the last failure. Zero disables the lockout.
+	LockoutAfter    int
+	LockoutDuration time.Duration
+	// IPLockoutAfter failures from one address, across all usernames, lock
+	// that address out for LockoutDuration. Zero disables the limit.
+	IPLockoutAfter int
+}
+
+// DefaultLockoutPolicy is the policy of new apps unless the server
+// overrides it.
+var DefaultLockoutPolicy = LockoutPolicy{
+	Window:          15 * time.Minute,
+	FreeAttempts:    3,
+	BaseDelay:       time.Second,
+	MaxDelay:        5 * time.Minute,
+	LockoutAfter:    10,
+	LockoutDuration: 15 * time.Minute,
+	IPLockoutAfter:  50,
+}
+
+// LockedError is returned when a login is refused before the password is
+// checked. Its message is meant to be shown to the user.
+type LockedError struct {
+	// RetryAfter is how long until the next attempt is accepted.
+	RetryAfter time.Duration
+	// Locked tells a lockout apart from the shorter backoff waits.
+	Locked bool
+}
+
+func (e *LockedError) Error() string {
+	if e.Locked {
+		return fmt.Sprintf("Too many failed login attempts. Login is locked for %s.", humanDuration(e.RetryAfter))
+	}
+	return fmt.Sprintf("Too many failed login attempts. Try again in %s.", humanDuration(e.RetryAfter))
+}
+
+// Seconds is RetryAfter rounded up, as sent in the Retry-After header.
+func (e *LockedError) Seconds() int {
+	return int((e.RetryAfter + time.Second - 1) / time.Second)
+}
+
+// Limiter applies a LockoutPolicy to the login attempts of an
+// AccountStore.
+type Limiter struct {
+	Policy LockoutPolicy
+	// Now is the clock; tests replace it to move time forward.
+	Now func() time.Time
+
+	// Attempts on the same account or from the same address run one at a
+	// time in this process, so parallel guesses cannot all pass Check before the first
+	// failure is recorded
+	mu    sync.Mutex
+	locks map[string]*keyLock
+}
+
+// keyLock is the lock of one account or address, freed once nobody holds
+// or waits for it.
+type keyLock struct {
+	sync.Mutex
+	refs int
+}
+
+// NewLimiter returns a Limiter using the wall clock.
+func NewLimiter(policy LockoutPolicy) *Limiter {
+	return &Limiter{Policy: policy, Now: time.Now}
+}
+
+// Authenticate is Authenticate with throttling: it refuses attempts while the
+// account or address is waiting out a backoff or lockout, and records every
+// attempt for auditing.
+func (l *Limiter) Authenticate(accounts AccountStore, username, password, ip string) (*model.User, error) {
+	defer l.lock(username, ip)()
+
+	if err := l.Check(accounts, username, ip); err != nil {
+		var locked *LockedError
+		if errors.As(err, &locked) {
+			if err := l.Record(accounts, username, ip, OutcomeLocked); err != nil {
+				return nil, err
+			}
+		}
+		return nil, err
+	}
+
+	user, err := accounts.Authenticate(username, password)
+	switch {
+	case errors.Is(err, ErrInvalidCredentials):
+		if err := l.Record(accounts, username, ip, OutcomeFailure); err != nil {
+			return nil, err
+		}
+		return nil, err
+	case err != nil:
+		return nil, err
+	}
+
+	outcome := OutcomeSuccess
+	if tf, err := accounts.FetchTwoFactor(user.ID); err != nil {
+		return nil, err
+	} else if !tf.EnabledAt.IsZero() {
+		outcome = OutcomePassword
+	}
+	if err := l.Record(accounts, username, ip, outcome); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// SecondFactor is VerifySecondFactor with the same throttling as
+// Authenticate: wrong codes count as failed logins of the account.
+func (l *Limiter) SecondFactor(accounts AccountStore, user *model.User, ip, code string) error {
+	defer l.lock(user.Username, ip)()
+
+	if err := l.Check(accounts, user.Username, ip); err != nil {
+		var locked *LockedError
+		if errors.As(err, &locked) {
+			if err := l.Record(accounts, user.Username, ip, OutcomeLocked); err != nil {
+				return err
+			}
+		}
+		return err
+	}
+
+	err := accounts.VerifySecondFactor(user.ID, code, l.Now())
+	switch {
+	case errors.Is(err, ErrInvalidCode):
+		if err := l.Record(accounts, user.Username, ip, OutcomeFailure); err != nil {
+			return err
+		}
+		return err
+	case err != nil:
+		return err
+	}
+	return l.Record(accounts, user.Username, ip, OutcomeSuccess)
+}
+
+// Check returns a *LockedError if an attempt for username from ip must not
+// be checked yet.
+func (l *Limiter) Check(accounts AccountStore, username, ip string) error {
+	now := l.Now().UTC()
+	since := now.Add(-l.Policy.Window)
+
+	// A successful login starts the account's count over
+	lastSuccess, err := accounts.LastLoginAttempt(username, OutcomeSuccess)
+	if err != nil {
+		return err
+	}
+	if lastSuccess.After(since) {
+		since = lastSuccess
+	}
+
+	failures, last, err := accounts.LoginFailures(username, since)
+	if err != nil {
+		return err
+	}
+	if wait, locked := l.wait(failures, last, now); wait > 0 {
+		return &LockedError{RetryAfter: wait, Locked: locked}
+	}
+
+	if l.Policy.IPLockoutAfter > 0 && ip != "" {
+		failures, last, err := accounts.AddressFailures(ip, now.Add(-l.Policy.Window))
+		if err != nil {
+			return err
+		}
+		if failures >= l.Policy.IPLockoutAfter {
+			if wait := last.Add(l.Policy.LockoutDuration).Sub(now); wait > 0 {
+				return &LockedError{RetryAfter: wait, Locked: true}
+			}
+		}
+	}
+	return nil
+}
+
+// Record stores one login attempt.
+func (l *Limiter) Record(accounts AccountStore, username, ip, outcome string) error {
+	return accounts.RecordLoginAttempt(username, ip, outcome, l.Now().UTC())
+}
+
+// RecordLoginAttempt stores one login attempt for auditing and throttling.
+func (s *Store) RecordLoginAttempt(username, ip, outcome string, at time.Time) error {
+	_, err := s.db.Exec("INSERT INTO login_attempts (username, ip, outcome, created_at) VALUES (?, ?, ?, ?)",
+		username, ip, outcome, at)
+	if err != nil {
+		return fmt.Errorf("error recording login attempt: %w", err)
+	}
+	return nil
+}
+
+// LastLoginAttempt returns the time of the newest attempt on username with
+// outcome, or the zero time when there is none.
+func (s *Store) LastLoginAttempt(username, outcome string) (time.Time, error) {
+	return s.lastAttempt("username = ? AND outcome = ?", username, outcome)
+}
+
+// LoginFailures counts the failed attempts on username since the given
+// time and returns the time of the newest one.
+func (s *Store) LoginFailures(username string, since time.Time) (int, time.Time, error) {
+	return s.countFailures("username = ?", username, since)
+}
+
+// AddressFailures counts the failed attempts from ip, on any account,
+// since the given time and returns the time of the newest one.
+func (s *Store) AddressFailures(ip string, since time.Time) (int, time.Time, error) {
+	return s.countFailures("ip = ?", ip, since)
+}
+
+// ClientIP returns the address a request came from, without the port.
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// -- Non-Global Functions : Only happens in this package -- //
+
+// lock holds the locks of username and ip until the returned function is
+// called. The account is always locked first, so two attempts never wait
+// on each other's second lock.
+func (l *Limiter) lock(username, ip string) func() {
+	keys := []string{"user:" + username}
+	if ip != "" {
+		keys = append(keys, "ip:"+ip)
+	}
+
+	held := make([]*keyLock, 0, len(keys))
+	for _, key := range keys {
+		l.mu.Lock()
+		if l.locks == nil {
+			l.locks = make(map[string]*keyLock)
+		}
+		k := l.locks[key]
+		if k == nil {
+			k = &keyLock{}
+			l.locks[key] = k
+		}
+		k.refs++
+		l.mu.Unlock()
+
+		k.Lock()
+		held = append(held, k)
+	}
+
+	return func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		for i, k := range held {
+			k.Unlock()
+			if k.refs--; k.refs == 0 {
+				delete(l.locks, keys[i])
+			}
+		}
+	}
+}
+
+// wait returns how long after the last of failures the next attempt has to
+// wait, and whether that is a lockout.
+func (l *Limiter) wait(failures int, last, now time.Time) (time.Duration, bool) {
+	p := l.Policy
+	if failures == 0 {
+		return 0, false
+	}
+	if p.LockoutAfter > 0 && failures >= p.LockoutAfter {
+		return last.Add(p.LockoutDuration).Sub(now), true
+	}
+	if failures < p.FreeAttempts {
+		return 0, false
+	}
+
+	delay := p.BaseDelay
+	for i := p.FreeAttempts; i < failures && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	return last.Add(delay).Sub(now), false
+}
+
+// countFailures counts failed attempts matching where since the given time
+// and returns the time of the newest one.
+func (s *Store) countFailures(where, arg string, since time.Time) (int, time.Time, error) {
+	var count int
+	err := s.db.QueryRow("SELECT COUNT(*) FROM login_attempts WHERE "+where+" AND outcome = ? AND created_at > ?",
+		arg, OutcomeFailure, since).Scan(&count)
+	if err != nil {
+		return 0, time.Time{}, fmt.Errorf("error counting login failures: %w", err)
+	}
+	if count == 0 {
+		return 0, time.Time{}, nil
+	}
+	last, err := s.lastAttempt(where+" AND outcome = ?", arg, OutcomeFailure)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	return count, last, nil
+}
+
+// lastAttempt returns the time of the newest attempt matching where, or the
+// zero time when there is none.
+func (s *Store) lastAttempt(where string, args ...interface{}) (time.Time, error) {
+	var at time.Time
+	err := s.db.QueryRow("SELECT created_at FROM login_attempts WHERE "+where+" ORDER BY created_at DESC LIMIT 1",
+		args...).Scan(&at)
+	if err == sql.ErrNoRows {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("error fetching login attempts: %w", err)
+	}
+	return at, nil
+}
+
+// humanDuration rounds d up to whole seconds or minutes for messages.
+func humanDuration(d time.Duration) string {
+	if d <= time.Minute {
+		seconds := int((d + time.Second - 1) / time.Second)
+		if seconds == 1 {
+			return "1 second"
+		}
+		return fmt.Sprintf("%d seconds", seconds)
+	}
+	minutes := int((d + time.Minute - 1) / time.Minute)
+	return fmt.Sprintf("%d minutes", minutes)
+}
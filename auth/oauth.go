@@ -0,0 +1,201 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Identity is what a sign-in provider tells us about the person who just
+// signed in with it.
+type Identity struct {
+	Provider      string
+	Subject       string // the provider's stable ID for the person
+	Email         string
+	EmailVerified bool
+	Username      string // a suggestion for new accounts, such as the GitHub login
+}
+
+// Provider is a third-party service people can sign in with, using the
+// OAuth2 authorization code flow with PKCE.
+type Provider interface {
+	// ID names the provider in URLs and in user_identities.
+	ID() string
+	// Label is the name shown on sign-in buttons.
+	Label() string
+	// AuthCodeURL is where the browser is sent to sign in.
+	AuthCodeURL(state, challenge, redirectURL string) string
+	// Exchange turns the code the browser came back with into the
+	// identity of the person who signed in.
+	Exchange(ctx context.Context, code, verifier, redirectURL string) (*Identity, error)
+}
+
+// OAuthProvider is a Provider for any OAuth2 service; Profile knows how to
+// read the person from the service's API.
+type OAuthProvider struct {
+	Name         string
+	DisplayName  string
+	ClientID     string
+	ClientSecret string
+	AuthURL      string
+	TokenURL     string
+	UserInfoURL  string
+	Scopes       []string
+
+	// Profile fetches the identity of the owner of accessToken.
+	Profile func(ctx context.Context, p *OAuthProvider, accessToken string) (*Identity, error)
+
+	// Client makes the token and API requests. Nil means a client with a
+	// ten second timeout.
+	Client *http.Client
+}
+
+func (p *OAuthProvider) ID() string    { return p.Name }
+func (p *OAuthProvider) Label() string { return p.DisplayName }
+
+func (p *OAuthProvider) AuthCodeURL(state, challenge, redirectURL string) string {
+	v := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.ClientID},
+		"redirect_uri":          {redirectURL},
+		"state":                 {state},
+		"code_challenge":        {challenge},
+		"code_challenge_method": {"S256"},
+	}
+	if len(p.Scopes) > 0 {
+		v.Set("scope", strings.Join(p.Scopes, " "))
+	}
+	sep := "?"
+	if strings.Contains(p.AuthURL, "?") {
+		sep = "&"
+	}
+	return p.AuthURL + sep + v.Encode()
+}
+
+func (p *OAuthProvider) Exchange(ctx context.Context, code, verifier, redirectURL string) (*Identity, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURL},
+		"client_id":     {p.ClientID},
+		"client_secret": {p.ClientSecret},
+		"code_verifier": {verifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("error building token request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	var token struct {
+		AccessToken      string `json:"access_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := p.do(req, &token); err != nil {
+		return nil, fmt.Errorf("error exchanging %s code: %w", p.Name, err)
+	}
+	// GitHub reports a bad code with 200 and an error field
+	if token.Error != "" {
+		return nil, fmt.Errorf("error exchanging %s code: %s %s", p.Name, token.Error, token.ErrorDescription)
+	}
+	if token.AccessToken == "" {
+		return nil, fmt.Errorf("error exchanging %s code: no access token", p.Name)
+	}
+
+	identity, err := p.Profile(ctx, p, token.AccessToken)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching %s profile: %w", p.Name, err)
+	}
+	if identity.Subject == "" {
+		return nil, fmt.Errorf("error fetching %s profile: no user ID", p.Name)
+	}
+	identity.Provider = p.Name
+	return identity, nil
+}
+
+// GetJSON fetches an API URL with accessToken and decodes the answer into
+// v. Profile functions use it.
+func (p *OAuthProvider) GetJSON(ctx context.Context, apiURL, accessToken string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, apiURL, nil)
+	if err != nil {
+		return fmt.Errorf("error building request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Accept", "application/json")
+	return p.do(req, v)
+}
+
+// Providers are the services sign-in is offered with, by ID.
+type Providers map[string]Provider
+
+// Add offers sign-in with p, replacing a provider with the same ID.
+func (ps Providers) Add(p Provider) {
+	ps[p.ID()] = p
+}
+
+// Lookup returns the provider with the given ID.
+func (ps Providers) Lookup(id string) (Provider, bool) {
+	p, ok := ps[id]
+	return p, ok
+}
+
+// List returns the providers by label, for sign-in buttons.
+func (ps Providers) List() []Provider {
+	list := make([]Provider, 0, len(ps))
+	for _, p := range ps {
+		list = append(list, p)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Label() < list[j].Label() })
+	return list
+}
+
+// NewPKCE returns a random code verifier and its S256 challenge.
+func NewPKCE() (verifier, challenge string) {
+	verifier = base64.RawURLEncoding.EncodeToString(randomTokenBytes(32))
+	sum := sha256.Sum256([]byte(verifier))
+	return verifier, base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// NewOAuthState returns a random value to tie a callback to the browser
+// that started the sign-in.
+func NewOAuthState() string {
+	return base64.RawURLEncoding.EncodeToString(randomTokenBytes(24))
+}
+
+// -- Non-Global Functions : Only happens in this package -- //
+
+// do sends req and decodes a JSON answer into v. Answers other than 200
+// are errors.
+func (p *OAuthProvider) do(req *http.Request, v interface{}) error {
+	client := p.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s answered %s", req.URL.Host, resp.Status)
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("error decoding answer from %s: %w", req.URL.Host, err)
+	}
+	return nil
+}
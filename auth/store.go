@@ -0,0 +1,154 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"forum-go/model"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// AccountStore checks passwords and keeps the emailed tokens, linked
+// sign-in providers, two-factor secrets and login attempts of accounts.
+type AccountStore interface {
+	AddUser(username, email, password string) error
+	UserExists(username string) (string, error)
+	Authenticate(username, password string) (*model.User, error)
+	CheckPassword(userID int, password string) error
+	ChangePassword(userID int, current, password string) error
+
+	IssueToken(userID int, purpose, email string, ttl time.Duration) (string, error)
+	UseToken(token, purpose string) (int, error)
+	CheckToken(token, purpose string) (int, error)
+	ResetPassword(token, password string) (int, error)
+	VerifyEmail(token string) (int, error)
+
+	SignInWithIdentity(identity *Identity) (user *model.User, created bool, err error)
+	LinkIdentity(userID int, identity *Identity) error
+	UnlinkIdentity(userID int, provider string) error
+	FetchIdentities(userID int) ([]model.LinkedIdentity, error)
+
+	FetchTwoFactor(userID int) (*model.TwoFactor, error)
+	BeginTwoFactor(userID int) (string, error)
+	EnableTwoFactor(userID int, code string) ([]string, error)
+	DisableTwoFactor(userID int) error
+	NewRecoveryCodes(userID int) ([]string, error)
+	VerifySecondFactor(userID int, code string, at time.Time) error
+
+	RecordLoginAttempt(username, ip, outcome string, at time.Time) error
+	LastLoginAttempt(username, outcome string) (time.Time, error)
+	LoginFailures(username string, since time.Time) (int, time.Time, error)
+	AddressFailures(ip string, since time.Time) (int, time.Time, error)
+}
+
+// Store is the AccountStore of the forum's database, SQLite or
+// PostgreSQL. It signs tokens with its key.
+type Store struct {
+	db       *conn
+	tokenKey []byte
+}
+
+var _ AccountStore = (*Store)(nil)
+
+// NewStore returns a Store over the SQLite database db signing tokens with
+// tokenKey, so emailed links keep working across restarts. An empty key is
+// replaced by a random per-process one.
+func NewStore(db *sql.DB, tokenKey string) *Store {
+	return newStore(&conn{db: db}, tokenKey)
+}
+
+// NewPostgresStore is NewStore for a PostgreSQL database.
+func NewPostgresStore(db *sql.DB, tokenKey string) *Store {
+	return newStore(&conn{db: db, numbered: true}, tokenKey)
+}
+
+// -- Non-Global Functions : Only happens in this package -- //
+
+func newStore(db *conn, tokenKey string) *Store {
+	if tokenKey == "" {
+		return &Store{db: db, tokenKey: randomTokenBytes(32)}
+	}
+	return &Store{db: db, tokenKey: []byte(tokenKey)}
+}
+
+// conn runs the package's queries, which are written with ? placeholders,
+// on either database. PostgreSQL gets them numbered as $1, $2 and so on.
+type conn struct {
+	db       *sql.DB
+	numbered bool
+}
+
+func (c *conn) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return c.db.Exec(c.rebind(query), args...)
+}
+
+func (c *conn) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return c.db.Query(c.rebind(query), args...)
+}
+
+func (c *conn) QueryRow(query string, args ...interface{}) *sql.Row {
+	return c.db.QueryRow(c.rebind(query), args...)
+}
+
+func (c *conn) Prepare(query string) (*sql.Stmt, error) {
+	return c.db.Prepare(c.rebind(query))
+}
+
+func (c *conn) Begin() (*connTx, error) {
+	t, err := c.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return &connTx{Tx: t, conn: c}, nil
+}
+
+// rebind numbers the placeholders of query for PostgreSQL. The queries
+// have no ? inside string literals.
+func (c *conn) rebind(query string) string {
+	if !c.numbered || !strings.Contains(query, "?") {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// connTx is a transaction of a conn, with its placeholders rebound the
+// same way.
+type connTx struct {
+	*sql.Tx
+	conn *conn
+}
+
+func (t *connTx) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return t.Tx.Exec(t.conn.rebind(query), args...)
+}
+
+func (t *connTx) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return t.Tx.Query(t.conn.rebind(query), args...)
+}
+
+func (t *connTx) QueryRow(query string, args ...interface{}) *sql.Row {
+	return t.Tx.QueryRow(t.conn.rebind(query), args...)
+}
+
+// isUniqueViolation reports whether err broke a unique constraint, in
+// either database.
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == "23505"
+	}
+	return strings.Contains(err.Error(), "UNIQUE constraint failed")
+}
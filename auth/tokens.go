@@ -0,0 +1,214 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Purposes of emailed tokens. A token only works for the purpose it was
+// issued for.
+const (
+	PurposePasswordReset = "password_reset"
+	PurposeVerifyEmail   = "verify_email"
+	// PurposeTwoFactorLogin tokens are not emailed: they carry a login
+	// from the password to the second factor.
+	PurposeTwoFactorLogin = "two_factor_login"
+)
+
+// Token lifetimes.
+const (
+	PasswordResetTTL  = time.Hour
+	VerifyEmailTTL    = 48 * time.Hour
+	TwoFactorLoginTTL = 5 * time.Minute
+)
+
+// ErrInvalidToken is returned for tokens that are forged, unknown, used,
+// expired, or issued for an email address the account no longer has.
+var ErrInvalidToken = errors.New("this link is invalid or has expired")
+
+// IssueToken creates a single-use token for purpose that is valid for ttl,
+// replacing the user's earlier unused ones for the same purpose. email is
+// the address the token is sent to: the token stops working if the account
+// changes address. Only a hash of the token is stored.
+func (s *Store) IssueToken(userID int, purpose, email string, ttl time.Duration) (string, error) {
+	secret := base64.RawURLEncoding.EncodeToString(randomTokenBytes(32))
+	now := time.Now().UTC()
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return "", fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM user_tokens WHERE user_id = ? AND purpose = ? AND used_at IS NULL", userID, purpose); err != nil {
+		return "", fmt.Errorf("error replacing tokens: %w", err)
+	}
+	_, err = tx.Exec(`INSERT INTO user_tokens (user_id, purpose, token_hash, email, expires_at, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)`, userID, purpose, hashToken(secret), email, now.Add(ttl), now)
+	if err != nil {
+		return "", fmt.Errorf("error storing token: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return "", fmt.Errorf("error committing token: %w", err)
+	}
+
+	return secret + "." + s.signToken(purpose, secret), nil
+}
+
+// UseToken uses up a token and reports the user it was issued to.
+func (s *Store) UseToken(token, purpose string) (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	userID, _, err := s.consumeToken(tx, token, purpose)
+	if err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("error committing token: %w", err)
+	}
+	return userID, nil
+}
+
+// CheckToken reports the user a token was issued to, without using it up.
+func (s *Store) CheckToken(token, purpose string) (int, error) {
+	secret, ok := s.verifyToken(token, purpose)
+	if !ok {
+		return 0, ErrInvalidToken
+	}
+
+	var userID int
+	err := s.db.QueryRow(`SELECT user_id FROM user_tokens t
+		WHERE token_hash = ? AND purpose = ? AND used_at IS NULL AND expires_at > ?
+		AND email = (SELECT email FROM users WHERE users.id = t.user_id)`,
+		hashToken(secret), purpose, time.Now().UTC()).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return 0, ErrInvalidToken
+	}
+	if err != nil {
+		return 0, fmt.Errorf("error checking token: %w", err)
+	}
+	return userID, nil
+}
+
+// ResetPassword uses a password reset token to set a new password. Every
+// session of the account is ended, so whoever knew the old password is
+// signed out.
+func (s *Store) ResetPassword(token, password string) (int, error) {
+	hashed, err := HashPassword(password)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	userID, _, err := s.consumeToken(tx, token, PurposePasswordReset)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec("UPDATE users SET password_hash = ? WHERE id = ?", hashed, userID); err != nil {
+		return 0, fmt.Errorf("error updating password: %w", err)
+	}
+	if _, err := tx.Exec("DELETE FROM sessions WHERE user_id = ?", userID); err != nil {
+		return 0, fmt.Errorf("error ending sessions: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("error committing password reset: %w", err)
+	}
+	return userID, nil
+}
+
+// VerifyEmail uses a verification token to mark the account's address as
+// verified.
+func (s *Store) VerifyEmail(token string) (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	userID, email, err := s.consumeToken(tx, token, PurposeVerifyEmail)
+	if err != nil {
+		return 0, err
+	}
+	_, err = tx.Exec("UPDATE users SET email_verified_at = ? WHERE id = ? AND email = ?", time.Now().UTC(), userID, email)
+	if err != nil {
+		return 0, fmt.Errorf("error verifying email: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("error committing verification: %w", err)
+	}
+	return userID, nil
+}
+
+// -- Non-Global Functions : Only happens in this package -- //
+
+// consumeToken marks a valid token used and returns its user and address.
+func (s *Store) consumeToken(tx *connTx, token, purpose string) (int, string, error) {
+	secret, ok := s.verifyToken(token, purpose)
+	if !ok {
+		return 0, "", ErrInvalidToken
+	}
+
+	var userID int
+	var email string
+	now := time.Now().UTC()
+	err := tx.QueryRow(`UPDATE user_tokens SET used_at = ?
+		WHERE token_hash = ? AND purpose = ? AND used_at IS NULL AND expires_at > ?
+		AND email = (SELECT email FROM users WHERE users.id = user_tokens.user_id)
+		RETURNING user_id, email`,
+		now, hashToken(secret), purpose, now).Scan(&userID, &email)
+	if err == sql.ErrNoRows {
+		return 0, "", ErrInvalidToken
+	}
+	if err != nil {
+		return 0, "", fmt.Errorf("error using token: %w", err)
+	}
+	return userID, email, nil
+}
+
+// verifyToken checks the signature of a token and returns its secret part.
+// Forged tokens are turned away without a database lookup.
+func (s *Store) verifyToken(token, purpose string) (string, bool) {
+	secret, sig, ok := strings.Cut(token, ".")
+	if !ok || secret == "" {
+		return "", false
+	}
+	return secret, hmac.Equal([]byte(sig), []byte(s.signToken(purpose, secret)))
+}
+
+func (s *Store) signToken(purpose, secret string) string {
+	mac := hmac.New(sha256.New, s.tokenKey)
+	mac.Write([]byte(purpose))
+	mac.Write([]byte{0})
+	mac.Write([]byte(secret))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func hashToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomTokenBytes(n int) []byte {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return b
+}
@@ -0,0 +1,320 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"database/sql"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"forum-go/model"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// TOTP parameters, the defaults of authenticator apps (RFC 6238).
+const (
+	totpDigits = 6
+	totpPeriod = 30 * time.Second
+	// totpSkew steps either side of the current one are accepted, for
+	// phones whose clock is a little off
+	totpSkew = 1
+)
+
+// RecoveryCodeCount is how many recovery codes an account gets at a time.
+const RecoveryCodeCount = 10
+
+var (
+	// ErrInvalidCode is returned for a wrong, reused or expired two-factor
+	// code or recovery code.
+	ErrInvalidCode = errors.New("invalid two-factor code")
+
+	// ErrTwoFactorEnabled is returned when setting up two-factor login
+	// for an account that already has it.
+	ErrTwoFactorEnabled = errors.New("two-factor login is already on")
+
+	// ErrTwoFactorDisabled is returned for changes that need two-factor
+	// login to be on.
+	ErrTwoFactorDisabled = errors.New("two-factor login is off")
+)
+
+var secretEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// MustEnrollTwoFactor reports whether user is kept from their role's
+// powers until they turn on two-factor login. role is the lowest role
+// that has to turn it on; empty forces no one.
+func MustEnrollTwoFactor(role string, user *model.User) bool {
+	return role != "" && user != nil && user.TwoFactorAt.IsZero() && user.HasRole(role)
+}
+
+// TOTPCode returns the code an authenticator app shows for secret at t.
+func TOTPCode(secret string, t time.Time) (string, error) {
+	key, err := secretEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", fmt.Errorf("error decoding secret: %w", err)
+	}
+	return totpAt(key, t.Unix()/int64(totpPeriod.Seconds())), nil
+}
+
+// TOTPURI is the otpauth:// address that authenticator apps read from a
+// QR code to add the account.
+func TOTPURI(issuer, account, secret string) string {
+	q := url.Values{}
+	q.Set("secret", secret)
+	q.Set("issuer", issuer)
+	q.Set("algorithm", "SHA1")
+	q.Set("digits", fmt.Sprint(totpDigits))
+	q.Set("period", fmt.Sprint(int(totpPeriod.Seconds())))
+	return "otpauth://totp/" + url.PathEscape(issuer+":"+account) + "?" + q.Encode()
+}
+
+// FetchTwoFactor returns the two-factor login state of a user.
+func (s *Store) FetchTwoFactor(userID int) (*model.TwoFactor, error) {
+	var tf model.TwoFactor
+	var secret string
+	var enabledAt sql.NullTime
+	err := s.db.QueryRow(`SELECT totp_secret, totp_enabled_at,
+		(SELECT COUNT(*) FROM recovery_codes r WHERE r.user_id = users.id AND r.used_at IS NULL)
+		FROM users WHERE id = ?`, userID).Scan(&secret, &enabledAt, &tf.RecoveryCodesLeft)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching two-factor state: %w", err)
+	}
+	tf.EnabledAt = enabledAt.Time
+	if !enabledAt.Valid {
+		tf.PendingSecret = secret
+	}
+	return &tf, nil
+}
+
+// BeginTwoFactor stores a new secret for the user to add to their app. It
+// only takes effect once EnableTwoFactor confirms a code from it.
+func (s *Store) BeginTwoFactor(userID int) (string, error) {
+	secret := secretEncoding.EncodeToString(randomTokenBytes(20))
+	result, err := s.db.Exec("UPDATE users SET totp_secret = ?, totp_last_step = 0 WHERE id = ? AND totp_enabled_at IS NULL",
+		secret, userID)
+	if err != nil {
+		return "", fmt.Errorf("error storing two-factor secret: %w", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return "", ErrTwoFactorEnabled
+	}
+	return secret, nil
+}
+
+// EnableTwoFactor turns on two-factor login once code shows the user's
+// app has the pending secret, and returns the account's recovery codes.
+func (s *Store) EnableTwoFactor(userID int, code string) ([]string, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var secret string
+	var enabledAt sql.NullTime
+	err = tx.QueryRow("SELECT totp_secret, totp_enabled_at FROM users WHERE id = ?", userID).Scan(&secret, &enabledAt)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching two-factor secret: %w", err)
+	}
+	if enabledAt.Valid {
+		return nil, ErrTwoFactorEnabled
+	}
+	if secret == "" {
+		return nil, ErrTwoFactorDisabled
+	}
+	step, ok := matchTOTP(secret, code, 0, time.Now())
+	if !ok {
+		return nil, ErrInvalidCode
+	}
+
+	_, err = tx.Exec("UPDATE users SET totp_enabled_at = ?, totp_last_step = ? WHERE id = ?", time.Now().UTC(), step, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error enabling two-factor login: %w", err)
+	}
+	codes, err := replaceRecoveryCodes(tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("error committing two-factor login: %w", err)
+	}
+	return codes, nil
+}
+
+// DisableTwoFactor turns two-factor login off and forgets the secret and
+// recovery codes.
+func (s *Store) DisableTwoFactor(userID int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("UPDATE users SET totp_secret = '', totp_enabled_at = NULL, totp_last_step = 0 WHERE id = ? AND totp_enabled_at IS NOT NULL", userID)
+	if err != nil {
+		return fmt.Errorf("error disabling two-factor login: %w", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrTwoFactorDisabled
+	}
+	if _, err := tx.Exec("DELETE FROM recovery_codes WHERE user_id = ?", userID); err != nil {
+		return fmt.Errorf("error deleting recovery codes: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing two-factor change: %w", err)
+	}
+	return nil
+}
+
+// NewRecoveryCodes replaces a user's recovery codes, used or not, with a
+// fresh set.
+func (s *Store) NewRecoveryCodes(userID int) ([]string, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	on, err := twoFactorOn(tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !on {
+		return nil, ErrTwoFactorDisabled
+	}
+	codes, err := replaceRecoveryCodes(tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("error committing recovery codes: %w", err)
+	}
+	return codes, nil
+}
+
+// VerifySecondFactor checks a code from the user's app as of now, or one
+// of their recovery codes, which is then used up. Each app code works
+// once.
+func (s *Store) VerifySecondFactor(userID int, code string, now time.Time) error {
+	code = normalizeCode(code)
+	if code == "" {
+		return ErrInvalidCode
+	}
+
+	var secret string
+	var lastStep int64
+	var enabledAt sql.NullTime
+	err := s.db.QueryRow("SELECT totp_secret, totp_last_step, totp_enabled_at FROM users WHERE id = ?", userID).
+		Scan(&secret, &lastStep, &enabledAt)
+	if err != nil {
+		return fmt.Errorf("error fetching two-factor secret: %w", err)
+	}
+	if !enabledAt.Valid {
+		return ErrTwoFactorDisabled
+	}
+
+	if len(code) == totpDigits {
+		step, ok := matchTOTP(secret, code, lastStep, now)
+		if !ok {
+			return ErrInvalidCode
+		}
+		// The condition keeps two requests racing with one code from both
+		// getting in
+		result, err := s.db.Exec("UPDATE users SET totp_last_step = ? WHERE id = ? AND totp_last_step < ?", step, userID, step)
+		if err != nil {
+			return fmt.Errorf("error recording two-factor code: %w", err)
+		}
+		if n, err := result.RowsAffected(); err == nil && n == 0 {
+			return ErrInvalidCode
+		}
+		return nil
+	}
+
+	result, err := s.db.Exec("UPDATE recovery_codes SET used_at = ? WHERE user_id = ? AND code_hash = ? AND used_at IS NULL",
+		now.UTC(), userID, hashToken(code))
+	if err != nil {
+		return fmt.Errorf("error using recovery code: %w", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrInvalidCode
+	}
+	return nil
+}
+
+// -- Non-Global Functions : Only happens in this package -- //
+
+// matchTOTP finds the time step code belongs to, near now and after
+// lastStep.
+func matchTOTP(secret, code string, lastStep int64, now time.Time) (int64, bool) {
+	key, err := secretEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil || len(code) != totpDigits {
+		return 0, false
+	}
+	current := now.Unix() / int64(totpPeriod.Seconds())
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step > lastStep && hmac.Equal([]byte(totpAt(key, step)), []byte(code)) {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// totpAt is the HOTP value of key for a time step (RFC 4226).
+func totpAt(key []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000)
+}
+
+// replaceRecoveryCodes stores hashes of a new set of codes and returns the
+// codes, shown to the user once.
+func replaceRecoveryCodes(tx *connTx, userID int) ([]string, error) {
+	if _, err := tx.Exec("DELETE FROM recovery_codes WHERE user_id = ?", userID); err != nil {
+		return nil, fmt.Errorf("error deleting recovery codes: %w", err)
+	}
+
+	now := time.Now().UTC()
+	codes := make([]string, RecoveryCodeCount)
+	for i := range codes {
+		raw := strings.ToLower(secretEncoding.EncodeToString(randomTokenBytes(7)))[:10]
+		codes[i] = raw[:5] + "-" + raw[5:]
+		_, err := tx.Exec("INSERT INTO recovery_codes (user_id, code_hash, created_at) VALUES (?, ?, ?)",
+			userID, hashToken(raw), now)
+		if err != nil {
+			return nil, fmt.Errorf("error storing recovery code: %w", err)
+		}
+	}
+	return codes, nil
+}
+
+// normalizeCode drops the spaces and dashes people type or paste along
+// with a code.
+func normalizeCode(code string) string {
+	return strings.Map(func(r rune) rune {
+		if r == ' ' || r == '-' {
+			return -1
+		}
+		return r
+	}, strings.ToLower(strings.TrimSpace(code)))
+}
+
+// queryer is what twoFactorOn needs from a database or transaction.
+type queryer interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+func twoFactorOn(db queryer, userID int) (bool, error) {
+	var on bool
+	err := db.QueryRow("SELECT totp_enabled_at IS NOT NULL FROM users WHERE id = ?", userID).Scan(&on)
+	if err != nil {
+		return false, fmt.Errorf("error checking two-factor login: %w", err)
+	}
+	return on, nil
+}
@@ -0,0 +1,127 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"forum-go/model"
+	"sort"
+)
+
+// DefaultMaxCommentDepth is how deeply replies nest before they are shown
+// flat under the deepest allowed ancestor.
+const DefaultMaxCommentDepth = 5
+
+// ErrParentNotFound is returned when a reply targets a comment that does not
+// exist on the same post.
+var ErrParentNotFound = errors.New("parent comment not found on this post")
+
+// ErrPostLocked is returned when commenting on a thread a moderator locked.
+var ErrPostLocked = errors.New("this thread is locked")
+
+// CreateComment stores a comment on postID. parentID is 0 for a top-level
+// comment; otherwise it must be a live comment on the same post.
+func (s *Store) CreateComment(postID, userID, parentID int, content string) (int64, error) {
+	// Deleted and hidden posts no longer take comments; sql.ErrNoRows tells
+	// the caller the post is gone.
+	var locked bool
+	err := s.db.QueryRow(
+		"SELECT locked_at IS NOT NULL FROM posts WHERE id = ? AND deleted_at IS NULL AND hidden_at IS NULL", postID,
+	).Scan(&locked)
+	if err != nil {
+		return 0, err
+	}
+	if locked {
+		return 0, ErrPostLocked
+	}
+
+	var parent interface{}
+	if parentID != 0 {
+		var parentPostID int
+		err := s.db.QueryRow("SELECT post_id FROM comments WHERE id = ? AND deleted_at IS NULL AND hidden_at IS NULL", parentID).Scan(&parentPostID)
+		if err == sql.ErrNoRows || (err == nil && parentPostID != postID) {
+			return 0, ErrParentNotFound
+		}
+		if err != nil {
+			return 0, fmt.Errorf("error checking parent comment: %w", err)
+		}
+		parent = parentID
+	}
+
+	result, err := s.db.Exec(
+		"INSERT INTO comments (post_id, user_id, parent_id, content) VALUES (?, ?, ?, ?)",
+		postID, userID, parent, content,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("error inserting comment: %w", err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// FetchCommentTree returns the top-level comments of a post with their
+// replies nested underneath. Replies that would go deeper than maxDepth are
+// listed at maxDepth, after the comment they answer, so long threads stay
+// readable.
+func (s *Store) FetchCommentTree(postID, maxDepth int) ([]model.Comment, error) {
+	flat, err := s.FetchCommentsByPostID(postID)
+	if err != nil {
+		return nil, err
+	}
+	return BuildCommentTree(flat, maxDepth), nil
+}
+
+// BuildCommentTree nests a post's flat list of comments the way
+// FetchCommentTree returns them, for stores that load the list themselves.
+func BuildCommentTree(flat []model.Comment, maxDepth int) []model.Comment {
+	// Parents always have lower IDs than their replies
+	sorted := make([]model.Comment, len(flat))
+	copy(sorted, flat)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].ID < sorted[j].ID })
+
+	byID := make(map[int]model.Comment, len(sorted))
+	depth := make(map[int]int, len(sorted))
+	anchor := make(map[int]int, len(sorted)) // effective parent after capping, 0 for roots
+	children := make(map[int][]int)
+	var roots []int
+
+	for _, c := range sorted {
+		byID[c.ID] = c
+
+		parent := c.ParentID
+		if _, ok := byID[parent]; !ok {
+			parent = 0
+		}
+		for parent != 0 && depth[parent] >= maxDepth {
+			parent = anchor[parent]
+		}
+
+		anchor[c.ID] = parent
+		if parent == 0 {
+			roots = append(roots, c.ID)
+			continue
+		}
+		depth[c.ID] = depth[parent] + 1
+		children[parent] = append(children[parent], c.ID)
+	}
+
+	var build func(id int) model.Comment
+	build = func(id int) model.Comment {
+		c := byID[id]
+		c.Depth = depth[id]
+		for _, child := range children[id] {
+			c.Replies = append(c.Replies, build(child))
+		}
+		return c
+	}
+
+	tree := make([]model.Comment, 0, len(roots))
+	for _, id := range roots {
+		tree = append(tree, build(id))
+	}
+	return tree
+}
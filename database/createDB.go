@@ -2,168 +2,107 @@ package database
 
 import (
 	"fmt"
+	"forum-go/model"
 )
 
-/* type DB struct {
-	*sql.DB
-} */
-
-func createTables() error {
-	// Create users table
-	_, err := DB.Exec(`
-        CREATE TABLE IF NOT EXISTS users (
-            id INTEGER PRIMARY KEY AUTOINCREMENT,
-            username TEXT UNIQUE NOT NULL,
-            email TEXT UNIQUE NOT NULL,
-            password_hash TEXT NOT NULL,
-            session_token TEXT UNIQUE,
-            session_expiry DATETIME,
-			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
-        )
-    `)
-	if err != nil {
-		return fmt.Errorf("error creating users table: %v", err)
-	}
-
-	// Create categories table
-	_, err = DB.Exec(`
-        CREATE TABLE IF NOT EXISTS categories (
-            id INTEGER PRIMARY KEY AUTOINCREMENT,
-            name TEXT UNIQUE NOT NULL,
-            emoji TEXT UNIQUE NOT NULL,
-            UNIQUE(name,emoji)
-
-        )
-    `)
-	if err != nil {
-		return fmt.Errorf("error creating categories table: %v", err)
-	}
+// SeedUser is an account every new forum starts with.
+type SeedUser struct {
+	Username     string
+	Email        string
+	PasswordHash string
+	Role         string
+}
 
-	// Create posts table
-	_, err = DB.Exec(`
-        CREATE TABLE IF NOT EXISTS posts (
-            id INTEGER PRIMARY KEY AUTOINCREMENT,
-            title TEXT NOT NULL UNIQUE,
-            content TEXT NOT NULL,
-            user_id  INTEGER NOT NULL,
-            categories TEXT NOT NULL,
-            created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-            updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-            FOREIGN KEY(user_id) REFERENCES users(id)
-        )
-    `)
-	if err != nil {
-		return fmt.Errorf("error creating posts table: %v", err)
-	}
+// SeedPost is a post every new forum starts with, linked to its categories
+// by name.
+type SeedPost struct {
+	Title      string
+	Content    string
+	Author     string
+	Categories []string
+}
 
-	// Create comments table
-	_, err = DB.Exec(`
-        CREATE TABLE IF NOT EXISTS comments (
-            id INTEGER PRIMARY KEY AUTOINCREMENT,
-            content TEXT NOT NULL,
-            user_id INTEGER NOT NULL,
-            post_id INTEGER NOT NULL,
-            created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-            FOREIGN KEY(user_id) REFERENCES users(id),
-            FOREIGN KEY(post_id) REFERENCES posts(id)
-        )
-    `)
-	if err != nil {
-		return fmt.Errorf("error creating comments table: %v", err)
-	}
+// SeedAdminHash is the bcrypt hash of "admin", the password of the seeded
+// admin account. The account is a plain user; administrators are made with
+// the promote command.
+const SeedAdminHash = "$2a$10$ryPUUMn0CPeuNh.NpQZOwuyoymt1sdzXrePhSeYArwv9puWlg1mF2"
 
-	// Create posts_categories table
-	_, err = DB.Exec(`
-        CREATE TABLE IF NOT EXISTS posts_categories (
-            id INTEGER PRIMARY KEY AUTOINCREMENT,
-            post_id INTEGER NOT NULL,
-            categories TEXT NOT NULL,
-            created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-            FOREIGN KEY (post_id) REFERENCES posts(id) ON DELETE CASCADE,
-            UNIQUE (post_id, categories)
-        )
-    `)
-	if err != nil {
-		return fmt.Errorf("error creating posts_categories table: %v", err)
+// SeedCategories, SeedUsers and SeedPosts are inserted on every start;
+// rows that already exist are left alone. The seeded accounts have
+// published passwords, so none of them gets a privileged role.
+var (
+	SeedCategories = []model.Category{
+		{Name: "Action", Emoji: "💥"}, {Name: "Adventure", Emoji: "🌄"}, {Name: "Animation", Emoji: "🧚"},
+		{Name: "Biography", Emoji: "📚"}, {Name: "Comedy", Emoji: "😂"}, {Name: "Crime", Emoji: "🕵️"}, {Name: "Documentary", Emoji: "🎥"},
+		{Name: "Drama", Emoji: "🎭"}, {Name: "Fantasy", Emoji: "🧙"}, {Name: "Horror", Emoji: "👻"}, {Name: "Mystery", Emoji: "🔍"},
+		{Name: "Romance", Emoji: "❤️"}, {Name: "Sci-Fi", Emoji: "🚀"}, {Name: "Thriller", Emoji: "😱"}, {Name: "Western", Emoji: "🤠"},
 	}
 
-	_, err = DB.Exec(`
-        CREATE TABLE IF NOT EXISTS sessions (
-            id INTEGER PRIMARY KEY AUTOINCREMENT,
-            user_id INTEGER NOT NULL,
-            session_token TEXT NOT NULL UNIQUE,
-            session_expiry DATETIME NOT NULL,
-            FOREIGN KEY (user_id) REFERENCES users(id)
-        )
-    `)
-	if err != nil {
-		return fmt.Errorf("error creating sessions table: %v", err)
+	SeedUsers = []SeedUser{
+		{"admin", "admin@admin.com", SeedAdminHash, model.RoleUser},
+		{"Mama", "mama@yahoo.com", "$2a$10$bfVNqrSBscGyfsGMSyEvaOCRbBbC54I2Lht5XuaBLiZKcdgoIRJQO", model.RoleUser},
+		{"batman", "batman@batman.com", "$2a$10$1ZAK4MxQuwCJZGqhpBBzPOMoDDeGob..uwEIIO9YsHpqx8qXPNH8u", model.RoleUser},
 	}
 
-	_, err = DB.Exec(`
-        CREATE TABLE IF NOT EXISTS votes (
-            id INTEGER PRIMARY KEY AUTOINCREMENT,
-            user_id INTEGER NOT NULL,
-            post_id INTEGER,
-            comment_id INTEGER,
-            vote INTEGER NOT NULL CHECK (vote IN (1, 0, -1)),
-            FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
-            FOREIGN KEY (post_id) REFERENCES posts(id) ON DELETE CASCADE,
-            FOREIGN KEY (comment_id) REFERENCES comments(id) ON DELETE CASCADE,
-            UNIQUE (user_id, post_id),
-            UNIQUE (user_id, comment_id)
-        )   
-    `)
-	if err != nil {
-		return fmt.Errorf("error creating sessions table: %v", err)
+	SeedPosts = []SeedPost{
+		{`The Thrilling Ride of "Quantum Horizon"`, "Quantum Horizon blends cutting-edge effects with a gripping narrative. The zero-gravity fights are breathtaking.", "Mama", []string{"Sci-Fi", "Action", "Thriller"}},
+		{"Laughing Through Time: A Hilarious Adventure", "A refreshing take on time-travel comedy. Clever writing and impeccable timing had me in stitches.", "admin", []string{"Comedy", "Adventure", "Sci-Fi"}},
+		{"Whispers of the West: A Haunting Frontier Tale", "This unconventional Western infuses horror into a frontier setting. Eerie atmosphere keeps viewers on edge.", "batman", []string{"Western", "Horror", "Mystery"}},
+		{"Brushstrokes of Genius: A Compelling Artist's Biography", "A meticulously crafted documentary about painter Isabella Rossi. Balances interviews with stunning visuals of her work.", "Mama", []string{"Documentary", "Biography"}},
 	}
+)
 
-	// Enable foreign key support
-	_, err = DB.Exec("PRAGMA foreign_keys = ON")
-	if err != nil {
-		return fmt.Errorf("error enabling foreign key support: %v", err)
+func (s *Store) insertCategories() error {
+	for _, c := range SeedCategories {
+		_, err := s.db.Exec("INSERT OR IGNORE INTO categories (name, emoji) VALUES (?, ?)", c.Name, c.Emoji)
+		if err != nil {
+			return fmt.Errorf("error inserting categories: %v", err)
+		}
 	}
-
 	return nil
 }
-
-func insertCategories() error {
-	_, err := DB.Exec(`
-    INSERT OR IGNORE INTO categories (name, emoji) VALUES
-    ('Action', '💥'), ('Adventure', '🌄'), ('Animation', '🧚'), 
-    ('Biography', '📚'), ('Comedy', '😂'), ('Crime', '🕵️'), ('Documentary', '🎥'), 
-    ('Drama', '🎭'), ('Fantasy', '🧙'), ('Horror', '👻'), ('Mystery', '🔍'), 
-    ('Romance', '❤️'), ('Sci-Fi', '🚀'), ('Thriller', '😱'), ('Western', '🤠')
-`)
-	if err != nil {
-		return fmt.Errorf("error inserting categories: %v", err)
+func (s *Store) insertUsers() error {
+	for _, u := range SeedUsers {
+		_, err := s.db.Exec(
+			"INSERT OR IGNORE INTO users (username, email, password_hash, role) VALUES (?, ?, ?, ?)",
+			u.Username, u.Email, u.PasswordHash, u.Role,
+		)
+		if err != nil {
+			return fmt.Errorf("error inserting users: %v", err)
+		}
 	}
 	return nil
 }
-func insertUsers() error {
-	_, err := DB.Exec(`
-    INSERT OR IGNORE INTO users (username, email, password_hash) VALUES
-    ('admin', 'admin@admin.com', '$2a$10$ryPUUMn0CPeuNh.NpQZOwuyoymt1sdzXrePhSeYArwv9puWlg1mF2'),
-    ('Mama', 'mama@yahoo.com', '$2a$10$bfVNqrSBscGyfsGMSyEvaOCRbBbC54I2Lht5XuaBLiZKcdgoIRJQO'),
-    ('batman', 'batman@batman.com', '$2a$10$1ZAK4MxQuwCJZGqhpBBzPOMoDDeGob..uwEIIO9YsHpqx8qXPNH8u')
-`)
 
-	if err != nil {
-		return fmt.Errorf("error inserting users: %v", err)
-	}
-	return nil
-}
-func insertPosts() error {
-	_, err := DB.Exec(`
-    INSERT OR IGNORE INTO posts (title, content, user_id, categories) VALUES
-    ('The Thrilling Ride of "Quantum Horizon"', 'Quantum Horizon blends cutting-edge effects with a gripping narrative. The zero-gravity fights are breathtaking.', 2, 'Sci-Fi,Action,Thriller'),
-    ('Laughing Through Time: A Hilarious Adventure', 'A refreshing take on time-travel comedy. Clever writing and impeccable timing had me in stitches.', 1, 'Comedy,Adventure,Sci-Fi'),
-    ('Whispers of the West: A Haunting Frontier Tale', 'This unconventional Western infuses horror into a frontier setting. Eerie atmosphere keeps viewers on edge.', 3, 'Western,Horror,Mystery'),
-    ('Brushstrokes of Genius: A Compelling Artist''s Biography', 'A meticulously crafted documentary about painter Isabella Rossi. Balances interviews with stunning visuals of her work.', 2, 'Documentary,Biography')
-`)
+func (s *Store) insertPosts() error {
+	for _, p := range SeedPosts {
+		result, err := s.db.Exec(
+			"INSERT OR IGNORE INTO posts (title, content, user_id) SELECT ?, ?, id FROM users WHERE username = ?",
+			p.Title, p.Content, p.Author,
+		)
+		if err != nil {
+			return fmt.Errorf("error inserting posts: %v", err)
+		}
+
+		// Already seeded on an earlier start
+		if n, err := result.RowsAffected(); err != nil || n == 0 {
+			continue
+		}
+
+		postID, err := result.LastInsertId()
+		if err != nil {
+			return fmt.Errorf("error getting seeded post ID: %v", err)
+		}
 
-	if err != nil {
-		return fmt.Errorf("error inserting posts: %v", err)
+		for _, name := range p.Categories {
+			_, err = s.db.Exec(`
+            INSERT OR IGNORE INTO posts_categories (post_id, category_id)
+            SELECT ?, id FROM categories WHERE name = ?
+            `, postID, name)
+			if err != nil {
+				return fmt.Errorf("error linking seeded post categories: %v", err)
+			}
+		}
 	}
 	return nil
 }
@@ -0,0 +1,258 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"forum-go/model"
+	"strings"
+)
+
+// ErrNotAuthor is returned when someone other than the author tries to edit
+// or delete a post or comment.
+var ErrNotAuthor = errors.New("only the author can change this")
+
+// ErrDuplicateTitle is returned when an edit would give a post the same
+// title as another post.
+var ErrDuplicateTitle = errors.New("a post with this title already exists")
+
+// CreatePost saves a new post with its categories and returns its ID.
+func (s *Store) CreatePost(post *model.Post) (int64, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := `
+        INSERT INTO posts (title, content, user_id, created_at, updated_at)
+        VALUES (?, ?, ?, ?, ?)
+    `
+	result, err := tx.Exec(query, post.Title, post.Content, post.UserID, post.CreatedAt, post.UpdatedAt)
+	if err != nil {
+		return 0, fmt.Errorf("error saving post: %w", err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("error getting last insert ID: %w", err)
+	}
+
+	for _, category := range post.Categories {
+		_, err = tx.Exec("INSERT INTO posts_categories (post_id, category_id) VALUES (?, ?)", id, category.ID)
+		if err != nil {
+			return 0, fmt.Errorf("error linking category %s: %w", category.Name, err)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, fmt.Errorf("error committing post: %w", err)
+	}
+
+	return id, nil
+}
+
+// UpdatePost replaces the title and content of a post and keeps the previous
+// version in post_revisions. Missing, deleted or hidden posts return
+// sql.ErrNoRows.
+func (s *Store) UpdatePost(postID, userID int, title, content string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var authorID int
+	var oldTitle, oldContent string
+	err = tx.QueryRow(
+		"SELECT user_id, title, content FROM posts WHERE id = ? AND deleted_at IS NULL AND hidden_at IS NULL", postID,
+	).Scan(&authorID, &oldTitle, &oldContent)
+	if err != nil {
+		return err
+	}
+	if authorID != userID {
+		return ErrNotAuthor
+	}
+	if title == oldTitle && content == oldContent {
+		return nil
+	}
+
+	_, err = tx.Exec(
+		"INSERT INTO post_revisions (post_id, title, content) VALUES (?, ?, ?)",
+		postID, oldTitle, oldContent,
+	)
+	if err != nil {
+		return fmt.Errorf("error saving post revision: %w", err)
+	}
+
+	_, err = tx.Exec(`
+        UPDATE posts
+        SET title = ?, content = ?, updated_at = CURRENT_TIMESTAMP, edited_at = CURRENT_TIMESTAMP
+        WHERE id = ?
+    `, title, content, postID)
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE constraint failed: posts.title") {
+			return ErrDuplicateTitle
+		}
+		return fmt.Errorf("error updating post: %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("error committing post edit: %w", err)
+	}
+	return nil
+}
+
+// DeletePost soft-deletes a post. It disappears from listings and search,
+// while its comments, votes and history stay in place.
+func (s *Store) DeletePost(postID, userID int) error {
+	return s.softDelete("posts", postID, userID)
+}
+
+// UpdateComment replaces the content of a comment and keeps the previous
+// version in comment_revisions.
+func (s *Store) UpdateComment(commentID, userID int, content string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var authorID int
+	var oldContent string
+	err = tx.QueryRow(
+		"SELECT user_id, content FROM comments WHERE id = ? AND deleted_at IS NULL AND hidden_at IS NULL", commentID,
+	).Scan(&authorID, &oldContent)
+	if err != nil {
+		return err
+	}
+	if authorID != userID {
+		return ErrNotAuthor
+	}
+	if content == oldContent {
+		return nil
+	}
+
+	_, err = tx.Exec(
+		"INSERT INTO comment_revisions (comment_id, content) VALUES (?, ?)",
+		commentID, oldContent,
+	)
+	if err != nil {
+		return fmt.Errorf("error saving comment revision: %w", err)
+	}
+
+	_, err = tx.Exec(
+		"UPDATE comments SET content = ?, edited_at = CURRENT_TIMESTAMP WHERE id = ?",
+		content, commentID,
+	)
+	if err != nil {
+		return fmt.Errorf("error updating comment: %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("error committing comment edit: %w", err)
+	}
+	return nil
+}
+
+// DeleteComment soft-deletes a comment. It is shown as "[deleted]" so its
+// replies keep their place in the thread and its votes still count.
+func (s *Store) DeleteComment(commentID, userID int) error {
+	return s.softDelete("comments", commentID, userID)
+}
+
+// FetchCommentByID returns a single comment that has not been deleted.
+// Hidden comments are returned with Hidden set.
+func (s *Store) FetchCommentByID(commentID int) (*model.Comment, error) {
+	var c model.Comment
+	var editedAt sql.NullTime
+	err := s.db.QueryRow(`
+        SELECT c.id, c.content, u.username, c.user_id, c.post_id,
+               COALESCE(c.parent_id, 0), c.created_at, c.edited_at, c.hidden_at IS NOT NULL
+        FROM comments c
+        JOIN users u ON c.user_id = u.id
+        WHERE c.id = ? AND c.deleted_at IS NULL
+    `, commentID).Scan(
+		&c.ID,
+		&c.Content,
+		&c.Author,
+		&c.UserID,
+		&c.PostID,
+		&c.ParentID,
+		&c.CreatedAt,
+		&editedAt,
+		&c.Hidden,
+	)
+	if err != nil {
+		return nil, err
+	}
+	c.EditedAt = editedAt.Time
+	return &c, nil
+}
+
+// FetchPostRevisions returns the earlier versions of a post, oldest first.
+func (s *Store) FetchPostRevisions(postID int) ([]model.Revision, error) {
+	rows, err := s.db.Query(
+		"SELECT id, title, content, created_at FROM post_revisions WHERE post_id = ? ORDER BY id ASC",
+		postID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error querying post revisions: %w", err)
+	}
+	defer rows.Close()
+
+	var revisions []model.Revision
+	for rows.Next() {
+		var rev model.Revision
+		if err := rows.Scan(&rev.ID, &rev.Title, &rev.Content, &rev.CreatedAt); err != nil {
+			return nil, fmt.Errorf("error scanning post revision: %w", err)
+		}
+		revisions = append(revisions, rev)
+	}
+	return revisions, rows.Err()
+}
+
+// FetchCommentRevisions returns the earlier versions of a comment, oldest first.
+func (s *Store) FetchCommentRevisions(commentID int) ([]model.Revision, error) {
+	rows, err := s.db.Query(
+		"SELECT id, content, created_at FROM comment_revisions WHERE comment_id = ? ORDER BY id ASC",
+		commentID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error querying comment revisions: %w", err)
+	}
+	defer rows.Close()
+
+	var revisions []model.Revision
+	for rows.Next() {
+		var rev model.Revision
+		if err := rows.Scan(&rev.ID, &rev.Content, &rev.CreatedAt); err != nil {
+			return nil, fmt.Errorf("error scanning comment revision: %w", err)
+		}
+		revisions = append(revisions, rev)
+	}
+	return revisions, rows.Err()
+}
+
+// -- Non-Global Functions : Only happens in this package -- //
+
+// softDelete marks a row of posts or comments as deleted after checking
+// that userID wrote it. table is never user input.
+func (s *Store) softDelete(table string, id, userID int) error {
+	var authorID int
+	err := s.db.QueryRow(
+		"SELECT user_id FROM "+table+" WHERE id = ? AND deleted_at IS NULL", id,
+	).Scan(&authorID)
+	if err != nil {
+		return err
+	}
+	if authorID != userID {
+		return ErrNotAuthor
+	}
+
+	_, err = s.db.Exec("UPDATE "+table+" SET deleted_at = CURRENT_TIMESTAMP WHERE id = ?", id)
+	if err != nil {
+		return fmt.Errorf("error deleting from %s: %w", table, err)
+	}
+	return nil
+}
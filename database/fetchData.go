@@ -2,65 +2,23 @@ package database
 
 import (
 	"database/sql"
-	"errors"
 	"fmt"
 	"forum-go/model"
+	"strings"
 )
 
-func FetchPosts() ([]model.Post, error) {
-
-	if DB == nil {
-		return nil, errors.New("database connection is nil")
-	}
-
-	query := `
-    SELECT p.id, u.username, p.title, p.content, p.user_id,
-			p.categories, p.created_at, p.updated_at,
-		    COALESCE(SUM(CASE WHEN v.vote = 1 THEN 1 ELSE 0 END), 0) AS upvotes,
-            COALESCE(SUM(CASE WHEN v.vote = -1 THEN 1 ELSE 0 END), 0) AS downvotes
-    FROM posts p
-    JOIN users u ON p.user_id = u.id
-	LEFT JOIN votes v ON p.id = v.post_id
-    GROUP BY p.id
-    ORDER BY p.created_at ASC
-    LIMIT 10
-`
-
-	rows, err := DB.Query(query)
+// FetchPosts returns the first page of the newest posts.
+func (s *Store) FetchPosts() ([]model.Post, error) {
+	page, err := s.FetchPostPage(PostListOptions{Sort: SortNewest})
 	if err != nil {
-		return nil, fmt.Errorf("error querying posts: %w", err)
-	}
-	defer rows.Close()
-
-	var posts []model.Post
-	for rows.Next() {
-		var p model.Post
-		err := rows.Scan(
-			&p.ID,
-			&p.Author,
-			&p.Title,
-			&p.Content,
-			&p.UserID,
-			&p.Categories,
-			&p.CreatedAt,
-			&p.UpdatedAt,
-			&p.Upvotes,
-			&p.Downvotes,
-		)
-		if err != nil {
-			return nil, fmt.Errorf("error querying posts: %w", err)
-		}
-		posts = append(posts, p)
-	}
-	if err = rows.Err(); err != nil {
-		return nil, fmt.Errorf("error after scanning posts: %w", err)
+		return nil, err
 	}
-	return posts, nil
+	return page.Posts, nil
 }
 
-func FetchCategories() ([]model.Category, error) {
+func (s *Store) FetchCategories() ([]model.Category, error) {
 	query := "SELECT id, name, emoji FROM categories ORDER BY name ASC"
-	rows, err := DB.Query(query)
+	rows, err := s.db.Query(query)
 	if err != nil {
 		return nil, err
 	}
@@ -78,9 +36,13 @@ func FetchCategories() ([]model.Category, error) {
 	return categories, nil
 }
 
-func FetchCommentsByPostID(postID int) ([]model.Comment, error) {
+func (s *Store) FetchCommentsByPostID(postID int) ([]model.Comment, error) {
 	query := `
-        SELECT c.id, c.content, u.username, c.user_id, c.post_id, c.created_at,
+        SELECT c.id,
+                CASE WHEN c.deleted_at IS NULL THEN c.content ELSE '' END,
+                CASE WHEN c.deleted_at IS NULL THEN u.username ELSE '' END,
+                c.user_id, c.post_id, COALESCE(c.parent_id, 0), c.created_at,
+                c.edited_at, c.deleted_at IS NOT NULL, c.hidden_at IS NOT NULL,
                 COALESCE(SUM(CASE WHEN v.vote = 1 THEN 1 ELSE 0 END), 0) AS upvotes,
                 COALESCE(SUM(CASE WHEN v.vote = -1 THEN 1 ELSE 0 END), 0) AS downvotes
         FROM comments c
@@ -91,7 +53,7 @@ func FetchCommentsByPostID(postID int) ([]model.Comment, error) {
         ORDER BY c.created_at ASC
     `
 
-	rows, err := DB.Query(query, postID)
+	rows, err := s.db.Query(query, postID)
 	if err != nil {
 		return nil, fmt.Errorf("error querying comments: %w", err)
 	}
@@ -100,19 +62,25 @@ func FetchCommentsByPostID(postID int) ([]model.Comment, error) {
 	var comments []model.Comment
 	for rows.Next() {
 		var c model.Comment
+		var editedAt sql.NullTime
 		err := rows.Scan(
 			&c.ID,
 			&c.Content,
 			&c.Author,
 			&c.UserID,
 			&c.PostID,
+			&c.ParentID,
 			&c.CreatedAt,
+			&editedAt,
+			&c.Deleted,
+			&c.Hidden,
 			&c.Upvotes,
 			&c.Downvotes,
 		)
 		if err != nil {
 			return nil, fmt.Errorf("error scanning comment row: %w", err)
 		}
+		c.EditedAt = editedAt.Time
 		comments = append(comments, c)
 	}
 
@@ -123,38 +91,47 @@ func FetchCommentsByPostID(postID int) ([]model.Comment, error) {
 	return comments, nil
 }
 
-func FetchPostByID(postID int) (*model.Post, error) {
+func (s *Store) FetchPostByID(postID int) (*model.Post, error) {
 	query := `
-        SELECT p.id, u.username, p.title, p.content, p.user_id, p.categories, 
-               p.created_at, p.updated_at,
+        SELECT p.id, u.username, p.title, p.content, p.user_id,
+               p.created_at, p.updated_at, p.edited_at,
+               p.hidden_at IS NOT NULL, p.locked_at IS NOT NULL,
 			   COALESCE(SUM(CASE WHEN v.vote = 1 THEN 1 ELSE 0 END), 0) AS upvotes,
                COALESCE(SUM(CASE WHEN v.vote = -1 THEN 1 ELSE 0 END), 0) AS downvotes
         FROM posts p
         JOIN users u ON p.user_id = u.id
 		LEFT JOIN votes v ON p.id = v.post_id
-        WHERE p.id = ?
+        WHERE p.id = ? AND p.deleted_at IS NULL
 		GROUP BY p.id
     `
 
 	var post model.Post
-	err := DB.QueryRow(query, postID).Scan(
+	var editedAt sql.NullTime
+	err := s.db.QueryRow(query, postID).Scan(
 		&post.ID,
 		&post.Author,
 		&post.Title,
 		&post.Content,
 		&post.UserID,
-		&post.Categories,
 		&post.CreatedAt,
 		&post.UpdatedAt,
+		&editedAt,
+		&post.Hidden,
+		&post.Locked,
 		&post.Upvotes,
 		&post.Downvotes,
 	)
 	if err != nil {
 		return nil, err
 	}
+	post.EditedAt = editedAt.Time
+
+	if err = s.attachCategories([]*model.Post{&post}); err != nil {
+		return nil, err
+	}
 
 	// Fetch comments for this post
-	comments, err := FetchCommentsByPostID(postID)
+	comments, err := s.FetchCommentsByPostID(postID)
 	if err != nil {
 		return nil, fmt.Errorf("error fetching comments: %w", err)
 	}
@@ -164,37 +141,66 @@ func FetchPostByID(postID int) (*model.Post, error) {
 }
 
 // Add this function to fetch user data by ID
-func FetchUserById(userID int) (*model.User, error) {
+func (s *Store) FetchUserById(userID int) (*model.User, error) {
 	var user model.User
-	err := DB.QueryRow(
-		"SELECT id, username, email, session_token, session_expiry, created_at FROM users WHERE id = ?", userID).Scan(
+	var bannedAt, verifiedAt, twoFactorAt sql.NullTime
+	err := s.db.QueryRow(`
+        SELECT id, username, email, role, banned_at, email_verified_at, totp_enabled_at, created_at,
+               (SELECT COUNT(*) FROM notifications n WHERE n.user_id = users.id AND n.read_at IS NULL),
+               (SELECT COUNT(*) FROM messages m WHERE m.recipient_id = users.id AND m.read_at IS NULL)
+        FROM users WHERE id = ?`, userID).Scan(
 		&user.ID,
 		&user.Username,
 		&user.Email,
-		&user.SessionToken,
-		&user.SessionExpiry,
-		&user.CreatedAt)
+		&user.Role,
+		&bannedAt,
+		&verifiedAt,
+		&twoFactorAt,
+		&user.CreatedAt,
+		&user.UnreadCount,
+		&user.UnreadMessages)
 	if err != nil {
 		return nil, err
 	}
+	user.BannedAt = bannedAt.Time
+	user.EmailVerifiedAt = verifiedAt.Time
+	user.TwoFactorAt = twoFactorAt.Time
 	return &user, nil
 }
 
-func FetchPostsByUserID(userID int) ([]*model.Post, error) {
+// FetchUserByEmail looks up an account by its email address, ignoring
+// case.
+func (s *Store) FetchUserByEmail(email string) (*model.User, error) {
+	var id int
+	err := s.db.QueryRow("SELECT id FROM users WHERE email = ? COLLATE NOCASE", email).Scan(&id)
+	if err != nil {
+		return nil, err
+	}
+	return s.FetchUserById(id)
+}
+
+// FetchUserIDByUsername looks up an account by its exact username.
+func (s *Store) FetchUserIDByUsername(username string) (int, error) {
+	var id int
+	err := s.db.QueryRow("SELECT id FROM users WHERE username = ?", username).Scan(&id)
+	return id, err
+}
+
+func (s *Store) FetchPostsByUserID(userID int) ([]*model.Post, error) {
 	query := `
-        SELECT p.id, u.username, p.title, p.content, p.categories, 
+        SELECT p.id, u.username, p.title, p.content, p.user_id,
                p.created_at, p.updated_at,
                COALESCE(SUM(CASE WHEN v.vote = 1 THEN 1 ELSE 0 END), 0) AS upvotes,
                COALESCE(SUM(CASE WHEN v.vote = -1 THEN 1 ELSE 0 END), 0) AS downvotes
         FROM posts p
         JOIN users u ON p.user_id = u.id
         LEFT JOIN votes v ON p.id = v.post_id
-        WHERE p.user_id = ?
+        WHERE p.user_id = ? AND p.deleted_at IS NULL AND p.hidden_at IS NULL
         GROUP BY p.id
         ORDER BY p.created_at DESC
     `
 
-	rows, err := DB.Query(query, userID)
+	rows, err := s.db.Query(query, userID)
 	if err != nil {
 		return nil, fmt.Errorf("error querying user posts: %w", err)
 	}
@@ -208,7 +214,7 @@ func FetchPostsByUserID(userID int) ([]*model.Post, error) {
 			&post.Author,
 			&post.Title,
 			&post.Content,
-			&post.Categories,
+			&post.UserID,
 			&post.CreatedAt,
 			&post.UpdatedAt,
 			&post.Upvotes,
@@ -219,25 +225,29 @@ func FetchPostsByUserID(userID int) ([]*model.Post, error) {
 		}
 		posts = append(posts, &post)
 	}
+	rows.Close()
 
+	if err = s.attachCategories(posts); err != nil {
+		return nil, err
+	}
 	return posts, nil
 }
 
-func FetchLikedPostsByUserID(userID int) ([]*model.Post, error) {
+func (s *Store) FetchLikedPostsByUserID(userID int) ([]*model.Post, error) {
 	query := `
-        SELECT p.id, u.username, p.title, p.content, p.categories, 
+        SELECT p.id, u.username, p.title, p.content, p.user_id,
                p.created_at, p.updated_at,
                COALESCE(SUM(CASE WHEN v.vote = 1 THEN 1 ELSE 0 END), 0) AS upvotes,
                COALESCE(SUM(CASE WHEN v.vote = -1 THEN 1 ELSE 0 END), 0) AS downvotes
         FROM posts p
         JOIN votes v ON p.id = v.post_id
         JOIN users u ON p.user_id = u.id
-        WHERE v.user_id = ? AND v.vote = 1
+        WHERE v.user_id = ? AND v.vote = 1 AND p.deleted_at IS NULL AND p.hidden_at IS NULL
         GROUP BY p.id
         ORDER BY p.created_at DESC
     `
 
-	rows, err := DB.Query(query, userID)
+	rows, err := s.db.Query(query, userID)
 	if err != nil {
 		return nil, fmt.Errorf("error querying liked posts: %w", err)
 	}
@@ -251,7 +261,7 @@ func FetchLikedPostsByUserID(userID int) ([]*model.Post, error) {
 			&p.Author,
 			&p.Title,
 			&p.Content,
-			&p.Categories,
+			&p.UserID,
 			&p.CreatedAt,
 			&p.UpdatedAt,
 			&p.Upvotes,
@@ -262,25 +272,29 @@ func FetchLikedPostsByUserID(userID int) ([]*model.Post, error) {
 		}
 		likedPosts = append(likedPosts, &p)
 	}
+	rows.Close()
 
+	if err = s.attachCategories(likedPosts); err != nil {
+		return nil, err
+	}
 	return likedPosts, nil
 }
 
-func FetchDislikedPostsByUserID(userID int) ([]*model.Post, error) {
+func (s *Store) FetchDislikedPostsByUserID(userID int) ([]*model.Post, error) {
 	query := `
-        SELECT p.id, u.username, p.title, p.content, p.categories, 
+        SELECT p.id, u.username, p.title, p.content, p.user_id,
                p.created_at, p.updated_at,
                COALESCE(SUM(CASE WHEN v.vote = 1 THEN 1 ELSE 0 END), 0) AS upvotes,
                COALESCE(SUM(CASE WHEN v.vote = -1 THEN 1 ELSE 0 END), 0) AS downvotes
         FROM posts p
         JOIN votes v ON p.id = v.post_id
         JOIN users u ON p.user_id = u.id
-        WHERE v.user_id = ? AND v.vote = -1
+        WHERE v.user_id = ? AND v.vote = -1 AND p.deleted_at IS NULL AND p.hidden_at IS NULL
         GROUP BY p.id
         ORDER BY p.created_at DESC
     `
 
-	rows, err := DB.Query(query, userID)
+	rows, err := s.db.Query(query, userID)
 	if err != nil {
 		return nil, fmt.Errorf("error querying disliked posts: %w", err)
 	}
@@ -294,7 +308,7 @@ func FetchDislikedPostsByUserID(userID int) ([]*model.Post, error) {
 			&p.Author,
 			&p.Title,
 			&p.Content,
-			&p.Categories,
+			&p.UserID,
 			&p.CreatedAt,
 			&p.UpdatedAt,
 			&p.Upvotes,
@@ -305,62 +319,31 @@ func FetchDislikedPostsByUserID(userID int) ([]*model.Post, error) {
 		}
 		dislikedPosts = append(dislikedPosts, &p)
 	}
+	rows.Close()
 
+	if err = s.attachCategories(dislikedPosts); err != nil {
+		return nil, err
+	}
 	return dislikedPosts, nil
 }
 
-func FetchPostsByCategory(category string) ([]model.Post, error) {
-	query := `
-        SELECT p.id, u.username, p.title, p.content, p.user_id,
-               p.categories, p.created_at, p.updated_at,
-               COALESCE(SUM(CASE WHEN v.vote = 1 THEN 1 ELSE 0 END), 0) AS upvotes,
-               COALESCE(SUM(CASE WHEN v.vote = -1 THEN 1 ELSE 0 END), 0) AS downvotes
-        FROM posts p
-        JOIN users u ON p.user_id = u.id
-        LEFT JOIN votes v ON p.id = v.post_id
-        WHERE p.categories LIKE ?
-        GROUP BY p.id
-        ORDER BY p.created_at DESC
-    `
-
-	rows, err := DB.Query(query, "%"+category+"%")
+// FetchPostsByCategory returns the first page of the newest posts in category.
+func (s *Store) FetchPostsByCategory(category string) ([]model.Post, error) {
+	page, err := s.FetchPostPage(PostListOptions{Category: category, Sort: SortNewest})
 	if err != nil {
 		return nil, fmt.Errorf("error querying posts by category: %w", err)
 	}
-	defer rows.Close()
-
-	var posts []model.Post
-	for rows.Next() {
-		var p model.Post
-		err := rows.Scan(
-			&p.ID,
-			&p.Author,
-			&p.Title,
-			&p.Content,
-			&p.UserID,
-			&p.Categories,
-			&p.CreatedAt,
-			&p.UpdatedAt,
-			&p.Upvotes,
-			&p.Downvotes,
-		)
-		if err != nil {
-			return nil, fmt.Errorf("error scanning post: %w", err)
-		}
-		posts = append(posts, p)
-	}
-
-	return posts, nil
+	return page.Posts, nil
 }
 
-func UpdateVote(userID, postID, voteValue int) error {
+func (s *Store) UpdateVote(userID, postID, voteValue int) error {
 	// Check if the user has already voted on this post
 	var existingVote int
-	err := DB.QueryRow("SELECT vote FROM votes WHERE user_id = ? AND post_id = ?", userID, postID).Scan(&existingVote)
+	err := s.db.QueryRow("SELECT vote FROM votes WHERE user_id = ? AND post_id = ?", userID, postID).Scan(&existingVote)
 
 	if err == sql.ErrNoRows {
 		// User hasn't voted yet → Insert new vote
-		_, err = DB.Exec("INSERT INTO votes (user_id, post_id, vote) VALUES (?, ?, ?)", userID, postID, voteValue)
+		_, err = s.db.Exec("INSERT INTO votes (user_id, post_id, vote) VALUES (?, ?, ?)", userID, postID, voteValue)
 		return err
 	} else if err != nil {
 		return err // Unexpected database error
@@ -368,7 +351,59 @@ func UpdateVote(userID, postID, voteValue int) error {
 
 	// If the vote is different, update it
 	if existingVote != voteValue {
-		_, err = DB.Exec("UPDATE votes SET vote = ? WHERE user_id = ? AND post_id = ?", voteValue, userID, postID)
+		_, err = s.db.Exec("UPDATE votes SET vote = ? WHERE user_id = ? AND post_id = ?", voteValue, userID, postID)
 	}
 	return err
 }
+
+// attachCategories fills Categories on each post with a single query through
+// posts_categories. Callers must have closed their own rows first so the
+// lookup does not need a second connection.
+func (s *Store) attachCategories(posts []*model.Post) error {
+	if len(posts) == 0 {
+		return nil
+	}
+
+	byID := make(map[int]*model.Post, len(posts))
+	placeholders := make([]string, 0, len(posts))
+	args := make([]interface{}, 0, len(posts))
+	for _, p := range posts {
+		byID[p.ID] = p
+		placeholders = append(placeholders, "?")
+		args = append(args, p.ID)
+	}
+
+	query := `
+        SELECT pc.post_id, c.id, c.name, c.emoji
+        FROM posts_categories pc
+        JOIN categories c ON c.id = pc.category_id
+        WHERE pc.post_id IN (` + strings.Join(placeholders, ", ") + `)
+        ORDER BY c.name ASC
+    `
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return fmt.Errorf("error querying post categories: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var postID int
+		var c model.Category
+		if err := rows.Scan(&postID, &c.ID, &c.Name, &c.Emoji); err != nil {
+			return fmt.Errorf("error scanning post category: %w", err)
+		}
+		if p, ok := byID[postID]; ok {
+			p.Categories = append(p.Categories, c)
+		}
+	}
+	return rows.Err()
+}
+
+func postPointers(posts []model.Post) []*model.Post {
+	ptrs := make([]*model.Post, len(posts))
+	for i := range posts {
+		ptrs[i] = &posts[i]
+	}
+	return ptrs
+}
@@ -4,69 +4,114 @@ import (
 	"database/sql"
 	"fmt"
 	"log"
-	"os"
+	"strings"
 )
 
-var DB *sql.DB
+// Store is the forum's SQLite database; every query is one of its methods.
+// Handlers reach it through the narrower PostStore, CommentStore,
+// UserStore, SessionStore and VoteStore interfaces where they can.
+type Store struct {
+	db       *sql.DB
+	sessions SessionOptions
+}
 
-func InitDB() error {
+// NewStore wraps an open database without touching its schema.
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db, sessions: DefaultSessionOptions}
+}
 
-	var err error
+// InitDB opens the SQLite database at path, brings its schema up to date
+// and seeds it.
+func InitDB(path string) (*Store, error) {
 
-	if DB == nil {
-		dbPath := os.Getenv("DB_PATH")
-		if dbPath == "" {
-			dbPath = "reeltalk.db"
-		}
-		DB, err = sql.Open("sqlite3", dbPath)
-		if err != nil {
-			return fmt.Errorf("error opening database: %v", err)
-		}
-		log.Println("Database connection opened successfully")
+	s, err := OpenDB(path)
+	if err != nil {
+		return nil, err
 	}
 
-	// Test the connection
-	if err = DB.Ping(); err != nil {
-		return fmt.Errorf("error pinging database: %v", err)
+	if err = s.Init(); err != nil {
+		s.Close()
+		return nil, err
 	}
-	log.Println("Database pinged successfully")
+	return s, nil
+}
+
+// Init brings the schema of an open database up to date and seeds it.
+func (s *Store) Init() error {
 
-	if err = createTables(); err != nil {
-		return fmt.Errorf("error creating tables: %v", err)
+	err := s.MigrateUp()
+	if err != nil {
+		return fmt.Errorf("error running migrations: %v", err)
 	}
 
-	if err = insertCategories(); err != nil {
+	if err = s.insertCategories(); err != nil {
 		return fmt.Errorf("error inserting categories data: %v", err)
 	}
 
-	if err = insertUsers(); err != nil {
+	if err = s.insertUsers(); err != nil {
 		return fmt.Errorf("error inserting users data: %v", err)
 	}
 
-	if err = insertPosts(); err != nil {
+	if err = s.insertPosts(); err != nil {
 		return fmt.Errorf("error inserting users data: %v", err)
 	}
 
-	if err = verifyData(); err != nil {
+	if err = s.verifyData(); err != nil {
 		return fmt.Errorf("error verifying data: %v", err)
 	}
 
-	// Execute schema
-	_, err = DB.Exec(`PRAGMA foreign_keys = ON`)
+	log.Println("Database initialization completed")
+	return nil
+}
+
+// OpenDB opens and pings the database at path without touching the
+// schema, so the migrate subcommand can inspect or roll back versions on
+// its own.
+func OpenDB(path string) (*Store, error) {
+
+	db, err := sql.Open("sqlite3", DSN(path))
 	if err != nil {
-		return fmt.Errorf("error enabling foreign keys: %v", err)
+		return nil, fmt.Errorf("error opening database: %v", err)
 	}
+	log.Println("Database connection opened successfully")
 
-	log.Println("Database initialization completed")
-	return nil
+	// Test the connection
+	if err = db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error pinging database: %v", err)
+	}
+	log.Println("Database pinged successfully")
+
+	return NewStore(db), nil
+}
+
+// DSN is the data source name for the SQLite database at path. Foreign
+// keys are switched on through it, since SQLite enforces them per
+// connection and the pool opens new connections as it needs them.
+func DSN(path string) string {
+	sep := "?"
+	if strings.Contains(path, "?") {
+		sep = "&"
+	}
+	return path + sep + "_foreign_keys=on"
+}
+
+// DB returns the connection, for the auth functions that take one.
+func (s *Store) DB() *sql.DB {
+	return s.db
+}
+
+// Close closes the database.
+func (s *Store) Close() error {
+	return s.db.Close()
 }
 
-func verifyData() error {
+func (s *Store) verifyData() error {
 
 	tables := []string{"categories", "users"}
 	for _, table := range tables {
 		var count int
-		err := DB.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM %s", table)).Scan(&count)
+		err := s.db.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM %s", table)).Scan(&count)
 		if err != nil {
 			return fmt.Errorf("error counting rows in %s: %v", table, err)
 		}
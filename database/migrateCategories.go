@@ -0,0 +1,117 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// normalizePostCategories turns the comma-joined posts.categories strings into
+// posts_categories rows keyed to categories.id, then drops the old column.
+// Seed data joined names with "," while NewPostHandler stored "<emoji> <name>"
+// joined with ", ", so both forms are recognised.
+func normalizePostCategories(tx *sql.Tx) error {
+	_, err := tx.Exec(`DROP TABLE IF EXISTS posts_categories`)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+        CREATE TABLE posts_categories (
+            post_id INTEGER NOT NULL,
+            category_id INTEGER NOT NULL,
+            PRIMARY KEY (post_id, category_id),
+            FOREIGN KEY (post_id) REFERENCES posts(id) ON DELETE CASCADE,
+            FOREIGN KEY (category_id) REFERENCES categories(id) ON DELETE CASCADE
+        )
+    `)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`CREATE INDEX posts_categories_category_id ON posts_categories(category_id)`)
+	if err != nil {
+		return err
+	}
+
+	categoryIDs, err := categoryIDsByName(tx)
+	if err != nil {
+		return err
+	}
+
+	links, err := legacyPostCategories(tx)
+	if err != nil {
+		return err
+	}
+
+	for postID, raw := range links {
+		for _, token := range strings.Split(raw, ",") {
+			categoryID, ok := matchCategory(categoryIDs, token)
+			if !ok {
+				if strings.TrimSpace(token) != "" {
+					log.Printf("Post %d: dropping unknown category %q", postID, strings.TrimSpace(token))
+				}
+				continue
+			}
+			_, err = tx.Exec("INSERT OR IGNORE INTO posts_categories (post_id, category_id) VALUES (?, ?)", postID, categoryID)
+			if err != nil {
+				return fmt.Errorf("error linking post %d to category %d: %w", postID, categoryID, err)
+			}
+		}
+	}
+
+	_, err = tx.Exec(`ALTER TABLE posts DROP COLUMN categories`)
+	return err
+}
+
+func categoryIDsByName(tx *sql.Tx) (map[string]int, error) {
+	rows, err := tx.Query("SELECT id, name FROM categories")
+	if err != nil {
+		return nil, fmt.Errorf("error querying categories: %w", err)
+	}
+	defer rows.Close()
+
+	ids := make(map[string]int)
+	for rows.Next() {
+		var id int
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			return nil, fmt.Errorf("error scanning category: %w", err)
+		}
+		ids[strings.ToLower(name)] = id
+	}
+	return ids, rows.Err()
+}
+
+func legacyPostCategories(tx *sql.Tx) (map[int]string, error) {
+	rows, err := tx.Query("SELECT id, categories FROM posts")
+	if err != nil {
+		return nil, fmt.Errorf("error querying post categories: %w", err)
+	}
+	defer rows.Close()
+
+	links := make(map[int]string)
+	for rows.Next() {
+		var id int
+		var categories string
+		if err := rows.Scan(&id, &categories); err != nil {
+			return nil, fmt.Errorf("error scanning post categories: %w", err)
+		}
+		links[id] = categories
+	}
+	return links, rows.Err()
+}
+
+// matchCategory accepts a bare name ("Action") or an emoji-prefixed one ("💥 Action").
+func matchCategory(ids map[string]int, token string) (int, bool) {
+	token = strings.ToLower(strings.TrimSpace(token))
+	if id, ok := ids[token]; ok {
+		return id, true
+	}
+	if i := strings.LastIndex(token, " "); i >= 0 {
+		id, ok := ids[token[i+1:]]
+		return id, ok
+	}
+	return 0, false
+}
@@ -0,0 +1,775 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+)
+
+// migration is one numbered schema change. Each step runs in its own
+// transaction together with its schema_migrations bookkeeping row, so a
+// failing step leaves the database at the previous version.
+type migration struct {
+	version int
+	name    string
+	up      func(tx *sql.Tx) error
+	down    func(tx *sql.Tx) error
+}
+
+// MigrationStatus reports whether a known migration has been applied.
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// migrations must stay ordered by version and must never be edited once
+// released; add a new step instead.
+var migrations = []migration{
+	{
+		version: 1,
+		name:    "create base schema",
+		up: execSQL(
+			`CREATE TABLE IF NOT EXISTS users (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				username TEXT UNIQUE NOT NULL,
+				email TEXT UNIQUE NOT NULL,
+				password_hash TEXT NOT NULL,
+				session_token TEXT UNIQUE,
+				session_expiry DATETIME,
+				created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+			)`,
+			`CREATE TABLE IF NOT EXISTS categories (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				name TEXT UNIQUE NOT NULL,
+				emoji TEXT UNIQUE NOT NULL,
+				UNIQUE(name,emoji)
+			)`,
+			`CREATE TABLE IF NOT EXISTS posts (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				title TEXT NOT NULL UNIQUE,
+				content TEXT NOT NULL,
+				user_id  INTEGER NOT NULL,
+				categories TEXT NOT NULL,
+				created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+				updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+				FOREIGN KEY(user_id) REFERENCES users(id)
+			)`,
+			`CREATE TABLE IF NOT EXISTS comments (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				content TEXT NOT NULL,
+				user_id INTEGER NOT NULL,
+				post_id INTEGER NOT NULL,
+				created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+				FOREIGN KEY(user_id) REFERENCES users(id),
+				FOREIGN KEY(post_id) REFERENCES posts(id)
+			)`,
+			`CREATE TABLE IF NOT EXISTS posts_categories (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				post_id INTEGER NOT NULL,
+				categories TEXT NOT NULL,
+				created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+				FOREIGN KEY (post_id) REFERENCES posts(id) ON DELETE CASCADE,
+				UNIQUE (post_id, categories)
+			)`,
+			`CREATE TABLE IF NOT EXISTS sessions (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				user_id INTEGER NOT NULL,
+				session_token TEXT NOT NULL UNIQUE,
+				session_expiry DATETIME NOT NULL,
+				FOREIGN KEY (user_id) REFERENCES users(id)
+			)`,
+			`CREATE TABLE IF NOT EXISTS votes (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				user_id INTEGER NOT NULL,
+				post_id INTEGER,
+				comment_id INTEGER,
+				vote INTEGER NOT NULL CHECK (vote IN (1, 0, -1)),
+				FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+				FOREIGN KEY (post_id) REFERENCES posts(id) ON DELETE CASCADE,
+				FOREIGN KEY (comment_id) REFERENCES comments(id) ON DELETE CASCADE,
+				UNIQUE (user_id, post_id),
+				UNIQUE (user_id, comment_id)
+			)`,
+		),
+		down: execSQL(
+			`DROP TABLE IF EXISTS votes`,
+			`DROP TABLE IF EXISTS sessions`,
+			`DROP TABLE IF EXISTS posts_categories`,
+			`DROP TABLE IF EXISTS comments`,
+			`DROP TABLE IF EXISTS posts`,
+			`DROP TABLE IF EXISTS categories`,
+			`DROP TABLE IF EXISTS users`,
+		),
+	},
+	{
+		// Sessions live in their own table; these columns were never written.
+		// SQLite cannot drop a UNIQUE column in place, so the table is rebuilt.
+		version: 2,
+		name:    "drop unused users session columns",
+		up: execSQL(
+			`CREATE TABLE users_new (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				username TEXT UNIQUE NOT NULL,
+				email TEXT UNIQUE NOT NULL,
+				password_hash TEXT NOT NULL,
+				created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+			)`,
+			`INSERT INTO users_new (id, username, email, password_hash, created_at)
+				SELECT id, username, email, password_hash, created_at FROM users`,
+			`DROP TABLE users`,
+			`ALTER TABLE users_new RENAME TO users`,
+		),
+		down: execSQL(
+			`ALTER TABLE users ADD COLUMN session_token TEXT`,
+			`ALTER TABLE users ADD COLUMN session_expiry DATETIME`,
+			`CREATE UNIQUE INDEX IF NOT EXISTS users_session_token ON users(session_token)`,
+		),
+	},
+	{
+		version: 3,
+		name:    "add posts edited_at",
+		up:      execSQL(`ALTER TABLE posts ADD COLUMN edited_at DATETIME`),
+		down:    execSQL(`ALTER TABLE posts DROP COLUMN edited_at`),
+	},
+	{
+		version: 4,
+		name:    "normalize post categories",
+		up:      normalizePostCategories,
+		down: execSQL(
+			`ALTER TABLE posts ADD COLUMN categories TEXT NOT NULL DEFAULT ''`,
+			`UPDATE posts SET categories = COALESCE((
+				SELECT group_concat(c.name, ',')
+				FROM posts_categories pc
+				JOIN categories c ON c.id = pc.category_id
+				WHERE pc.post_id = posts.id
+			), '')`,
+			`DROP TABLE posts_categories`,
+			`CREATE TABLE posts_categories (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				post_id INTEGER NOT NULL,
+				categories TEXT NOT NULL,
+				created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+				FOREIGN KEY (post_id) REFERENCES posts(id) ON DELETE CASCADE,
+				UNIQUE (post_id, categories)
+			)`,
+		),
+	},
+	{
+		version: 5,
+		name:    "add full-text search index",
+		up:      createSearchIndex,
+		down:    execSQL(dropSearchIndex...),
+	},
+	{
+		version: 6,
+		name:    "add comment replies",
+		up: execSQL(
+			`ALTER TABLE comments ADD COLUMN parent_id INTEGER REFERENCES comments(id) ON DELETE CASCADE`,
+			`CREATE INDEX comments_parent_id ON comments(parent_id)`,
+		),
+		// SQLite cannot drop a foreign key column, so the table is rebuilt
+		// and the search triggers that went with it are recreated.
+		down: func(tx *sql.Tx) error {
+			err := execSQL(
+				`DROP INDEX IF EXISTS comments_parent_id`,
+				`CREATE TABLE comments_old (
+					id INTEGER PRIMARY KEY AUTOINCREMENT,
+					content TEXT NOT NULL,
+					user_id INTEGER NOT NULL,
+					post_id INTEGER NOT NULL,
+					created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+					FOREIGN KEY(user_id) REFERENCES users(id),
+					FOREIGN KEY(post_id) REFERENCES posts(id)
+				)`,
+				`INSERT INTO comments_old (id, content, user_id, post_id, created_at)
+					SELECT id, content, user_id, post_id, created_at FROM comments`,
+				`DROP TABLE comments`,
+				`ALTER TABLE comments_old RENAME TO comments`,
+			)(tx)
+			if err != nil {
+				return err
+			}
+			return execSQL(commentSearchTriggers...)(tx)
+		},
+	},
+	{
+		// Each revision row keeps the text an edit replaced, so the history of
+		// a post is its revisions in order followed by the live row.
+		version: 7,
+		name:    "add edit history and soft delete",
+		up: execSQL(
+			`ALTER TABLE posts ADD COLUMN deleted_at DATETIME`,
+			`ALTER TABLE comments ADD COLUMN edited_at DATETIME`,
+			`ALTER TABLE comments ADD COLUMN deleted_at DATETIME`,
+			`CREATE TABLE post_revisions (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				post_id INTEGER NOT NULL,
+				title TEXT NOT NULL,
+				content TEXT NOT NULL,
+				created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+				FOREIGN KEY (post_id) REFERENCES posts(id) ON DELETE CASCADE
+			)`,
+			`CREATE INDEX post_revisions_post_id ON post_revisions(post_id)`,
+			`CREATE TABLE comment_revisions (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				comment_id INTEGER NOT NULL,
+				content TEXT NOT NULL,
+				created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+				FOREIGN KEY (comment_id) REFERENCES comments(id) ON DELETE CASCADE
+			)`,
+			`CREATE INDEX comment_revisions_comment_id ON comment_revisions(comment_id)`,
+		),
+		down: execSQL(
+			`DROP TABLE comment_revisions`,
+			`DROP TABLE post_revisions`,
+			`ALTER TABLE comments DROP COLUMN deleted_at`,
+			`ALTER TABLE comments DROP COLUMN edited_at`,
+			`ALTER TABLE posts DROP COLUMN deleted_at`,
+		),
+	},
+	{
+		// Guests are simply visitors without a session, so only the logged-in
+		// roles are stored.
+		version: 8,
+		name:    "add roles and moderation",
+		up: execSQL(
+			`ALTER TABLE users ADD COLUMN role TEXT NOT NULL DEFAULT 'user' CHECK (role IN ('user', 'moderator', 'admin'))`,
+			`ALTER TABLE users ADD COLUMN banned_at DATETIME`,
+			`ALTER TABLE posts ADD COLUMN hidden_at DATETIME`,
+			`ALTER TABLE posts ADD COLUMN locked_at DATETIME`,
+			`ALTER TABLE comments ADD COLUMN hidden_at DATETIME`,
+			`CREATE TABLE moderation_log (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				moderator_id INTEGER NOT NULL,
+				action TEXT NOT NULL,
+				target_type TEXT NOT NULL,
+				target_id INTEGER NOT NULL,
+				reason TEXT NOT NULL DEFAULT '',
+				created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+				FOREIGN KEY (moderator_id) REFERENCES users(id)
+			)`,
+			`CREATE INDEX moderation_log_target ON moderation_log(target_type, target_id)`,
+		),
+		down: execSQL(
+			`DROP TABLE moderation_log`,
+			`ALTER TABLE comments DROP COLUMN hidden_at`,
+			`ALTER TABLE posts DROP COLUMN locked_at`,
+			`ALTER TABLE posts DROP COLUMN hidden_at`,
+			`ALTER TABLE users DROP COLUMN banned_at`,
+			`ALTER TABLE users DROP COLUMN role`,
+		),
+	},
+	{
+		// A reader can have one open report per item; once it is resolved
+		// they may report it again.
+		version: 9,
+		name:    "add content reports",
+		up: execSQL(
+			`CREATE TABLE reports (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				reporter_id INTEGER NOT NULL,
+				target_type TEXT NOT NULL CHECK (target_type IN ('post', 'comment')),
+				target_id INTEGER NOT NULL,
+				reason TEXT NOT NULL CHECK (reason IN ('spam', 'spoiler', 'harassment', 'other')),
+				details TEXT NOT NULL DEFAULT '',
+				status TEXT NOT NULL DEFAULT 'open' CHECK (status IN ('open', 'accepted', 'dismissed')),
+				resolved_by INTEGER,
+				resolved_at DATETIME,
+				created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+				FOREIGN KEY (reporter_id) REFERENCES users(id) ON DELETE CASCADE,
+				FOREIGN KEY (resolved_by) REFERENCES users(id)
+			)`,
+			`CREATE UNIQUE INDEX reports_one_open ON reports(reporter_id, target_type, target_id) WHERE status = 'open'`,
+			`CREATE INDEX reports_target ON reports(target_type, target_id)`,
+		),
+		down: execSQL(`DROP TABLE reports`),
+	},
+	{
+		// Preferences only hold the kinds a user switched off; a missing
+		// row means the notification is wanted.
+		version: 10,
+		name:    "add notifications",
+		up: execSQL(
+			`CREATE TABLE notifications (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				user_id INTEGER NOT NULL,
+				actor_id INTEGER,
+				kind TEXT NOT NULL CHECK (kind IN ('comment', 'reply', 'mention', 'vote', 'report')),
+				post_id INTEGER,
+				comment_id INTEGER,
+				detail TEXT NOT NULL DEFAULT '',
+				read_at DATETIME,
+				created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+				FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+				FOREIGN KEY (actor_id) REFERENCES users(id) ON DELETE SET NULL,
+				FOREIGN KEY (post_id) REFERENCES posts(id) ON DELETE CASCADE,
+				FOREIGN KEY (comment_id) REFERENCES comments(id) ON DELETE CASCADE
+			)`,
+			`CREATE INDEX notifications_user_unread ON notifications(user_id, read_at)`,
+			`CREATE TABLE notification_preferences (
+				user_id INTEGER NOT NULL,
+				kind TEXT NOT NULL,
+				enabled INTEGER NOT NULL DEFAULT 1,
+				PRIMARY KEY (user_id, kind),
+				FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+			)`,
+		),
+		down: execSQL(
+			`DROP TABLE notification_preferences`,
+			`DROP TABLE notifications`,
+		),
+	},
+	{
+		// A conversation is between exactly two users, stored with the
+		// lower user ID first so each pair has a single row.
+		version: 11,
+		name:    "add private messages",
+		up: execSQL(
+			`CREATE TABLE conversations (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				user_a INTEGER NOT NULL,
+				user_b INTEGER NOT NULL,
+				created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+				updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+				UNIQUE (user_a, user_b),
+				CHECK (user_a < user_b),
+				FOREIGN KEY (user_a) REFERENCES users(id) ON DELETE CASCADE,
+				FOREIGN KEY (user_b) REFERENCES users(id) ON DELETE CASCADE
+			)`,
+			`CREATE TABLE messages (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				conversation_id INTEGER NOT NULL,
+				sender_id INTEGER NOT NULL,
+				recipient_id INTEGER NOT NULL,
+				body TEXT NOT NULL,
+				read_at DATETIME,
+				created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+				FOREIGN KEY (conversation_id) REFERENCES conversations(id) ON DELETE CASCADE,
+				FOREIGN KEY (sender_id) REFERENCES users(id) ON DELETE CASCADE,
+				FOREIGN KEY (recipient_id) REFERENCES users(id) ON DELETE CASCADE
+			)`,
+			`CREATE INDEX messages_conversation ON messages(conversation_id, id)`,
+			`CREATE INDEX messages_recipient_unread ON messages(recipient_id, read_at)`,
+			`CREATE TABLE user_blocks (
+				blocker_id INTEGER NOT NULL,
+				blocked_id INTEGER NOT NULL,
+				created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+				PRIMARY KEY (blocker_id, blocked_id),
+				FOREIGN KEY (blocker_id) REFERENCES users(id) ON DELETE CASCADE,
+				FOREIGN KEY (blocked_id) REFERENCES users(id) ON DELETE CASCADE
+			)`,
+		),
+		down: execSQL(
+			`DROP TABLE user_blocks`,
+			`DROP TABLE messages`,
+			`DROP TABLE conversations`,
+		),
+	},
+	{
+		// Every login attempt is kept for auditing. outcome is "success",
+		// "failure" (wrong credentials) or "locked" (refused without
+		// checking the password).
+		version: 12,
+		name:    "add login attempts",
+		up: execSQL(
+			`CREATE TABLE login_attempts (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				username TEXT NOT NULL,
+				ip TEXT NOT NULL,
+				outcome TEXT NOT NULL CHECK (outcome IN ('success', 'failure', 'locked')),
+				created_at DATETIME NOT NULL
+			)`,
+			`CREATE INDEX login_attempts_username ON login_attempts(username, created_at)`,
+			`CREATE INDEX login_attempts_ip ON login_attempts(ip, created_at)`,
+		),
+		down: execSQL(
+			`DROP TABLE login_attempts`,
+		),
+	},
+	{
+		// Users keep a session per device instead of one at a time, and
+		// can see where they are signed in.
+		version: 13,
+		name:    "track session devices",
+		up: execSQL(
+			`ALTER TABLE sessions ADD COLUMN user_agent TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE sessions ADD COLUMN ip TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE sessions ADD COLUMN created_at DATETIME`,
+			`ALTER TABLE sessions ADD COLUMN last_seen DATETIME`,
+			`CREATE INDEX IF NOT EXISTS sessions_user ON sessions(user_id)`,
+		),
+		down: execSQL(
+			`DROP INDEX IF EXISTS sessions_user`,
+			`ALTER TABLE sessions DROP COLUMN last_seen`,
+			`ALTER TABLE sessions DROP COLUMN created_at`,
+			`ALTER TABLE sessions DROP COLUMN ip`,
+			`ALTER TABLE sessions DROP COLUMN user_agent`,
+		),
+	},
+	{
+		// Sessions started with "keep me signed in" renew to a longer
+		// lifetime.
+		version: 14,
+		name:    "add session remember flag",
+		up:      execSQL(`ALTER TABLE sessions ADD COLUMN remember BOOLEAN NOT NULL DEFAULT 0`),
+		down:    execSQL(`ALTER TABLE sessions DROP COLUMN remember`),
+	},
+	{
+		// Emailed single-use tokens for password resets and address
+		// verification. Only hashes of the tokens are stored.
+		version: 15,
+		name:    "add user tokens and email verification",
+		up: execSQL(
+			`CREATE TABLE user_tokens (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				user_id INTEGER NOT NULL,
+				purpose TEXT NOT NULL CHECK (purpose IN ('password_reset', 'verify_email')),
+				token_hash TEXT NOT NULL UNIQUE,
+				email TEXT NOT NULL,
+				expires_at DATETIME NOT NULL,
+				used_at DATETIME,
+				created_at DATETIME NOT NULL,
+				FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+			)`,
+			`CREATE INDEX user_tokens_user ON user_tokens(user_id, purpose)`,
+			`ALTER TABLE users ADD COLUMN email_verified_at DATETIME`,
+		),
+		down: execSQL(
+			`ALTER TABLE users DROP COLUMN email_verified_at`,
+			`DROP TABLE user_tokens`,
+		),
+	},
+	{
+		// Deleted accounts keep a scrubbed row, so their remaining posts,
+		// messages and moderation history still have an author.
+		version: 16,
+		name:    "add account deletion",
+		up:      execSQL(`ALTER TABLE users ADD COLUMN deleted_at DATETIME`),
+		down:    execSQL(`ALTER TABLE users DROP COLUMN deleted_at`),
+	},
+	{
+		// Accounts at GitHub, Google and other providers that people sign
+		// in with. subject is the provider's ID for the person.
+		version: 17,
+		name:    "add user identities",
+		up: execSQL(
+			`CREATE TABLE user_identities (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				user_id INTEGER NOT NULL,
+				provider TEXT NOT NULL,
+				subject TEXT NOT NULL,
+				email TEXT NOT NULL DEFAULT '',
+				created_at DATETIME NOT NULL,
+				last_used_at DATETIME,
+				FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+				UNIQUE (provider, subject),
+				UNIQUE (user_id, provider)
+			)`,
+		),
+		down: execSQL(`DROP TABLE user_identities`),
+	},
+	{
+		// Login attempts gain the 'password' outcome, for a right password
+		// that still needs its second factor, and tokens gain the login
+		// step between the two. SQLite cannot change a CHECK in place, so
+		// both tables are rebuilt.
+		version: 18,
+		name:    "add two-factor login",
+		up: execSQL(
+			`ALTER TABLE users ADD COLUMN totp_secret TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE users ADD COLUMN totp_enabled_at DATETIME`,
+			`ALTER TABLE users ADD COLUMN totp_last_step INTEGER NOT NULL DEFAULT 0`,
+			`CREATE TABLE recovery_codes (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				user_id INTEGER NOT NULL,
+				code_hash TEXT NOT NULL,
+				created_at DATETIME NOT NULL,
+				used_at DATETIME,
+				FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+				UNIQUE (user_id, code_hash)
+			)`,
+			loginAttemptsTable("login_attempts_new", "'success', 'failure', 'locked', 'password'"),
+			`INSERT INTO login_attempts_new SELECT id, username, ip, outcome, created_at FROM login_attempts`,
+			`DROP TABLE login_attempts`,
+			`ALTER TABLE login_attempts_new RENAME TO login_attempts`,
+			`CREATE INDEX login_attempts_username ON login_attempts(username, created_at)`,
+			`CREATE INDEX login_attempts_ip ON login_attempts(ip, created_at)`,
+			userTokensTable("user_tokens_new", "'password_reset', 'verify_email', 'two_factor_login'"),
+			`INSERT INTO user_tokens_new SELECT id, user_id, purpose, token_hash, email, expires_at, used_at, created_at FROM user_tokens`,
+			`DROP TABLE user_tokens`,
+			`ALTER TABLE user_tokens_new RENAME TO user_tokens`,
+			`CREATE INDEX user_tokens_user ON user_tokens(user_id, purpose)`,
+		),
+		down: execSQL(
+			userTokensTable("user_tokens_old", "'password_reset', 'verify_email'"),
+			`INSERT INTO user_tokens_old SELECT id, user_id, purpose, token_hash, email, expires_at, used_at, created_at FROM user_tokens
+				WHERE purpose != 'two_factor_login'`,
+			`DROP TABLE user_tokens`,
+			`ALTER TABLE user_tokens_old RENAME TO user_tokens`,
+			`CREATE INDEX user_tokens_user ON user_tokens(user_id, purpose)`,
+			loginAttemptsTable("login_attempts_old", "'success', 'failure', 'locked'"),
+			`INSERT INTO login_attempts_old SELECT id, username, ip, outcome, created_at FROM login_attempts
+				WHERE outcome != 'password'`,
+			`DROP TABLE login_attempts`,
+			`ALTER TABLE login_attempts_old RENAME TO login_attempts`,
+			`CREATE INDEX login_attempts_username ON login_attempts(username, created_at)`,
+			`CREATE INDEX login_attempts_ip ON login_attempts(ip, created_at)`,
+			`DROP TABLE recovery_codes`,
+			`ALTER TABLE users DROP COLUMN totp_last_step`,
+			`ALTER TABLE users DROP COLUMN totp_enabled_at`,
+			`ALTER TABLE users DROP COLUMN totp_secret`,
+		),
+	},
+	{
+		// Builds without FTS5 used to fall back to FTS4; rebuilding moves
+		// those databases to FTS5 and bm25 ranking.
+		version: 19,
+		name:    "rebuild full-text search index with FTS5",
+		up: func(tx *sql.Tx) error {
+			if err := execSQL(dropSearchIndex...)(tx); err != nil {
+				return err
+			}
+			return createSearchIndex(tx)
+		},
+		down: execSQL(),
+	},
+	{
+		// Migration 8 used to make the seeded admin account, whose password
+		// is public, an administrator. Accounts that still have the seeded
+		// password lose the role; admins are made with `promote` instead.
+		version: 20,
+		name:    "demote the seeded admin account",
+		up: func(tx *sql.Tx) error {
+			_, err := tx.Exec("UPDATE users SET role = 'user' WHERE username = 'admin' AND password_hash = ?", SeedAdminHash)
+			return err
+		},
+		down: execSQL(),
+	},
+}
+
+// loginAttemptsTable is the login_attempts schema of migration 12 under
+// another name, allowing the given outcomes.
+func loginAttemptsTable(name, outcomes string) string {
+	return `CREATE TABLE ` + name + ` (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		username TEXT NOT NULL,
+		ip TEXT NOT NULL,
+		outcome TEXT NOT NULL CHECK (outcome IN (` + outcomes + `)),
+		created_at DATETIME NOT NULL
+	)`
+}
+
+// userTokensTable is the user_tokens schema of migration 15 under another
+// name, allowing the given purposes.
+func userTokensTable(name, purposes string) string {
+	return `CREATE TABLE ` + name + ` (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL,
+		purpose TEXT NOT NULL CHECK (purpose IN (` + purposes + `)),
+		token_hash TEXT NOT NULL UNIQUE,
+		email TEXT NOT NULL,
+		expires_at DATETIME NOT NULL,
+		used_at DATETIME,
+		created_at DATETIME NOT NULL,
+		FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+	)`
+}
+
+// execSQL builds a migration step that runs the given statements in order.
+func execSQL(statements ...string) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		for _, stmt := range statements {
+			if _, err := tx.Exec(stmt); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// MigrateUp applies every pending migration in version order. It fails
+// with ErrNoFTS5 before touching the schema when search cannot work.
+func (s *Store) MigrateUp() error {
+	if err := s.checkFTS5(); err != nil {
+		return err
+	}
+	return s.withMigrationConn(func(ctx context.Context, conn *sql.Conn) error {
+		applied, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, m := range sortedMigrations() {
+			if _, ok := applied[m.version]; ok {
+				continue
+			}
+			if err := runMigration(ctx, conn, m, true); err != nil {
+				return err
+			}
+			log.Printf("Applied migration %d: %s", m.version, m.name)
+		}
+		return nil
+	})
+}
+
+// MigrateDown rolls back the most recently applied migrations, newest first.
+func (s *Store) MigrateDown(steps int) error {
+	if steps < 1 {
+		return fmt.Errorf("steps must be at least 1, got %d", steps)
+	}
+	return s.withMigrationConn(func(ctx context.Context, conn *sql.Conn) error {
+		applied, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+		all := sortedMigrations()
+		for i := len(all) - 1; i >= 0 && steps > 0; i-- {
+			m := all[i]
+			if _, ok := applied[m.version]; !ok {
+				continue
+			}
+			if err := runMigration(ctx, conn, m, false); err != nil {
+				return err
+			}
+			log.Printf("Rolled back migration %d: %s", m.version, m.name)
+			steps--
+		}
+		return nil
+	})
+}
+
+// MigrationStatuses lists every known migration and whether it has been applied.
+func (s *Store) MigrationStatuses() ([]MigrationStatus, error) {
+	var statuses []MigrationStatus
+	err := s.withMigrationConn(func(ctx context.Context, conn *sql.Conn) error {
+		applied, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, m := range sortedMigrations() {
+			appliedAt, ok := applied[m.version]
+			statuses = append(statuses, MigrationStatus{
+				Version:   m.version,
+				Name:      m.name,
+				Applied:   ok,
+				AppliedAt: appliedAt,
+			})
+		}
+		return nil
+	})
+	return statuses, err
+}
+
+// -- Non-Global Functions : Only happens in this package -- //
+
+// withMigrationConn pins a single connection for the whole run. Table
+// rebuilds need foreign keys switched off, and SQLite only honours that
+// pragma per connection and outside of a transaction.
+func (s *Store) withMigrationConn(fn func(ctx context.Context, conn *sql.Conn) error) error {
+	if s.db == nil {
+		return fmt.Errorf("database connection is nil")
+	}
+	ctx := context.Background()
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("error acquiring connection: %w", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "PRAGMA foreign_keys = OFF"); err != nil {
+		return fmt.Errorf("error disabling foreign keys: %w", err)
+	}
+	defer func() {
+		if _, err := conn.ExecContext(ctx, "PRAGMA foreign_keys = ON"); err != nil {
+			log.Printf("Error re-enabling foreign keys: %v", err)
+		}
+	}()
+
+	_, err = conn.ExecContext(ctx, `
+        CREATE TABLE IF NOT EXISTS schema_migrations (
+            version INTEGER PRIMARY KEY,
+            name TEXT NOT NULL,
+            applied_at DATETIME DEFAULT CURRENT_TIMESTAMP
+        )
+    `)
+	if err != nil {
+		return fmt.Errorf("error creating schema_migrations table: %w", err)
+	}
+
+	return fn(ctx, conn)
+}
+
+func appliedVersions(ctx context.Context, conn *sql.Conn) (map[int]time.Time, error) {
+	rows, err := conn.QueryContext(ctx, "SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, fmt.Errorf("error querying schema_migrations: %w", err)
+	}
+	defer rows.Close()
+
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, fmt.Errorf("error scanning schema_migrations row: %w", err)
+		}
+		applied[version] = appliedAt
+	}
+	return applied, rows.Err()
+}
+
+func runMigration(ctx context.Context, conn *sql.Conn, m migration, up bool) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting migration %d: %w", m.version, err)
+	}
+	defer tx.Rollback()
+
+	step := m.down
+	if up {
+		step = m.up
+	}
+	if err := step(tx); err != nil {
+		return fmt.Errorf("migration %d (%s) failed: %w", m.version, m.name, err)
+	}
+
+	if err := checkForeignKeys(tx); err != nil {
+		return fmt.Errorf("migration %d (%s) failed: %w", m.version, m.name, err)
+	}
+
+	if up {
+		_, err = tx.Exec("INSERT INTO schema_migrations (version, name) VALUES (?, ?)", m.version, m.name)
+	} else {
+		_, err = tx.Exec("DELETE FROM schema_migrations WHERE version = ?", m.version)
+	}
+	if err != nil {
+		return fmt.Errorf("error recording migration %d: %w", m.version, err)
+	}
+
+	return tx.Commit()
+}
+
+// checkForeignKeys catches rows a rebuild left dangling while enforcement was off.
+func checkForeignKeys(tx *sql.Tx) error {
+	rows, err := tx.Query("PRAGMA foreign_key_check")
+	if err != nil {
+		return fmt.Errorf("error checking foreign keys: %w", err)
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		return fmt.Errorf("foreign key violations left behind")
+	}
+	return rows.Err()
+}
+
+func sortedMigrations() []migration {
+	sorted := make([]migration, len(migrations))
+	copy(sorted, migrations)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].version < sorted[j].version })
+	return sorted
+}
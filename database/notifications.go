@@ -0,0 +1,243 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"forum-go/model"
+	"forum-go/pkg/utils"
+)
+
+// Notification kinds, in the order they are listed in the preferences.
+const (
+	NotificationComment = "comment" // someone commented on your post
+	NotificationReply   = "reply"   // someone replied to your comment
+	NotificationMention = "mention" // someone wrote @yourname in a comment
+	NotificationVote    = "vote"    // someone voted on your post or comment
+	NotificationReport  = "report"  // a moderator resolved one of your reports
+)
+
+var NotificationKinds = []string{NotificationComment, NotificationReply, NotificationMention, NotificationVote, NotificationReport}
+
+// NotifyNewComment records the notifications for a new comment: a reply for
+// the author of the parent comment, a comment for the author of the post and
+// a mention for every @username in the text. Nobody is notified twice for
+// the same comment or about their own comment.
+func (s *Store) NotifyNewComment(commentID int) error {
+	var authorID, postID, postAuthorID int
+	var parentAuthorID sql.NullInt64
+	var content string
+	err := s.db.QueryRow(`
+        SELECT c.user_id, c.post_id, c.content, p.user_id, pc.user_id
+        FROM comments c
+        JOIN posts p ON p.id = c.post_id
+        LEFT JOIN comments pc ON pc.id = c.parent_id
+        WHERE c.id = ?
+    `, commentID).Scan(&authorID, &postID, &content, &postAuthorID, &parentAuthorID)
+	if err != nil {
+		return fmt.Errorf("error loading comment %d: %w", commentID, err)
+	}
+
+	var recipients []recipient
+	if parentAuthorID.Valid {
+		recipients = append(recipients, recipient{int(parentAuthorID.Int64), NotificationReply})
+	}
+	recipients = append(recipients, recipient{postAuthorID, NotificationComment})
+
+	for _, name := range utils.ExtractMentions(content) {
+		var userID int
+		err := s.db.QueryRow("SELECT id FROM users WHERE username = ?", name).Scan(&userID)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("error looking up mentioned user: %w", err)
+		}
+		recipients = append(recipients, recipient{userID, NotificationMention})
+	}
+
+	notified := map[int]bool{authorID: true}
+	for _, rcpt := range recipients {
+		if notified[rcpt.userID] {
+			continue
+		}
+		notified[rcpt.userID] = true
+		if err := notify(s.db, rcpt.userID, authorID, rcpt.kind, postID, commentID, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NotifyVote tells the author of a post or comment about a new or changed
+// vote. commentID is zero for votes on the post itself. An unread vote
+// notification from the same voter is replaced, so flipping a vote back
+// and forth does not pile up.
+func (s *Store) NotifyVote(voterID, postID, commentID, vote int) error {
+	var ownerID int
+	var err error
+	if commentID != 0 {
+		err = s.db.QueryRow("SELECT user_id, post_id FROM comments WHERE id = ?", commentID).Scan(&ownerID, &postID)
+	} else {
+		err = s.db.QueryRow("SELECT user_id FROM posts WHERE id = ?", postID).Scan(&ownerID)
+	}
+	if err != nil {
+		return fmt.Errorf("error loading voted item: %w", err)
+	}
+	if ownerID == voterID {
+		return nil
+	}
+
+	_, err = s.db.Exec(`
+        DELETE FROM notifications
+        WHERE user_id = ? AND actor_id = ? AND kind = ? AND post_id = ? AND comment_id IS ? AND read_at IS NULL
+    `, ownerID, voterID, NotificationVote, postID, nullableID(commentID))
+	if err != nil {
+		return fmt.Errorf("error replacing vote notification: %w", err)
+	}
+
+	detail := "up"
+	if vote < 0 {
+		detail = "down"
+	}
+	return notify(s.db, ownerID, voterID, NotificationVote, postID, commentID, detail)
+}
+
+// FetchNotifications returns the latest notifications of a user, newest first.
+func (s *Store) FetchNotifications(userID, limit int) ([]model.Notification, error) {
+	rows, err := s.db.Query(`
+        SELECT n.id, n.kind, COALESCE(a.username, ''), COALESCE(n.post_id, 0), COALESCE(p.title, ''),
+               COALESCE(n.comment_id, 0), n.detail, n.read_at IS NOT NULL, n.created_at
+        FROM notifications n
+        LEFT JOIN users a ON a.id = n.actor_id
+        LEFT JOIN posts p ON p.id = n.post_id
+        WHERE n.user_id = ?
+        ORDER BY n.id DESC
+        LIMIT ?
+    `, userID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("error querying notifications: %w", err)
+	}
+	defer rows.Close()
+
+	var notifications []model.Notification
+	for rows.Next() {
+		var n model.Notification
+		err := rows.Scan(&n.ID, &n.Kind, &n.Actor, &n.PostID, &n.PostTitle, &n.CommentID, &n.Detail, &n.Read, &n.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning notification: %w", err)
+		}
+		notifications = append(notifications, n)
+	}
+	return notifications, rows.Err()
+}
+
+// MarkNotificationRead marks one notification of userID as read. Someone
+// else's notification returns sql.ErrNoRows.
+func (s *Store) MarkNotificationRead(userID, notificationID int) error {
+	result, err := s.db.Exec(
+		"UPDATE notifications SET read_at = COALESCE(read_at, CURRENT_TIMESTAMP) WHERE id = ? AND user_id = ?",
+		notificationID, userID,
+	)
+	if err != nil {
+		return fmt.Errorf("error marking notification read: %w", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// MarkAllNotificationsRead marks every unread notification of userID as read.
+func (s *Store) MarkAllNotificationsRead(userID int) error {
+	_, err := s.db.Exec("UPDATE notifications SET read_at = CURRENT_TIMESTAMP WHERE user_id = ? AND read_at IS NULL", userID)
+	if err != nil {
+		return fmt.Errorf("error marking notifications read: %w", err)
+	}
+	return nil
+}
+
+// FetchNotificationPreferences returns, for every kind in NotificationKinds,
+// whether the user wants to receive it.
+func (s *Store) FetchNotificationPreferences(userID int) (map[string]bool, error) {
+	prefs := make(map[string]bool, len(NotificationKinds))
+	for _, kind := range NotificationKinds {
+		prefs[kind] = true
+	}
+
+	rows, err := s.db.Query("SELECT kind, enabled FROM notification_preferences WHERE user_id = ?", userID)
+	if err != nil {
+		return nil, fmt.Errorf("error querying notification preferences: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var kind string
+		var enabled bool
+		if err := rows.Scan(&kind, &enabled); err != nil {
+			return nil, fmt.Errorf("error scanning notification preference: %w", err)
+		}
+		prefs[kind] = enabled
+	}
+	return prefs, rows.Err()
+}
+
+// SetNotificationPreferences saves which kinds of notification the user
+// wants. Kinds missing from enabled are switched off.
+func (s *Store) SetNotificationPreferences(userID int, enabled map[string]bool) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, kind := range NotificationKinds {
+		_, err := tx.Exec(`
+            INSERT INTO notification_preferences (user_id, kind, enabled) VALUES (?, ?, ?)
+            ON CONFLICT (user_id, kind) DO UPDATE SET enabled = excluded.enabled
+        `, userID, kind, enabled[kind])
+		if err != nil {
+			return fmt.Errorf("error saving notification preference: %w", err)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("error committing notification preferences: %w", err)
+	}
+	return nil
+}
+
+// -- Non-Global Functions : Only happens in this package -- //
+
+type recipient struct {
+	userID int
+	kind   string
+}
+
+// execer is satisfied by both *sql.DB and *sql.Tx, so notifications can be
+// written inside another operation's transaction.
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// notify stores one notification unless the user switched its kind off.
+// actorID, postID and commentID may be zero.
+func notify(db execer, userID, actorID int, kind string, postID, commentID int, detail string) error {
+	_, err := db.Exec(`
+        INSERT INTO notifications (user_id, actor_id, kind, post_id, comment_id, detail)
+        SELECT ?, ?, ?, ?, ?, ?
+        WHERE NOT EXISTS (
+            SELECT 1 FROM notification_preferences WHERE user_id = ? AND kind = ? AND enabled = 0
+        )
+    `, userID, nullableID(actorID), kind, nullableID(postID), nullableID(commentID), detail, userID, kind)
+	if err != nil {
+		return fmt.Errorf("error saving %s notification: %w", kind, err)
+	}
+	return nil
+}
+
+func nullableID(id int) interface{} {
+	if id == 0 {
+		return nil
+	}
+	return id
+}
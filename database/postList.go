@@ -0,0 +1,253 @@
+package database
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"forum-go/model"
+	"strconv"
+	"strings"
+)
+
+// Sort orders accepted by FetchPostPage.
+const (
+	SortNewest   = "newest"
+	SortOldest   = "oldest"
+	SortTop      = "top"
+	SortComments = "comments"
+	SortActive   = "active"
+)
+
+// DefaultPageSize is used when PostListOptions.Limit is not set.
+const DefaultPageSize = 10
+
+// ErrInvalidCursor is returned when a page cursor cannot be decoded.
+var ErrInvalidCursor = errors.New("invalid page cursor")
+
+// PostListOptions selects one page of posts. At most one of After and Before
+// should be set; they are cursors taken from a previous PostPage.
+type PostListOptions struct {
+	Category string // exact category name, empty for every category
+	Sort     string
+	After    string
+	Before   string
+	Limit    int
+}
+
+// PostPage is one page of posts plus the cursors of its neighbours. A cursor
+// is empty when there is nothing further in that direction.
+type PostPage struct {
+	Posts      []model.Post
+	NextCursor string
+	PrevCursor string
+}
+
+// sortKeys are computed over the aggregated post rows in FetchPostPage.
+var sortKeys = map[string]struct {
+	expr string
+	desc bool
+}{
+	SortNewest:   {"julianday(created_at)", true},
+	SortOldest:   {"julianday(created_at)", false},
+	SortTop:      {"upvotes - downvotes", true},
+	SortComments: {"comment_count", true},
+	SortActive:   {"MAX(julianday(created_at), COALESCE(last_comment_at, 0))", true},
+}
+
+// ValidSort reports whether sort is one of the Sort* constants.
+func ValidSort(sort string) bool {
+	_, ok := sortKeys[sort]
+	return ok
+}
+
+// FetchPostPage returns one page of posts using keyset pagination, so pages
+// stay stable while new posts and votes arrive.
+func (s *Store) FetchPostPage(opts PostListOptions) (*PostPage, error) {
+
+	if s.db == nil {
+		return nil, errors.New("database connection is nil")
+	}
+
+	if opts.Sort == "" {
+		opts.Sort = SortNewest
+	}
+	key, ok := sortKeys[opts.Sort]
+	if !ok {
+		return nil, fmt.Errorf("unknown sort order %q", opts.Sort)
+	}
+	if opts.Limit <= 0 {
+		opts.Limit = DefaultPageSize
+	}
+
+	var args []interface{}
+	where := `
+            WHERE p.deleted_at IS NULL AND p.hidden_at IS NULL`
+	if opts.Category != "" {
+		where += ` AND EXISTS (
+                SELECT 1 FROM posts_categories pc
+                JOIN categories c ON c.id = pc.category_id
+                WHERE pc.post_id = p.id AND c.name = ?
+            )`
+		args = append(args, opts.Category)
+	}
+
+	// Walking backwards flips both the comparison and the order; the rows are
+	// reversed again after scanning.
+	backward := opts.Before != ""
+	cursor := opts.After
+	if backward {
+		cursor = opts.Before
+	}
+	descending := key.desc != backward
+
+	keyset := ""
+	if cursor != "" {
+		sortValue, id, err := DecodeCursor(cursor)
+		if err != nil {
+			return nil, err
+		}
+		op := ">"
+		if descending {
+			op = "<"
+		}
+		keyset = fmt.Sprintf("WHERE (sort_key, id) %s (?, ?)", op)
+		args = append(args, sortValue, id)
+	}
+
+	direction := "ASC"
+	if descending {
+		direction = "DESC"
+	}
+
+	query := `
+    SELECT id, username, title, content, user_id, created_at, updated_at,
+           upvotes, downvotes, comment_count, sort_key
+    FROM (
+        SELECT *, ` + key.expr + ` AS sort_key
+        FROM (
+            SELECT p.id, u.username, p.title, p.content, p.user_id,
+                   p.created_at, p.updated_at,
+                   COALESCE(SUM(CASE WHEN v.vote = 1 THEN 1 ELSE 0 END), 0) AS upvotes,
+                   COALESCE(SUM(CASE WHEN v.vote = -1 THEN 1 ELSE 0 END), 0) AS downvotes,
+                   (SELECT COUNT(*) FROM comments cm WHERE cm.post_id = p.id AND cm.deleted_at IS NULL AND cm.hidden_at IS NULL) AS comment_count,
+                   (SELECT MAX(julianday(cm.created_at)) FROM comments cm WHERE cm.post_id = p.id AND cm.deleted_at IS NULL AND cm.hidden_at IS NULL) AS last_comment_at
+            FROM posts p
+            JOIN users u ON p.user_id = u.id
+            LEFT JOIN votes v ON p.id = v.post_id` + where + `
+            GROUP BY p.id
+        )
+    )
+    ` + keyset + `
+    ORDER BY sort_key ` + direction + `, id ` + direction + `
+    LIMIT ?
+`
+	args = append(args, opts.Limit+1)
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error querying posts: %w", err)
+	}
+	defer rows.Close()
+
+	var posts []model.Post
+	var keys []float64
+	for rows.Next() {
+		var p model.Post
+		var sortValue float64
+		err := rows.Scan(
+			&p.ID,
+			&p.Author,
+			&p.Title,
+			&p.Content,
+			&p.UserID,
+			&p.CreatedAt,
+			&p.UpdatedAt,
+			&p.Upvotes,
+			&p.Downvotes,
+			&p.CommentCount,
+			&sortValue,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning post: %w", err)
+		}
+		posts = append(posts, p)
+		keys = append(keys, sortValue)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error after scanning posts: %w", err)
+	}
+	rows.Close()
+
+	page := NewPostPage(opts, posts, keys)
+	if err = s.attachCategories(postPointers(page.Posts)); err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+// NewPostPage makes a PostPage from the rows of a page query: up to
+// opts.Limit+1 posts in the order they were read, walking backwards when
+// opts.Before is set, with the sort key of each.
+func NewPostPage(opts PostListOptions, posts []model.Post, keys []float64) *PostPage {
+	backward := opts.Before != ""
+	hasMore := len(posts) > opts.Limit
+	if hasMore {
+		posts = posts[:opts.Limit]
+		keys = keys[:opts.Limit]
+	}
+	if backward {
+		for i, j := 0, len(posts)-1; i < j; i, j = i+1, j-1 {
+			posts[i], posts[j] = posts[j], posts[i]
+			keys[i], keys[j] = keys[j], keys[i]
+		}
+	}
+
+	page := &PostPage{Posts: posts}
+	if len(posts) > 0 {
+		first, last := 0, len(posts)-1
+		if backward {
+			// We came from a later page, so there is always a next one
+			page.NextCursor = EncodeCursor(keys[last], posts[last].ID)
+			if hasMore {
+				page.PrevCursor = EncodeCursor(keys[first], posts[first].ID)
+			}
+		} else {
+			if hasMore {
+				page.NextCursor = EncodeCursor(keys[last], posts[last].ID)
+			}
+			if opts.After != "" {
+				page.PrevCursor = EncodeCursor(keys[first], posts[first].ID)
+			}
+		}
+	}
+	return page
+}
+
+// EncodeCursor makes the cursor of a boundary row from its sort key and
+// post ID, so ties on the sort key are broken by ID.
+func EncodeCursor(sortValue float64, id int) string {
+	raw := strconv.FormatFloat(sortValue, 'g', -1, 64) + ":" + strconv.Itoa(id)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// DecodeCursor reads a cursor made by EncodeCursor. Anything else returns
+// ErrInvalidCursor.
+func DecodeCursor(cursor string) (float64, int, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, 0, ErrInvalidCursor
+	}
+	key, id, ok := strings.Cut(string(raw), ":")
+	if !ok {
+		return 0, 0, ErrInvalidCursor
+	}
+	sortValue, err := strconv.ParseFloat(key, 64)
+	if err != nil {
+		return 0, 0, ErrInvalidCursor
+	}
+	postID, err := strconv.Atoi(id)
+	if err != nil {
+		return 0, 0, ErrInvalidCursor
+	}
+	return sortValue, postID, nil
+}
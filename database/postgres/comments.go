@@ -0,0 +1,210 @@
+package postgres
+
+import (
+	"database/sql"
+	"fmt"
+	"forum-go/database"
+	"forum-go/model"
+)
+
+// CreateComment stores a comment on postID. parentID is 0 for a top-level
+// comment; otherwise it must be a live comment on the same post.
+func (s *Store) CreateComment(postID, userID, parentID int, content string) (int64, error) {
+	// Deleted and hidden posts no longer take comments; sql.ErrNoRows tells
+	// the caller the post is gone.
+	var locked bool
+	err := s.db.QueryRow(
+		"SELECT locked_at IS NOT NULL FROM posts WHERE id = $1 AND deleted_at IS NULL AND hidden_at IS NULL", postID,
+	).Scan(&locked)
+	if err != nil {
+		return 0, err
+	}
+	if locked {
+		return 0, database.ErrPostLocked
+	}
+
+	var parent interface{}
+	if parentID != 0 {
+		var parentPostID int
+		err := s.db.QueryRow("SELECT post_id FROM comments WHERE id = $1 AND deleted_at IS NULL AND hidden_at IS NULL", parentID).Scan(&parentPostID)
+		if err == sql.ErrNoRows || (err == nil && parentPostID != postID) {
+			return 0, database.ErrParentNotFound
+		}
+		if err != nil {
+			return 0, fmt.Errorf("error checking parent comment: %w", err)
+		}
+		parent = parentID
+	}
+
+	var id int64
+	err = s.db.QueryRow(
+		"INSERT INTO comments (post_id, user_id, parent_id, content) VALUES ($1, $2, $3, $4) RETURNING id",
+		postID, userID, parent, content,
+	).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("error inserting comment: %w", err)
+	}
+
+	return id, nil
+}
+
+// FetchCommentByID returns a single comment that has not been deleted.
+// Hidden comments are returned with Hidden set.
+func (s *Store) FetchCommentByID(commentID int) (*model.Comment, error) {
+	var c model.Comment
+	var editedAt sql.NullTime
+	err := s.db.QueryRow(`
+        SELECT c.id, c.content, u.username, c.user_id, c.post_id,
+               COALESCE(c.parent_id, 0), c.created_at, c.edited_at, c.hidden_at IS NOT NULL
+        FROM comments c
+        JOIN users u ON c.user_id = u.id
+        WHERE c.id = $1 AND c.deleted_at IS NULL`, commentID).Scan(
+		&c.ID,
+		&c.Content,
+		&c.Author,
+		&c.UserID,
+		&c.PostID,
+		&c.ParentID,
+		&c.CreatedAt,
+		&editedAt,
+		&c.Hidden,
+	)
+	if err != nil {
+		return nil, err
+	}
+	c.EditedAt = editedAt.Time
+	return &c, nil
+}
+
+// FetchCommentsByPostID returns every comment on a post, oldest first.
+// Deleted comments keep their place with their text and author blanked.
+func (s *Store) FetchCommentsByPostID(postID int) ([]model.Comment, error) {
+	rows, err := s.db.Query(`
+        SELECT c.id,
+               CASE WHEN c.deleted_at IS NULL THEN c.content ELSE '' END,
+               CASE WHEN c.deleted_at IS NULL THEN u.username ELSE '' END,
+               c.user_id, c.post_id, COALESCE(c.parent_id, 0), c.created_at,
+               c.edited_at, c.deleted_at IS NOT NULL, c.hidden_at IS NOT NULL,
+               COUNT(*) FILTER (WHERE v.vote = 1),
+               COUNT(*) FILTER (WHERE v.vote = -1)
+        FROM comments c
+        JOIN users u ON c.user_id = u.id
+        LEFT JOIN votes v ON c.id = v.comment_id
+        WHERE c.post_id = $1
+        GROUP BY c.id, u.username
+        ORDER BY c.created_at ASC, c.id ASC`, postID)
+	if err != nil {
+		return nil, fmt.Errorf("error querying comments: %w", err)
+	}
+	defer rows.Close()
+
+	var comments []model.Comment
+	for rows.Next() {
+		var c model.Comment
+		var editedAt sql.NullTime
+		err := rows.Scan(
+			&c.ID,
+			&c.Content,
+			&c.Author,
+			&c.UserID,
+			&c.PostID,
+			&c.ParentID,
+			&c.CreatedAt,
+			&editedAt,
+			&c.Deleted,
+			&c.Hidden,
+			&c.Upvotes,
+			&c.Downvotes,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning comment row: %w", err)
+		}
+		c.EditedAt = editedAt.Time
+		comments = append(comments, c)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error after scanning comments: %w", err)
+	}
+	return comments, nil
+}
+
+// FetchCommentTree returns the top-level comments of a post with their
+// replies nested as database.BuildCommentTree does.
+func (s *Store) FetchCommentTree(postID, maxDepth int) ([]model.Comment, error) {
+	flat, err := s.FetchCommentsByPostID(postID)
+	if err != nil {
+		return nil, err
+	}
+	return database.BuildCommentTree(flat, maxDepth), nil
+}
+
+// FetchCommentRevisions returns the earlier versions of a comment, oldest first.
+func (s *Store) FetchCommentRevisions(commentID int) ([]model.Revision, error) {
+	rows, err := s.db.Query(
+		"SELECT id, content, created_at FROM comment_revisions WHERE comment_id = $1 ORDER BY id ASC",
+		commentID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error querying comment revisions: %w", err)
+	}
+	defer rows.Close()
+
+	var revisions []model.Revision
+	for rows.Next() {
+		var rev model.Revision
+		if err := rows.Scan(&rev.ID, &rev.Content, &rev.CreatedAt); err != nil {
+			return nil, fmt.Errorf("error scanning comment revision: %w", err)
+		}
+		revisions = append(revisions, rev)
+	}
+	return revisions, rows.Err()
+}
+
+// UpdateComment replaces the content of a comment and keeps the previous
+// version in comment_revisions.
+func (s *Store) UpdateComment(commentID, userID int, content string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var authorID int
+	var oldContent string
+	err = tx.QueryRow(
+		"SELECT user_id, content FROM comments WHERE id = $1 AND deleted_at IS NULL AND hidden_at IS NULL FOR UPDATE", commentID,
+	).Scan(&authorID, &oldContent)
+	if err != nil {
+		return err
+	}
+	if authorID != userID {
+		return database.ErrNotAuthor
+	}
+	if content == oldContent {
+		return nil
+	}
+
+	_, err = tx.Exec(
+		"INSERT INTO comment_revisions (comment_id, content) VALUES ($1, $2)",
+		commentID, oldContent,
+	)
+	if err != nil {
+		return fmt.Errorf("error saving comment revision: %w", err)
+	}
+
+	_, err = tx.Exec("UPDATE comments SET content = $1, edited_at = now() WHERE id = $2", content, commentID)
+	if err != nil {
+		return fmt.Errorf("error updating comment: %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("error committing comment edit: %w", err)
+	}
+	return nil
+}
+
+// DeleteComment soft-deletes a comment. It is shown as "[deleted]" so its
+// replies keep their place in the thread and its votes still count.
+func (s *Store) DeleteComment(commentID, userID int) error {
+	return s.softDelete("comments", commentID, userID)
+}
@@ -0,0 +1,204 @@
+package postgres
+
+import (
+	"fmt"
+	"forum-go/database"
+	"forum-go/model"
+)
+
+// SendMessage stores a private message, starting the conversation between
+// the two users if needed. A missing recipient returns sql.ErrNoRows.
+func (s *Store) SendMessage(senderID, recipientID int, body string) (*model.Message, error) {
+	if senderID == recipientID {
+		return nil, database.ErrSelfMessage
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	msg := model.Message{SenderID: senderID, RecipientID: recipientID, Body: body}
+	var blocked bool
+	err = tx.QueryRow(`
+        SELECT s.username,
+               EXISTS (SELECT 1 FROM user_blocks
+                       WHERE (blocker_id = s.id AND blocked_id = r.id) OR (blocker_id = r.id AND blocked_id = s.id))
+        FROM users s, users r
+        WHERE s.id = $1 AND r.id = $2`, senderID, recipientID).Scan(&msg.Sender, &blocked)
+	if err != nil {
+		return nil, err
+	}
+	if blocked {
+		return nil, database.ErrBlocked
+	}
+
+	userA, userB := senderID, recipientID
+	if userA > userB {
+		userA, userB = userB, userA
+	}
+	var conversationID int
+	err = tx.QueryRow(`
+        INSERT INTO conversations (user_a, user_b) VALUES ($1, $2)
+        ON CONFLICT (user_a, user_b) DO UPDATE SET updated_at = now()
+        RETURNING id`, userA, userB).Scan(&conversationID)
+	if err != nil {
+		return nil, fmt.Errorf("error saving conversation: %w", err)
+	}
+
+	err = tx.QueryRow(`
+        INSERT INTO messages (conversation_id, sender_id, recipient_id, body) VALUES ($1, $2, $3, $4)
+        RETURNING id, created_at`, conversationID, senderID, recipientID, body).Scan(&msg.ID, &msg.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("error inserting message: %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("error committing message: %w", err)
+	}
+
+	return &msg, nil
+}
+
+// FetchConversations returns the inbox of a user, most recent conversation
+// first.
+func (s *Store) FetchConversations(userID int) ([]model.Conversation, error) {
+	rows, err := s.db.Query(`
+        SELECT c.id, o.id, o.username,
+               m.id, m.sender_id, m.recipient_id, m.body, m.read_at IS NOT NULL, m.created_at,
+               (SELECT COUNT(*) FROM messages u
+                WHERE u.conversation_id = c.id AND u.recipient_id = $1 AND u.read_at IS NULL),
+               EXISTS (SELECT 1 FROM user_blocks b WHERE b.blocker_id = $1 AND b.blocked_id = o.id)
+        FROM conversations c
+        JOIN users o ON o.id = CASE WHEN c.user_a = $1 THEN c.user_b ELSE c.user_a END
+        JOIN messages m ON m.id = (SELECT MAX(id) FROM messages WHERE conversation_id = c.id)
+        WHERE c.user_a = $1 OR c.user_b = $1
+        ORDER BY m.id DESC`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error querying conversations: %w", err)
+	}
+	defer rows.Close()
+
+	var conversations []model.Conversation
+	for rows.Next() {
+		var c model.Conversation
+		m := &c.LastMessage
+		err := rows.Scan(&c.ID, &c.OtherID, &c.Other,
+			&m.ID, &m.SenderID, &m.RecipientID, &m.Body, &m.Read, &m.CreatedAt,
+			&c.Unread, &c.Blocked)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning conversation: %w", err)
+		}
+		m.Sender = c.Other
+		if m.SenderID == userID {
+			m.Sender = ""
+		}
+		conversations = append(conversations, c)
+	}
+	return conversations, rows.Err()
+}
+
+// FetchMessages returns the messages between two users, oldest first. With
+// afterID zero it returns the latest limit messages; otherwise the first
+// limit messages newer than afterID, which is how clients catch up.
+func (s *Store) FetchMessages(userID, otherID, afterID, limit int) ([]model.Message, error) {
+	query := `
+        SELECT m.id, m.sender_id, s.username, m.recipient_id, m.body, m.read_at IS NOT NULL, m.created_at
+        FROM messages m
+        JOIN users s ON s.id = m.sender_id
+        WHERE ((m.sender_id = $1 AND m.recipient_id = $2) OR (m.sender_id = $2 AND m.recipient_id = $1))`
+	args := []interface{}{userID, otherID}
+	if afterID > 0 {
+		query += " AND m.id > $3 ORDER BY m.id ASC LIMIT $4"
+		args = append(args, afterID, limit)
+	} else {
+		query += " ORDER BY m.id DESC LIMIT $3"
+		args = append(args, limit)
+	}
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error querying messages: %w", err)
+	}
+	defer rows.Close()
+
+	var messages []model.Message
+	for rows.Next() {
+		var m model.Message
+		if err := rows.Scan(&m.ID, &m.SenderID, &m.Sender, &m.RecipientID, &m.Body, &m.Read, &m.CreatedAt); err != nil {
+			return nil, fmt.Errorf("error scanning message: %w", err)
+		}
+		messages = append(messages, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if afterID <= 0 {
+		for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+			messages[i], messages[j] = messages[j], messages[i]
+		}
+	}
+	return messages, nil
+}
+
+// MarkConversationRead marks every message otherID sent to userID as read.
+func (s *Store) MarkConversationRead(userID, otherID int) error {
+	_, err := s.db.Exec(
+		"UPDATE messages SET read_at = now() WHERE recipient_id = $1 AND sender_id = $2 AND read_at IS NULL",
+		userID, otherID,
+	)
+	if err != nil {
+		return fmt.Errorf("error marking messages read: %w", err)
+	}
+	return nil
+}
+
+// BlockUser stops messages between blockerID and blockedID in both
+// directions. Existing messages stay readable.
+func (s *Store) BlockUser(blockerID, blockedID int) error {
+	if blockerID == blockedID {
+		return database.ErrSelfMessage
+	}
+	_, err := s.db.Exec(
+		"INSERT INTO user_blocks (blocker_id, blocked_id) VALUES ($1, $2) ON CONFLICT DO NOTHING",
+		blockerID, blockedID,
+	)
+	if err != nil {
+		return fmt.Errorf("error blocking user: %w", err)
+	}
+	return nil
+}
+
+// UnblockUser lifts a block set by blockerID.
+func (s *Store) UnblockUser(blockerID, blockedID int) error {
+	_, err := s.db.Exec("DELETE FROM user_blocks WHERE blocker_id = $1 AND blocked_id = $2", blockerID, blockedID)
+	if err != nil {
+		return fmt.Errorf("error unblocking user: %w", err)
+	}
+	return nil
+}
+
+// BlockState reports whether userID blocked otherID and whether otherID
+// blocked userID.
+func (s *Store) BlockState(userID, otherID int) (blocked, blockedBy bool, err error) {
+	err = s.db.QueryRow(`
+        SELECT EXISTS (SELECT 1 FROM user_blocks WHERE blocker_id = $1 AND blocked_id = $2),
+               EXISTS (SELECT 1 FROM user_blocks WHERE blocker_id = $2 AND blocked_id = $1)`,
+		userID, otherID).Scan(&blocked, &blockedBy)
+	if err != nil {
+		return false, false, fmt.Errorf("error checking blocks: %w", err)
+	}
+	return blocked, blockedBy, nil
+}
+
+// UnreadMessageCount returns how many messages userID has not read yet.
+func (s *Store) UnreadMessageCount(userID int) (int, error) {
+	var n int
+	err := s.db.QueryRow("SELECT COUNT(*) FROM messages WHERE recipient_id = $1 AND read_at IS NULL", userID).Scan(&n)
+	if err != nil {
+		return 0, fmt.Errorf("error counting unread messages: %w", err)
+	}
+	return n, nil
+}
@@ -0,0 +1,436 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"forum-go/database"
+	"log"
+	"sort"
+	"time"
+)
+
+// migration is one numbered schema change. PostgreSQL runs DDL inside
+// transactions, so each step and its schema_migrations row commit together.
+type migration struct {
+	version int
+	name    string
+	up      func(tx *sql.Tx) error
+	down    func(tx *sql.Tx) error
+}
+
+// migrationLock is the advisory lock key held while migrating, so two
+// servers starting at once do not both apply the same step.
+const migrationLock = 7_301_542
+
+// migrations are numbered separately from the SQLite ones. They must stay
+// ordered by version and must never be edited once released; add a new
+// step instead.
+var migrations = []migration{
+	{
+		version: 1,
+		name:    "create base schema",
+		up: execSQL(
+			`CREATE TABLE users (
+				id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+				username TEXT NOT NULL CONSTRAINT users_username_key UNIQUE,
+				email TEXT NOT NULL CONSTRAINT users_email_key UNIQUE,
+				password_hash TEXT NOT NULL,
+				role TEXT NOT NULL DEFAULT 'user' CHECK (role IN ('user', 'moderator', 'admin')),
+				banned_at TIMESTAMPTZ,
+				email_verified_at TIMESTAMPTZ,
+				deleted_at TIMESTAMPTZ,
+				totp_secret TEXT NOT NULL DEFAULT '',
+				totp_enabled_at TIMESTAMPTZ,
+				totp_last_step BIGINT NOT NULL DEFAULT 0,
+				created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+			)`,
+			`CREATE INDEX users_email_lower ON users (lower(email))`,
+			`CREATE TABLE categories (
+				id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+				name TEXT NOT NULL UNIQUE,
+				emoji TEXT NOT NULL UNIQUE
+			)`,
+			`CREATE TABLE posts (
+				id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+				title TEXT NOT NULL CONSTRAINT posts_title_key UNIQUE,
+				content TEXT NOT NULL,
+				user_id BIGINT NOT NULL REFERENCES users(id),
+				created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+				updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+				edited_at TIMESTAMPTZ,
+				deleted_at TIMESTAMPTZ,
+				hidden_at TIMESTAMPTZ,
+				locked_at TIMESTAMPTZ
+			)`,
+			`CREATE INDEX posts_user_id ON posts(user_id)`,
+			`CREATE TABLE posts_categories (
+				post_id BIGINT NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
+				category_id BIGINT NOT NULL REFERENCES categories(id) ON DELETE CASCADE,
+				PRIMARY KEY (post_id, category_id)
+			)`,
+			`CREATE INDEX posts_categories_category_id ON posts_categories(category_id)`,
+			`CREATE TABLE comments (
+				id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+				content TEXT NOT NULL,
+				user_id BIGINT NOT NULL REFERENCES users(id),
+				post_id BIGINT NOT NULL REFERENCES posts(id),
+				parent_id BIGINT REFERENCES comments(id) ON DELETE CASCADE,
+				created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+				edited_at TIMESTAMPTZ,
+				deleted_at TIMESTAMPTZ,
+				hidden_at TIMESTAMPTZ
+			)`,
+			`CREATE INDEX comments_post_id ON comments(post_id)`,
+			`CREATE INDEX comments_parent_id ON comments(parent_id)`,
+			`CREATE TABLE post_revisions (
+				id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+				post_id BIGINT NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
+				title TEXT NOT NULL,
+				content TEXT NOT NULL,
+				created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+			)`,
+			`CREATE INDEX post_revisions_post_id ON post_revisions(post_id)`,
+			`CREATE TABLE comment_revisions (
+				id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+				comment_id BIGINT NOT NULL REFERENCES comments(id) ON DELETE CASCADE,
+				content TEXT NOT NULL,
+				created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+			)`,
+			`CREATE INDEX comment_revisions_comment_id ON comment_revisions(comment_id)`,
+			`CREATE TABLE votes (
+				id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+				user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+				post_id BIGINT REFERENCES posts(id) ON DELETE CASCADE,
+				comment_id BIGINT REFERENCES comments(id) ON DELETE CASCADE,
+				vote SMALLINT NOT NULL CHECK (vote IN (1, 0, -1)),
+				UNIQUE (user_id, post_id),
+				UNIQUE (user_id, comment_id)
+			)`,
+			`CREATE INDEX votes_post_id ON votes(post_id)`,
+			`CREATE INDEX votes_comment_id ON votes(comment_id)`,
+			`CREATE TABLE sessions (
+				id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+				user_id BIGINT NOT NULL REFERENCES users(id),
+				session_token TEXT NOT NULL UNIQUE,
+				session_expiry TIMESTAMPTZ NOT NULL,
+				user_agent TEXT NOT NULL DEFAULT '',
+				ip TEXT NOT NULL DEFAULT '',
+				created_at TIMESTAMPTZ,
+				last_seen TIMESTAMPTZ,
+				remember BOOLEAN NOT NULL DEFAULT false
+			)`,
+			`CREATE INDEX sessions_user ON sessions(user_id)`,
+			`CREATE TABLE moderation_log (
+				id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+				moderator_id BIGINT NOT NULL REFERENCES users(id),
+				action TEXT NOT NULL,
+				target_type TEXT NOT NULL,
+				target_id BIGINT NOT NULL,
+				reason TEXT NOT NULL DEFAULT '',
+				created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+			)`,
+			`CREATE INDEX moderation_log_target ON moderation_log(target_type, target_id)`,
+			`CREATE TABLE reports (
+				id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+				reporter_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+				target_type TEXT NOT NULL CHECK (target_type IN ('post', 'comment')),
+				target_id BIGINT NOT NULL,
+				reason TEXT NOT NULL CHECK (reason IN ('spam', 'spoiler', 'harassment', 'other')),
+				details TEXT NOT NULL DEFAULT '',
+				status TEXT NOT NULL DEFAULT 'open' CHECK (status IN ('open', 'accepted', 'dismissed')),
+				resolved_by BIGINT REFERENCES users(id),
+				resolved_at TIMESTAMPTZ,
+				created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+			)`,
+			`CREATE INDEX reports_target ON reports(target_type, target_id)`,
+			`CREATE UNIQUE INDEX reports_one_open ON reports(reporter_id, target_type, target_id) WHERE status = 'open'`,
+			`CREATE TABLE notifications (
+				id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+				user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+				actor_id BIGINT REFERENCES users(id) ON DELETE SET NULL,
+				kind TEXT NOT NULL CHECK (kind IN ('comment', 'reply', 'mention', 'vote', 'report')),
+				post_id BIGINT REFERENCES posts(id) ON DELETE CASCADE,
+				comment_id BIGINT REFERENCES comments(id) ON DELETE CASCADE,
+				detail TEXT NOT NULL DEFAULT '',
+				read_at TIMESTAMPTZ,
+				created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+			)`,
+			`CREATE INDEX notifications_user_unread ON notifications(user_id, read_at)`,
+			`CREATE TABLE notification_preferences (
+				user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+				kind TEXT NOT NULL,
+				enabled BOOLEAN NOT NULL DEFAULT true,
+				PRIMARY KEY (user_id, kind)
+			)`,
+			`CREATE TABLE conversations (
+				id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+				user_a BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+				user_b BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+				created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+				updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+				UNIQUE (user_a, user_b),
+				CHECK (user_a < user_b)
+			)`,
+			`CREATE TABLE messages (
+				id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+				conversation_id BIGINT NOT NULL REFERENCES conversations(id) ON DELETE CASCADE,
+				sender_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+				recipient_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+				body TEXT NOT NULL,
+				read_at TIMESTAMPTZ,
+				created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+			)`,
+			`CREATE INDEX messages_conversation ON messages(conversation_id, id)`,
+			`CREATE INDEX messages_recipient_unread ON messages(recipient_id, read_at)`,
+			`CREATE TABLE user_blocks (
+				blocker_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+				blocked_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+				created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+				PRIMARY KEY (blocker_id, blocked_id)
+			)`,
+			`CREATE TABLE login_attempts (
+				id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+				username TEXT NOT NULL,
+				ip TEXT NOT NULL,
+				outcome TEXT NOT NULL CHECK (outcome IN ('success', 'failure', 'locked', 'password')),
+				created_at TIMESTAMPTZ NOT NULL
+			)`,
+			`CREATE INDEX login_attempts_username ON login_attempts(username, created_at)`,
+			`CREATE INDEX login_attempts_ip ON login_attempts(ip, created_at)`,
+			`CREATE TABLE user_tokens (
+				id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+				user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+				purpose TEXT NOT NULL CHECK (purpose IN ('password_reset', 'verify_email', 'two_factor_login')),
+				token_hash TEXT NOT NULL UNIQUE,
+				email TEXT NOT NULL,
+				expires_at TIMESTAMPTZ NOT NULL,
+				used_at TIMESTAMPTZ,
+				created_at TIMESTAMPTZ NOT NULL
+			)`,
+			`CREATE INDEX user_tokens_user ON user_tokens(user_id, purpose)`,
+			`CREATE TABLE user_identities (
+				id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+				user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+				provider TEXT NOT NULL,
+				subject TEXT NOT NULL,
+				email TEXT NOT NULL DEFAULT '',
+				created_at TIMESTAMPTZ NOT NULL,
+				last_used_at TIMESTAMPTZ,
+				UNIQUE (provider, subject),
+				UNIQUE (user_id, provider)
+			)`,
+			`CREATE TABLE recovery_codes (
+				id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+				user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+				code_hash TEXT NOT NULL,
+				created_at TIMESTAMPTZ NOT NULL,
+				used_at TIMESTAMPTZ,
+				UNIQUE (user_id, code_hash)
+			)`,
+		),
+		down: execSQL(
+			`DROP TABLE recovery_codes, user_identities, user_tokens, login_attempts, user_blocks,
+				messages, conversations, notification_preferences, notifications, reports,
+				moderation_log, sessions, votes, comment_revisions, post_revisions, comments,
+				posts_categories, posts, categories, users`,
+		),
+	},
+	{
+		// Generated columns keep the index in step with edits, which the
+		// SQLite schema does with triggers.
+		version: 2,
+		name:    "add full-text search index",
+		up: execSQL(
+			`ALTER TABLE posts ADD COLUMN search tsvector GENERATED ALWAYS AS (
+				setweight(to_tsvector('simple', title), 'A') || setweight(to_tsvector('simple', content), 'B')
+			) STORED`,
+			`CREATE INDEX posts_search ON posts USING GIN (search)`,
+			`ALTER TABLE comments ADD COLUMN search tsvector GENERATED ALWAYS AS (to_tsvector('simple', content)) STORED`,
+			`CREATE INDEX comments_search ON comments USING GIN (search)`,
+		),
+		down: execSQL(
+			`ALTER TABLE comments DROP COLUMN search`,
+			`ALTER TABLE posts DROP COLUMN search`,
+		),
+	},
+	{
+		// Databases seeded before the fix made the seeded admin account,
+		// whose password is public, an administrator. Admins are made with
+		// `promote` instead.
+		version: 3,
+		name:    "demote the seeded admin account",
+		up: func(tx *sql.Tx) error {
+			_, err := tx.Exec("UPDATE users SET role = 'user' WHERE username = 'admin' AND password_hash = $1", database.SeedAdminHash)
+			return err
+		},
+		down: execSQL(),
+	},
+}
+
+// MigrateUp applies every migration that has not run yet, oldest first.
+func (s *Store) MigrateUp() error {
+	return s.withMigrationConn(func(ctx context.Context, conn *sql.Conn) error {
+		applied, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, m := range sortedMigrations() {
+			if _, ok := applied[m.version]; ok {
+				continue
+			}
+			if err := runMigration(ctx, conn, m, true); err != nil {
+				return err
+			}
+			log.Printf("Applied migration %d: %s", m.version, m.name)
+		}
+		return nil
+	})
+}
+
+// MigrateDown rolls back the most recently applied migrations, newest first.
+func (s *Store) MigrateDown(steps int) error {
+	if steps < 1 {
+		return fmt.Errorf("steps must be at least 1, got %d", steps)
+	}
+	return s.withMigrationConn(func(ctx context.Context, conn *sql.Conn) error {
+		applied, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+		all := sortedMigrations()
+		for i := len(all) - 1; i >= 0 && steps > 0; i-- {
+			m := all[i]
+			if _, ok := applied[m.version]; !ok {
+				continue
+			}
+			if err := runMigration(ctx, conn, m, false); err != nil {
+				return err
+			}
+			log.Printf("Rolled back migration %d: %s", m.version, m.name)
+			steps--
+		}
+		return nil
+	})
+}
+
+// MigrationStatuses lists every known migration and whether it has been applied.
+func (s *Store) MigrationStatuses() ([]database.MigrationStatus, error) {
+	var statuses []database.MigrationStatus
+	err := s.withMigrationConn(func(ctx context.Context, conn *sql.Conn) error {
+		applied, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, m := range sortedMigrations() {
+			appliedAt, ok := applied[m.version]
+			statuses = append(statuses, database.MigrationStatus{
+				Version:   m.version,
+				Name:      m.name,
+				Applied:   ok,
+				AppliedAt: appliedAt,
+			})
+		}
+		return nil
+	})
+	return statuses, err
+}
+
+// -- Non-Global Functions : Only happens in this package -- //
+
+func execSQL(statements ...string) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		for _, stmt := range statements {
+			if _, err := tx.Exec(stmt); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// withMigrationConn pins one connection and holds the migration lock on
+// it for the whole run.
+func (s *Store) withMigrationConn(fn func(ctx context.Context, conn *sql.Conn) error) error {
+	ctx := context.Background()
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("error acquiring connection: %w", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", migrationLock); err != nil {
+		return fmt.Errorf("error taking the migration lock: %w", err)
+	}
+	defer func() {
+		if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", migrationLock); err != nil {
+			log.Printf("Error releasing the migration lock: %v", err)
+		}
+	}()
+
+	_, err = conn.ExecContext(ctx, `
+        CREATE TABLE IF NOT EXISTS schema_migrations (
+            version INTEGER PRIMARY KEY,
+            name TEXT NOT NULL,
+            applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+        )
+    `)
+	if err != nil {
+		return fmt.Errorf("error creating schema_migrations table: %w", err)
+	}
+
+	return fn(ctx, conn)
+}
+
+func appliedVersions(ctx context.Context, conn *sql.Conn) (map[int]time.Time, error) {
+	rows, err := conn.QueryContext(ctx, "SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, fmt.Errorf("error querying schema_migrations: %w", err)
+	}
+	defer rows.Close()
+
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, fmt.Errorf("error scanning schema_migrations row: %w", err)
+		}
+		applied[version] = appliedAt
+	}
+	return applied, rows.Err()
+}
+
+func runMigration(ctx context.Context, conn *sql.Conn, m migration, up bool) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting migration %d: %w", m.version, err)
+	}
+	defer tx.Rollback()
+
+	step := m.down
+	if up {
+		step = m.up
+	}
+	if err := step(tx); err != nil {
+		return fmt.Errorf("migration %d (%s) failed: %w", m.version, m.name, err)
+	}
+
+	if up {
+		_, err = tx.Exec("INSERT INTO schema_migrations (version, name) VALUES ($1, $2)", m.version, m.name)
+	} else {
+		_, err = tx.Exec("DELETE FROM schema_migrations WHERE version = $1", m.version)
+	}
+	if err != nil {
+		return fmt.Errorf("error recording migration %d: %w", m.version, err)
+	}
+
+	return tx.Commit()
+}
+
+func sortedMigrations() []migration {
+	sorted := make([]migration, len(migrations))
+	copy(sorted, migrations)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].version < sorted[j].version })
+	return sorted
+}
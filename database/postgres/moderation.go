@@ -0,0 +1,188 @@
+package postgres
+
+import (
+	"database/sql"
+	"fmt"
+	"forum-go/database"
+	"forum-go/model"
+)
+
+// SetPostHidden hides or restores a post and records who did it.
+func (s *Store) SetPostHidden(moderatorID, postID int, hidden bool, reason string) error {
+	action := database.ActionRestorePost
+	if hidden {
+		action = database.ActionHidePost
+	}
+	return s.moderate(moderatorID, action, "post", postID, reason,
+		"UPDATE posts SET hidden_at = "+timestampOrNull(hidden)+" WHERE id = $1 AND deleted_at IS NULL", postID)
+}
+
+// SetPostLocked closes or reopens a thread to new comments.
+func (s *Store) SetPostLocked(moderatorID, postID int, locked bool, reason string) error {
+	action := database.ActionUnlockPost
+	if locked {
+		action = database.ActionLockPost
+	}
+	return s.moderate(moderatorID, action, "post", postID, reason,
+		"UPDATE posts SET locked_at = "+timestampOrNull(locked)+" WHERE id = $1 AND deleted_at IS NULL", postID)
+}
+
+// SetCommentHidden hides or restores a comment. Hidden comments keep their
+// place in the thread like deleted ones.
+func (s *Store) SetCommentHidden(moderatorID, commentID int, hidden bool, reason string) error {
+	action := database.ActionRestoreComment
+	if hidden {
+		action = database.ActionHideComment
+	}
+	return s.moderate(moderatorID, action, "comment", commentID, reason,
+		"UPDATE comments SET hidden_at = "+timestampOrNull(hidden)+" WHERE id = $1 AND deleted_at IS NULL", commentID)
+}
+
+// SetUserBanned bans or unbans an account. Banning also ends every session
+// of the account, and moderators can only ban plain users.
+func (s *Store) SetUserBanned(moderatorID, userID int, banned bool, reason string) error {
+	if err := s.checkOutranks(moderatorID, userID); err != nil {
+		return err
+	}
+
+	action := database.ActionUnbanUser
+	if banned {
+		action = database.ActionBanUser
+	}
+	err := s.moderate(moderatorID, action, "user", userID, reason,
+		"UPDATE users SET banned_at = "+timestampOrNull(banned)+" WHERE id = $1", userID)
+	if err != nil {
+		return err
+	}
+
+	if banned {
+		if _, err := s.db.Exec("DELETE FROM sessions WHERE user_id = $1", userID); err != nil {
+			return fmt.Errorf("error ending sessions of banned user: %w", err)
+		}
+	}
+	return nil
+}
+
+// SetUserRole changes the role of an account. Only admins can do this, and
+// not to themselves or to other admins.
+func (s *Store) SetUserRole(adminID, userID int, role string) error {
+	if !model.ValidRole(role) {
+		return fmt.Errorf("unknown role %q", role)
+	}
+	admin, err := s.FetchUserById(adminID)
+	if err != nil {
+		return err
+	}
+	if !admin.HasRole(model.RoleAdmin) {
+		return database.ErrInsufficientRole
+	}
+	if err := s.checkOutranks(adminID, userID); err != nil {
+		return err
+	}
+	return s.moderate(adminID, database.ActionSetRole, "user", userID, role,
+		"UPDATE users SET role = $1 WHERE id = $2", role, userID)
+}
+
+// PromoteAdmin makes an account an administrator. It is how the first admin
+// is created, so it checks nobody's role; a missing or deleted account
+// returns sql.ErrNoRows.
+func (s *Store) PromoteAdmin(username string) error {
+	result, err := s.db.Exec("UPDATE users SET role = 'admin' WHERE username = $1 AND deleted_at IS NULL", username)
+	if err != nil {
+		return fmt.Errorf("error promoting user: %w", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// FetchModerationLog returns the most recent moderation actions first.
+func (s *Store) FetchModerationLog(limit int) ([]model.ModerationEntry, error) {
+	rows, err := s.db.Query(`
+        SELECT m.id, u.username, m.action, m.target_type, m.target_id, m.reason, m.created_at
+        FROM moderation_log m
+        JOIN users u ON u.id = m.moderator_id
+        ORDER BY m.id DESC
+        LIMIT $1`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("error querying moderation log: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []model.ModerationEntry
+	for rows.Next() {
+		var e model.ModerationEntry
+		err := rows.Scan(&e.ID, &e.Moderator, &e.Action, &e.TargetType, &e.TargetID, &e.Reason, &e.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning moderation log: %w", err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// -- Non-Global Functions : Only happens in this package -- //
+
+// moderate runs update and writes the audit log row in one transaction.
+func (s *Store) moderate(moderatorID int, action, targetType string, targetID int, reason, update string, args ...interface{}) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := moderateTx(tx, moderatorID, action, targetType, targetID, reason, update, args...); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("error committing %s: %w", action, err)
+	}
+	return nil
+}
+
+// moderateTx is moderate inside a caller's transaction. An update that
+// matches no row returns sql.ErrNoRows.
+func moderateTx(tx *sql.Tx, moderatorID int, action, targetType string, targetID int, reason, update string, args ...interface{}) error {
+	result, err := tx.Exec(update, args...)
+	if err != nil {
+		return fmt.Errorf("error applying %s: %w", action, err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+
+	_, err = tx.Exec(
+		"INSERT INTO moderation_log (moderator_id, action, target_type, target_id, reason) VALUES ($1, $2, $3, $4, $5)",
+		moderatorID, action, targetType, targetID, reason,
+	)
+	if err != nil {
+		return fmt.Errorf("error logging %s: %w", action, err)
+	}
+	return nil
+}
+
+// checkOutranks makes sure the acting account has a higher role than the
+// target, so moderators cannot ban each other or an admin.
+func (s *Store) checkOutranks(actorID, targetID int) error {
+	actor, err := s.FetchUserById(actorID)
+	if err != nil {
+		return err
+	}
+	target, err := s.FetchUserById(targetID)
+	if err != nil {
+		return err
+	}
+	if actorID == targetID || target.HasRole(actor.Role) {
+		return database.ErrInsufficientRole
+	}
+	return nil
+}
+
+func timestampOrNull(set bool) string {
+	if set {
+		return "now()"
+	}
+	return "NULL"
+}
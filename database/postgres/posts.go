@@ -0,0 +1,482 @@
+package postgres
+
+import (
+	"database/sql"
+	"fmt"
+	"forum-go/database"
+	"forum-go/model"
+	"strconv"
+
+	"github.com/lib/pq"
+)
+
+// sortKeys are computed over the aggregated post rows in FetchPostPage.
+// Times are in seconds since the epoch.
+var sortKeys = map[string]struct {
+	expr string
+	desc bool
+}{
+	database.SortNewest:   {"EXTRACT(EPOCH FROM created_at)::float8", true},
+	database.SortOldest:   {"EXTRACT(EPOCH FROM created_at)::float8", false},
+	database.SortTop:      {"(upvotes - downvotes)::float8", true},
+	database.SortComments: {"comment_count::float8", true},
+	database.SortActive:   {"GREATEST(EXTRACT(EPOCH FROM created_at), COALESCE(last_comment_at, 0))::float8", true},
+}
+
+// postColumns are the columns every post listing reads, in the order
+// scanPosts expects them; the query must join users u and votes v.
+const postColumns = `p.id, u.username, p.title, p.content, p.user_id,
+               p.created_at, p.updated_at,
+               COUNT(*) FILTER (WHERE v.vote = 1) AS upvotes,
+               COUNT(*) FILTER (WHERE v.vote = -1) AS downvotes`
+
+// CreatePost saves a new post with its categories and returns its ID.
+func (s *Store) CreatePost(post *model.Post) (int64, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var id int64
+	err = tx.QueryRow(`
+        INSERT INTO posts (title, content, user_id, created_at, updated_at)
+        VALUES ($1, $2, $3, $4, $5)
+        RETURNING id`, post.Title, post.Content, post.UserID, post.CreatedAt, post.UpdatedAt).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("error saving post: %w", err)
+	}
+
+	for _, category := range post.Categories {
+		_, err = tx.Exec("INSERT INTO posts_categories (post_id, category_id) VALUES ($1, $2)", id, category.ID)
+		if err != nil {
+			return 0, fmt.Errorf("error linking category %s: %w", category.Name, err)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, fmt.Errorf("error committing post: %w", err)
+	}
+	return id, nil
+}
+
+// FetchPostByID returns a post that has not been deleted, with its
+// categories and comments. Hidden posts are returned with Hidden set.
+func (s *Store) FetchPostByID(postID int) (*model.Post, error) {
+	var post model.Post
+	var editedAt sql.NullTime
+	err := s.db.QueryRow(`
+        SELECT p.id, u.username, p.title, p.content, p.user_id,
+               p.created_at, p.updated_at, p.edited_at,
+               p.hidden_at IS NOT NULL, p.locked_at IS NOT NULL,
+               COUNT(*) FILTER (WHERE v.vote = 1),
+               COUNT(*) FILTER (WHERE v.vote = -1)
+        FROM posts p
+        JOIN users u ON p.user_id = u.id
+        LEFT JOIN votes v ON p.id = v.post_id
+        WHERE p.id = $1 AND p.deleted_at IS NULL
+        GROUP BY p.id, u.username`, postID).Scan(
+		&post.ID,
+		&post.Author,
+		&post.Title,
+		&post.Content,
+		&post.UserID,
+		&post.CreatedAt,
+		&post.UpdatedAt,
+		&editedAt,
+		&post.Hidden,
+		&post.Locked,
+		&post.Upvotes,
+		&post.Downvotes,
+	)
+	if err != nil {
+		return nil, err
+	}
+	post.EditedAt = editedAt.Time
+
+	if err = s.attachCategories([]*model.Post{&post}); err != nil {
+		return nil, err
+	}
+
+	comments, err := s.FetchCommentsByPostID(postID)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching comments: %w", err)
+	}
+	post.Comments = comments
+	return &post, nil
+}
+
+// FetchPosts returns the first page of the newest posts.
+func (s *Store) FetchPosts() ([]model.Post, error) {
+	page, err := s.FetchPostPage(database.PostListOptions{Sort: database.SortNewest})
+	if err != nil {
+		return nil, err
+	}
+	return page.Posts, nil
+}
+
+// FetchPostsByCategory returns the first page of the newest posts in category.
+func (s *Store) FetchPostsByCategory(category string) ([]model.Post, error) {
+	page, err := s.FetchPostPage(database.PostListOptions{Category: category, Sort: database.SortNewest})
+	if err != nil {
+		return nil, fmt.Errorf("error querying posts by category: %w", err)
+	}
+	return page.Posts, nil
+}
+
+// FetchPostPage returns one page of posts using keyset pagination. Its
+// cursors are only understood by this store.
+func (s *Store) FetchPostPage(opts database.PostListOptions) (*database.PostPage, error) {
+	if opts.Sort == "" {
+		opts.Sort = database.SortNewest
+	}
+	key, ok := sortKeys[opts.Sort]
+	if !ok {
+		return nil, fmt.Errorf("unknown sort order %q", opts.Sort)
+	}
+	if opts.Limit <= 0 {
+		opts.Limit = database.DefaultPageSize
+	}
+
+	var args []interface{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	where := `
+            WHERE p.deleted_at IS NULL AND p.hidden_at IS NULL`
+	if opts.Category != "" {
+		where += ` AND EXISTS (
+                SELECT 1 FROM posts_categories pc
+                JOIN categories c ON c.id = pc.category_id
+                WHERE pc.post_id = p.id AND c.name = ` + arg(opts.Category) + `
+            )`
+	}
+
+	// Walking backwards flips both the comparison and the order; the rows
+	// are put back in order by database.NewPostPage.
+	backward := opts.Before != ""
+	cursor := opts.After
+	if backward {
+		cursor = opts.Before
+	}
+	descending := key.desc != backward
+
+	keyset := ""
+	if cursor != "" {
+		sortValue, id, err := database.DecodeCursor(cursor)
+		if err != nil {
+			return nil, err
+		}
+		op := ">"
+		if descending {
+			op = "<"
+		}
+		keyset = "WHERE (sort_key, id) " + op + " (" + arg(sortValue) + "::float8, " + arg(id) + "::bigint)"
+	}
+
+	direction := "ASC"
+	if descending {
+		direction = "DESC"
+	}
+
+	query := `
+    SELECT id, username, title, content, user_id, created_at, updated_at,
+           upvotes, downvotes, comment_count, sort_key
+    FROM (
+        SELECT totals.*, ` + key.expr + ` AS sort_key
+        FROM (
+            SELECT ` + postColumns + `,
+                   (SELECT COUNT(*) FROM comments cm WHERE cm.post_id = p.id AND cm.deleted_at IS NULL AND cm.hidden_at IS NULL) AS comment_count,
+                   (SELECT MAX(EXTRACT(EPOCH FROM cm.created_at)) FROM comments cm WHERE cm.post_id = p.id AND cm.deleted_at IS NULL AND cm.hidden_at IS NULL) AS last_comment_at
+            FROM posts p
+            JOIN users u ON p.user_id = u.id
+            LEFT JOIN votes v ON p.id = v.post_id` + where + `
+            GROUP BY p.id, u.username
+        ) totals
+    ) keyed
+    ` + keyset + `
+    ORDER BY sort_key ` + direction + `, id ` + direction + `
+    LIMIT ` + arg(opts.Limit+1)
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error querying posts: %w", err)
+	}
+	defer rows.Close()
+
+	var posts []model.Post
+	var keys []float64
+	for rows.Next() {
+		var p model.Post
+		var sortValue float64
+		err := rows.Scan(
+			&p.ID,
+			&p.Author,
+			&p.Title,
+			&p.Content,
+			&p.UserID,
+			&p.CreatedAt,
+			&p.UpdatedAt,
+			&p.Upvotes,
+			&p.Downvotes,
+			&p.CommentCount,
+			&sortValue,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning post: %w", err)
+		}
+		posts = append(posts, p)
+		keys = append(keys, sortValue)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error after scanning posts: %w", err)
+	}
+	rows.Close()
+
+	page := database.NewPostPage(opts, posts, keys)
+	if err = s.attachCategories(postPointers(page.Posts)); err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+// FetchPostsByUserID returns the visible posts a user wrote, newest first.
+func (s *Store) FetchPostsByUserID(userID int) ([]*model.Post, error) {
+	return s.queryPosts("user posts", `
+        SELECT `+postColumns+`
+        FROM posts p
+        JOIN users u ON p.user_id = u.id
+        LEFT JOIN votes v ON p.id = v.post_id
+        WHERE p.user_id = $1 AND p.deleted_at IS NULL AND p.hidden_at IS NULL
+        GROUP BY p.id, u.username
+        ORDER BY p.created_at DESC`, userID)
+}
+
+// FetchLikedPostsByUserID returns the visible posts a user liked.
+func (s *Store) FetchLikedPostsByUserID(userID int) ([]*model.Post, error) {
+	return s.queryPosts("liked posts", votedPostsQuery(1), userID)
+}
+
+// FetchDislikedPostsByUserID returns the visible posts a user disliked.
+func (s *Store) FetchDislikedPostsByUserID(userID int) ([]*model.Post, error) {
+	return s.queryPosts("disliked posts", votedPostsQuery(-1), userID)
+}
+
+// FetchPostRevisions returns the earlier versions of a post, oldest first.
+func (s *Store) FetchPostRevisions(postID int) ([]model.Revision, error) {
+	rows, err := s.db.Query(
+		"SELECT id, title, content, created_at FROM post_revisions WHERE post_id = $1 ORDER BY id ASC",
+		postID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error querying post revisions: %w", err)
+	}
+	defer rows.Close()
+
+	var revisions []model.Revision
+	for rows.Next() {
+		var rev model.Revision
+		if err := rows.Scan(&rev.ID, &rev.Title, &rev.Content, &rev.CreatedAt); err != nil {
+			return nil, fmt.Errorf("error scanning post revision: %w", err)
+		}
+		revisions = append(revisions, rev)
+	}
+	return revisions, rows.Err()
+}
+
+// UpdatePost replaces the title and content of a post and keeps the
+// previous version in post_revisions. Missing, deleted or hidden posts
+// return sql.ErrNoRows.
+func (s *Store) UpdatePost(postID, userID int, title, content string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var authorID int
+	var oldTitle, oldContent string
+	err = tx.QueryRow(
+		"SELECT user_id, title, content FROM posts WHERE id = $1 AND deleted_at IS NULL AND hidden_at IS NULL FOR UPDATE", postID,
+	).Scan(&authorID, &oldTitle, &oldContent)
+	if err != nil {
+		return err
+	}
+	if authorID != userID {
+		return database.ErrNotAuthor
+	}
+	if title == oldTitle && content == oldContent {
+		return nil
+	}
+
+	_, err = tx.Exec(
+		"INSERT INTO post_revisions (post_id, title, content) VALUES ($1, $2, $3)",
+		postID, oldTitle, oldContent,
+	)
+	if err != nil {
+		return fmt.Errorf("error saving post revision: %w", err)
+	}
+
+	_, err = tx.Exec(`
+        UPDATE posts
+        SET title = $1, content = $2, updated_at = now(), edited_at = now()
+        WHERE id = $3`, title, content, postID)
+	if err != nil {
+		if isUniqueViolation(err, "posts_title_key") {
+			return database.ErrDuplicateTitle
+		}
+		return fmt.Errorf("error updating post: %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("error committing post edit: %w", err)
+	}
+	return nil
+}
+
+// DeletePost soft-deletes a post. It disappears from listings and search,
+// while its comments, votes and history stay in place.
+func (s *Store) DeletePost(postID, userID int) error {
+	return s.softDelete("posts", postID, userID)
+}
+
+// FetchCategories returns every category by name.
+func (s *Store) FetchCategories() ([]model.Category, error) {
+	rows, err := s.db.Query("SELECT id, name, emoji FROM categories ORDER BY name ASC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var categories []model.Category
+	for rows.Next() {
+		var c model.Category
+		if err := rows.Scan(&c.ID, &c.Name, &c.Emoji); err != nil {
+			return nil, err
+		}
+		categories = append(categories, c)
+	}
+	return categories, rows.Err()
+}
+
+// -- Non-Global Functions : Only happens in this package -- //
+
+func votedPostsQuery(vote int) string {
+	return `
+        SELECT ` + postColumns + `
+        FROM posts p
+        JOIN users u ON p.user_id = u.id
+        LEFT JOIN votes v ON p.id = v.post_id
+        WHERE p.deleted_at IS NULL AND p.hidden_at IS NULL
+            AND EXISTS (SELECT 1 FROM votes mine WHERE mine.post_id = p.id AND mine.user_id = $1 AND mine.vote = ` + strconv.Itoa(vote) + `)
+        GROUP BY p.id, u.username
+        ORDER BY p.created_at DESC`
+}
+
+// queryPosts runs a listing built on postColumns and attaches the
+// categories. what names the listing in errors.
+func (s *Store) queryPosts(what, query string, args ...interface{}) ([]*model.Post, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error querying %s: %w", what, err)
+	}
+	defer rows.Close()
+
+	var posts []*model.Post
+	for rows.Next() {
+		var p model.Post
+		err := rows.Scan(
+			&p.ID,
+			&p.Author,
+			&p.Title,
+			&p.Content,
+			&p.UserID,
+			&p.CreatedAt,
+			&p.UpdatedAt,
+			&p.Upvotes,
+			&p.Downvotes,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning %s: %w", what, err)
+		}
+		posts = append(posts, &p)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error after scanning %s: %w", what, err)
+	}
+	rows.Close()
+
+	if err = s.attachCategories(posts); err != nil {
+		return nil, err
+	}
+	return posts, nil
+}
+
+// attachCategories fills Categories on each post with a single query
+// through posts_categories.
+func (s *Store) attachCategories(posts []*model.Post) error {
+	if len(posts) == 0 {
+		return nil
+	}
+
+	byID := make(map[int]*model.Post, len(posts))
+	ids := make([]int64, 0, len(posts))
+	for _, p := range posts {
+		byID[p.ID] = p
+		ids = append(ids, int64(p.ID))
+	}
+
+	rows, err := s.db.Query(`
+        SELECT pc.post_id, c.id, c.name, c.emoji
+        FROM posts_categories pc
+        JOIN categories c ON c.id = pc.category_id
+        WHERE pc.post_id = ANY($1)
+        ORDER BY c.name ASC`, pq.Array(ids))
+	if err != nil {
+		return fmt.Errorf("error querying post categories: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var postID int
+		var c model.Category
+		if err := rows.Scan(&postID, &c.ID, &c.Name, &c.Emoji); err != nil {
+			return fmt.Errorf("error scanning post category: %w", err)
+		}
+		if p, ok := byID[postID]; ok {
+			p.Categories = append(p.Categories, c)
+		}
+	}
+	return rows.Err()
+}
+
+func postPointers(posts []model.Post) []*model.Post {
+	ptrs := make([]*model.Post, len(posts))
+	for i := range posts {
+		ptrs[i] = &posts[i]
+	}
+	return ptrs
+}
+
+// softDelete marks a row of posts or comments as deleted after checking
+// that userID wrote it. table is never user input.
+func (s *Store) softDelete(table string, id, userID int) error {
+	var authorID int
+	err := s.db.QueryRow(
+		"SELECT user_id FROM "+table+" WHERE id = $1 AND deleted_at IS NULL", id,
+	).Scan(&authorID)
+	if err != nil {
+		return err
+	}
+	if authorID != userID {
+		return database.ErrNotAuthor
+	}
+
+	_, err = s.db.Exec("UPDATE "+table+" SET deleted_at = now() WHERE id = $1", id)
+	if err != nil {
+		return fmt.Errorf("error deleting from %s: %w", table, err)
+	}
+	return nil
+}
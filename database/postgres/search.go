@@ -0,0 +1,123 @@
+package postgres
+
+import (
+	"fmt"
+	"forum-go/database"
+	"forum-go/model"
+	"strconv"
+	"strings"
+)
+
+const defaultSearchLimit = 50
+
+// headlineOptions makes ts_headline mark hits the way the SQLite snippets
+// do, so the same code can escape and highlight them.
+var headlineOptions = `StartSel="` + database.SnippetOpen + `", StopSel="` + database.SnippetClose + `", ` +
+	`MaxWords=12, MinWords=6, MaxFragments=1, FragmentDelimiter="…"`
+
+// SearchPosts finds posts and comments containing every word of the query,
+// best matches first. Title hits weigh more than body hits.
+func (s *Store) SearchPosts(opts database.SearchOptions) ([]model.SearchResult, error) {
+	terms := database.SearchTerms(opts.Query)
+	if len(terms) == 0 {
+		return nil, nil
+	}
+	if opts.Limit <= 0 {
+		opts.Limit = defaultSearchLimit
+	}
+
+	var args []interface{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	// plainto_tsquery joins the words with AND and ignores any operators
+	match := "plainto_tsquery('simple', " + arg(strings.Join(terms, " ")) + ")"
+	options := arg(headlineOptions)
+
+	query := `
+    SELECT post_id, post_title, comment_id, author, created_at, snippet
+    FROM (
+        SELECT p.id AS post_id, p.title AS post_title, 0::bigint AS comment_id,
+               u.username AS author, p.created_at AS created_at,
+               ts_headline('simple', p.title || ' ' || p.content, ` + match + `, ` + options + `) AS snippet,
+               ts_rank(p.search, ` + match + `) AS rank
+        FROM posts p
+        JOIN users u ON u.id = p.user_id
+        WHERE p.search @@ ` + match + ` AND p.deleted_at IS NULL AND p.hidden_at IS NULL` + searchFilters(opts, "p", arg) + `
+
+        UNION ALL
+
+        SELECT p.id, p.title, c.id,
+               u.username, c.created_at,
+               ts_headline('simple', c.content, ` + match + `, ` + options + `),
+               ts_rank(c.search, ` + match + `)
+        FROM comments c
+        JOIN posts p ON p.id = c.post_id
+        JOIN users u ON u.id = c.user_id
+        WHERE c.search @@ ` + match + `
+            AND c.deleted_at IS NULL AND c.hidden_at IS NULL
+            AND p.deleted_at IS NULL AND p.hidden_at IS NULL` + searchFilters(opts, "c", arg) + `
+    ) hits
+    ORDER BY rank DESC, created_at DESC
+    LIMIT ` + arg(opts.Limit)
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error searching posts: %w", err)
+	}
+	defer rows.Close()
+
+	var results []model.SearchResult
+	for rows.Next() {
+		var r model.SearchResult
+		err := rows.Scan(
+			&r.PostID,
+			&r.PostTitle,
+			&r.CommentID,
+			&r.Author,
+			&r.CreatedAt,
+			&r.Snippet,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning search result: %w", err)
+		}
+		results = append(results, r)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error after scanning search results: %w", err)
+	}
+	return results, nil
+}
+
+// -- Non-Global Functions : Only happens in this package -- //
+
+// searchFilters builds the extra WHERE clauses for one side of the search,
+// numbering its arguments through arg. alias is the table whose author and
+// date are filtered ("p" or "c").
+func searchFilters(opts database.SearchOptions, alias string, arg func(interface{}) string) string {
+	var clauses []string
+
+	if opts.Category != "" {
+		clauses = append(clauses, `EXISTS (
+            SELECT 1 FROM posts_categories pc
+            JOIN categories cat ON cat.id = pc.category_id
+            WHERE pc.post_id = p.id AND cat.name = `+arg(opts.Category)+`
+        )`)
+	}
+	if opts.Author != "" {
+		clauses = append(clauses, "u.username = "+arg(opts.Author))
+	}
+	if !opts.From.IsZero() {
+		clauses = append(clauses, alias+".created_at >= "+arg(opts.From))
+	}
+	if !opts.To.IsZero() {
+		clauses = append(clauses, alias+".created_at < "+arg(opts.To))
+	}
+
+	if len(clauses) == 0 {
+		return ""
+	}
+	return "\n        AND " + strings.Join(clauses, "\n        AND ")
+}
@@ -0,0 +1,199 @@
+package postgres
+
+import (
+	"database/sql"
+	"fmt"
+	"forum-go/database"
+	"forum-go/model"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+// CheckUserLoggedIn reports whether the request carries the cookie of a
+// live session, and whose it is.
+func (s *Store) CheckUserLoggedIn(r *http.Request) (bool, int) {
+	sessionToken, err := r.Cookie("session_token")
+	if err != nil {
+		return false, 0
+	}
+	return s.SessionUserID(sessionToken.Value)
+}
+
+// SessionUserID looks up the user of a session token. Expired sessions are
+// removed, and live ones are renewed.
+func (s *Store) SessionUserID(token string) (bool, int) {
+	var userID int
+	var expiresAt time.Time
+
+	err := s.db.QueryRow("SELECT user_id, session_expiry FROM sessions WHERE session_token = $1", token).Scan(&userID, &expiresAt)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("Error checking session: %v", err)
+		}
+		return false, 0
+	}
+
+	if time.Now().After(expiresAt) {
+		if err := s.EndSession(token); err != nil {
+			log.Printf("Error deleting session: %v", err)
+		}
+		return false, 0
+	}
+
+	if _, err := s.RenewSession(token); err != nil {
+		log.Printf("Error updating session: %v", err)
+	}
+	return true, userID
+}
+
+// ConfigureSessions sets the session lifetimes and cookie attributes. Call
+// it before the store is in use.
+func (s *Store) ConfigureSessions(opts database.SessionOptions) {
+	s.sessions = opts
+}
+
+// SessionOptions returns the session lifetimes and cookie attributes.
+func (s *Store) SessionOptions() database.SessionOptions {
+	return s.sessions
+}
+
+// CreateSession starts a session for userID on the device described by
+// userAgent and ip, and sets its cookie.
+func (s *Store) CreateSession(w http.ResponseWriter, userID int, userAgent, ip string, remember bool) error {
+	cookie, err := s.NewSession(userID, userAgent, ip, remember)
+	if err != nil {
+		return err
+	}
+
+	http.SetCookie(w, cookie)
+	return nil
+}
+
+// NewSession starts a session for userID and returns its cookie without
+// sending it.
+func (s *Store) NewSession(userID int, userAgent, ip string, remember bool) (*http.Cookie, error) {
+	token, err := uuid.NewV4()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	expires := now.Add(s.sessions.Lifetime(remember))
+	_, err = s.db.Exec(`
+        INSERT INTO sessions
+        (user_id, session_token, session_expiry, user_agent, ip, created_at, last_seen, remember)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		userID, token.String(), expires, userAgent, ip, now, now, remember)
+	if err != nil {
+		return nil, fmt.Errorf("failed to insert session: %v", err)
+	}
+	return s.sessions.Cookie(token.String(), expires), nil
+}
+
+// RenewSession moves the end of a live session back to a full lifetime
+// from now and returns its refreshed cookie, at most once every
+// database.SessionSeenInterval.
+func (s *Store) RenewSession(token string) (*http.Cookie, error) {
+	now := time.Now().UTC()
+	var remember bool
+	err := s.db.QueryRow(`
+        UPDATE sessions
+        SET last_seen = $1, session_expiry = CASE WHEN remember THEN $2::timestamptz ELSE $3::timestamptz END
+        WHERE session_token = $4 AND session_expiry > $1 AND (last_seen IS NULL OR last_seen < $5)
+        RETURNING remember`,
+		now, now.Add(s.sessions.RememberTTL), now.Add(s.sessions.TTL), token, now.Add(-database.SessionSeenInterval)).Scan(&remember)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error renewing session: %w", err)
+	}
+	return s.sessions.Cookie(token, now.Add(s.sessions.Lifetime(remember))), nil
+}
+
+// PurgeExpiredSessions deletes sessions that have run out and returns how
+// many there were.
+func (s *Store) PurgeExpiredSessions() (int64, error) {
+	result, err := s.db.Exec("DELETE FROM sessions WHERE session_expiry <= now()")
+	if err != nil {
+		return 0, fmt.Errorf("error purging sessions: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+// StartSessionJanitor purges expired sessions every interval in the
+// background until the returned stop function is called.
+func (s *Store) StartSessionJanitor(interval time.Duration) (stop func()) {
+	return database.RunSessionJanitor(interval, s.PurgeExpiredSessions)
+}
+
+// FetchSessions returns the live sessions of a user, most recently used
+// first. The one with currentToken is marked Current.
+func (s *Store) FetchSessions(userID int, currentToken string) ([]model.Session, error) {
+	rows, err := s.db.Query(`
+        SELECT id, session_token, user_agent, ip, created_at, last_seen
+        FROM sessions
+        WHERE user_id = $1 AND session_expiry > now()
+        ORDER BY last_seen DESC NULLS LAST, id DESC`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching sessions: %w", err)
+	}
+	defer rows.Close()
+
+	var sessions []model.Session
+	for rows.Next() {
+		var session model.Session
+		var token string
+		var createdAt, lastSeen sql.NullTime
+		if err := rows.Scan(&session.ID, &token, &session.UserAgent, &session.IP, &createdAt, &lastSeen); err != nil {
+			return nil, fmt.Errorf("error scanning session: %w", err)
+		}
+		session.CreatedAt = createdAt.Time
+		session.LastSeen = lastSeen.Time
+		session.Current = token == currentToken
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
+// EndUserSession signs one of a user's sessions out. It returns
+// sql.ErrNoRows when the user has no session with that ID.
+func (s *Store) EndUserSession(userID, sessionID int) error {
+	result, err := s.db.Exec("DELETE FROM sessions WHERE id = $1 AND user_id = $2", sessionID, userID)
+	if err != nil {
+		return fmt.Errorf("error deleting session: %w", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// EndAllSessions signs a user out on every device.
+func (s *Store) EndAllSessions(userID int) error {
+	if _, err := s.db.Exec("DELETE FROM sessions WHERE user_id = $1", userID); err != nil {
+		return fmt.Errorf("error deleting sessions: %w", err)
+	}
+	return nil
+}
+
+// EndOtherSessions signs a user out everywhere except the session with
+// the given token, as after a password change.
+func (s *Store) EndOtherSessions(userID int, keepToken string) error {
+	_, err := s.db.Exec("DELETE FROM sessions WHERE user_id = $1 AND session_token <> $2", userID, keepToken)
+	if err != nil {
+		return fmt.Errorf("error deleting sessions: %w", err)
+	}
+	return nil
+}
+
+// EndSession deletes a session so its token stops working.
+func (s *Store) EndSession(token string) error {
+	if _, err := s.db.Exec("DELETE FROM sessions WHERE session_token = $1", token); err != nil {
+		return fmt.Errorf("error deleting session: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,195 @@
+package postgres
+
+import (
+	"database/sql"
+	"fmt"
+	"forum-go/database"
+	"forum-go/model"
+	"time"
+)
+
+// FetchUserById returns an account with its unread notification and
+// message counts.
+func (s *Store) FetchUserById(userID int) (*model.User, error) {
+	var user model.User
+	var bannedAt, verifiedAt, twoFactorAt sql.NullTime
+	err := s.db.QueryRow(`
+        SELECT id, username, email, role, banned_at, email_verified_at, totp_enabled_at, created_at,
+               (SELECT COUNT(*) FROM notifications n WHERE n.user_id = users.id AND n.read_at IS NULL),
+               (SELECT COUNT(*) FROM messages m WHERE m.recipient_id = users.id AND m.read_at IS NULL)
+        FROM users WHERE id = $1`, userID).Scan(
+		&user.ID,
+		&user.Username,
+		&user.Email,
+		&user.Role,
+		&bannedAt,
+		&verifiedAt,
+		&twoFactorAt,
+		&user.CreatedAt,
+		&user.UnreadCount,
+		&user.UnreadMessages)
+	if err != nil {
+		return nil, err
+	}
+	user.BannedAt = bannedAt.Time
+	user.EmailVerifiedAt = verifiedAt.Time
+	user.TwoFactorAt = twoFactorAt.Time
+	return &user, nil
+}
+
+// FetchUserByEmail looks up an account by its email address, ignoring
+// case.
+func (s *Store) FetchUserByEmail(email string) (*model.User, error) {
+	var id int
+	err := s.db.QueryRow("SELECT id FROM users WHERE lower(email) = lower($1)", email).Scan(&id)
+	if err != nil {
+		return nil, err
+	}
+	return s.FetchUserById(id)
+}
+
+// FetchUserIDByUsername looks up an account by its exact username.
+func (s *Store) FetchUserIDByUsername(username string) (int, error) {
+	var id int
+	err := s.db.QueryRow("SELECT id FROM users WHERE username = $1", username).Scan(&id)
+	return id, err
+}
+
+// FetchUsers returns every account that has not been deleted, by username.
+func (s *Store) FetchUsers() ([]model.User, error) {
+	rows, err := s.db.Query("SELECT id, username, email, role, banned_at, created_at FROM users WHERE deleted_at IS NULL ORDER BY username ASC")
+	if err != nil {
+		return nil, fmt.Errorf("error querying users: %w", err)
+	}
+	defer rows.Close()
+
+	var users []model.User
+	for rows.Next() {
+		var u model.User
+		var bannedAt sql.NullTime
+		if err := rows.Scan(&u.ID, &u.Username, &u.Email, &u.Role, &bannedAt, &u.CreatedAt); err != nil {
+			return nil, fmt.Errorf("error scanning user: %w", err)
+		}
+		u.BannedAt = bannedAt.Time
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+// UsernameTaken reports whether an account already has username.
+func (s *Store) UsernameTaken(username string) (bool, error) {
+	var count int
+	err := s.db.QueryRow("SELECT COUNT(*) FROM users WHERE username = $1", username).Scan(&count)
+	if err != nil {
+		return false, fmt.Errorf("error checking username: %w", err)
+	}
+	return count > 0, nil
+}
+
+// EmailTaken reports whether an account already has email.
+func (s *Store) EmailTaken(email string) (bool, error) {
+	var count int
+	err := s.db.QueryRow("SELECT COUNT(*) FROM users WHERE email = $1", email).Scan(&count)
+	if err != nil {
+		return false, fmt.Errorf("error checking email: %w", err)
+	}
+	return count > 0, nil
+}
+
+// UpdateUsername renames an account. Posts and comments show the new name
+// right away since they are joined to the user.
+func (s *Store) UpdateUsername(userID int, username string) error {
+	_, err := s.db.Exec("UPDATE users SET username = $1 WHERE id = $2 AND deleted_at IS NULL", username, userID)
+	if err != nil {
+		if isUniqueViolation(err, "users_username_key") {
+			return database.ErrUsernameTaken
+		}
+		return fmt.Errorf("error updating username: %w", err)
+	}
+	return nil
+}
+
+// UpdateEmail changes the address of an account and marks it unverified.
+// Links already emailed to the old address stop working.
+func (s *Store) UpdateEmail(userID int, email string) error {
+	_, err := s.db.Exec(
+		"UPDATE users SET email = $1, email_verified_at = NULL WHERE id = $2 AND deleted_at IS NULL",
+		email, userID,
+	)
+	if err != nil {
+		if isUniqueViolation(err, "users_email_key") {
+			return database.ErrEmailTaken
+		}
+		return fmt.Errorf("error updating email: %w", err)
+	}
+	return nil
+}
+
+// DeleteAccount closes an account the same way as the SQLite store: its
+// sign-in details are replaced and everything tied to it is removed, and
+// with removeContent its posts, comments and votes go too.
+func (s *Store) DeleteAccount(userID int, removeContent bool) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	type statement struct {
+		query   string
+		withNow bool
+	}
+	var statements []statement
+	if removeContent {
+		statements = append(statements,
+			statement{`DELETE FROM post_revisions WHERE post_id IN (SELECT id FROM posts WHERE user_id = $1)`, false},
+			statement{`DELETE FROM comment_revisions WHERE comment_id IN (SELECT id FROM comments WHERE user_id = $1)`, false},
+			// Titles are unique, so each removed post gets its own placeholder
+			statement{`UPDATE posts SET title = '[deleted post ' || id || ']', content = '',
+				deleted_at = COALESCE(deleted_at, $2) WHERE user_id = $1`, true},
+			statement{`UPDATE comments SET content = '', deleted_at = COALESCE(deleted_at, $2) WHERE user_id = $1`, true},
+			statement{`DELETE FROM votes WHERE user_id = $1`, false},
+		)
+	}
+	for _, query := range []string{
+		`DELETE FROM sessions WHERE user_id = $1`,
+		`DELETE FROM user_tokens WHERE user_id = $1`,
+		`DELETE FROM user_identities WHERE user_id = $1`,
+		`DELETE FROM recovery_codes WHERE user_id = $1`,
+		`DELETE FROM notifications WHERE user_id = $1`,
+		`DELETE FROM notification_preferences WHERE user_id = $1`,
+		`DELETE FROM user_blocks WHERE blocker_id = $1 OR blocked_id = $1`,
+	} {
+		statements = append(statements, statement{query, false})
+	}
+
+	// Postgres wants exactly as many arguments as a statement uses
+	for _, stmt := range statements {
+		args := []interface{}{userID}
+		if stmt.withNow {
+			args = append(args, now)
+		}
+		if _, err := tx.Exec(stmt.query, args...); err != nil {
+			return fmt.Errorf("error deleting account data: %w", err)
+		}
+	}
+
+	// Brackets are not allowed in usernames and the placeholder has no @,
+	// so no one can register either value
+	placeholder := fmt.Sprintf("[deleted-%d]", userID)
+	result, err := tx.Exec(`UPDATE users SET username = $1, email = $2, password_hash = '', role = 'user',
+		email_verified_at = NULL, totp_secret = '', totp_enabled_at = NULL, deleted_at = $3 WHERE id = $4 AND deleted_at IS NULL`,
+		placeholder, placeholder, now, userID)
+	if err != nil {
+		return fmt.Errorf("error deleting account: %w", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("error deleting account: user %d not found", userID)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing account deletion: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package postgres
+
+import (
+	"database/sql"
+	"fmt"
+	"forum-go/database"
+)
+
+// TogglePostVote applies a click on a post's like or dislike button: the
+// same vote again undoes it, the other one replaces it. Missing, deleted
+// and hidden posts return sql.ErrNoRows.
+func (s *Store) TogglePostVote(userID, postID, vote int) (*database.VoteResult, error) {
+	return s.toggleVote("post_id", "posts", userID, postID, vote)
+}
+
+// ToggleCommentVote is TogglePostVote for comments.
+func (s *Store) ToggleCommentVote(userID, commentID, vote int) (*database.VoteResult, error) {
+	return s.toggleVote("comment_id", "comments", userID, commentID, vote)
+}
+
+// UpdateVote sets a user's vote on a post, replacing any earlier one.
+func (s *Store) UpdateVote(userID, postID, voteValue int) error {
+	_, err := s.db.Exec(`
+        INSERT INTO votes (user_id, post_id, vote) VALUES ($1, $2, $3)
+        ON CONFLICT (user_id, post_id) DO UPDATE SET vote = EXCLUDED.vote`,
+		userID, postID, voteValue)
+	return err
+}
+
+// -- Non-Global Functions : Only happens in this package -- //
+
+// toggleVote does the work of TogglePostVote and ToggleCommentVote. column
+// and table are never user input.
+func (s *Store) toggleVote(column, table string, userID, targetID, vote int) (*database.VoteResult, error) {
+	if vote != 1 && vote != -1 {
+		return nil, database.ErrInvalidVote
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Locking the target keeps two clicks from the same user apart
+	postColumn := "id"
+	if table == "comments" {
+		postColumn = "post_id"
+	}
+	var postID int
+	err = tx.QueryRow(
+		"SELECT "+postColumn+" FROM "+table+" WHERE id = $1 AND deleted_at IS NULL AND hidden_at IS NULL FOR UPDATE", targetID,
+	).Scan(&postID)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &database.VoteResult{Vote: vote, PostID: postID}
+	var existing int
+	err = tx.QueryRow("SELECT vote FROM votes WHERE user_id = $1 AND "+column+" = $2", userID, targetID).Scan(&existing)
+	switch {
+	case err == sql.ErrNoRows:
+		_, err = tx.Exec("INSERT INTO votes (user_id, "+column+", vote) VALUES ($1, $2, $3)", userID, targetID, vote)
+	case err != nil:
+		return nil, fmt.Errorf("error reading vote: %w", err)
+	case existing == vote:
+		// Clicking the same button again takes the vote back
+		result.Vote = 0
+		_, err = tx.Exec("DELETE FROM votes WHERE user_id = $1 AND "+column+" = $2", userID, targetID)
+	default:
+		_, err = tx.Exec("UPDATE votes SET vote = $1 WHERE user_id = $2 AND "+column+" = $3", vote, userID, targetID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error saving vote: %w", err)
+	}
+
+	err = tx.QueryRow(`
+        SELECT COUNT(*) FILTER (WHERE vote = 1), COUNT(*) FILTER (WHERE vote = -1)
+        FROM votes WHERE `+column+` = $1`, targetID).Scan(&result.Upvotes, &result.Downvotes)
+	if err != nil {
+		return nil, fmt.Errorf("error counting votes: %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("error committing vote: %w", err)
+	}
+
+	return result, nil
+}
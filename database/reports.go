@@ -0,0 +1,239 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"forum-go/model"
+	"strings"
+)
+
+// Report reasons, in the order they are offered to readers.
+const (
+	ReasonSpam       = "spam"
+	ReasonSpoiler    = "spoiler"
+	ReasonHarassment = "harassment"
+	ReasonOther      = "other"
+)
+
+var ReportReasons = []string{ReasonSpam, ReasonSpoiler, ReasonHarassment, ReasonOther}
+
+// Report statuses.
+const (
+	ReportOpen      = "open"
+	ReportAccepted  = "accepted"
+	ReportDismissed = "dismissed"
+)
+
+// ErrAlreadyReported is returned when a reader reports the same item again
+// while their earlier report is still open.
+var ErrAlreadyReported = errors.New("you have already reported this")
+
+// ValidReportReason reports whether reason is one of ReportReasons.
+func ValidReportReason(reason string) bool {
+	for _, r := range ReportReasons {
+		if r == reason {
+			return true
+		}
+	}
+	return false
+}
+
+// CreateReport files a report on a live post or comment. Missing, deleted
+// or already hidden targets return sql.ErrNoRows.
+func (s *Store) CreateReport(reporterID int, targetType string, targetID int, reason, details string) error {
+	if !ValidReportReason(reason) {
+		return fmt.Errorf("unknown report reason %q", reason)
+	}
+	table, err := reportTable(targetType)
+	if err != nil {
+		return err
+	}
+
+	var exists int
+	err = s.db.QueryRow(
+		"SELECT 1 FROM "+table+" WHERE id = ? AND deleted_at IS NULL AND hidden_at IS NULL", targetID,
+	).Scan(&exists)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec(
+		"INSERT INTO reports (reporter_id, target_type, target_id, reason, details) VALUES (?, ?, ?, ?, ?)",
+		reporterID, targetType, targetID, reason, details,
+	)
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE constraint failed") {
+			return ErrAlreadyReported
+		}
+		return fmt.Errorf("error saving report: %w", err)
+	}
+	return nil
+}
+
+// FetchOpenReports returns the moderation queue, oldest report first.
+func (s *Store) FetchOpenReports() ([]model.Report, error) {
+	return s.fetchReports("r.status = ?", ReportOpen, "ASC")
+}
+
+// FetchReportsByUser returns the reports a reader filed, newest first.
+func (s *Store) FetchReportsByUser(userID int) ([]model.Report, error) {
+	return s.fetchReports("r.reporter_id = ?", userID, "DESC")
+}
+
+// ResolveReport closes an open report. Accepting hides the reported item
+// and closes every other open report on it too; dismissing only closes
+// this one. Both are written to the moderation log, and every reporter
+// whose report was closed is notified.
+func (s *Store) ResolveReport(moderatorID, reportID int, accept bool) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var targetType, reason string
+	var targetID, reporterID int
+	err = tx.QueryRow(
+		"SELECT target_type, target_id, reason, reporter_id FROM reports WHERE id = ? AND status = ?", reportID, ReportOpen,
+	).Scan(&targetType, &targetID, &reason, &reporterID)
+	if err != nil {
+		return err
+	}
+
+	postID, commentID := targetID, 0
+	if targetType == "comment" {
+		commentID = targetID
+		if err := tx.QueryRow("SELECT post_id FROM comments WHERE id = ?", commentID).Scan(&postID); err != nil {
+			return fmt.Errorf("error loading reported comment: %w", err)
+		}
+	}
+	reporters := []int{reporterID}
+	outcome := ReportDismissed
+
+	logReason := fmt.Sprintf("report #%d: %s", reportID, reason)
+	if accept {
+		table, err := reportTable(targetType)
+		if err != nil {
+			return err
+		}
+		action := ActionHidePost
+		if targetType == "comment" {
+			action = ActionHideComment
+		}
+		err = moderateTx(tx, moderatorID, action, targetType, targetID, logReason,
+			"UPDATE "+table+" SET hidden_at = COALESCE(hidden_at, CURRENT_TIMESTAMP) WHERE id = ?", targetID)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+
+		if reporters, err = openReporters(tx, targetType, targetID); err != nil {
+			return err
+		}
+		outcome = ReportAccepted
+
+		_, err = tx.Exec(`
+            UPDATE reports SET status = ?, resolved_by = ?, resolved_at = CURRENT_TIMESTAMP
+            WHERE target_type = ? AND target_id = ? AND status = ?
+        `, ReportAccepted, moderatorID, targetType, targetID, ReportOpen)
+		if err != nil {
+			return fmt.Errorf("error accepting reports: %w", err)
+		}
+	} else {
+		err = moderateTx(tx, moderatorID, ActionDismissReport, "report", reportID, logReason, `
+            UPDATE reports SET status = ?, resolved_by = ?, resolved_at = CURRENT_TIMESTAMP
+            WHERE id = ?
+        `, ReportDismissed, moderatorID, reportID)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, id := range reporters {
+		if err := notify(tx, id, 0, NotificationReport, postID, commentID, outcome); err != nil {
+			return err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("error committing report resolution: %w", err)
+	}
+	return nil
+}
+
+// -- Non-Global Functions : Only happens in this package -- //
+
+func reportTable(targetType string) (string, error) {
+	switch targetType {
+	case "post":
+		return "posts", nil
+	case "comment":
+		return "comments", nil
+	}
+	return "", fmt.Errorf("unknown report target %q", targetType)
+}
+
+// openReporters lists who has an open report on the target.
+func openReporters(tx *sql.Tx, targetType string, targetID int) ([]int, error) {
+	rows, err := tx.Query(
+		"SELECT reporter_id FROM reports WHERE target_type = ? AND target_id = ? AND status = ?",
+		targetType, targetID, ReportOpen,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error querying reporters: %w", err)
+	}
+	defer rows.Close()
+
+	var reporters []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("error scanning reporter: %w", err)
+		}
+		reporters = append(reporters, id)
+	}
+	return reporters, rows.Err()
+}
+
+func (s *Store) fetchReports(where string, arg interface{}, order string) ([]model.Report, error) {
+	rows, err := s.db.Query(`
+        SELECT r.id, u.username, r.target_type, r.target_id,
+               COALESCE(p.id, c.post_id, 0),
+               COALESCE(p.title, c.content, ''),
+               r.reason, r.details, r.status, r.created_at, r.resolved_at
+        FROM reports r
+        JOIN users u ON u.id = r.reporter_id
+        LEFT JOIN posts p ON r.target_type = 'post' AND p.id = r.target_id
+        LEFT JOIN comments c ON r.target_type = 'comment' AND c.id = r.target_id
+        WHERE `+where+`
+        ORDER BY r.id `+order, arg)
+	if err != nil {
+		return nil, fmt.Errorf("error querying reports: %w", err)
+	}
+	defer rows.Close()
+
+	var reports []model.Report
+	for rows.Next() {
+		var rep model.Report
+		var resolvedAt sql.NullTime
+		err := rows.Scan(
+			&rep.ID,
+			&rep.Reporter,
+			&rep.TargetType,
+			&rep.TargetID,
+			&rep.PostID,
+			&rep.Excerpt,
+			&rep.Reason,
+			&rep.Details,
+			&rep.Status,
+			&rep.CreatedAt,
+			&resolvedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning report: %w", err)
+		}
+		rep.ResolvedAt = resolvedAt.Time
+		reports = append(reports, rep)
+	}
+	return reports, rows.Err()
+}
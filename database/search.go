@@ -0,0 +1,262 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"forum-go/model"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Snippet markers wrap matched terms in SearchResult.Snippet. They are
+// control characters so they can never collide with user content and the
+// caller can escape the text before turning them into markup.
+const (
+	SnippetOpen  = "\x02"
+	SnippetClose = "\x03"
+)
+
+const defaultSearchLimit = 50
+
+// SearchOptions filters a full-text search. Zero values mean "no filter".
+type SearchOptions struct {
+	Query    string
+	Category string
+	Author   string
+	From     time.Time
+	To       time.Time
+	Limit    int
+}
+
+// ErrNoFTS5 is returned when SQLite was compiled without FTS5, which
+// search needs. mattn/go-sqlite3 only includes it with the sqlite_fts5
+// build tag.
+var ErrNoFTS5 = errors.New("SQLite was built without FTS5; build with -tags sqlite_fts5")
+
+// createSearchIndex builds the posts_fts and comments_fts tables and the
+// triggers that keep them in sync.
+func createSearchIndex(tx *sql.Tx) error {
+	err := execSQL(
+		`CREATE VIRTUAL TABLE posts_fts USING fts5(title, content)`,
+		`CREATE VIRTUAL TABLE comments_fts USING fts5(content)`,
+		`INSERT INTO posts_fts (rowid, title, content) SELECT id, title, content FROM posts`,
+		`INSERT INTO comments_fts (rowid, content) SELECT id, content FROM comments`,
+		`CREATE TRIGGER posts_fts_insert AFTER INSERT ON posts BEGIN
+			INSERT INTO posts_fts (rowid, title, content) VALUES (new.id, new.title, new.content);
+		END`,
+		`CREATE TRIGGER posts_fts_update AFTER UPDATE OF title, content ON posts BEGIN
+			UPDATE posts_fts SET title = new.title, content = new.content WHERE rowid = old.id;
+		END`,
+		`CREATE TRIGGER posts_fts_delete AFTER DELETE ON posts BEGIN
+			DELETE FROM posts_fts WHERE rowid = old.id;
+		END`,
+	)(tx)
+	if err != nil {
+		return err
+	}
+	return execSQL(commentSearchTriggers...)(tx)
+}
+
+// dropSearchIndex removes what createSearchIndex builds.
+var dropSearchIndex = []string{
+	`DROP TRIGGER IF EXISTS comments_fts_delete`,
+	`DROP TRIGGER IF EXISTS comments_fts_update`,
+	`DROP TRIGGER IF EXISTS comments_fts_insert`,
+	`DROP TRIGGER IF EXISTS posts_fts_delete`,
+	`DROP TRIGGER IF EXISTS posts_fts_update`,
+	`DROP TRIGGER IF EXISTS posts_fts_insert`,
+	`DROP TABLE IF EXISTS comments_fts`,
+	`DROP TABLE IF EXISTS posts_fts`,
+}
+
+// commentSearchTriggers are kept separately because rebuilding the comments
+// table drops them along with it.
+var commentSearchTriggers = []string{
+	`CREATE TRIGGER comments_fts_insert AFTER INSERT ON comments BEGIN
+		INSERT INTO comments_fts (rowid, content) VALUES (new.id, new.content);
+	END`,
+	`CREATE TRIGGER comments_fts_update AFTER UPDATE OF content ON comments BEGIN
+		UPDATE comments_fts SET content = new.content WHERE rowid = old.id;
+	END`,
+	`CREATE TRIGGER comments_fts_delete AFTER DELETE ON comments BEGIN
+		DELETE FROM comments_fts WHERE rowid = old.id;
+	END`,
+}
+
+// SearchPosts runs a ranked full-text search over post titles, post content
+// and comments. Comment hits link back to their post.
+func (s *Store) SearchPosts(opts SearchOptions) ([]model.SearchResult, error) {
+
+	if s.db == nil {
+		return nil, errors.New("database connection is nil")
+	}
+
+	match := matchExpression(opts.Query)
+	if match == "" {
+		return nil, nil
+	}
+	if opts.Limit <= 0 {
+		opts.Limit = defaultSearchLimit
+	}
+
+	var args []interface{}
+
+	postFilter, postArgs := searchFilters(opts, "p")
+	args = append(args, SnippetOpen, SnippetClose, match)
+	args = append(args, postArgs...)
+
+	commentFilter, commentArgs := searchFilters(opts, "c")
+	args = append(args, SnippetOpen, SnippetClose, match)
+	args = append(args, commentArgs...)
+
+	args = append(args, opts.Limit)
+
+	query := `
+    SELECT post_id, post_title, comment_id, author, created_at, snippet
+    FROM (
+        SELECT p.id AS post_id, p.title AS post_title, 0 AS comment_id,
+               u.username AS author, p.created_at AS created_at,
+               snippet(posts_fts, -1, ?, ?, '…', 12) AS snippet,
+               bm25(posts_fts, 2.0, 1.0) AS rank
+        FROM posts_fts
+        JOIN posts p ON p.id = posts_fts.rowid
+        JOIN users u ON u.id = p.user_id
+        WHERE posts_fts MATCH ? AND p.deleted_at IS NULL AND p.hidden_at IS NULL` + postFilter + `
+
+        UNION ALL
+
+        SELECT p.id, p.title, c.id,
+               u.username, c.created_at,
+               snippet(comments_fts, 0, ?, ?, '…', 12),
+               bm25(comments_fts)
+        FROM comments_fts
+        JOIN comments c ON c.id = comments_fts.rowid
+        JOIN posts p ON p.id = c.post_id
+        JOIN users u ON u.id = c.user_id
+        WHERE comments_fts MATCH ?
+            AND c.deleted_at IS NULL AND c.hidden_at IS NULL
+            AND p.deleted_at IS NULL AND p.hidden_at IS NULL` + commentFilter + `
+    )
+    ORDER BY rank ASC, created_at DESC
+    LIMIT ?
+`
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error searching posts: %w", err)
+	}
+	defer rows.Close()
+
+	var results []model.SearchResult
+	for rows.Next() {
+		var r model.SearchResult
+		var createdAt interface{}
+		err := rows.Scan(
+			&r.PostID,
+			&r.PostTitle,
+			&r.CommentID,
+			&r.Author,
+			&createdAt,
+			&r.Snippet,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning search result: %w", err)
+		}
+		r.CreatedAt = parseTimestamp(createdAt)
+		results = append(results, r)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error after scanning search results: %w", err)
+	}
+
+	return results, nil
+}
+
+// SearchTerms splits a search query into the words every hit must
+// contain. Anything but letters and digits separates words.
+func SearchTerms(query string) []string {
+	return strings.FieldsFunc(query, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// -- Non-Global Functions : Only happens in this package -- //
+
+// checkFTS5 fails with ErrNoFTS5 unless SQLite can create FTS5 tables.
+func (s *Store) checkFTS5() error {
+	var hasFTS5 bool
+	if err := s.db.QueryRow("SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&hasFTS5); err != nil {
+		return fmt.Errorf("error checking for FTS5: %w", err)
+	}
+	if !hasFTS5 {
+		return ErrNoFTS5
+	}
+	return nil
+}
+
+// matchExpression turns free text into an FTS query that requires every word.
+// Each word is quoted so user input can never form FTS operators or syntax errors.
+func matchExpression(query string) string {
+	words := SearchTerms(query)
+	quoted := make([]string, 0, len(words))
+	for _, w := range words {
+		quoted = append(quoted, `"`+w+`"`)
+	}
+	return strings.Join(quoted, " ")
+}
+
+// searchFilters builds the extra WHERE clauses for one side of the search.
+// alias is the table whose author and date are filtered ("p" or "c").
+func searchFilters(opts SearchOptions, alias string) (string, []interface{}) {
+	var clauses []string
+	var args []interface{}
+
+	if opts.Category != "" {
+		clauses = append(clauses, `EXISTS (
+            SELECT 1 FROM posts_categories pc
+            JOIN categories cat ON cat.id = pc.category_id
+            WHERE pc.post_id = p.id AND cat.name = ?
+        )`)
+		args = append(args, opts.Category)
+	}
+	if opts.Author != "" {
+		clauses = append(clauses, "u.username = ?")
+		args = append(args, opts.Author)
+	}
+	if !opts.From.IsZero() {
+		clauses = append(clauses, "julianday("+alias+".created_at) >= julianday(?)")
+		args = append(args, opts.From)
+	}
+	if !opts.To.IsZero() {
+		clauses = append(clauses, "julianday("+alias+".created_at) < julianday(?)")
+		args = append(args, opts.To)
+	}
+
+	if len(clauses) == 0 {
+		return "", nil
+	}
+	return "\n        AND " + strings.Join(clauses, "\n        AND "), args
+}
+
+// parseTimestamp reads a DATETIME that came through a UNION, where the
+// driver no longer knows the declared column type and returns raw text.
+func parseTimestamp(v interface{}) time.Time {
+	switch t := v.(type) {
+	case time.Time:
+		return t
+	case string:
+		for _, layout := range []string{
+			"2006-01-02 15:04:05.999999999-07:00",
+			"2006-01-02T15:04:05.999999999-07:00",
+			"2006-01-02 15:04:05",
+			"2006-01-02T15:04:05Z",
+		} {
+			if parsed, err := time.Parse(layout, t); err == nil {
+				return parsed
+			}
+		}
+	}
+	return time.Time{}
+}
@@ -3,38 +3,31 @@ package database
 import (
 	"database/sql"
 	"fmt"
+	"forum-go/model"
 	"log"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/gofrs/uuid"
 )
 
-var db *sql.DB
-
-func CheckUserLoggedIn(r *http.Request) (bool, int) {
+func (s *Store) CheckUserLoggedIn(r *http.Request) (bool, int) {
 	sessionToken, err := r.Cookie("session_token")
 	if err != nil {
 		return false, 0
 	}
+	return s.SessionUserID(sessionToken.Value)
+}
 
+// SessionUserID looks up the user of a session token, whether it came from
+// the session cookie or an API Authorization header. Expired sessions are
+// removed, and live ones are renewed.
+func (s *Store) SessionUserID(token string) (bool, int) {
 	var userID int
 	var expiresAt time.Time
 
-	query := `
-    SELECT user_id, session_expiry 
-    FROM sessions 
-    WHERE session_token = ? 
-    AND session_token = (
-        SELECT session_token 
-        FROM sessions AS s2 
-        WHERE s2.user_id = sessions.user_id 
-        ORDER BY session_expiry DESC 
-        LIMIT 1
-    )
-	`
-
-	err = DB.QueryRow(query, sessionToken.Value).Scan(&userID, &expiresAt)
+	err := s.db.QueryRow("SELECT user_id, session_expiry FROM sessions WHERE session_token = ?", token).Scan(&userID, &expiresAt)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return false, 0
@@ -45,66 +38,260 @@ func CheckUserLoggedIn(r *http.Request) (bool, int) {
 
 	if time.Now().After(expiresAt) {
 		// Session has expired
-		deleteSession(sessionToken.Value)
+		s.deleteSession(token)
 		return false, 0
 	}
 
+	s.touchSession(token)
 	return true, userID
 }
 
-func CreateSession(w http.ResponseWriter, userID int) error {
-	// First, invalidate any existing session for this user
-	_, err := DB.Exec("DELETE FROM sessions WHERE user_id = ?", userID)
+// SessionOptions are the lifetimes and cookie attributes of sessions.
+type SessionOptions struct {
+	// TTL is how long a session lasts without activity; every request
+	// pushes its end back again.
+	TTL time.Duration
+	// RememberTTL replaces TTL for sessions started with "keep me signed
+	// in".
+	RememberTTL time.Duration
+	// Secure marks session cookies Secure, so browsers only send them over
+	// HTTPS.
+	Secure bool
+	// SameSite is the SameSite attribute of session cookies.
+	SameSite http.SameSite
+}
+
+// DefaultSessionOptions are the session options of a new Store.
+var DefaultSessionOptions = SessionOptions{
+	TTL:         24 * time.Hour,
+	RememberTTL: 30 * 24 * time.Hour,
+	SameSite:    http.SameSiteLaxMode,
+}
+
+// ConfigureSessions sets the session lifetimes and cookie attributes. Call
+// it before the store is in use.
+func (s *Store) ConfigureSessions(opts SessionOptions) {
+	s.sessions = opts
+}
+
+// SessionOptions returns the session lifetimes and cookie attributes.
+func (s *Store) SessionOptions() SessionOptions {
+	return s.sessions
+}
+
+// CreateSession starts a session for userID on the device described by
+// userAgent and ip, and sets its cookie.
+func (s *Store) CreateSession(w http.ResponseWriter, userID int, userAgent, ip string, remember bool) error {
+	cookie, err := s.NewSession(userID, userAgent, ip, remember)
 	if err != nil {
-		log.Printf("Error deleting old sessions: %v", err)
+		return err
 	}
-	token, cookie, err := generateSessionToken()
+
+	http.SetCookie(w, cookie)
+	return nil
+}
+
+// NewSession starts a session for userID and returns its cookie without
+// sending it. Sessions on the user's other devices stay signed in. With
+// remember the session lasts RememberTTL instead of TTL. The cookie
+// value is the token API clients pass as a bearer token.
+func (s *Store) NewSession(userID int, userAgent, ip string, remember bool) (*http.Cookie, error) {
+	token, err := uuid.NewV4()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	query := `
     INSERT INTO sessions 
-    (user_id, session_token, session_expiry) 
-    VALUES (?, ?, ?)
+    (user_id, session_token, session_expiry, user_agent, ip, created_at, last_seen, remember) 
+    VALUES (?, ?, ?, ?, ?, ?, ?, ?)
 	`
 
-	_, err = DB.Exec(query, userID, token, cookie.Expires)
+	now := time.Now().UTC()
+	expires := now.Add(s.sessions.Lifetime(remember))
+	_, err = s.db.Exec(query, userID, token.String(), expires, userAgent, ip, now, now, remember)
 	if err != nil {
-		return fmt.Errorf("failed to insert session: %v", err)
+		return nil, fmt.Errorf("failed to insert session: %v", err)
 	}
-
-	http.SetCookie(w, cookie)
-	return nil
+	return s.sessions.Cookie(token.String(), expires), nil
 }
 
-// -- Non-Global Functions : Only happens in this package server -- //
-
-func deleteSession(token string) {
-	_, err := DB.Exec("DELETE FROM sessions WHERE session_token = ?", token)
+// RenewSession moves the end of a live session back to a full lifetime
+// from now and returns its refreshed cookie. Renewals happen at most once
+// a minute per session; in between, and for unknown or expired tokens, the
+// cookie is nil.
+func (s *Store) RenewSession(token string) (*http.Cookie, error) {
+	now := time.Now().UTC()
+	var remember bool
+	err := s.db.QueryRow(`
+		UPDATE sessions
+		SET last_seen = ?, session_expiry = CASE WHEN remember THEN ? ELSE ? END
+		WHERE session_token = ? AND session_expiry > ? AND (last_seen IS NULL OR last_seen < ?)
+		RETURNING remember`,
+		now, now.Add(s.sessions.RememberTTL), now.Add(s.sessions.TTL), token, now, now.Add(-SessionSeenInterval)).Scan(&remember)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
 	if err != nil {
-		log.Printf("Error deleting session: %v", err)
+		return nil, fmt.Errorf("error renewing session: %w", err)
 	}
+	return s.sessions.Cookie(token, now.Add(s.sessions.Lifetime(remember))), nil
 }
 
-func generateSessionToken() (string, *http.Cookie, error) {
-	token, err := uuid.NewV4()
-	if err != nil {
-		return "", nil, err
+// SessionSeenInterval is how stale last_seen may get before a request
+// updates it, so that browsing does not write on every page.
+const SessionSeenInterval = time.Minute
+
+// Lifetime is how long a session lasts from its last use.
+func (o SessionOptions) Lifetime(remember bool) time.Duration {
+	if remember {
+		return o.RememberTTL
 	}
+	return o.TTL
+}
 
-	expiration := time.Now().Add(24 * time.Hour)
-	cookie := &http.Cookie{
+// Cookie is the cookie carrying a session token until expires.
+func (o SessionOptions) Cookie(token string, expires time.Time) *http.Cookie {
+	return &http.Cookie{
 		Name:     "session_token",
-		Value:    token.String(),
-		Expires:  expiration,
+		Value:    token,
+		Expires:  expires,
 		Path:     "/",
 		HttpOnly: true,
+		Secure:   o.Secure,
+		SameSite: o.SameSite,
+	}
+}
+
+// ExpiredCookie returns the cookie that makes browsers forget their
+// session.
+func (o SessionOptions) ExpiredCookie() *http.Cookie {
+	cookie := o.Cookie("", time.Unix(0, 0))
+	cookie.MaxAge = -1
+	return cookie
+}
+
+// PurgeExpiredSessions deletes sessions that have run out and returns how
+// many there were.
+func (s *Store) PurgeExpiredSessions() (int64, error) {
+	result, err := s.db.Exec("DELETE FROM sessions WHERE session_expiry <= ?", time.Now().UTC())
+	if err != nil {
+		return 0, fmt.Errorf("error purging sessions: %w", err)
 	}
-	return token.String(), cookie, nil
+	return result.RowsAffected()
+}
+
+// StartSessionJanitor purges expired sessions every interval in the
+// background until the returned stop function is called.
+func (s *Store) StartSessionJanitor(interval time.Duration) (stop func()) {
+	return RunSessionJanitor(interval, s.PurgeExpiredSessions)
 }
 
-// func invalidateUserSessions(userID int) error {
-// 	_, err := db.Exec("DELETE FROM sessions WHERE user_id = ?", userID)
-// 	return err
-// }
+// RunSessionJanitor calls purge every interval in the background until the
+// returned stop function is called, logging what it removed.
+func RunSessionJanitor(interval time.Duration, purge func() (int64, error)) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				n, err := purge()
+				if err != nil {
+					log.Printf("Session janitor: %v", err)
+				} else if n > 0 {
+					log.Printf("Session janitor removed %d expired sessions", n)
+				}
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }
+}
+
+// FetchSessions returns the live sessions of a user, most recently used
+// first. The one with currentToken is marked Current.
+func (s *Store) FetchSessions(userID int, currentToken string) ([]model.Session, error) {
+	rows, err := s.db.Query(`
+		SELECT id, session_token, user_agent, ip, created_at, last_seen
+		FROM sessions
+		WHERE user_id = ? AND session_expiry > ?
+		ORDER BY last_seen DESC, id DESC`, userID, time.Now().UTC())
+	if err != nil {
+		return nil, fmt.Errorf("error fetching sessions: %w", err)
+	}
+	defer rows.Close()
+
+	var sessions []model.Session
+	for rows.Next() {
+		var session model.Session
+		var token string
+		var createdAt, lastSeen sql.NullTime
+		if err := rows.Scan(&session.ID, &token, &session.UserAgent, &session.IP, &createdAt, &lastSeen); err != nil {
+			return nil, fmt.Errorf("error scanning session: %w", err)
+		}
+		session.CreatedAt = createdAt.Time
+		session.LastSeen = lastSeen.Time
+		session.Current = token == currentToken
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
+// EndUserSession signs one of a user's sessions out. It returns
+// sql.ErrNoRows when the user has no session with that ID.
+func (s *Store) EndUserSession(userID, sessionID int) error {
+	result, err := s.db.Exec("DELETE FROM sessions WHERE id = ? AND user_id = ?", sessionID, userID)
+	if err != nil {
+		return fmt.Errorf("error deleting session: %w", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// EndAllSessions signs a user out on every device.
+func (s *Store) EndAllSessions(userID int) error {
+	if _, err := s.db.Exec("DELETE FROM sessions WHERE user_id = ?", userID); err != nil {
+		return fmt.Errorf("error deleting sessions: %w", err)
+	}
+	return nil
+}
+
+// EndOtherSessions signs a user out everywhere except the session with
+// the given token, as after a password change.
+func (s *Store) EndOtherSessions(userID int, keepToken string) error {
+	_, err := s.db.Exec("DELETE FROM sessions WHERE user_id = ? AND session_token != ?", userID, keepToken)
+	if err != nil {
+		return fmt.Errorf("error deleting sessions: %w", err)
+	}
+	return nil
+}
+
+// EndSession deletes a session so its token stops working.
+func (s *Store) EndSession(token string) error {
+	if _, err := s.db.Exec("DELETE FROM sessions WHERE session_token = ?", token); err != nil {
+		return fmt.Errorf("error deleting session: %w", err)
+	}
+	return nil
+}
+
+// -- Non-Global Functions : Only happens in this package server -- //
+
+func (s *Store) deleteSession(token string) {
+	_, err := s.db.Exec("DELETE FROM sessions WHERE session_token = ?", token)
+	if err != nil {
+		log.Printf("Error deleting session: %v", err)
+	}
+}
+
+func (s *Store) touchSession(token string) {
+	if _, err := s.RenewSession(token); err != nil {
+		log.Printf("Error updating session: %v", err)
+	}
+}
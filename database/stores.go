@@ -0,0 +1,134 @@
+package database
+
+import (
+	"forum-go/model"
+	"net/http"
+	"time"
+)
+
+// PostStore reads and writes posts and the categories they are filed under.
+type PostStore interface {
+	CreatePost(post *model.Post) (int64, error)
+	FetchPostByID(postID int) (*model.Post, error)
+	FetchPosts() ([]model.Post, error)
+	FetchPostsByCategory(category string) ([]model.Post, error)
+	FetchPostPage(opts PostListOptions) (*PostPage, error)
+	FetchPostsByUserID(userID int) ([]*model.Post, error)
+	FetchLikedPostsByUserID(userID int) ([]*model.Post, error)
+	FetchDislikedPostsByUserID(userID int) ([]*model.Post, error)
+	FetchPostRevisions(postID int) ([]model.Revision, error)
+	UpdatePost(postID, userID int, title, content string) error
+	DeletePost(postID, userID int) error
+	SearchPosts(opts SearchOptions) ([]model.SearchResult, error)
+	FetchCategories() ([]model.Category, error)
+}
+
+// CommentStore reads and writes comments.
+type CommentStore interface {
+	CreateComment(postID, userID, parentID int, content string) (int64, error)
+	FetchCommentByID(commentID int) (*model.Comment, error)
+	FetchCommentsByPostID(postID int) ([]model.Comment, error)
+	FetchCommentTree(postID, maxDepth int) ([]model.Comment, error)
+	FetchCommentRevisions(commentID int) ([]model.Revision, error)
+	UpdateComment(commentID, userID int, content string) error
+	DeleteComment(commentID, userID int) error
+}
+
+// UserStore reads and changes accounts. Passwords, tokens and two-factor
+// secrets are handled by the auth package.
+type UserStore interface {
+	FetchUserById(userID int) (*model.User, error)
+	FetchUserByEmail(email string) (*model.User, error)
+	FetchUserIDByUsername(username string) (int, error)
+	FetchUsers() ([]model.User, error)
+	UsernameTaken(username string) (bool, error)
+	EmailTaken(email string) (bool, error)
+	UpdateUsername(userID int, username string) error
+	UpdateEmail(userID int, email string) error
+	DeleteAccount(userID int, removeContent bool) error
+}
+
+// SessionStore keeps track of who is signed in on which device.
+type SessionStore interface {
+	CreateSession(w http.ResponseWriter, userID int, userAgent, ip string, remember bool) error
+	NewSession(userID int, userAgent, ip string, remember bool) (*http.Cookie, error)
+	RenewSession(token string) (*http.Cookie, error)
+	SessionUserID(token string) (bool, int)
+	CheckUserLoggedIn(r *http.Request) (bool, int)
+	FetchSessions(userID int, currentToken string) ([]model.Session, error)
+	EndSession(token string) error
+	EndUserSession(userID, sessionID int) error
+	EndOtherSessions(userID int, keepToken string) error
+	EndAllSessions(userID int) error
+	PurgeExpiredSessions() (int64, error)
+	StartSessionJanitor(interval time.Duration) (stop func())
+	SessionOptions() SessionOptions
+}
+
+// VoteStore records likes and dislikes.
+type VoteStore interface {
+	TogglePostVote(userID, postID, vote int) (*VoteResult, error)
+	ToggleCommentVote(userID, commentID, vote int) (*VoteResult, error)
+	UpdateVote(userID, postID, voteValue int) error
+}
+
+// NotificationStore sends notifications about replies, mentions and votes
+// and keeps each user's choice of which ones they want.
+type NotificationStore interface {
+	NotifyNewComment(commentID int) error
+	NotifyVote(voterID, postID, commentID, vote int) error
+	FetchNotifications(userID, limit int) ([]model.Notification, error)
+	MarkNotificationRead(userID, notificationID int) error
+	MarkAllNotificationsRead(userID int) error
+	FetchNotificationPreferences(userID int) (map[string]bool, error)
+	SetNotificationPreferences(userID int, enabled map[string]bool) error
+}
+
+// ModerationStore applies moderator and admin actions and logs them.
+type ModerationStore interface {
+	SetPostHidden(moderatorID, postID int, hidden bool, reason string) error
+	SetPostLocked(moderatorID, postID int, locked bool, reason string) error
+	SetCommentHidden(moderatorID, commentID int, hidden bool, reason string) error
+	SetUserBanned(moderatorID, userID int, banned bool, reason string) error
+	SetUserRole(adminID, userID int, role string) error
+	FetchModerationLog(limit int) ([]model.ModerationEntry, error)
+}
+
+// ReportStore keeps the reports users file about posts and comments.
+type ReportStore interface {
+	CreateReport(reporterID int, targetType string, targetID int, reason, details string) error
+	FetchOpenReports() ([]model.Report, error)
+	FetchReportsByUser(userID int) ([]model.Report, error)
+	ResolveReport(moderatorID, reportID int, accept bool) error
+}
+
+// MessageStore keeps private messages and who blocks whom.
+type MessageStore interface {
+	SendMessage(senderID, recipientID int, body string) (*model.Message, error)
+	FetchConversations(userID int) ([]model.Conversation, error)
+	FetchMessages(userID, otherID, afterID, limit int) ([]model.Message, error)
+	MarkConversationRead(userID, otherID int) error
+	BlockUser(blockerID, blockedID int) error
+	UnblockUser(blockerID, blockedID int) error
+	BlockState(userID, otherID int) (blocked, blockedBy bool, err error)
+	UnreadMessageCount(userID int) (int, error)
+}
+
+// Stores is everything the forum keeps in its database, as one value.
+// Both the SQLite Store and the PostgreSQL one in database/postgres
+// implement it.
+type Stores interface {
+	PostStore
+	CommentStore
+	UserStore
+	SessionStore
+	VoteStore
+	NotificationStore
+	ModerationStore
+	ReportStore
+	MessageStore
+	ConfigureSessions(opts SessionOptions)
+}
+
+// Store implements every store interface.
+var _ Stores = (*Store)(nil)
@@ -0,0 +1,125 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrUsernameTaken and ErrEmailTaken are returned when another account
+// already has the requested username or email address.
+var (
+	ErrUsernameTaken = errors.New("username already taken")
+	ErrEmailTaken    = errors.New("email already registered")
+)
+
+// UsernameTaken reports whether an account already has username.
+func (s *Store) UsernameTaken(username string) (bool, error) {
+	var count int
+	err := s.db.QueryRow("SELECT COUNT(*) FROM users WHERE username = ?", username).Scan(&count)
+	if err != nil {
+		return false, fmt.Errorf("error checking username: %w", err)
+	}
+	return count > 0, nil
+}
+
+// EmailTaken reports whether an account already has email.
+func (s *Store) EmailTaken(email string) (bool, error) {
+	var count int
+	err := s.db.QueryRow("SELECT COUNT(*) FROM users WHERE email = ?", email).Scan(&count)
+	if err != nil {
+		return false, fmt.Errorf("error checking email: %w", err)
+	}
+	return count > 0, nil
+}
+
+// UpdateUsername renames an account. Posts and comments show the new name
+// right away since they are joined to the user.
+func (s *Store) UpdateUsername(userID int, username string) error {
+	_, err := s.db.Exec("UPDATE users SET username = ? WHERE id = ? AND deleted_at IS NULL", username, userID)
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE constraint failed: users.username") {
+			return ErrUsernameTaken
+		}
+		return fmt.Errorf("error updating username: %w", err)
+	}
+	return nil
+}
+
+// UpdateEmail changes the address of an account and marks it unverified.
+// Links already emailed to the old address stop working.
+func (s *Store) UpdateEmail(userID int, email string) error {
+	_, err := s.db.Exec(
+		"UPDATE users SET email = ?, email_verified_at = NULL WHERE id = ? AND deleted_at IS NULL",
+		email, userID,
+	)
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE constraint failed: users.email") {
+			return ErrEmailTaken
+		}
+		return fmt.Errorf("error updating email: %w", err)
+	}
+	return nil
+}
+
+// DeleteAccount closes an account. Its name, address and password are
+// replaced so nobody can sign in to it, and its sessions, tokens, linked
+// sign-in providers, two-factor secrets, notifications and blocks are
+// removed. With
+// removeContent the user's posts and comments are deleted, their text and
+// history erased, and their votes withdrawn; otherwise they stay up under
+// the placeholder name.
+func (s *Store) DeleteAccount(userID int, removeContent bool) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	var statements []string
+	if removeContent {
+		statements = append(statements,
+			`DELETE FROM post_revisions WHERE post_id IN (SELECT id FROM posts WHERE user_id = ?1)`,
+			`DELETE FROM comment_revisions WHERE comment_id IN (SELECT id FROM comments WHERE user_id = ?1)`,
+			// Titles are unique, so each removed post gets its own placeholder
+			`UPDATE posts SET title = '[deleted post ' || id || ']', content = '',
+				deleted_at = COALESCE(deleted_at, ?2) WHERE user_id = ?1`,
+			`UPDATE comments SET content = '', deleted_at = COALESCE(deleted_at, ?2) WHERE user_id = ?1`,
+			`DELETE FROM votes WHERE user_id = ?1`,
+		)
+	}
+	statements = append(statements,
+		`DELETE FROM sessions WHERE user_id = ?1`,
+		`DELETE FROM user_tokens WHERE user_id = ?1`,
+		`DELETE FROM user_identities WHERE user_id = ?1`,
+		`DELETE FROM recovery_codes WHERE user_id = ?1`,
+		`DELETE FROM notifications WHERE user_id = ?1`,
+		`DELETE FROM notification_preferences WHERE user_id = ?1`,
+		`DELETE FROM user_blocks WHERE blocker_id = ?1 OR blocked_id = ?1`,
+	)
+	for _, stmt := range statements {
+		if _, err := tx.Exec(stmt, userID, now); err != nil {
+			return fmt.Errorf("error deleting account data: %w", err)
+		}
+	}
+
+	// Brackets are not allowed in usernames and the placeholder has no @,
+	// so no one can register either value
+	placeholder := fmt.Sprintf("[deleted-%d]", userID)
+	result, err := tx.Exec(`UPDATE users SET username = ?, email = ?, password_hash = '', role = 'user',
+		email_verified_at = NULL, totp_secret = '', totp_enabled_at = NULL, deleted_at = ? WHERE id = ? AND deleted_at IS NULL`,
+		placeholder, placeholder, now, userID)
+	if err != nil {
+		return fmt.Errorf("error deleting account: %w", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("error deleting account: user %d not found", userID)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing account deletion: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// ErrInvalidVote is returned for a vote other than 1 or -1.
+var ErrInvalidVote = errors.New("vote must be 1 or -1")
+
+// VoteResult is the state of a post or comment after a vote.
+type VoteResult struct {
+	Vote      int // the voter's vote afterwards: 1, -1, or 0 when it was undone
+	Upvotes   int
+	Downvotes int
+	PostID    int // the post voted on, or the post of the comment
+}
+
+// TogglePostVote applies a click on a post's like or dislike button: the
+// same vote again undoes it, the other one replaces it. Missing, deleted
+// and hidden posts return sql.ErrNoRows.
+func (s *Store) TogglePostVote(userID, postID, vote int) (*VoteResult, error) {
+	return s.toggleVote("post_id", "posts", userID, postID, vote)
+}
+
+// ToggleCommentVote is TogglePostVote for comments.
+func (s *Store) ToggleCommentVote(userID, commentID, vote int) (*VoteResult, error) {
+	return s.toggleVote("comment_id", "comments", userID, commentID, vote)
+}
+
+// -- Non-Global Functions : Only happens in this package -- //
+
+// toggleVote does the work of TogglePostVote and ToggleCommentVote. column
+// and table are never user input.
+func (s *Store) toggleVote(column, table string, userID, targetID, vote int) (*VoteResult, error) {
+	if vote != 1 && vote != -1 {
+		return nil, ErrInvalidVote
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// The post the target belongs to, so callers can update its viewers
+	postColumn := "id"
+	if table == "comments" {
+		postColumn = "post_id"
+	}
+	var postID int
+	err = tx.QueryRow(
+		"SELECT "+postColumn+" FROM "+table+" WHERE id = ? AND deleted_at IS NULL AND hidden_at IS NULL", targetID,
+	).Scan(&postID)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &VoteResult{Vote: vote, PostID: postID}
+	var existing int
+	err = tx.QueryRow("SELECT vote FROM votes WHERE user_id = ? AND "+column+" = ?", userID, targetID).Scan(&existing)
+	switch {
+	case err == sql.ErrNoRows:
+		_, err = tx.Exec("INSERT INTO votes (user_id, "+column+", vote) VALUES (?, ?, ?)", userID, targetID, vote)
+	case err != nil:
+		return nil, fmt.Errorf("error reading vote: %w", err)
+	case existing == vote:
+		// Clicking the same button again takes the vote back
+		result.Vote = 0
+		_, err = tx.Exec("DELETE FROM votes WHERE user_id = ? AND "+column+" = ?", userID, targetID)
+	default:
+		_, err = tx.Exec("UPDATE votes SET vote = ? WHERE user_id = ? AND "+column+" = ?", vote, userID, targetID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error saving vote: %w", err)
+	}
+
+	err = tx.QueryRow(`
+        SELECT COALESCE(SUM(CASE WHEN vote = 1 THEN 1 ELSE 0 END), 0),
+               COALESCE(SUM(CASE WHEN vote = -1 THEN 1 ELSE 0 END), 0)
+        FROM votes WHERE `+column+` = ?
+    `, targetID).Scan(&result.Upvotes, &result.Downvotes)
+	if err != nil {
+		return nil, fmt.Errorf("error counting votes: %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("error committing vote: %w", err)
+	}
+
+	return result, nil
+}
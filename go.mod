@@ -7,3 +7,9 @@ require golang.org/x/crypto v0.32.0
 require github.com/gofrs/uuid v4.4.0+incompatible
 
 require github.com/mattn/go-sqlite3 v1.14.24
+
+require github.com/gorilla/websocket v1.5.3
+
+require rsc.io/qr v0.2.0
+
+require github.com/lib/pq v1.10.9
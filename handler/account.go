@@ -0,0 +1,215 @@
+package handler
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"forum-go/auth"
+	"forum-go/mail"
+	"forum-go/model"
+	"forum-go/pkg/utils"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// accountPage is what account.html shows: Page picks the form or notice.
+type accountPage struct {
+	Title      string
+	User       *model.User
+	IsLoggedIn bool
+	Page       string
+	Token      string
+	Email      string
+	Error      string
+}
+
+// ForgotPasswordHandler asks for an email address and sends a reset link to
+// it. The answer is the same whether or not an account has that address.
+func (app *App) ForgotPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		app.renderAccountPage(w, r, http.StatusOK, accountPage{Page: "forgot"})
+
+	case http.MethodPost:
+		email := strings.TrimSpace(r.FormValue("email"))
+		if email == "" {
+			app.renderAccountPage(w, r, http.StatusBadRequest, accountPage{Page: "forgot", Error: "Please enter your email address."})
+			return
+		}
+
+		user, err := app.Users.FetchUserByEmail(email)
+		switch {
+		case err == sql.ErrNoRows:
+			log.Printf("Password reset requested for unknown address %s", email)
+		case err != nil:
+			log.Printf("Error fetching user by email: %v", err)
+			ErrorHandler(w, r, http.StatusInternalServerError)
+			return
+		default:
+			if err := app.sendPasswordReset(user); err != nil {
+				log.Printf("Error sending password reset: %v", err)
+				ErrorHandler(w, r, http.StatusInternalServerError)
+				return
+			}
+		}
+		app.renderAccountPage(w, r, http.StatusOK, accountPage{Page: "forgot-sent", Email: email})
+
+	default:
+		ErrorHandler(w, r, http.StatusMethodNotAllowed)
+	}
+}
+
+// ResetPasswordHandler shows the new password form of an emailed link and
+// sets the password. Resetting signs the account out everywhere.
+func (app *App) ResetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		token := r.URL.Query().Get("token")
+		if _, err := app.Accounts.CheckToken(token, auth.PurposePasswordReset); err != nil {
+			app.invalidToken(w, r, err)
+			return
+		}
+		app.renderAccountPage(w, r, http.StatusOK, accountPage{Page: "reset", Token: token})
+
+	case http.MethodPost:
+		token := r.FormValue("token")
+		password := r.FormValue("password")
+		retry := accountPage{Page: "reset", Token: token}
+
+		if password != r.FormValue("confirm") {
+			retry.Error = "The passwords do not match."
+			app.renderAccountPage(w, r, http.StatusBadRequest, retry)
+			return
+		}
+		if err := utils.ValidatePassword(password); err != nil {
+			retry.Error = err.Error()
+			app.renderAccountPage(w, r, http.StatusBadRequest, retry)
+			return
+		}
+
+		userID, err := app.Accounts.ResetPassword(token, password)
+		if err != nil {
+			app.invalidToken(w, r, err)
+			return
+		}
+		log.Printf("User %d reset their password", userID)
+		app.clearSessionCookie(w)
+		app.renderAccountPage(w, r, http.StatusOK, accountPage{Page: "reset-done"})
+
+	default:
+		ErrorHandler(w, r, http.StatusMethodNotAllowed)
+	}
+}
+
+// VerifyEmailHandler confirms an address from the link sent to it.
+func (app *App) VerifyEmailHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		ErrorHandler(w, r, http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, err := app.Accounts.VerifyEmail(r.URL.Query().Get("token"))
+	if err != nil {
+		app.invalidToken(w, r, err)
+		return
+	}
+	log.Printf("User %d verified their email address", userID)
+	app.renderAccountPage(w, r, http.StatusOK, accountPage{Page: "verified"})
+}
+
+// ResendVerificationHandler sends the signed-in user a new verification
+// link.
+func (app *App) ResendVerificationHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		ErrorHandler(w, r, http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, _ := r.Context().Value("user_id").(int)
+	user, err := app.Users.FetchUserById(userID)
+	if err != nil {
+		log.Printf("Error fetching user data: %v", err)
+		ErrorHandler(w, r, http.StatusInternalServerError)
+		return
+	}
+	if !user.EmailVerifiedAt.IsZero() {
+		http.Redirect(w, r, "/profile", http.StatusSeeOther)
+		return
+	}
+
+	if err := app.sendVerification(user); err != nil {
+		log.Printf("Error sending verification email: %v", err)
+		ErrorHandler(w, r, http.StatusInternalServerError)
+		return
+	}
+	app.renderAccountPage(w, r, http.StatusOK, accountPage{Page: "verify-sent", Email: user.Email})
+}
+
+// -- Non-Global Functions : Only happens in this package -- //
+
+func (app *App) renderAccountPage(w http.ResponseWriter, r *http.Request, status int, page accountPage) {
+	if loggedIn, userID := app.Sessions.CheckUserLoggedIn(r); loggedIn {
+		user, err := app.Users.FetchUserById(userID)
+		if err != nil {
+			log.Printf("Error fetching user data: %v", err)
+		} else {
+			page.User = user
+			page.IsLoggedIn = true
+		}
+	}
+	if page.Title == "" {
+		page.Title = "Your Account"
+	}
+
+	w.WriteHeader(status)
+	if err := app.Templates.ExecuteTemplate(w, r, "account.html", page); err != nil {
+		log.Printf("Error executing template: %v", err)
+	}
+}
+
+func (app *App) invalidToken(w http.ResponseWriter, r *http.Request, err error) {
+	if !errors.Is(err, auth.ErrInvalidToken) {
+		log.Printf("Error checking token: %v", err)
+		ErrorHandler(w, r, http.StatusInternalServerError)
+		return
+	}
+	app.renderAccountPage(w, r, http.StatusBadRequest, accountPage{Page: "invalid"})
+}
+
+func (app *App) sendPasswordReset(user *model.User) error {
+	token, err := app.Accounts.IssueToken(user.ID, auth.PurposePasswordReset, user.Email, auth.PasswordResetTTL)
+	if err != nil {
+		return err
+	}
+	link := app.absoluteURL("/reset-password?token=" + url.QueryEscape(token))
+	return app.Mailer.Send(mail.Message{
+		To:      user.Email,
+		Subject: "Reset your Reel Movie Talk password",
+		Body: fmt.Sprintf("Hi %s,\n\nSomeone asked to reset the password of your account. "+
+			"Open this link within an hour to choose a new one:\n\n%s\n\n"+
+			"If it wasn't you, ignore this email and your password stays the same.\n",
+			user.Username, link),
+	})
+}
+
+func (app *App) sendVerification(user *model.User) error {
+	token, err := app.Accounts.IssueToken(user.ID, auth.PurposeVerifyEmail, user.Email, auth.VerifyEmailTTL)
+	if err != nil {
+		return err
+	}
+	link := app.absoluteURL("/verify-email?token=" + url.QueryEscape(token))
+	return app.Mailer.Send(mail.Message{
+		To:      user.Email,
+		Subject: "Confirm your email address for Reel Movie Talk",
+		Body: fmt.Sprintf("Hi %s,\n\nPlease confirm this is your email address by opening this link:\n\n%s\n\n"+
+			"The link works for 48 hours.\n",
+			user.Username, link),
+	})
+}
+
+// absoluteURL turns a path into a link that works outside the site.
+func (app *App) absoluteURL(path string) string {
+	return strings.TrimRight(app.BaseURL, "/") + path
+}
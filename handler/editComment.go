@@ -0,0 +1,85 @@
+package handler
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+func (app *App) EditCommentHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		ErrorHandler(w, r, http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := r.Context().Value("user_id").(int)
+	if !ok {
+		ErrorHandler(w, r, http.StatusUnauthorized)
+		return
+	}
+
+	commentID, err := strconv.Atoi(r.FormValue("comment_id"))
+	content := strings.TrimSpace(r.FormValue("content"))
+	if err != nil || content == "" {
+		ErrorHandler(w, r, http.StatusBadRequest)
+		return
+	}
+
+	comment, err := app.Comments.FetchCommentByID(commentID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			ErrorHandler(w, r, http.StatusNotFound)
+		} else {
+			log.Printf("Error fetching comment: %v", err)
+			ErrorHandler(w, r, http.StatusInternalServerError)
+		}
+		return
+	}
+
+	if err := app.Comments.UpdateComment(commentID, userID, content); err != nil {
+		writeEditError(w, r, err)
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/viewpost?id=%d#comment-%d", comment.PostID, commentID), http.StatusSeeOther)
+}
+
+func (app *App) DeleteCommentHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		ErrorHandler(w, r, http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := r.Context().Value("user_id").(int)
+	if !ok {
+		ErrorHandler(w, r, http.StatusUnauthorized)
+		return
+	}
+
+	commentID, err := strconv.Atoi(r.FormValue("comment_id"))
+	if err != nil {
+		ErrorHandler(w, r, http.StatusBadRequest)
+		return
+	}
+
+	comment, err := app.Comments.FetchCommentByID(commentID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			ErrorHandler(w, r, http.StatusNotFound)
+		} else {
+			log.Printf("Error fetching comment: %v", err)
+			ErrorHandler(w, r, http.StatusInternalServerError)
+		}
+		return
+	}
+
+	if err := app.Comments.DeleteComment(commentID, userID); err != nil {
+		writeEditError(w, r, err)
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/viewpost?id=%d#comment-%d", comment.PostID, commentID), http.StatusSeeOther)
+}
@@ -0,0 +1,142 @@
+package handler
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"forum-go/database"
+	"forum-go/model"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+func (app *App) EditPostHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("user_id").(int)
+	if !ok {
+		ErrorHandler(w, r, http.StatusUnauthorized)
+		return
+	}
+
+	postID, err := strconv.Atoi(r.FormValue("id"))
+	if err != nil {
+		ErrorHandler(w, r, http.StatusBadRequest)
+		return
+	}
+
+	post, err := app.Posts.FetchPostByID(postID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			ErrorHandler(w, r, http.StatusNotFound)
+		} else {
+			log.Printf("Error fetching post: %v", err)
+			ErrorHandler(w, r, http.StatusInternalServerError)
+		}
+		return
+	}
+	if post.Hidden {
+		ErrorHandler(w, r, http.StatusNotFound)
+		return
+	}
+	if post.UserID != userID {
+		ErrorHandler(w, r, http.StatusForbidden)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		app.renderEditPost(w, r, post, userID, "")
+
+	case http.MethodPost:
+		title := strings.TrimSpace(r.FormValue("title"))
+		content := strings.TrimSpace(r.FormValue("content"))
+		if title == "" || content == "" {
+			ErrorHandler(w, r, http.StatusBadRequest)
+			return
+		}
+
+		err = app.Posts.UpdatePost(postID, userID, title, content)
+		if errors.Is(err, database.ErrDuplicateTitle) {
+			// Show the form again with what they typed
+			post.Title, post.Content = title, content
+			w.WriteHeader(http.StatusConflict)
+			app.renderEditPost(w, r, post, userID, "Another post already uses this title.")
+			return
+		}
+		if err != nil {
+			writeEditError(w, r, err)
+			return
+		}
+
+		http.Redirect(w, r, fmt.Sprintf("/viewpost?id=%d", postID), http.StatusSeeOther)
+
+	default:
+		ErrorHandler(w, r, http.StatusMethodNotAllowed)
+	}
+}
+
+func (app *App) DeletePostHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		ErrorHandler(w, r, http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := r.Context().Value("user_id").(int)
+	if !ok {
+		ErrorHandler(w, r, http.StatusUnauthorized)
+		return
+	}
+
+	postID, err := strconv.Atoi(r.FormValue("id"))
+	if err != nil {
+		ErrorHandler(w, r, http.StatusBadRequest)
+		return
+	}
+
+	if err := app.Posts.DeletePost(postID, userID); err != nil {
+		writeEditError(w, r, err)
+		return
+	}
+
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+func (app *App) renderEditPost(w http.ResponseWriter, r *http.Request, post *model.Post, userID int, message string) {
+	user, err := app.Users.FetchUserById(userID)
+	if err != nil {
+		log.Printf("Error fetching user data: %v", err)
+		ErrorHandler(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	data := struct {
+		Post       *model.Post
+		IsLoggedIn bool
+		User       *model.User
+		Error      string
+	}{
+		Post:       post,
+		IsLoggedIn: true,
+		User:       user,
+		Error:      message,
+	}
+
+	if err := app.Templates.ExecuteTemplate(w, r, "editPost.html", data); err != nil {
+		log.Printf("Error executing template: %v", err)
+		ErrorHandler(w, r, http.StatusInternalServerError)
+	}
+}
+
+// writeEditError maps the errors shared by the edit and delete handlers.
+func writeEditError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		ErrorHandler(w, r, http.StatusNotFound)
+	case errors.Is(err, database.ErrNotAuthor):
+		ErrorHandler(w, r, http.StatusForbidden)
+	default:
+		log.Printf("Error changing content: %v", err)
+		ErrorHandler(w, r, http.StatusInternalServerError)
+	}
+}
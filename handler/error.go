@@ -51,6 +51,11 @@ func ErrorHandler(w http.ResponseWriter, r *http.Request, status int) {
 			ErrorNum: status,
 			ErrorMes: "HTTP status 405: Method Not Allowed",
 		}
+	case http.StatusTooManyRequests:
+		p = Text{
+			ErrorNum: status,
+			ErrorMes: "HTTP status 429: Too Many Requests\nYou are doing that too often, please wait a moment",
+		}
 	case http.StatusConflict:
 		p = Text{
 			ErrorNum: status,
@@ -0,0 +1,180 @@
+package handler
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"forum-go/database"
+	"forum-go/live"
+	"forum-go/model"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// eventHeartbeat is how often an idle event stream sends a comment line so
+// proxies keep the connection open.
+const eventHeartbeat = 25 * time.Second
+
+// eventRetry is the reconnection delay suggested to browsers, in milliseconds.
+const eventRetry = 3000
+
+// PostEventsHandler streams new comments and vote counts of a post as
+// server-sent events. A browser that reconnects sends Last-Event-ID and gets
+// the events it missed; when those are gone it gets a "reset" event and
+// reloads the page instead.
+func (app *App) PostEventsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		ErrorHandler(w, r, http.StatusMethodNotAllowed)
+		return
+	}
+
+	postID, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		ErrorHandler(w, r, http.StatusBadRequest)
+		return
+	}
+
+	post, err := app.Posts.FetchPostByID(postID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			ErrorHandler(w, r, http.StatusNotFound)
+		} else {
+			log.Printf("Error fetching post: %v", err)
+			ErrorHandler(w, r, http.StatusInternalServerError)
+		}
+		return
+	}
+
+	isLoggedIn, userID := app.Sessions.CheckUserLoggedIn(r)
+	var user *model.User
+	if isLoggedIn {
+		user, err = app.Users.FetchUserById(userID)
+		if err != nil {
+			log.Printf("Error fetching user data: %v", err)
+		}
+	}
+	viewer := commentViewer{
+		userID:      userID,
+		canModerate: app.isModerator(user),
+		canReply:    !post.Locked,
+	}
+
+	if post.Hidden && !viewer.canModerate {
+		ErrorHandler(w, r, http.StatusNotFound)
+		return
+	}
+
+	// The stream outlives the server's write timeout, if one is set
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		log.Printf("Error clearing write deadline: %v", err)
+	}
+
+	sub := app.PostEvents.Subscribe(postID, r.Header.Get("Last-Event-ID"))
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	fmt.Fprintf(w, "retry: %d\n\n", eventRetry)
+	if sub.Reset {
+		fmt.Fprint(w, "event: reset\ndata: {}\n\n")
+	}
+	for _, event := range sub.Missed {
+		app.writeEvent(w, r, event, viewer)
+	}
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(eventHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": ping\n\n")
+		case event, ok := <-sub.Events:
+			if !ok {
+				// Dropped for falling behind; the browser reconnects
+				return
+			}
+			app.writeEvent(w, r, event, viewer)
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// PublishComment tells the viewers of a post about a new comment. Call it
+// once the comment is stored.
+func (app *App) PublishComment(postID int, commentID int64, parentID int) {
+	app.PostEvents.Publish(postID, live.EventComment, live.NewComment{CommentID: int(commentID), ParentID: parentID})
+}
+
+// PublishVote sends the new counts of a post, or of one of its comments
+// when commentID is set, to the viewers of the post.
+func (app *App) PublishVote(result *database.VoteResult, commentID int) {
+	update := live.VoteUpdate{CommentID: commentID, Upvotes: result.Upvotes, Downvotes: result.Downvotes}
+	app.PostEvents.Publish(result.PostID, live.EventVote, update)
+}
+
+// PublishMessage pushes a stored message to its recipient, and to its
+// sender for their other open tabs.
+func (app *App) PublishMessage(msg *model.Message) {
+	app.MessageEvents.Publish(msg.RecipientID, live.EventMessage, *msg)
+	app.MessageEvents.Publish(msg.SenderID, live.EventMessage, *msg)
+}
+
+// writeEvent writes one event in the text/event-stream format. Comments are
+// rendered for the viewer, so they get the same buttons as on page load.
+func (app *App) writeEvent(w http.ResponseWriter, r *http.Request, event live.Event, viewer commentViewer) {
+	var data interface{} = event.Data
+
+	if c, ok := event.Data.(live.NewComment); ok {
+		html, err := app.renderComment(r, c.CommentID, viewer)
+		if err != nil {
+			if err != sql.ErrNoRows {
+				log.Printf("Error rendering live comment: %v", err)
+			}
+			return
+		}
+		data = struct {
+			live.NewComment
+			HTML string `json:"html"`
+		}{c, html}
+	}
+
+	payload, err := json.Marshal(data)
+	if err != nil {
+		log.Printf("Error encoding live event: %v", err)
+		return
+	}
+	fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", event.ID, event.Type, payload)
+}
+
+// renderComment executes the comment template for a single new comment.
+func (app *App) renderComment(r *http.Request, commentID int, viewer commentViewer) (string, error) {
+	comment, err := app.Comments.FetchCommentByID(commentID)
+	if err != nil {
+		return "", err
+	}
+
+	comments := []model.Comment{*comment}
+	prepareComments(comments, viewer)
+
+	var buf bytes.Buffer
+	if err := app.Templates.ExecuteTemplate(&buf, r, "comment", comments[0]); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
@@ -2,6 +2,6 @@ package handler
 
 import "net/http"
 
-func FaviconHandler(w http.ResponseWriter, r *http.Request) {
+func (app *App) FaviconHandler(w http.ResponseWriter, r *http.Request) {
 	http.ServeFile(w, r, "assets/images/favicon.ico")
 }
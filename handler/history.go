@@ -0,0 +1,139 @@
+package handler
+
+import (
+	"database/sql"
+	"forum-go/model"
+	"forum-go/pkg/utils"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// historyEntry is one version in the edit history, with the changes that
+// produced it. The original version has no previous text, so every line
+// shows as unchanged.
+type historyEntry struct {
+	Label       string
+	CreatedAt   time.Time
+	TitleDiff   []utils.DiffLine
+	ContentDiff []utils.DiffLine
+}
+
+// HistoryHandler shows the edit history of a post (?post_id=) or a
+// comment (?comment_id=), newest version first.
+func (app *App) HistoryHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		ErrorHandler(w, r, http.StatusMethodNotAllowed)
+		return
+	}
+
+	var (
+		postID    int
+		heading   string
+		hidden    bool
+		revisions []model.Revision
+		current   model.Revision
+		err       error
+	)
+
+	isLoggedIn, userID := app.Sessions.CheckUserLoggedIn(r)
+	var user *model.User
+	if isLoggedIn {
+		user, err = app.Users.FetchUserById(userID)
+		if err != nil {
+			log.Printf("Error fetching user data: %v", err)
+			// Continue without user data
+		}
+	}
+
+	if raw := r.URL.Query().Get("comment_id"); raw != "" {
+		commentID, convErr := strconv.Atoi(raw)
+		if convErr != nil {
+			ErrorHandler(w, r, http.StatusBadRequest)
+			return
+		}
+		var comment *model.Comment
+		comment, err = app.Comments.FetchCommentByID(commentID)
+		if err == nil {
+			postID = comment.PostID
+			hidden = comment.Hidden
+			heading = "Comment by " + comment.Author
+			current = model.Revision{Content: comment.Content, CreatedAt: comment.CreatedAt}
+			revisions, err = app.Comments.FetchCommentRevisions(commentID)
+		}
+	} else {
+		postID, err = strconv.Atoi(r.URL.Query().Get("post_id"))
+		if err != nil {
+			ErrorHandler(w, r, http.StatusBadRequest)
+			return
+		}
+		var post *model.Post
+		post, err = app.Posts.FetchPostByID(postID)
+		if err == nil {
+			hidden = post.Hidden
+			heading = post.Title
+			current = model.Revision{Title: post.Title, Content: post.Content, CreatedAt: post.CreatedAt}
+			revisions, err = app.Posts.FetchPostRevisions(postID)
+		}
+	}
+	if err == nil && hidden && !app.isModerator(user) {
+		err = sql.ErrNoRows
+	}
+	if err != nil {
+		if err == sql.ErrNoRows {
+			ErrorHandler(w, r, http.StatusNotFound)
+		} else {
+			log.Printf("Error fetching history: %v", err)
+			ErrorHandler(w, r, http.StatusInternalServerError)
+		}
+		return
+	}
+
+	data := struct {
+		Title      string
+		PostID     int
+		Entries    []historyEntry
+		IsLoggedIn bool
+		User       *model.User
+	}{
+		Title:      heading,
+		PostID:     postID,
+		Entries:    buildHistory(revisions, current),
+		IsLoggedIn: isLoggedIn,
+		User:       user,
+	}
+
+	if err := app.Templates.ExecuteTemplate(w, r, "history.html", data); err != nil {
+		log.Printf("Error executing template: %v", err)
+		ErrorHandler(w, r, http.StatusInternalServerError)
+	}
+}
+
+// buildHistory diffs each version against the one before it. Each revision
+// holds the text an edit replaced and is stamped with that edit's time, so
+// version i was created at the timestamp of revision i-1.
+func buildHistory(revisions []model.Revision, current model.Revision) []historyEntry {
+	versions := append(append([]model.Revision{}, revisions...), current)
+	created := make([]time.Time, len(versions))
+	created[0] = current.CreatedAt
+	for i := 1; i < len(versions); i++ {
+		created[i] = revisions[i-1].CreatedAt
+	}
+
+	entries := make([]historyEntry, 0, len(versions))
+	for i := len(versions) - 1; i >= 0; i-- {
+		entry := historyEntry{Label: "Original", CreatedAt: created[i]}
+		prev := model.Revision{Title: versions[i].Title, Content: versions[i].Content}
+		if i > 0 {
+			entry.Label = "Edit " + strconv.Itoa(i)
+			prev = versions[i-1]
+		}
+		if versions[i].Title != "" {
+			entry.TitleDiff = utils.DiffLines(prev.Title, versions[i].Title)
+		}
+		entry.ContentDiff = utils.DiffLines(prev.Content, versions[i].Content)
+		entries = append(entries, entry)
+	}
+	return entries
+}
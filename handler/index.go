@@ -1,44 +1,50 @@
 package handler
 
 import (
+	"errors"
 	"forum-go/database"
 	"forum-go/model"
-	"forum-go/render"
 	"log"
 	"net/http"
+	"net/url"
 )
 
-func IndexHandler(w http.ResponseWriter, r *http.Request) {
+func (app *App) IndexHandler(w http.ResponseWriter, r *http.Request) {
 
 	if r.URL.Path != "/" {
 		ErrorHandler(w, r, http.StatusNotFound)
 		return
 	}
 
-	category := r.URL.Query().Get("category")
+	query := r.URL.Query()
 
-	var posts []model.Post
-	var err error
+	category := query.Get("category")
+	if category == "All Movies" { // "All Movies" should return all posts
+		category = ""
+	}
 
-	if category != "" && category != "All Movies" { // "All Movies" should return all posts
-		// Fetch posts filtered by category
-		posts, err = database.FetchPostsByCategory(category)
-		if err != nil {
-			log.Printf("Error fetching posts by category: %v", err)
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-			return
-		}
-	} else {
-		// Fetch all posts
-		posts, err = database.FetchPosts()
-		if err != nil {
-			log.Printf("Error fetching posts: %v", err)
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	sort := query.Get("sort")
+	if !database.ValidSort(sort) {
+		sort = database.SortNewest
+	}
+
+	page, err := app.Posts.FetchPostPage(database.PostListOptions{
+		Category: category,
+		Sort:     sort,
+		After:    query.Get("after"),
+		Before:   query.Get("before"),
+	})
+	if err != nil {
+		if errors.Is(err, database.ErrInvalidCursor) {
+			ErrorHandler(w, r, http.StatusBadRequest)
 			return
 		}
+		log.Printf("Error fetching posts: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
 	}
 
-	categories, err := database.FetchCategories()
+	categories, err := app.Posts.FetchCategories()
 	if err != nil {
 		log.Printf("Error fetching categories: %v", err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
@@ -47,10 +53,10 @@ func IndexHandler(w http.ResponseWriter, r *http.Request) {
 
 	success := r.URL.Query().Get("success") == "true"
 
-	isLoggedIn, userID := database.CheckUserLoggedIn(r)
+	isLoggedIn, userID := app.Sessions.CheckUserLoggedIn(r)
 	var user *model.User
 	if isLoggedIn {
-		user, err = database.FetchUserById(userID)
+		user, err = app.Users.FetchUserById(userID)
 		if err != nil {
 			log.Printf("Error fetching user data: %v", err)
 			// Continue without user data
@@ -58,17 +64,66 @@ func IndexHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	data := model.HomePageData{
-		Posts:      posts,
-		Categories: categories,
-		User:       user,
-		Success:    success,
-		IsLoggedIn: isLoggedIn,
+		Posts:       page.Posts,
+		Categories:  categories,
+		User:        user,
+		Success:     success,
+		IsLoggedIn:  isLoggedIn,
+		Category:    category,
+		SortOptions: sortOptions(category, sort),
+	}
+	if page.PrevCursor != "" {
+		data.PrevPage = indexURL(category, sort, "before", page.PrevCursor)
+	}
+	if page.NextCursor != "" {
+		data.NextPage = indexURL(category, sort, "after", page.NextCursor)
 	}
 
-	err = render.Templates.ExecuteTemplate(w, "index.html", data)
+	err = app.Templates.ExecuteTemplate(w, r, "index.html", data)
 	if err != nil {
 		log.Printf("Template execution error: %v", err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
 }
+
+var sortLabels = []struct {
+	value string
+	label string
+}{
+	{database.SortNewest, "Newest"},
+	{database.SortOldest, "Oldest"},
+	{database.SortTop, "Top Score"},
+	{database.SortComments, "Most Commented"},
+	{database.SortActive, "Recently Active"},
+}
+
+func sortOptions(category, current string) []model.SortOption {
+	options := make([]model.SortOption, 0, len(sortLabels))
+	for _, s := range sortLabels {
+		options = append(options, model.SortOption{
+			Label:  s.label,
+			URL:    indexURL(category, s.value, "", ""),
+			Active: s.value == current,
+		})
+	}
+	return options
+}
+
+// indexURL builds a link back to the index keeping the category and sort.
+func indexURL(category, sort, cursorParam, cursor string) string {
+	values := url.Values{}
+	if category != "" {
+		values.Set("category", category)
+	}
+	if sort != database.SortNewest {
+		values.Set("sort", sort)
+	}
+	if cursorParam != "" {
+		values.Set(cursorParam, cursor)
+	}
+	if len(values) == 0 {
+		return "/"
+	}
+	return "/?" + values.Encode()
+}
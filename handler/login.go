@@ -1,22 +1,16 @@
 package handler
 
 import (
+	"errors"
 	"forum-go/auth"
-	"forum-go/database"
 	"log"
 	"net/http"
+	"strconv"
 	"time"
-
-	"golang.org/x/crypto/bcrypt"
 )
 
-func LoginHandler(w http.ResponseWriter, r *http.Request) {
+func (app *App) LoginHandler(w http.ResponseWriter, r *http.Request) {
 
-	if database.DB == nil {
-		log.Printf("Error: database connection is nil")
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-		return
-	}
 	if err := r.ParseMultipartForm(10 << 20); err != nil {
 		ErrorHandler(w, r, http.StatusBadRequest)
 		return
@@ -25,29 +19,54 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 	submittedUsername := r.FormValue("username")
 	submittedPassword := r.FormValue("password")
 
-	user, err := auth.GetUserInfo(database.DB, submittedUsername)
+	user, err := app.Logins.Authenticate(app.Accounts, submittedUsername, submittedPassword, auth.ClientIP(r))
 	if err != nil {
-		if err.Error() == "user not found" {
+		var locked *auth.LockedError
+		if errors.As(err, &locked) {
+			log.Printf("Login refused for %s from %s: %v", submittedUsername, auth.ClientIP(r), err)
+			w.Header().Set("Retry-After", strconv.Itoa(locked.Seconds()))
+			http.Error(w, locked.Error(), http.StatusTooManyRequests)
+			return
+		}
+		if errors.Is(err, auth.ErrInvalidCredentials) {
+			log.Printf("Login failed for %s at %s\n", submittedUsername, time.Now().Format("2006-01-02 15:04:05"))
 			http.Error(w, "Invalid username or password", http.StatusUnauthorized)
-			return 
-		} else {
-			log.Printf("Error retrieving user info: %v", err)
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 			return
 		}
+		log.Printf("Error retrieving user info: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
 	}
 
+	// Banned accounts keep their content but can no longer sign in
+	account, err := app.Users.FetchUserById(user.ID)
+	if err != nil {
+		log.Printf("Error retrieving user info: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if account.Banned() {
+		http.Error(w, "This account has been banned", http.StatusForbidden)
+		return
+	}
 
+	// Create session, a long-lived one if the user asked to stay signed in
+	remember := r.FormValue("remember") != ""
 
-
-	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(submittedPassword)); err != nil {
-		log.Printf("Login failed (wrong password) for %s at %s\n", submittedUsername, time.Now().Format("2006-01-02 15:04:05"))
-		http.Error(w, "Invalid username or password", http.StatusUnauthorized)
+	// Accounts with two-factor login get their session after the second step
+	if !account.TwoFactorAt.IsZero() {
+		if err := app.startTwoFactorLogin(w, account, remember); err != nil {
+			log.Printf("Error starting two-factor login: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Location", "/login/two-factor")
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte("Enter your two-factor code"))
 		return
 	}
 
-	// Create session
-	if err := database.CreateSession(w, user.ID); err != nil {
+	if err := app.Sessions.CreateSession(w, user.ID, r.UserAgent(), auth.ClientIP(r), remember); err != nil {
 		log.Printf("Error creating session: %v", err)
 		http.Error(w, "Error creating session", http.StatusInternalServerError)
 		return
@@ -56,7 +75,7 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 	log.Printf("Login successful: User '%s' logged in at %s",
 		user.Username,
 		time.Now().Format("2006-01-02 15:04:05"))
-	
+
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("Login successful")) // Add this line to send a success message
 }
@@ -1,19 +1,18 @@
 package handler
 
 import (
-	"forum-go/database"
 	"log"
 	"net/http"
-	"time"
 )
 
-func LogoutHandler(w http.ResponseWriter, r *http.Request) {
-
-	if database.DB == nil {
-		log.Println("Database connection is nil in LogoutHandler")
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+// LogoutHandler ends the current session. It only accepts POST, so a link
+// or image on another site cannot log the user out.
+func (app *App) LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		ErrorHandler(w, r, http.StatusMethodNotAllowed)
 		return
 	}
+
 	cookie, err := r.Cookie("session_token")
 	if err != nil {
 		if err == http.ErrNoCookie {
@@ -27,17 +26,13 @@ func LogoutHandler(w http.ResponseWriter, r *http.Request) {
 
 	sessionToken := cookie.Value
 
-	_, err = database.DB.Exec("DELETE FROM sessions WHERE session_token = ?", sessionToken)
+	err = app.Sessions.EndSession(sessionToken)
 	if err != nil {
 		log.Printf("Error deleting session: %v", err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
-	http.SetCookie(w, &http.Cookie{
-		Name:    "session_token",
-		Value:   "",
-		Expires: time.Now(),
-	})
+	app.clearSessionCookie(w)
 
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
@@ -0,0 +1,426 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"forum-go/database"
+	"forum-go/model"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	conversationPageSize = 100
+	maxMessageLength     = 2000
+
+	// A socket that does not answer a ping within socketPongWait is closed.
+	socketPingInterval = 30 * time.Second
+	socketPongWait     = 60 * time.Second
+	socketWriteWait    = 10 * time.Second
+)
+
+// The default origin check only accepts sockets opened by our own pages.
+var upgrader = websocket.Upgrader{ReadBufferSize: 1024, WriteBufferSize: 1024}
+
+// messageJSON is a message as sent to messages.js, over the socket or from
+// the polling endpoint.
+type messageJSON struct {
+	ID          int       `json:"id"`
+	SenderID    int       `json:"sender_id"`
+	Sender      string    `json:"sender"`
+	RecipientID int       `json:"recipient_id"`
+	Body        string    `json:"body"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// MessagesHandler shows the inbox of the signed-in user.
+func (app *App) MessagesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		ErrorHandler(w, r, http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, _ := r.Context().Value("user_id").(int)
+	user, err := app.Users.FetchUserById(userID)
+	if err != nil {
+		log.Printf("Error fetching user data: %v", err)
+		ErrorHandler(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	conversations, err := app.Messages.FetchConversations(userID)
+	if err != nil {
+		log.Printf("Error fetching conversations: %v", err)
+		ErrorHandler(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	data := struct {
+		Title         string
+		User          *model.User
+		IsLoggedIn    bool
+		Conversations []model.Conversation
+		Error         string
+	}{
+		Title:         "Messages",
+		User:          user,
+		IsLoggedIn:    true,
+		Conversations: conversations,
+		Error:         r.URL.Query().Get("error"),
+	}
+
+	if err := app.Templates.ExecuteTemplate(w, r, "messages.html", data); err != nil {
+		log.Printf("Error executing template: %v", err)
+		ErrorHandler(w, r, http.StatusInternalServerError)
+	}
+}
+
+// ConversationHandler shows the messages exchanged with one user and marks
+// the ones received as read.
+func (app *App) ConversationHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		ErrorHandler(w, r, http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, _ := r.Context().Value("user_id").(int)
+	otherName := r.URL.Query().Get("with")
+	otherID, ok := app.messagePartner(w, r, userID, otherName)
+	if !ok {
+		return
+	}
+
+	messages, err := app.Messages.FetchMessages(userID, otherID, 0, conversationPageSize)
+	if err != nil {
+		log.Printf("Error fetching messages: %v", err)
+		ErrorHandler(w, r, http.StatusInternalServerError)
+		return
+	}
+	if err := app.Messages.MarkConversationRead(userID, otherID); err != nil {
+		log.Printf("Error marking messages read: %v", err)
+	}
+
+	blocked, blockedBy, err := app.Messages.BlockState(userID, otherID)
+	if err != nil {
+		log.Printf("Error checking blocks: %v", err)
+		ErrorHandler(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	// Fetched after marking read, so the header count is current
+	user, err := app.Users.FetchUserById(userID)
+	if err != nil {
+		log.Printf("Error fetching user data: %v", err)
+		ErrorHandler(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	lastID := 0
+	if len(messages) > 0 {
+		lastID = messages[len(messages)-1].ID
+	}
+
+	data := struct {
+		Title      string
+		User       *model.User
+		IsLoggedIn bool
+		Other      string
+		OtherID    int
+		Messages   []model.Message
+		LastID     int
+		Blocked    bool // the user blocked Other
+		BlockedBy  bool // Other blocked the user
+	}{
+		Title:      "Messages with " + otherName,
+		User:       user,
+		IsLoggedIn: true,
+		Other:      otherName,
+		OtherID:    otherID,
+		Messages:   messages,
+		LastID:     lastID,
+		Blocked:    blocked,
+		BlockedBy:  blockedBy,
+	}
+
+	if err := app.Templates.ExecuteTemplate(w, r, "conversation.html", data); err != nil {
+		log.Printf("Error executing template: %v", err)
+		ErrorHandler(w, r, http.StatusInternalServerError)
+	}
+}
+
+// SendMessageHandler sends a private message to the user named in "to".
+// Scripts that ask for JSON get the stored message back; plain forms are
+// redirected to the conversation.
+func (app *App) SendMessageHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		ErrorHandler(w, r, http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, _ := r.Context().Value("user_id").(int)
+	to := strings.TrimSpace(r.FormValue("to"))
+	body := strings.TrimSpace(r.FormValue("body"))
+	if to == "" || body == "" || len(body) > maxMessageLength {
+		ErrorHandler(w, r, http.StatusBadRequest)
+		return
+	}
+
+	recipientID, err := app.Users.FetchUserIDByUsername(to)
+	if err == nil {
+		var msg *model.Message
+		msg, err = app.Messages.SendMessage(userID, recipientID, body)
+		if err == nil {
+			app.PublishMessage(msg)
+			if wantsJSON(r) {
+				writeJSON(w, http.StatusCreated, toMessageJSON(*msg))
+				return
+			}
+			http.Redirect(w, r, "/messages/view?with="+url.QueryEscape(to), http.StatusSeeOther)
+			return
+		}
+	}
+
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		// From the inbox form a typo in the name is the likely cause
+		if !wantsJSON(r) {
+			http.Redirect(w, r, "/messages?error="+url.QueryEscape("No user named "+to), http.StatusSeeOther)
+			return
+		}
+		ErrorHandler(w, r, http.StatusNotFound)
+	case errors.Is(err, database.ErrBlocked):
+		ErrorHandler(w, r, http.StatusForbidden)
+	case errors.Is(err, database.ErrSelfMessage):
+		ErrorHandler(w, r, http.StatusBadRequest)
+	default:
+		log.Printf("Error sending message: %v", err)
+		ErrorHandler(w, r, http.StatusInternalServerError)
+	}
+}
+
+// MessagesPollHandler is the fallback for browsers without a working
+// socket. With "with" set it returns the messages of that conversation
+// after the ID in "after" (or the latest ones for 0) and marks them read;
+// it always returns the unread count.
+func (app *App) MessagesPollHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		ErrorHandler(w, r, http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, _ := r.Context().Value("user_id").(int)
+	messages := []messageJSON{}
+
+	if with := r.URL.Query().Get("with"); with != "" {
+		otherID, ok := app.messagePartner(w, r, userID, with)
+		if !ok {
+			return
+		}
+		after, err := strconv.Atoi(r.URL.Query().Get("after"))
+		if err != nil || after < 0 {
+			ErrorHandler(w, r, http.StatusBadRequest)
+			return
+		}
+		fetched, err := app.Messages.FetchMessages(userID, otherID, after, conversationPageSize)
+		if err != nil {
+			log.Printf("Error fetching messages: %v", err)
+			ErrorHandler(w, r, http.StatusInternalServerError)
+			return
+		}
+		for _, m := range fetched {
+			messages = append(messages, toMessageJSON(m))
+		}
+		if err := app.Messages.MarkConversationRead(userID, otherID); err != nil {
+			log.Printf("Error marking messages read: %v", err)
+		}
+	}
+
+	unread, err := app.Messages.UnreadMessageCount(userID)
+	if err != nil {
+		log.Printf("Error counting unread messages: %v", err)
+		ErrorHandler(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, struct {
+		Messages []messageJSON `json:"messages"`
+		Unread   int           `json:"unread"`
+	}{messages, unread})
+}
+
+// MarkMessagesReadHandler marks a conversation read after messages.js
+// showed a message that arrived over the socket.
+func (app *App) MarkMessagesReadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		ErrorHandler(w, r, http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, _ := r.Context().Value("user_id").(int)
+	otherID, ok := app.messagePartner(w, r, userID, r.FormValue("with"))
+	if !ok {
+		return
+	}
+	if err := app.Messages.MarkConversationRead(userID, otherID); err != nil {
+		log.Printf("Error marking messages read: %v", err)
+		ErrorHandler(w, r, http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// BlockUserHandler blocks or unblocks the user named in "username".
+func (app *App) BlockUserHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		ErrorHandler(w, r, http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, _ := r.Context().Value("user_id").(int)
+	name := r.FormValue("username")
+	otherID, ok := app.messagePartner(w, r, userID, name)
+	if !ok {
+		return
+	}
+
+	var err error
+	switch r.FormValue("action") {
+	case "block":
+		err = app.Messages.BlockUser(userID, otherID)
+	case "unblock":
+		err = app.Messages.UnblockUser(userID, otherID)
+	default:
+		ErrorHandler(w, r, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("Error changing block: %v", err)
+		ErrorHandler(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/messages/view?with="+url.QueryEscape(name), http.StatusSeeOther)
+}
+
+// MessagesSocketHandler upgrades to a WebSocket that delivers every message
+// sent or received by the signed-in user as it happens. The socket is
+// one-way: messages are sent with SendMessageHandler.
+func (app *App) MessagesSocketHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := r.Context().Value("user_id").(int)
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade has already written the error response
+		return
+	}
+	defer conn.Close()
+
+	sub := app.MessageEvents.Subscribe(userID, "")
+	defer sub.Close()
+
+	// Reading is needed to process pongs and notice when the browser
+	// goes away
+	gone := make(chan struct{})
+	go func() {
+		defer close(gone)
+		conn.SetReadLimit(512)
+		conn.SetReadDeadline(time.Now().Add(socketPongWait))
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(socketPongWait))
+		})
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ping := time.NewTicker(socketPingInterval)
+	defer ping.Stop()
+
+	for {
+		select {
+		case <-gone:
+			return
+		case <-ping.C:
+			conn.SetWriteDeadline(time.Now().Add(socketWriteWait))
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		case event, ok := <-sub.Events:
+			if !ok {
+				// Dropped for falling behind; the browser reconnects and
+				// catches up through the polling endpoint
+				return
+			}
+			msg, ok := event.Data.(model.Message)
+			if !ok {
+				continue
+			}
+			conn.SetWriteDeadline(time.Now().Add(socketWriteWait))
+			err := conn.WriteJSON(struct {
+				Type    string      `json:"type"`
+				Message messageJSON `json:"message"`
+			}{event.Type, toMessageJSON(msg)})
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+// -- Non-Global Functions : Only happens in this package -- //
+
+// messagePartner resolves the other user of a conversation by name. It
+// writes the error response itself.
+func (app *App) messagePartner(w http.ResponseWriter, r *http.Request, userID int, name string) (int, bool) {
+	if name == "" {
+		ErrorHandler(w, r, http.StatusBadRequest)
+		return 0, false
+	}
+	otherID, err := app.Users.FetchUserIDByUsername(name)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			ErrorHandler(w, r, http.StatusNotFound)
+		} else {
+			log.Printf("Error looking up user: %v", err)
+			ErrorHandler(w, r, http.StatusInternalServerError)
+		}
+		return 0, false
+	}
+	if otherID == userID {
+		ErrorHandler(w, r, http.StatusBadRequest)
+		return 0, false
+	}
+	return otherID, true
+}
+
+func toMessageJSON(m model.Message) messageJSON {
+	return messageJSON{
+		ID:          m.ID,
+		SenderID:    m.SenderID,
+		Sender:      m.Sender,
+		RecipientID: m.RecipientID,
+		Body:        m.Body,
+		CreatedAt:   m.CreatedAt,
+	}
+}
+
+func wantsJSON(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "application/json")
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Error encoding JSON response: %v", err)
+	}
+}
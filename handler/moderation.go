@@ -0,0 +1,229 @@
+package handler
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"forum-go/auth"
+	"forum-go/database"
+	"forum-go/model"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const moderationLogSize = 100
+
+// ModerationHandler shows the account list and the latest moderation actions.
+func (app *App) ModerationHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		ErrorHandler(w, r, http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, _ := r.Context().Value("user_id").(int)
+	user, err := app.Users.FetchUserById(userID)
+	if err != nil {
+		log.Printf("Error fetching user data: %v", err)
+		ErrorHandler(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	users, err := app.Users.FetchUsers()
+	if err != nil {
+		log.Printf("Error fetching users: %v", err)
+		ErrorHandler(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	entries, err := app.Moderation.FetchModerationLog(moderationLogSize)
+	if err != nil {
+		log.Printf("Error fetching moderation log: %v", err)
+		ErrorHandler(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	reports, err := app.Reports.FetchOpenReports()
+	if err != nil {
+		log.Printf("Error fetching reports: %v", err)
+		ErrorHandler(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	data := struct {
+		Title       string
+		User        *model.User
+		IsLoggedIn  bool
+		IsAdmin     bool
+		Users       []model.User
+		Log         []model.ModerationEntry
+		Roles       []string
+		OpenReports int
+	}{
+		Title:       "Moderation",
+		User:        user,
+		IsLoggedIn:  true,
+		IsAdmin:     user.HasRole(model.RoleAdmin),
+		Users:       users,
+		Log:         entries,
+		Roles:       []string{model.RoleUser, model.RoleModerator, model.RoleAdmin},
+		OpenReports: len(reports),
+	}
+
+	if err := app.Templates.ExecuteTemplate(w, r, "moderation.html", data); err != nil {
+		log.Printf("Error executing template: %v", err)
+		ErrorHandler(w, r, http.StatusInternalServerError)
+	}
+}
+
+// ModeratePostHandler hides, restores, locks or unlocks a post.
+func (app *App) ModeratePostHandler(w http.ResponseWriter, r *http.Request) {
+	moderatorID, postID, ok := moderationTarget(w, r, "post_id")
+	if !ok {
+		return
+	}
+	reason := strings.TrimSpace(r.FormValue("reason"))
+
+	var err error
+	switch r.FormValue("action") {
+	case "hide":
+		err = app.Moderation.SetPostHidden(moderatorID, postID, true, reason)
+	case "restore":
+		err = app.Moderation.SetPostHidden(moderatorID, postID, false, reason)
+	case "lock":
+		err = app.Moderation.SetPostLocked(moderatorID, postID, true, reason)
+	case "unlock":
+		err = app.Moderation.SetPostLocked(moderatorID, postID, false, reason)
+	default:
+		ErrorHandler(w, r, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		writeModerationError(w, r, err)
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/viewpost?id=%d", postID), http.StatusSeeOther)
+}
+
+// ModerateCommentHandler hides or restores a comment.
+func (app *App) ModerateCommentHandler(w http.ResponseWriter, r *http.Request) {
+	moderatorID, commentID, ok := moderationTarget(w, r, "comment_id")
+	if !ok {
+		return
+	}
+
+	var hidden bool
+	switch r.FormValue("action") {
+	case "hide":
+		hidden = true
+	case "restore":
+		hidden = false
+	default:
+		ErrorHandler(w, r, http.StatusBadRequest)
+		return
+	}
+
+	comment, err := app.Comments.FetchCommentByID(commentID)
+	if err != nil {
+		writeModerationError(w, r, err)
+		return
+	}
+
+	err = app.Moderation.SetCommentHidden(moderatorID, commentID, hidden, strings.TrimSpace(r.FormValue("reason")))
+	if err != nil {
+		writeModerationError(w, r, err)
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/viewpost?id=%d#comment-%d", comment.PostID, commentID), http.StatusSeeOther)
+}
+
+// ModerateUserHandler bans or unbans an account.
+func (app *App) ModerateUserHandler(w http.ResponseWriter, r *http.Request) {
+	moderatorID, userID, ok := moderationTarget(w, r, "user_id")
+	if !ok {
+		return
+	}
+
+	var banned bool
+	switch r.FormValue("action") {
+	case "ban":
+		banned = true
+	case "unban":
+		banned = false
+	default:
+		ErrorHandler(w, r, http.StatusBadRequest)
+		return
+	}
+
+	err := app.Moderation.SetUserBanned(moderatorID, userID, banned, strings.TrimSpace(r.FormValue("reason")))
+	if err != nil {
+		writeModerationError(w, r, err)
+		return
+	}
+
+	http.Redirect(w, r, "/moderation", http.StatusSeeOther)
+}
+
+// SetRoleHandler lets an admin change the role of another account.
+func (app *App) SetRoleHandler(w http.ResponseWriter, r *http.Request) {
+	adminID, userID, ok := moderationTarget(w, r, "user_id")
+	if !ok {
+		return
+	}
+
+	role := r.FormValue("role")
+	if !model.ValidRole(role) {
+		ErrorHandler(w, r, http.StatusBadRequest)
+		return
+	}
+
+	if err := app.Moderation.SetUserRole(adminID, userID, role); err != nil {
+		writeModerationError(w, r, err)
+		return
+	}
+
+	http.Redirect(w, r, "/moderation", http.StatusSeeOther)
+}
+
+// moderationTarget checks the method and reads the acting user and the
+// numeric target ID from the form. It writes the error response itself.
+func moderationTarget(w http.ResponseWriter, r *http.Request, field string) (int, int, bool) {
+	if r.Method != http.MethodPost {
+		ErrorHandler(w, r, http.StatusMethodNotAllowed)
+		return 0, 0, false
+	}
+
+	moderatorID, ok := r.Context().Value("user_id").(int)
+	if !ok {
+		ErrorHandler(w, r, http.StatusUnauthorized)
+		return 0, 0, false
+	}
+
+	targetID, err := strconv.Atoi(r.FormValue(field))
+	if err != nil {
+		ErrorHandler(w, r, http.StatusBadRequest)
+		return 0, 0, false
+	}
+	return moderatorID, targetID, true
+}
+
+func writeModerationError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		ErrorHandler(w, r, http.StatusNotFound)
+	case errors.Is(err, database.ErrInsufficientRole):
+		ErrorHandler(w, r, http.StatusForbidden)
+	default:
+		log.Printf("Error applying moderation action: %v", err)
+		ErrorHandler(w, r, http.StatusInternalServerError)
+	}
+}
+
+// isModerator reports whether user may see hidden content and moderation
+// controls on public pages. user is nil for guests.
+func (app *App) isModerator(user *model.User) bool {
+	return user != nil && !user.Banned() && user.HasRole(model.RoleModerator) && !auth.MustEnrollTwoFactor(app.TwoFactorRole, user)
+}
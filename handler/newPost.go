@@ -2,29 +2,27 @@ package handler
 
 import (
 	"fmt"
-	"forum-go/database"
 	"forum-go/model"
 	"log"
 	"net/http"
 	"strings"
-	"text/template"
 	"time"
 )
 
-func NewPostHandler(w http.ResponseWriter, r *http.Request) {
+func (app *App) NewPostHandler(w http.ResponseWriter, r *http.Request) {
 
 	if r.URL.Path != "/newpost" {
 		ErrorHandler(w, r, http.StatusNotFound)
 		return
 	}
 
-	isLoggedIn, userID := database.CheckUserLoggedIn(r)
+	isLoggedIn, userID := app.Sessions.CheckUserLoggedIn(r)
 	if !isLoggedIn {
 		http.Redirect(w, r, "/login", http.StatusSeeOther)
 		return
 	}
 
-	user, err := database.FetchUserById(userID)
+	user, err := app.Users.FetchUserById(userID)
 	if err != nil {
 		log.Printf("Error fetching user data: %v", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
@@ -34,7 +32,7 @@ func NewPostHandler(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodGet:
 
-		categories, err := database.FetchCategories()
+		categories, err := app.Posts.FetchCategories()
 		if err != nil {
 			log.Printf("Error fetching categories: %v", err)
 			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
@@ -52,16 +50,7 @@ func NewPostHandler(w http.ResponseWriter, r *http.Request) {
 		}
 
 		// Display the new post form
-		tmpl, err := template.ParseFiles(
-			"./templates/newPost.html",
-			"./templates/header.html",
-			"./templates/footer.html")
-		if err != nil {
-			log.Printf("Error parsing template: %v", err)
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
-			return
-		}
-		err = tmpl.Execute(w, data)
+		err = app.Templates.ExecuteTemplate(w, r, "newPost.html", data)
 		if err != nil {
 			log.Printf("Error executing template: %v", err)
 			http.Error(w, "Internal server error", http.StatusInternalServerError)
@@ -78,26 +67,38 @@ func NewPostHandler(w http.ResponseWriter, r *http.Request) {
 
 		title := r.FormValue("title")
 		content := r.FormValue("content")
-		categories := r.Form["category"]
+		categoryIDs := r.Form["category"]
 
-		if title == "" || content == "" || len(categories) == 0 {
+		if title == "" || content == "" || len(categoryIDs) == 0 || len(categoryIDs) > 3 {
 			http.Error(w, "All fields are required", http.StatusBadRequest)
 			return
 		}
-		categoriesStr := strings.Join(categories, ", ")
+
+		known, err := app.Posts.FetchCategories()
+		if err != nil {
+			log.Printf("Error fetching categories: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		selected, ok := selectCategories(known, categoryIDs)
+		if !ok {
+			http.Error(w, "Unknown category", http.StatusBadRequest)
+			return
+		}
 
 		// Create a new post
 		post := &model.Post{
 			Title:      title,
 			Content:    content,
 			UserID:     userID,
-			Categories: categoriesStr,
+			Categories: selected,
 			CreatedAt:  time.Now(),
 			UpdatedAt:  time.Now(),
 		}
 
 		// Save the post to the database
-		postID, err := savePost(post)
+		postID, err := app.Posts.CreatePost(post)
 		if err != nil {
 			log.Printf("Error saving post: %v", err)
 			http.Error(w, "Error saving post", http.StatusInternalServerError)
@@ -112,20 +113,26 @@ func NewPostHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func savePost(post *model.Post) (int64, error) {
-	query := `
-        INSERT INTO posts (title, content, user_id, categories, created_at, updated_at)
-        VALUES (?, ?, ?, ?, ?, ?)
-    `
-	result, err := database.DB.Exec(query, post.Title, post.Content, post.UserID, post.Categories, post.CreatedAt, post.UpdatedAt)
-	if err != nil {
-		return 0, fmt.Errorf("error saving post: %w", err)
+// selectCategories maps submitted category IDs onto known categories,
+// ignoring duplicates. It reports false if any ID is unknown.
+func selectCategories(known []model.Category, ids []string) ([]model.Category, bool) {
+	byID := make(map[string]model.Category, len(known))
+	for _, c := range known {
+		byID[c.ID] = c
 	}
 
-	id, err := result.LastInsertId()
-	if err != nil {
-		return 0, fmt.Errorf("error getting last insert ID: %w", err)
+	var selected []model.Category
+	seen := make(map[string]bool)
+	for _, id := range ids {
+		id = strings.TrimSpace(id)
+		c, ok := byID[id]
+		if !ok {
+			return nil, false
+		}
+		if !seen[id] {
+			seen[id] = true
+			selected = append(selected, c)
+		}
 	}
-
-	return id, nil
+	return selected, true
 }
@@ -0,0 +1,154 @@
+package handler
+
+import (
+	"database/sql"
+	"errors"
+	"forum-go/database"
+	"forum-go/model"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+const notificationPageSize = 50
+
+// notificationLabels describe each kind on the preferences form.
+var notificationLabels = map[string]string{
+	database.NotificationComment: "Comments on my posts",
+	database.NotificationReply:   "Replies to my comments",
+	database.NotificationMention: "Mentions of @my name",
+	database.NotificationVote:    "Votes on my posts and comments",
+	database.NotificationReport:  "Decisions on my reports",
+}
+
+// NotificationsHandler lists the latest notifications of the signed-in user
+// together with their preferences.
+func (app *App) NotificationsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		ErrorHandler(w, r, http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, _ := r.Context().Value("user_id").(int)
+	user, err := app.Users.FetchUserById(userID)
+	if err != nil {
+		log.Printf("Error fetching user data: %v", err)
+		ErrorHandler(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	notifications, err := app.Notifications.FetchNotifications(userID, notificationPageSize)
+	if err != nil {
+		log.Printf("Error fetching notifications: %v", err)
+		ErrorHandler(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	prefs, err := app.Notifications.FetchNotificationPreferences(userID)
+	if err != nil {
+		log.Printf("Error fetching notification preferences: %v", err)
+		ErrorHandler(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	type preference struct {
+		Kind    string
+		Label   string
+		Enabled bool
+	}
+	var preferences []preference
+	for _, kind := range database.NotificationKinds {
+		preferences = append(preferences, preference{kind, notificationLabels[kind], prefs[kind]})
+	}
+
+	data := struct {
+		Title         string
+		User          *model.User
+		IsLoggedIn    bool
+		Notifications []model.Notification
+		Preferences   []preference
+		Saved         bool
+	}{
+		Title:         "Notifications",
+		User:          user,
+		IsLoggedIn:    true,
+		Notifications: notifications,
+		Preferences:   preferences,
+		Saved:         r.URL.Query().Get("saved") == "1",
+	}
+
+	if err := app.Templates.ExecuteTemplate(w, r, "notifications.html", data); err != nil {
+		log.Printf("Error executing template: %v", err)
+		ErrorHandler(w, r, http.StatusInternalServerError)
+	}
+}
+
+// MarkNotificationsReadHandler marks one notification as read, or all of
+// them when no id is sent.
+func (app *App) MarkNotificationsReadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		ErrorHandler(w, r, http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := r.Context().Value("user_id").(int)
+	if !ok {
+		ErrorHandler(w, r, http.StatusUnauthorized)
+		return
+	}
+
+	var err error
+	if raw := r.FormValue("id"); raw != "" {
+		notificationID, convErr := strconv.Atoi(raw)
+		if convErr != nil {
+			ErrorHandler(w, r, http.StatusBadRequest)
+			return
+		}
+		err = app.Notifications.MarkNotificationRead(userID, notificationID)
+	} else {
+		err = app.Notifications.MarkAllNotificationsRead(userID)
+	}
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			ErrorHandler(w, r, http.StatusNotFound)
+			return
+		}
+		log.Printf("Error marking notifications read: %v", err)
+		ErrorHandler(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/notifications", http.StatusSeeOther)
+}
+
+// NotificationPreferencesHandler saves which kinds of notification the
+// user wants. Unchecked boxes are not sent, so they switch a kind off.
+func (app *App) NotificationPreferencesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		ErrorHandler(w, r, http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := r.Context().Value("user_id").(int)
+	if !ok {
+		ErrorHandler(w, r, http.StatusUnauthorized)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		ErrorHandler(w, r, http.StatusBadRequest)
+		return
+	}
+	enabled := make(map[string]bool)
+	for _, kind := range r.Form["kind"] {
+		enabled[kind] = true
+	}
+
+	if err := app.Notifications.SetNotificationPreferences(userID, enabled); err != nil {
+		log.Printf("Error saving notification preferences: %v", err)
+		ErrorHandler(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/notifications?saved=1#preferences", http.StatusSeeOther)
+}
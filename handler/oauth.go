@@ -0,0 +1,200 @@
+package handler
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"forum-go/auth"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// oauthCookie carries the state and PKCE verifier of a sign-in in
+// progress, from the redirect to the provider until the callback.
+const oauthCookie = "oauth_state"
+
+// OAuthLoginHandler sends the browser to the provider named in the path
+// to sign in.
+func (app *App) OAuthLoginHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		ErrorHandler(w, r, http.StatusMethodNotAllowed)
+		return
+	}
+	provider, ok := app.Providers.Lookup(r.PathValue("provider"))
+	if !ok {
+		ErrorHandler(w, r, http.StatusNotFound)
+		return
+	}
+
+	state := auth.NewOAuthState()
+	verifier, challenge := auth.NewPKCE()
+	http.SetCookie(w, &http.Cookie{
+		Name:     oauthCookie,
+		Value:    state + "." + verifier,
+		Path:     "/oauth/",
+		MaxAge:   int((10 * time.Minute).Seconds()),
+		HttpOnly: true,
+		Secure:   app.Sessions.SessionOptions().Secure,
+		// Lax, so the cookie comes back with the provider's redirect
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, provider.AuthCodeURL(state, challenge, app.oauthRedirectURL(provider)), http.StatusFound)
+}
+
+// OAuthCallbackHandler finishes a sign-in when the provider sends the
+// browser back. Signed-in users link the provider account to theirs;
+// everyone else is logged in, to a linked account, an account with the
+// same verified address, or a new one.
+func (app *App) OAuthCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		ErrorHandler(w, r, http.StatusMethodNotAllowed)
+		return
+	}
+	provider, ok := app.Providers.Lookup(r.PathValue("provider"))
+	if !ok {
+		ErrorHandler(w, r, http.StatusNotFound)
+		return
+	}
+
+	// The flow is over whatever happens next
+	cookie, cookieErr := r.Cookie(oauthCookie)
+	http.SetCookie(w, &http.Cookie{Name: oauthCookie, Value: "", Path: "/oauth/", MaxAge: -1, HttpOnly: true})
+
+	if reason := r.URL.Query().Get("error"); reason != "" {
+		log.Printf("%s sign-in was refused: %s", provider.Label(), reason)
+		app.oauthFailed(w, r, http.StatusBadRequest, "Signing in with "+provider.Label()+" was cancelled.")
+		return
+	}
+	state, verifier, _ := strings.Cut(valueOf(cookie, cookieErr), ".")
+	query := r.URL.Query().Get("state")
+	if state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(query)) != 1 {
+		app.oauthFailed(w, r, http.StatusBadRequest, "This sign-in has expired. Please try again.")
+		return
+	}
+
+	identity, err := provider.Exchange(r.Context(), r.URL.Query().Get("code"), verifier, app.oauthRedirectURL(provider))
+	if err != nil {
+		log.Printf("Error finishing %s sign-in: %v", provider.Label(), err)
+		app.oauthFailed(w, r, http.StatusBadGateway, provider.Label()+" did not confirm who you are. Please try again.")
+		return
+	}
+
+	if loggedIn, userID := app.Sessions.CheckUserLoggedIn(r); loggedIn {
+		app.linkIdentity(w, r, provider, userID, identity)
+		return
+	}
+
+	user, created, err := app.Accounts.SignInWithIdentity(identity)
+	switch {
+	case errors.Is(err, auth.ErrEmailNotVerified):
+		app.oauthFailed(w, r, http.StatusForbidden, provider.Label()+" did not share a verified email address with us. Verify one there, or register with a password.")
+		return
+	case errors.Is(err, auth.ErrEmailInUse):
+		app.oauthFailed(w, r, http.StatusConflict, "An account already uses the email address of your "+provider.Label()+
+			" account. Log in with its password, then connect "+provider.Label()+" from your settings.")
+		return
+	case err != nil:
+		log.Printf("Error signing in with %s: %v", provider.Label(), err)
+		ErrorHandler(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	account, err := app.Users.FetchUserById(user.ID)
+	if err != nil {
+		log.Printf("Error retrieving user info: %v", err)
+		ErrorHandler(w, r, http.StatusInternalServerError)
+		return
+	}
+	if account.Banned() {
+		app.oauthFailed(w, r, http.StatusForbidden, "This account has been banned.")
+		return
+	}
+
+	ip := auth.ClientIP(r)
+	outcome := auth.OutcomeSuccess
+	if !account.TwoFactorAt.IsZero() {
+		// The provider stands in for the password, not the second factor
+		outcome = auth.OutcomePassword
+	}
+	if err := app.Logins.Record(app.Accounts, user.Username, ip, outcome); err != nil {
+		log.Printf("Error recording login: %v", err)
+	}
+	if !account.TwoFactorAt.IsZero() {
+		if err := app.startTwoFactorLogin(w, account, false); err != nil {
+			log.Printf("Error starting two-factor login: %v", err)
+			ErrorHandler(w, r, http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, "/login/two-factor", http.StatusSeeOther)
+		return
+	}
+	if err := app.Sessions.CreateSession(w, user.ID, r.UserAgent(), ip, false); err != nil {
+		log.Printf("Error creating session: %v", err)
+		ErrorHandler(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Login successful: User '%s' logged in with %s", user.Username, provider.Label())
+	if created {
+		// New accounts get a username made up from the provider's profile
+		http.Redirect(w, r, "/settings?saved=welcome#username", http.StatusSeeOther)
+		return
+	}
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// UnlinkIdentityHandler stops the user from logging in with a provider.
+func (app *App) UnlinkIdentityHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		ErrorHandler(w, r, http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, _ := r.Context().Value("user_id").(int)
+	if err := app.Accounts.UnlinkIdentity(userID, r.FormValue("provider")); err != nil {
+		if err == sql.ErrNoRows {
+			ErrorHandler(w, r, http.StatusNotFound)
+			return
+		}
+		log.Printf("Error unlinking identity: %v", err)
+		ErrorHandler(w, r, http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/settings?saved=disconnected#connected", http.StatusSeeOther)
+}
+
+// -- Non-Global Functions : Only happens in this package -- //
+
+func (app *App) linkIdentity(w http.ResponseWriter, r *http.Request, provider auth.Provider, userID int, identity *auth.Identity) {
+	err := app.Accounts.LinkIdentity(userID, identity)
+	if errors.Is(err, auth.ErrIdentityTaken) {
+		app.oauthFailed(w, r, http.StatusConflict, "This "+provider.Label()+" account already signs in to another user.")
+		return
+	}
+	if err != nil {
+		log.Printf("Error linking %s: %v", provider.Label(), err)
+		ErrorHandler(w, r, http.StatusInternalServerError)
+		return
+	}
+	log.Printf("User %d connected their %s account", userID, provider.Label())
+	http.Redirect(w, r, "/settings?saved=connected#connected", http.StatusSeeOther)
+}
+
+func (app *App) oauthFailed(w http.ResponseWriter, r *http.Request, status int, message string) {
+	app.renderAccountPage(w, r, status, accountPage{Title: "Sign-in failed", Page: "oauth-failed", Error: message})
+}
+
+// oauthRedirectURL is the callback address registered with the provider,
+// on the configured BaseURL.
+func (app *App) oauthRedirectURL(provider auth.Provider) string {
+	return app.absoluteURL("/oauth/" + provider.ID() + "/callback")
+}
+
+func valueOf(cookie *http.Cookie, err error) string {
+	if err != nil {
+		return ""
+	}
+	return cookie.Value
+}
@@ -2,48 +2,63 @@ package handler
 
 import (
 	"fmt"
-	"forum-go/database"
 	"forum-go/model"
-	"forum-go/render"
 	"log"
 	"net/http"
 )
 
-func ProfileHandler(w http.ResponseWriter, r *http.Request) {
+func (app *App) ProfileHandler(w http.ResponseWriter, r *http.Request) {
 	// Check if user is logged in
-	loggedIn, userID := database.CheckUserLoggedIn(r)
+	loggedIn, userID := app.Sessions.CheckUserLoggedIn(r)
 	if !loggedIn {
 		http.Redirect(w, r, "/login", http.StatusSeeOther)
 		return
 	}
 
 	// Fetch user information
-	user, err := database.FetchUserById(userID)
+	user, err := app.Users.FetchUserById(userID)
 	if err != nil {
 		ErrorHandler(w, r, http.StatusInternalServerError)
 		return
 	}
 
 	// Fetch user's posts
-	posts, err := database.FetchPostsByUserID(userID)
+	posts, err := app.Posts.FetchPostsByUserID(userID)
 	if err != nil {
 		ErrorHandler(w, r, http.StatusInternalServerError)
 		return
 	}
 
 	// Fetch liked posts
-	likedPosts, err := database.FetchLikedPostsByUserID(userID)
+	likedPosts, err := app.Posts.FetchLikedPostsByUserID(userID)
 	if err != nil {
 		ErrorHandler(w, r, http.StatusInternalServerError)
 		return
 	}
 
-	dislikedPosts, err := database.FetchDislikedPostsByUserID(userID)
+	dislikedPosts, err := app.Posts.FetchDislikedPostsByUserID(userID)
 	if err != nil {
 		ErrorHandler(w, r, http.StatusInternalServerError)
 		return
 	}
 
+	// Reporters follow what happened to their reports here
+	reports, err := app.Reports.FetchReportsByUser(userID)
+	if err != nil {
+		ErrorHandler(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	// Every device the user is signed in on, to revoke from here
+	var sessions []model.Session
+	if cookie, err := r.Cookie("session_token"); err == nil {
+		sessions, err = app.Sessions.FetchSessions(userID, cookie.Value)
+		if err != nil {
+			ErrorHandler(w, r, http.StatusInternalServerError)
+			return
+		}
+	}
+
 	//fmt.Printf("User: %s, LikedPosts Count: %d\n", user.Username, len(likedPosts))
 
 	// Prepare data for the template
@@ -53,6 +68,8 @@ func ProfileHandler(w http.ResponseWriter, r *http.Request) {
 		Posts         []*model.Post
 		LikedPosts    []*model.Post
 		DislikedPosts []*model.Post
+		Reports       []model.Report
+		Sessions      []model.Session
 		IsLoggedIn    bool
 	}{
 		Title:         fmt.Sprintf("%s's Profile", user.Username),
@@ -60,10 +77,12 @@ func ProfileHandler(w http.ResponseWriter, r *http.Request) {
 		Posts:         posts,
 		LikedPosts:    likedPosts,
 		DislikedPosts: dislikedPosts,
+		Reports:       reports,
+		Sessions:      sessions,
 		IsLoggedIn:    true,
 	}
 
-	err = render.Templates.ExecuteTemplate(w, "profile.html", data)
+	err = app.Templates.ExecuteTemplate(w, r, "profile.html", data)
 	if err != nil {
 		log.Printf("Error executing template: %v", err)
 		ErrorHandler(w, r, http.StatusInternalServerError)
@@ -3,14 +3,12 @@ package handler
 import (
 	"database/sql"
 	"fmt"
-	"forum-go/auth"
-	"forum-go/database"
 	"forum-go/pkg/utils"
 	"log"
 	"net/http"
 )
 
-func RegisterHandler(w http.ResponseWriter, r *http.Request) {
+func (app *App) RegisterHandler(w http.ResponseWriter, r *http.Request) {
 
 	if r.Method != http.MethodPost {
 		ErrorHandler(w, r, http.StatusMethodNotAllowed)
@@ -35,14 +33,14 @@ func RegisterHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// **Validate input using `ValidateInputs()`**
-	if err := utils.ValidateInputs(database.DB, username, email, password); err != nil {
+	if err := utils.ValidateInputs(app.Users, username, email, password); err != nil {
 		log.Printf("Validation failed: %v", err)
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
 	// Check if user already exists
-	existingUserID, err := auth.UserExists(database.DB, username)
+	existingUserID, err := app.Accounts.UserExists(username)
 	if err != nil && err != sql.ErrNoRows {
 		log.Printf("Error checking user existence: %v", err)
 		ErrorHandler(w, r, http.StatusInternalServerError)
@@ -54,13 +52,20 @@ func RegisterHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Add the user to the database
-	err = auth.AddUser(database.DB,username, email, password)
+	err = app.Accounts.AddUser(username, email, password)
 	if err != nil {
 		log.Printf("Error adding user: %v", err)
 		ErrorHandler(w, r, http.StatusInternalServerError)
 		return
 	}
 
+	// Ask the user to confirm the address; the account works meanwhile
+	if user, err := app.Users.FetchUserByEmail(email); err != nil {
+		log.Printf("Error fetching new user: %v", err)
+	} else if err := app.sendVerification(user); err != nil {
+		log.Printf("Error sending verification email: %v", err)
+	}
+
 	// Respond with success
 	w.WriteHeader(http.StatusCreated)
 	fmt.Fprintf(w, "User registered successfully")
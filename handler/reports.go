@@ -0,0 +1,138 @@
+package handler
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"forum-go/database"
+	"forum-go/model"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const maxReportDetails = 500
+
+// ReportHandler files a report on a post or comment and sends the reader
+// back to the thread.
+func (app *App) ReportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		ErrorHandler(w, r, http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := r.Context().Value("user_id").(int)
+	if !ok {
+		ErrorHandler(w, r, http.StatusUnauthorized)
+		return
+	}
+
+	targetType := r.FormValue("target_type")
+	targetID, err := strconv.Atoi(r.FormValue("target_id"))
+	if err != nil || (targetType != "post" && targetType != "comment") {
+		ErrorHandler(w, r, http.StatusBadRequest)
+		return
+	}
+
+	reason := r.FormValue("reason")
+	details := strings.TrimSpace(r.FormValue("details"))
+	if !database.ValidReportReason(reason) || len(details) > maxReportDetails {
+		ErrorHandler(w, r, http.StatusBadRequest)
+		return
+	}
+
+	// Work out where to send the reader back to
+	redirect := fmt.Sprintf("/viewpost?id=%d&reported=1", targetID)
+	if targetType == "comment" {
+		comment, err := app.Comments.FetchCommentByID(targetID)
+		if err != nil {
+			writeReportError(w, r, err)
+			return
+		}
+		redirect = fmt.Sprintf("/viewpost?id=%d&reported=1#comment-%d", comment.PostID, targetID)
+	}
+
+	err = app.Reports.CreateReport(userID, targetType, targetID, reason, details)
+	if err != nil && !errors.Is(err, database.ErrAlreadyReported) {
+		writeReportError(w, r, err)
+		return
+	}
+
+	http.Redirect(w, r, redirect, http.StatusSeeOther)
+}
+
+// ReportQueueHandler lists the open reports for moderators, oldest first.
+func (app *App) ReportQueueHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		ErrorHandler(w, r, http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, _ := r.Context().Value("user_id").(int)
+	user, err := app.Users.FetchUserById(userID)
+	if err != nil {
+		log.Printf("Error fetching user data: %v", err)
+		ErrorHandler(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	reports, err := app.Reports.FetchOpenReports()
+	if err != nil {
+		log.Printf("Error fetching reports: %v", err)
+		ErrorHandler(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	data := struct {
+		Title      string
+		User       *model.User
+		IsLoggedIn bool
+		Reports    []model.Report
+	}{
+		Title:      "Reports",
+		User:       user,
+		IsLoggedIn: true,
+		Reports:    reports,
+	}
+
+	if err := app.Templates.ExecuteTemplate(w, r, "reportQueue.html", data); err != nil {
+		log.Printf("Error executing template: %v", err)
+		ErrorHandler(w, r, http.StatusInternalServerError)
+	}
+}
+
+// ResolveReportHandler accepts or dismisses a report from the queue.
+func (app *App) ResolveReportHandler(w http.ResponseWriter, r *http.Request) {
+	moderatorID, reportID, ok := moderationTarget(w, r, "report_id")
+	if !ok {
+		return
+	}
+
+	var accept bool
+	switch r.FormValue("action") {
+	case "accept":
+		accept = true
+	case "dismiss":
+		accept = false
+	default:
+		ErrorHandler(w, r, http.StatusBadRequest)
+		return
+	}
+
+	if err := app.Reports.ResolveReport(moderatorID, reportID, accept); err != nil {
+		writeModerationError(w, r, err)
+		return
+	}
+
+	http.Redirect(w, r, "/moderation/reports", http.StatusSeeOther)
+}
+
+func writeReportError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, sql.ErrNoRows) {
+		ErrorHandler(w, r, http.StatusNotFound)
+		return
+	}
+	log.Printf("Error saving report: %v", err)
+	ErrorHandler(w, r, http.StatusInternalServerError)
+}
@@ -0,0 +1,128 @@
+package handler
+
+import (
+	"forum-go/database"
+	"forum-go/model"
+	"html"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type searchResultView struct {
+	model.SearchResult
+	Highlight template.HTML
+}
+
+func (app *App) SearchHandler(w http.ResponseWriter, r *http.Request) {
+
+	if r.URL.Path != "/search" {
+		ErrorHandler(w, r, http.StatusNotFound)
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		ErrorHandler(w, r, http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	opts := database.SearchOptions{
+		Query:    strings.TrimSpace(query.Get("q")),
+		Category: query.Get("category"),
+		Author:   strings.TrimSpace(query.Get("author")),
+	}
+
+	var err error
+	if from := query.Get("from"); from != "" {
+		opts.From, err = time.ParseInLocation("2006-01-02", from, time.Local)
+		if err != nil {
+			ErrorHandler(w, r, http.StatusBadRequest)
+			return
+		}
+	}
+	if to := query.Get("to"); to != "" {
+		opts.To, err = time.ParseInLocation("2006-01-02", to, time.Local)
+		if err != nil {
+			ErrorHandler(w, r, http.StatusBadRequest)
+			return
+		}
+		// The end date is inclusive
+		opts.To = opts.To.AddDate(0, 0, 1)
+	}
+
+	var results []searchResultView
+	if opts.Query != "" {
+		hits, err := app.Posts.SearchPosts(opts)
+		if err != nil {
+			log.Printf("Error searching posts: %v", err)
+			ErrorHandler(w, r, http.StatusInternalServerError)
+			return
+		}
+		for _, hit := range hits {
+			results = append(results, searchResultView{
+				SearchResult: hit,
+				Highlight:    highlightSnippet(hit.Snippet),
+			})
+		}
+	}
+
+	categories, err := app.Posts.FetchCategories()
+	if err != nil {
+		log.Printf("Error fetching categories: %v", err)
+		ErrorHandler(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	isLoggedIn, userID := app.Sessions.CheckUserLoggedIn(r)
+	var user *model.User
+	if isLoggedIn {
+		user, err = app.Users.FetchUserById(userID)
+		if err != nil {
+			log.Printf("Error fetching user data: %v", err)
+			// Continue without user data
+		}
+	}
+
+	data := struct {
+		Query      string
+		Category   string
+		Author     string
+		From       string
+		To         string
+		Searched   bool
+		Results    []searchResultView
+		Categories []model.Category
+		IsLoggedIn bool
+		User       *model.User
+	}{
+		Query:      opts.Query,
+		Category:   opts.Category,
+		Author:     opts.Author,
+		From:       query.Get("from"),
+		To:         query.Get("to"),
+		Searched:   opts.Query != "",
+		Results:    results,
+		Categories: categories,
+		IsLoggedIn: isLoggedIn,
+		User:       user,
+	}
+
+	err = app.Templates.ExecuteTemplate(w, r, "search.html", data)
+	if err != nil {
+		log.Printf("Error executing template: %v", err)
+		ErrorHandler(w, r, http.StatusInternalServerError)
+		return
+	}
+}
+
+// highlightSnippet escapes a search snippet and turns the database markers
+// around matched terms into <mark> tags.
+func highlightSnippet(snippet string) template.HTML {
+	escaped := html.EscapeString(snippet)
+	escaped = strings.ReplaceAll(escaped, database.SnippetOpen, "<mark>")
+	escaped = strings.ReplaceAll(escaped, database.SnippetClose, "</mark>")
+	return template.HTML(escaped)
+}
@@ -0,0 +1,83 @@
+package handler
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// RevokeSessionHandler signs one of the user's devices out from the
+// profile page. Revoking the current session logs the user out here too.
+func (app *App) RevokeSessionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		ErrorHandler(w, r, http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, _ := r.Context().Value("user_id").(int)
+	sessionID, err := strconv.Atoi(r.FormValue("session_id"))
+	if err != nil {
+		ErrorHandler(w, r, http.StatusBadRequest)
+		return
+	}
+
+	// Looked up before deleting, to know whether it is this browser's
+	current := false
+	if cookie, err := r.Cookie("session_token"); err == nil {
+		sessions, err := app.Sessions.FetchSessions(userID, cookie.Value)
+		if err != nil {
+			log.Printf("Error fetching sessions: %v", err)
+			ErrorHandler(w, r, http.StatusInternalServerError)
+			return
+		}
+		for _, s := range sessions {
+			if s.ID == sessionID {
+				current = s.Current
+			}
+		}
+	}
+
+	if err := app.Sessions.EndUserSession(userID, sessionID); err != nil {
+		if err == sql.ErrNoRows {
+			ErrorHandler(w, r, http.StatusNotFound)
+			return
+		}
+		log.Printf("Error revoking session: %v", err)
+		ErrorHandler(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	if current {
+		app.clearSessionCookie(w)
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	http.Redirect(w, r, "/profile#devices", http.StatusSeeOther)
+}
+
+// LogoutEverywhereHandler ends every session of the user, on all devices
+// including this one.
+func (app *App) LogoutEverywhereHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		ErrorHandler(w, r, http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, _ := r.Context().Value("user_id").(int)
+	if err := app.Sessions.EndAllSessions(userID); err != nil {
+		log.Printf("Error ending sessions: %v", err)
+		ErrorHandler(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("User %d logged out everywhere", userID)
+	app.clearSessionCookie(w)
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// -- Non-Global Functions : Only happens in this package -- //
+
+func (app *App) clearSessionCookie(w http.ResponseWriter) {
+	http.SetCookie(w, app.Sessions.SessionOptions().ExpiredCookie())
+}
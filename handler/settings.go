@@ -0,0 +1,299 @@
+package handler
+
+import (
+	"errors"
+	"forum-go/auth"
+	"forum-go/database"
+	"forum-go/model"
+	"forum-go/pkg/utils"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// Choices for what happens to the posts and comments of a deleted account.
+const (
+	DeleteKeepContent   = "anonymize"
+	DeleteRemoveContent = "remove"
+)
+
+// settingsPage is what settings.html shows. Form names the section that
+// was just saved or that failed with Error.
+type settingsPage struct {
+	Title       string
+	User        *model.User
+	IsLoggedIn  bool
+	Saved       string
+	Form        string
+	Error       string
+	Connections []connection
+
+	TwoFactor *model.TwoFactor
+	// TwoFactorRequired is set when the user's role has to use two-factor
+	// login
+	TwoFactorRequired bool
+	// SetupURI and SetupQR carry the pending secret to the user's app
+	SetupURI template.URL
+	SetupQR  template.URL
+	// RecoveryCodes are shown once, right after they are made
+	RecoveryCodes []string
+}
+
+// connection is a sign-in provider on the settings page, with the
+// provider account the user linked, if any.
+type connection struct {
+	ID     string
+	Label  string
+	Linked *model.LinkedIdentity
+}
+
+// SettingsHandler shows the forms to change the account's username, email
+// address and password, set up two-factor login, and delete the account.
+func (app *App) SettingsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		ErrorHandler(w, r, http.StatusMethodNotAllowed)
+		return
+	}
+	app.renderSettings(w, r, http.StatusOK, settingsPage{Saved: r.URL.Query().Get("saved")})
+}
+
+// ChangeUsernameHandler renames the account, following the same rules as
+// registration.
+func (app *App) ChangeUsernameHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		ErrorHandler(w, r, http.StatusMethodNotAllowed)
+		return
+	}
+
+	user, ok := app.settingsUser(w, r)
+	if !ok {
+		return
+	}
+	username := strings.TrimSpace(r.FormValue("username"))
+	if username == user.Username {
+		http.Redirect(w, r, "/settings#username", http.StatusSeeOther)
+		return
+	}
+
+	err := utils.ValidateUsername(app.Users, username)
+	if err == nil {
+		err = app.Users.UpdateUsername(user.ID, username)
+	}
+	if err != nil {
+		app.settingsError(w, r, "username", err)
+		return
+	}
+
+	log.Printf("User %d renamed from %s to %s", user.ID, user.Username, username)
+	http.Redirect(w, r, "/settings?saved=username#username", http.StatusSeeOther)
+}
+
+// ChangeEmailHandler moves the account to a new address after checking the
+// password. The new address must be verified again.
+func (app *App) ChangeEmailHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		ErrorHandler(w, r, http.StatusMethodNotAllowed)
+		return
+	}
+
+	user, ok := app.settingsUser(w, r)
+	if !ok {
+		return
+	}
+	email := strings.TrimSpace(r.FormValue("email"))
+	if email == user.Email {
+		http.Redirect(w, r, "/settings#email", http.StatusSeeOther)
+		return
+	}
+
+	err := app.Accounts.CheckPassword(user.ID, r.FormValue("password"))
+	if err == nil {
+		err = utils.ValidateEmail(app.Users, email)
+	}
+	if err == nil {
+		err = app.Users.UpdateEmail(user.ID, email)
+	}
+	if err != nil {
+		app.settingsError(w, r, "email", err)
+		return
+	}
+
+	log.Printf("User %d changed their email address", user.ID)
+	user.Email = email
+	if err := app.sendVerification(user); err != nil {
+		log.Printf("Error sending verification email: %v", err)
+	}
+	http.Redirect(w, r, "/settings?saved=email#email", http.StatusSeeOther)
+}
+
+// ChangePasswordHandler sets a new password after checking the current
+// one, and signs the account out on its other devices.
+func (app *App) ChangePasswordHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		ErrorHandler(w, r, http.StatusMethodNotAllowed)
+		return
+	}
+
+	user, ok := app.settingsUser(w, r)
+	if !ok {
+		return
+	}
+	password := r.FormValue("password")
+	if password != r.FormValue("confirm") {
+		app.settingsInvalid(w, r, "password", "the new passwords do not match")
+		return
+	}
+	if err := utils.ValidatePassword(password); err != nil {
+		app.settingsInvalid(w, r, "password", err.Error())
+		return
+	}
+	if err := app.Accounts.ChangePassword(user.ID, r.FormValue("current_password"), password); err != nil {
+		app.settingsError(w, r, "password", err)
+		return
+	}
+
+	current := ""
+	if cookie, err := r.Cookie("session_token"); err == nil {
+		current = cookie.Value
+	}
+	if err := app.Sessions.EndOtherSessions(user.ID, current); err != nil {
+		log.Printf("Error ending other sessions: %v", err)
+	}
+
+	log.Printf("User %d changed their password", user.ID)
+	http.Redirect(w, r, "/settings?saved=password#password", http.StatusSeeOther)
+}
+
+// DeleteAccountHandler closes the account after checking the password.
+// The user chooses whether their posts and comments stay up without their
+// name or are removed with the account.
+func (app *App) DeleteAccountHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		ErrorHandler(w, r, http.StatusMethodNotAllowed)
+		return
+	}
+
+	user, ok := app.settingsUser(w, r)
+	if !ok {
+		return
+	}
+	content := r.FormValue("content")
+	if content != DeleteKeepContent && content != DeleteRemoveContent {
+		app.settingsInvalid(w, r, "delete", "choose what happens to your posts and comments")
+		return
+	}
+	if err := app.Accounts.CheckPassword(user.ID, r.FormValue("password")); err != nil {
+		app.settingsError(w, r, "delete", err)
+		return
+	}
+
+	if err := app.Users.DeleteAccount(user.ID, content == DeleteRemoveContent); err != nil {
+		log.Printf("Error deleting account: %v", err)
+		ErrorHandler(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("User %d (%s) deleted their account, content: %s", user.ID, user.Username, content)
+	app.clearSessionCookie(w)
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// -- Non-Global Functions : Only happens in this package -- //
+
+// settingsUser loads the signed-in user that RequireRole put in the
+// context.
+func (app *App) settingsUser(w http.ResponseWriter, r *http.Request) (*model.User, bool) {
+	userID, _ := r.Context().Value("user_id").(int)
+	user, err := app.Users.FetchUserById(userID)
+	if err != nil {
+		log.Printf("Error fetching user data: %v", err)
+		ErrorHandler(w, r, http.StatusInternalServerError)
+		return nil, false
+	}
+	return user, true
+}
+
+// settingsError shows the settings page again with err next to form.
+// Validation problems and a wrong password are the user's to fix; anything
+// else is a server error.
+func (app *App) settingsError(w http.ResponseWriter, r *http.Request, form string, err error) {
+	var validation utils.ValidationError
+	switch {
+	case errors.As(err, &validation):
+		app.settingsInvalid(w, r, form, validation.Message)
+	case errors.Is(err, auth.ErrInvalidCredentials):
+		app.settingsInvalid(w, r, form, "your password is wrong")
+	case errors.Is(err, auth.ErrInvalidCode):
+		app.settingsInvalid(w, r, form, "that code is not right: check that your phone's clock is correct and try the next one")
+	case errors.Is(err, database.ErrUsernameTaken), errors.Is(err, database.ErrEmailTaken):
+		app.settingsInvalid(w, r, form, err.Error())
+	default:
+		log.Printf("Error updating %s: %v", form, err)
+		ErrorHandler(w, r, http.StatusInternalServerError)
+	}
+}
+
+func (app *App) settingsInvalid(w http.ResponseWriter, r *http.Request, form, message string) {
+	app.renderSettings(w, r, http.StatusBadRequest, settingsPage{Form: form, Error: message})
+}
+
+func (app *App) renderSettings(w http.ResponseWriter, r *http.Request, status int, page settingsPage) {
+	user, ok := app.settingsUser(w, r)
+	if !ok {
+		return
+	}
+	page.Title = "Settings"
+	page.User = user
+	page.IsLoggedIn = true
+
+	identities, err := app.Accounts.FetchIdentities(user.ID)
+	if err != nil {
+		log.Printf("Error fetching identities: %v", err)
+		ErrorHandler(w, r, http.StatusInternalServerError)
+		return
+	}
+	page.Connections = app.connections(identities)
+
+	page.TwoFactor, err = app.Accounts.FetchTwoFactor(user.ID)
+	if err != nil {
+		log.Printf("Error fetching two-factor state: %v", err)
+		ErrorHandler(w, r, http.StatusInternalServerError)
+		return
+	}
+	page.TwoFactorRequired = app.TwoFactorRole != "" && user.HasRole(app.TwoFactorRole)
+	if secret := page.TwoFactor.PendingSecret; secret != "" {
+		uri := auth.TOTPURI(twoFactorIssuer, user.Username, secret)
+		page.SetupURI = template.URL(uri)
+		if page.SetupQR, err = qrDataURL(uri); err != nil {
+			log.Printf("Error drawing QR code: %v", err)
+		}
+	}
+
+	w.WriteHeader(status)
+	if err := app.Templates.ExecuteTemplate(w, r, "settings.html", page); err != nil {
+		log.Printf("Error executing template: %v", err)
+	}
+}
+
+// connections pairs every configured provider with the user's account
+// there. Accounts at providers that are no longer configured are listed
+// too, so they can be disconnected.
+func (app *App) connections(identities []model.LinkedIdentity) []connection {
+	linked := make(map[string]*model.LinkedIdentity, len(identities))
+	for i := range identities {
+		linked[identities[i].Provider] = &identities[i]
+	}
+
+	var list []connection
+	for _, p := range app.Providers.List() {
+		list = append(list, connection{ID: p.ID(), Label: p.Label(), Linked: linked[p.ID()]})
+		delete(linked, p.ID())
+	}
+	for _, li := range identities {
+		if linked[li.Provider] != nil {
+			list = append(list, connection{ID: li.Provider, Label: li.Provider, Linked: linked[li.Provider]})
+		}
+	}
+	return list
+}
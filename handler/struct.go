@@ -1,8 +1,11 @@
 package handler
 
 import (
-	"database/sql"
-	"text/template"
+	"forum-go/auth"
+	"forum-go/database"
+	"forum-go/live"
+	"forum-go/mail"
+	"forum-go/render"
 )
 
 type Text struct {
@@ -10,6 +13,71 @@ type Text struct {
 	ErrorMes string
 }
 
-var Templates *template.Template
+// App holds what the handlers share: the stores they read and write
+// through, and the page templates. The handlers are its methods.
+type App struct {
+	Posts    database.PostStore
+	Comments database.CommentStore
+	Users    database.UserStore
+	Sessions database.SessionStore
+	Votes    database.VoteStore
 
-var DB *sql.DB
+	Notifications database.NotificationStore
+	Moderation    database.ModerationStore
+	Reports       database.ReportStore
+	Messages      database.MessageStore
+	// Accounts checks passwords and keeps tokens, linked providers,
+	// two-factor secrets and login attempts
+	Accounts  auth.AccountStore
+	Templates *render.Templates
+	// Logins throttles the login forms of the site and the API
+	Logins *auth.Limiter
+	// Providers are the services offered for sign-in
+	Providers auth.Providers
+	// TwoFactorRole is the lowest role that has to turn on two-factor
+	// login before using its powers. Empty forces no one.
+	TwoFactorRole string
+	// Mailer sends the password reset and verification emails
+	Mailer mail.Mailer
+	// BaseURL is the public address of the forum, such as
+	// https://forum.example.com, used for links in emails and for OAuth
+	// callbacks. Links are never built from the request's Host header,
+	// which the client chooses.
+	BaseURL string
+	// MaxCommentDepth caps how deeply replies are nested, on the post page
+	// and in the API
+	MaxCommentDepth int
+	// PostEvents carries new comments and vote counts to the viewers of
+	// a post, keyed by post ID
+	PostEvents *live.Hub
+	// MessageEvents delivers private messages to the open connections of
+	// their sender and recipient, keyed by user ID. Clients catch up on
+	// reconnect by asking for messages after the last one they have, so
+	// nothing is replayed.
+	MessageEvents *live.Hub
+}
+
+// NewApp returns an App whose stores are all backed by store, SQLite or
+// PostgreSQL, and whose accounts are kept by accounts. Emails are only
+// logged and no sign-in providers are offered until the caller sets them.
+func NewApp(store database.Stores, accounts auth.AccountStore, templates *render.Templates) *App {
+	return &App{
+		Posts:           store,
+		Comments:        store,
+		Users:           store,
+		Sessions:        store,
+		Votes:           store,
+		Notifications:   store,
+		Moderation:      store,
+		Reports:         store,
+		Messages:        store,
+		Accounts:        accounts,
+		Templates:       templates,
+		Logins:          auth.NewLimiter(auth.DefaultLockoutPolicy),
+		Providers:       auth.Providers{},
+		Mailer:          &mail.LogMailer{},
+		MaxCommentDepth: database.DefaultMaxCommentDepth,
+		PostEvents:      live.NewHub(100, live.DefaultReplayTTL),
+		MessageEvents:   live.NewHub(0, live.DefaultReplayTTL),
+	}
+}
@@ -1,11 +1,16 @@
 package handler
 
 import (
+	"database/sql"
+	"errors"
+	"fmt"
 	"forum-go/database"
+	"log"
 	"net/http"
+	"strconv"
 )
 
-func SubmitCommentHandler(w http.ResponseWriter, r *http.Request) {
+func (app *App) SubmitCommentHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		ErrorHandler(w, r, http.StatusMethodNotAllowed)
 		return
@@ -17,19 +22,48 @@ func SubmitCommentHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	postID := r.FormValue("post_id")
+	postID, err := strconv.Atoi(r.FormValue("post_id"))
 	content := r.FormValue("content")
 
-	if postID == "" || content == "" {
+	if err != nil || content == "" {
 		ErrorHandler(w, r, http.StatusBadRequest)
 		return
 	}
 
-	_, err := database.DB.Exec("INSERT INTO comments (post_id, user_id, content) VALUES (?, ?, ?)", postID, userID, content)
+	// parent_id is only sent by the reply forms
+	parentID := 0
+	if raw := r.FormValue("parent_id"); raw != "" {
+		parentID, err = strconv.Atoi(raw)
+		if err != nil {
+			ErrorHandler(w, r, http.StatusBadRequest)
+			return
+		}
+	}
+
+	commentID, err := app.Comments.CreateComment(postID, userID, parentID, content)
 	if err != nil {
+		if errors.Is(err, database.ErrParentNotFound) {
+			ErrorHandler(w, r, http.StatusBadRequest)
+			return
+		}
+		if errors.Is(err, sql.ErrNoRows) {
+			ErrorHandler(w, r, http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, database.ErrPostLocked) {
+			ErrorHandler(w, r, http.StatusForbidden)
+			return
+		}
+		log.Printf("Error creating comment: %v", err)
 		ErrorHandler(w, r, http.StatusInternalServerError)
 		return
 	}
 
-	http.Redirect(w, r, "/viewpost?id="+postID, http.StatusSeeOther)
+	app.PublishComment(postID, commentID, parentID)
+	// A failed notification should not lose the comment
+	if err := app.Notifications.NotifyNewComment(int(commentID)); err != nil {
+		log.Printf("Error sending comment notifications: %v", err)
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/viewpost?id=%d#comment-%d", postID, commentID), http.StatusSeeOther)
 }
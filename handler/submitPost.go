@@ -2,11 +2,13 @@ package handler
 
 import (
 	"fmt"
+	"forum-go/model"
 	"log"
 	"net/http"
+	"time"
 )
 
-func SubmitPostHandler(w http.ResponseWriter, r *http.Request) {
+func (app *App) SubmitPostHandler(w http.ResponseWriter, r *http.Request) {
 
 	if r.Method != http.MethodPost {
 		ErrorHandler(w, r, http.StatusMethodNotAllowed)
@@ -25,7 +27,7 @@ func SubmitPostHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Validate Categories
 
-	if len(categories) > 3 || len(categories) > 0 {
+	if len(categories) == 0 || len(categories) > 3 {
 		ErrorHandler(w, r, http.StatusBadRequest)
 		return
 	}
@@ -37,30 +39,32 @@ func SubmitPostHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	result, err := DB.Exec("INSERT INTO posts (user_id, title, content) VALUES (?, ?, ?)", userID, title, content)
+	known, err := app.Posts.FetchCategories()
 	if err != nil {
-		log.Printf("Error creating post: %v", err)
+		log.Printf("Error fetching categories: %v", err)
 		ErrorHandler(w, r, http.StatusInternalServerError)
 		return
 	}
+	selected, ok := selectCategories(known, categories)
+	if !ok {
+		ErrorHandler(w, r, http.StatusBadRequest)
+		return
+	}
 
-	// Get the last inserted post ID
-	postID, err := result.LastInsertId()
+	postID, err := app.Posts.CreatePost(&model.Post{
+		Title:      title,
+		Content:    content,
+		UserID:     userID,
+		Categories: selected,
+		CreatedAt:  time.Now(),
+		UpdatedAt:  time.Now(),
+	})
 	if err != nil {
-		log.Printf("Error getting last insert ID: %v", err)
+		log.Printf("Error creating post: %v", err)
 		ErrorHandler(w, r, http.StatusInternalServerError)
 		return
 	}
 
-	// Insert each selected category into a junction table (e.g., post_categories)
-	for _, category := range categories {
-		_, err := DB.Exec("INSERT INTO post_categories (post_id, category_id) VALUES (?, ?)", postID, category)
-		if err != nil {
-			log.Printf("Error linking category %s to post: %v", category, err)
-			ErrorHandler(w, r, http.StatusInternalServerError)
-			return
-		}
-	}
 	redirectURL := fmt.Sprintf("/viewpost?id=%d", postID)
 
 	http.Redirect(w, r, redirectURL, http.StatusSeeOther)
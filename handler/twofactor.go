@@ -0,0 +1,248 @@
+package handler
+
+import (
+	"encoding/base64"
+	"errors"
+	"forum-go/auth"
+	"forum-go/model"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"rsc.io/qr"
+)
+
+// twoFactorCookie carries a login that passed the password check to the
+// second step. Its value is "1." or "0." for the remember-me choice,
+// followed by the login token.
+const twoFactorCookie = "two_factor"
+
+// twoFactorIssuer names the forum in authenticator apps.
+const twoFactorIssuer = "Reel Movie Talk"
+
+// TwoFactorLoginHandler asks for the code from the user's app, or a
+// recovery code, and finishes the login that the password started. Wrong
+// codes count as failed logins.
+func (app *App) TwoFactorLoginHandler(w http.ResponseWriter, r *http.Request) {
+	remember, token := pendingLogin(r)
+	userID, err := app.Accounts.CheckToken(token, auth.PurposeTwoFactorLogin)
+	if err != nil {
+		if !errors.Is(err, auth.ErrInvalidToken) {
+			log.Printf("Error checking login token: %v", err)
+		}
+		clearTwoFactorCookie(w)
+		app.renderAccountPage(w, r, http.StatusBadRequest, accountPage{Title: "Two-factor login", Page: "two-factor-expired"})
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		app.renderAccountPage(w, r, http.StatusOK, accountPage{Title: "Two-factor login", Page: "two-factor"})
+
+	case http.MethodPost:
+		user, err := app.Users.FetchUserById(userID)
+		if err != nil {
+			log.Printf("Error retrieving user info: %v", err)
+			ErrorHandler(w, r, http.StatusInternalServerError)
+			return
+		}
+		retry := accountPage{Title: "Two-factor login", Page: "two-factor"}
+
+		ip := auth.ClientIP(r)
+		err = app.Logins.SecondFactor(app.Accounts, user, ip, r.FormValue("code"))
+		var locked *auth.LockedError
+		switch {
+		case errors.As(err, &locked):
+			log.Printf("Two-factor login refused for %s from %s: %v", user.Username, ip, err)
+			w.Header().Set("Retry-After", strconv.Itoa(locked.Seconds()))
+			retry.Error = locked.Error()
+			app.renderAccountPage(w, r, http.StatusTooManyRequests, retry)
+			return
+		case errors.Is(err, auth.ErrInvalidCode):
+			log.Printf("Two-factor login failed for %s at %s", user.Username, time.Now().Format("2006-01-02 15:04:05"))
+			retry.Error = "That code is not right. Try the next one from your app, or a recovery code."
+			app.renderAccountPage(w, r, http.StatusUnauthorized, retry)
+			return
+		case err != nil:
+			log.Printf("Error checking two-factor code: %v", err)
+			ErrorHandler(w, r, http.StatusInternalServerError)
+			return
+		}
+
+		// Another tab may have finished this login first
+		if _, err := app.Accounts.UseToken(token, auth.PurposeTwoFactorLogin); err != nil {
+			clearTwoFactorCookie(w)
+			app.renderAccountPage(w, r, http.StatusBadRequest, accountPage{Title: "Two-factor login", Page: "two-factor-expired"})
+			return
+		}
+		clearTwoFactorCookie(w)
+		if err := app.Sessions.CreateSession(w, user.ID, r.UserAgent(), ip, remember); err != nil {
+			log.Printf("Error creating session: %v", err)
+			ErrorHandler(w, r, http.StatusInternalServerError)
+			return
+		}
+
+		log.Printf("Login successful: User '%s' passed two-factor login", user.Username)
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+
+	default:
+		ErrorHandler(w, r, http.StatusMethodNotAllowed)
+	}
+}
+
+// SetupTwoFactorHandler makes a new secret for the user to add to their
+// authenticator app. The settings page shows it until a code confirms it.
+func (app *App) SetupTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		ErrorHandler(w, r, http.StatusMethodNotAllowed)
+		return
+	}
+
+	user, ok := app.settingsUser(w, r)
+	if !ok {
+		return
+	}
+	if _, err := app.Accounts.BeginTwoFactor(user.ID); err != nil && !errors.Is(err, auth.ErrTwoFactorEnabled) {
+		log.Printf("Error starting two-factor setup: %v", err)
+		ErrorHandler(w, r, http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/settings#two-factor", http.StatusSeeOther)
+}
+
+// EnableTwoFactorHandler turns on two-factor login once the user enters a
+// code from the new secret, and shows their recovery codes.
+func (app *App) EnableTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		ErrorHandler(w, r, http.StatusMethodNotAllowed)
+		return
+	}
+
+	user, ok := app.settingsUser(w, r)
+	if !ok {
+		return
+	}
+	codes, err := app.Accounts.EnableTwoFactor(user.ID, strings.TrimSpace(r.FormValue("code")))
+	switch {
+	case errors.Is(err, auth.ErrTwoFactorEnabled), errors.Is(err, auth.ErrTwoFactorDisabled):
+		http.Redirect(w, r, "/settings#two-factor", http.StatusSeeOther)
+		return
+	case err != nil:
+		app.settingsError(w, r, "two-factor", err)
+		return
+	}
+
+	log.Printf("User %d turned on two-factor login", user.ID)
+	app.renderSettings(w, r, http.StatusOK, settingsPage{Saved: "two-factor", RecoveryCodes: codes})
+}
+
+// DisableTwoFactorHandler turns two-factor login off after checking the
+// password. Users whose role requires it cannot.
+func (app *App) DisableTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		ErrorHandler(w, r, http.StatusMethodNotAllowed)
+		return
+	}
+
+	user, ok := app.settingsUser(w, r)
+	if !ok {
+		return
+	}
+	if app.TwoFactorRole != "" && user.HasRole(app.TwoFactorRole) {
+		app.settingsInvalid(w, r, "two-factor", "your role requires two-factor login")
+		return
+	}
+	if err := app.Accounts.CheckPassword(user.ID, r.FormValue("password")); err != nil {
+		app.settingsError(w, r, "two-factor", err)
+		return
+	}
+	if err := app.Accounts.DisableTwoFactor(user.ID); err != nil && !errors.Is(err, auth.ErrTwoFactorDisabled) {
+		log.Printf("Error turning off two-factor login: %v", err)
+		ErrorHandler(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("User %d turned off two-factor login", user.ID)
+	http.Redirect(w, r, "/settings?saved=two-factor-off#two-factor", http.StatusSeeOther)
+}
+
+// RecoveryCodesHandler replaces the user's recovery codes after checking
+// the password, and shows the new ones.
+func (app *App) RecoveryCodesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		ErrorHandler(w, r, http.StatusMethodNotAllowed)
+		return
+	}
+
+	user, ok := app.settingsUser(w, r)
+	if !ok {
+		return
+	}
+	if err := app.Accounts.CheckPassword(user.ID, r.FormValue("password")); err != nil {
+		app.settingsError(w, r, "recovery", err)
+		return
+	}
+	codes, err := app.Accounts.NewRecoveryCodes(user.ID)
+	if errors.Is(err, auth.ErrTwoFactorDisabled) {
+		http.Redirect(w, r, "/settings#two-factor", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		log.Printf("Error making recovery codes: %v", err)
+		ErrorHandler(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("User %d made new recovery codes", user.ID)
+	app.renderSettings(w, r, http.StatusOK, settingsPage{Saved: "recovery", RecoveryCodes: codes})
+}
+
+// -- Non-Global Functions : Only happens in this package -- //
+
+// startTwoFactorLogin holds back the session of a user who passed the
+// password check until they pass the second step at /login/two-factor.
+func (app *App) startTwoFactorLogin(w http.ResponseWriter, user *model.User, remember bool) error {
+	token, err := app.Accounts.IssueToken(user.ID, auth.PurposeTwoFactorLogin, user.Email, auth.TwoFactorLoginTTL)
+	if err != nil {
+		return err
+	}
+	flag := "0."
+	if remember {
+		flag = "1."
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     twoFactorCookie,
+		Value:    flag + token,
+		Path:     "/login/",
+		MaxAge:   int(auth.TwoFactorLoginTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   app.Sessions.SessionOptions().Secure,
+		// Lax, so logins that come back from an OAuth provider keep it
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+// pendingLogin reads the cookie set by startTwoFactorLogin.
+func pendingLogin(r *http.Request) (remember bool, token string) {
+	cookie, err := r.Cookie(twoFactorCookie)
+	flag, token, _ := strings.Cut(valueOf(cookie, err), ".")
+	return flag == "1", token
+}
+
+func clearTwoFactorCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{Name: twoFactorCookie, Value: "", Path: "/login/", MaxAge: -1, HttpOnly: true})
+}
+
+// qrDataURL draws text as a QR code in a data: URL for an <img>.
+func qrDataURL(text string) (template.URL, error) {
+	code, err := qr.Encode(text, qr.M)
+	if err != nil {
+		return "", err
+	}
+	code.Scale = 5
+	return template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(code.PNG())), nil
+}
@@ -3,9 +3,7 @@ package handler
 import (
 	"database/sql"
 	"fmt"
-	"forum-go/database"
 	"forum-go/model"
-	"forum-go/render"
 	"time"
 
 	"log"
@@ -13,7 +11,7 @@ import (
 	"strconv"
 )
 
-func ViewPostHandler(w http.ResponseWriter, r *http.Request) {
+func (app *App) ViewPostHandler(w http.ResponseWriter, r *http.Request) {
 
 	if r.URL.Path != "/viewpost" {
 		ErrorHandler(w, r, http.StatusNotFound)
@@ -35,7 +33,7 @@ func ViewPostHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Fetch the post
-	post, err := database.FetchPostByID(postID)
+	post, err := app.Posts.FetchPostByID(postID)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			ErrorHandler(w, r, http.StatusNotFound)
@@ -46,40 +44,61 @@ func ViewPostHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Fetch comments for the post
-	comments, err := database.FetchCommentsByPostID(postID)
-	if err != nil {
-		log.Printf("Error fetching comments: %v", err)
-		// Decide how to handle this error (continue without comments or return an error)
-	}
-
-	for i := range comments {
-		comments[i].TimeAgo = calculateTimeAgo(comments[i].CreatedAt)
-	}
-
-	isLoggedIn, userID := database.CheckUserLoggedIn(r)
+	isLoggedIn, userID := app.Sessions.CheckUserLoggedIn(r)
 	var user *model.User
 	if isLoggedIn {
-		user, err = database.FetchUserById(userID)
+		user, err = app.Users.FetchUserById(userID)
 		if err != nil {
 			log.Printf("Error fetching user data: %v", err)
 			// Continue without user data
 		}
 	}
+	canModerate := app.isModerator(user)
+
+	// Hidden posts stay reachable for moderators so they can be restored
+	if post.Hidden && !canModerate {
+		ErrorHandler(w, r, http.StatusNotFound)
+		return
+	}
+
+	// Fetch the comment threads for the post
+	comments, err := app.Comments.FetchCommentTree(postID, app.MaxCommentDepth)
+	if err != nil {
+		log.Printf("Error fetching comments: %v", err)
+		// Decide how to handle this error (continue without comments or return an error)
+	}
+
+	prepareComments(comments, commentViewer{
+		userID:      userID,
+		canModerate: canModerate,
+		canReply:    !post.Locked,
+	})
+
+	isAuthor := isLoggedIn && userID == post.UserID
 
 	data := struct {
 		*model.Post
-		IsLoggedIn bool
-		User       *model.User
-		Comments   []model.Comment
+		IsLoggedIn  bool
+		IsAuthor    bool
+		CanModerate bool
+		CanReport   bool
+		Reported    bool // the reader was just sent back here after filing a report
+		User        *model.User
+		Comments    []model.Comment
+		MaxDepth    int // live.js nests new replies the way the server does
 	}{
-		Post:       post,
-		IsLoggedIn: isLoggedIn,
-		User:       user,
-		Comments:   comments,
+		Post:        post,
+		IsLoggedIn:  isLoggedIn,
+		IsAuthor:    isAuthor,
+		CanModerate: canModerate,
+		CanReport:   isLoggedIn && !isAuthor && !post.Hidden,
+		Reported:    r.URL.Query().Get("reported") == "1",
+		User:        user,
+		Comments:    comments,
+		MaxDepth:    app.MaxCommentDepth,
 	}
 
-	err = render.Templates.ExecuteTemplate(w, "viewPost.html", data)
+	err = app.Templates.ExecuteTemplate(w, r, "viewPost.html", data)
 	if err != nil {
 		log.Printf("Error executing template: %v", err)
 		ErrorHandler(w, r, http.StatusInternalServerError)
@@ -87,6 +106,33 @@ func ViewPostHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// commentViewer describes who is looking at a comment tree. userID is 0
+// for guests.
+type commentViewer struct {
+	userID      int
+	canModerate bool
+	canReply    bool // false once the thread is locked
+}
+
+// prepareComments fills the display-only fields of a comment tree for the
+// viewer. Hidden comments are blanked like deleted ones unless the viewer
+// is a moderator.
+func prepareComments(comments []model.Comment, viewer commentViewer) {
+	for i := range comments {
+		c := &comments[i]
+		c.TimeAgo = calculateTimeAgo(c.CreatedAt)
+		if c.Hidden && !viewer.canModerate {
+			c.Content, c.Author = "", ""
+		}
+		live := !c.Deleted && !c.Hidden
+		c.CanEdit = viewer.userID != 0 && c.UserID == viewer.userID && live
+		c.CanReply = viewer.canReply && live
+		c.CanModerate = viewer.canModerate && !c.Deleted
+		c.CanReport = viewer.userID != 0 && c.UserID != viewer.userID && live
+		prepareComments(c.Replies, viewer)
+	}
+}
+
 func calculateTimeAgo(t time.Time) string {
 	duration := time.Since(t)
 
@@ -2,8 +2,8 @@ package handler
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
-	"forum-go/database"
 	"log"
 	"net/http"
 	"strconv"
@@ -47,14 +47,14 @@ import (
 		http.Redirect(w, r, fmt.Sprintf("/viewpost?id=%d", postID), http.StatusSeeOther)
 	}
 */
-func VoteHandler(w http.ResponseWriter, r *http.Request) {
+func (app *App) VoteHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		ErrorHandler(w, r, http.StatusMethodNotAllowed)
 		return
 	}
 
 	// Check if user is logged in
-	isLoggedIn, userID := database.CheckUserLoggedIn(r)
+	isLoggedIn, userID := app.Sessions.CheckUserLoggedIn(r)
 	if !isLoggedIn {
 		ErrorHandler(w, r, http.StatusUnauthorized)
 		return
@@ -73,50 +73,37 @@ func VoteHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check if the user already voted on this post
-	var existingVote int
-	err = database.DB.QueryRow("SELECT vote FROM votes WHERE user_id = ? AND post_id = ?", userID, postID).Scan(&existingVote)
-
-	if err == sql.ErrNoRows {
-		// User hasn't voted yet → Insert new vote
-		_, err = database.DB.Exec("INSERT INTO votes (user_id, post_id, vote) VALUES (?, ?, ?)", userID, postID, voteValue)
-		if err != nil {
-			ErrorHandler(w, r, http.StatusInternalServerError)
+	result, err := app.Votes.TogglePostVote(userID, postID, voteValue)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			ErrorHandler(w, r, http.StatusNotFound)
 			return
 		}
-	} else if err == nil {
-		if existingVote == voteValue {
-			// User clicked the same button → Undo vote (delete it)
-			_, err = database.DB.Exec("DELETE FROM votes WHERE user_id = ? AND post_id = ?", userID, postID)
-			if err != nil {
-				ErrorHandler(w, r, http.StatusInternalServerError)
-				return
-			}
-		} else {
-			// User changed their vote → Update it
-			_, err = database.DB.Exec("UPDATE votes SET vote = ? WHERE user_id = ? AND post_id = ?", voteValue, userID, postID)
-			if err != nil {
-				ErrorHandler(w, r, http.StatusInternalServerError)
-				return
-			}
-		}
-	} else {
+		log.Printf("Error saving vote: %v", err)
 		ErrorHandler(w, r, http.StatusInternalServerError)
 		return
 	}
 
+	app.PublishVote(result, 0)
+	// Undoing a vote is not worth a notification
+	if result.Vote != 0 {
+		if err := app.Notifications.NotifyVote(userID, postID, 0, voteValue); err != nil {
+			log.Printf("Error sending vote notification: %v", err)
+		}
+	}
+
 	// Redirect back to the post
 	http.Redirect(w, r, fmt.Sprintf("/viewpost?id=%d", postID), http.StatusSeeOther)
 }
 
-func VoteCommentHandler(w http.ResponseWriter, r *http.Request) {
+func (app *App) VoteCommentHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		log.Println("Invalid method:", r.Method)
 		return
 	}
 
-	isLoggedIn, userID := database.CheckUserLoggedIn(r)
+	isLoggedIn, userID := app.Sessions.CheckUserLoggedIn(r)
 	if !isLoggedIn {
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		log.Println("Unauthorized user tried to vote.")
@@ -146,46 +133,23 @@ func VoteCommentHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check if the user already voted on this comment
-	var existingVote int
-	err = database.DB.QueryRow("SELECT vote FROM votes WHERE user_id = ? AND comment_id = ?", userID, commentID).Scan(&existingVote)
-
-	if err == sql.ErrNoRows {
-		// User hasn't voted yet → Insert new vote
-		_, err = database.DB.Exec("INSERT INTO votes (user_id, comment_id, vote) VALUES (?, ?, ?)", userID, commentID, voteValue)
-		if err != nil {
-			http.Error(w, "Error processing vote", http.StatusInternalServerError)
-			log.Println("Database error:", err)
+	result, err := app.Votes.ToggleCommentVote(userID, commentID, voteValue)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Comment not found", http.StatusNotFound)
 			return
 		}
-		//log.Printf("User %d voted %d on comment %d\n", userID, voteValue, commentID)
-
-	} else if err == nil {
-		if existingVote == voteValue {
-			// User clicked the same button → Undo vote (delete it)
-			_, err = database.DB.Exec("DELETE FROM votes WHERE user_id = ? AND comment_id = ?", userID, commentID)
-			if err != nil {
-				http.Error(w, "Error removing vote", http.StatusInternalServerError)
-				log.Println("Database error (removing vote):", err)
-				return
-			}
-			//log.Printf("User %d removed vote on comment %d\n", userID, commentID)
-
-		} else {
-			// User changed their vote → Update it
-			_, err = database.DB.Exec("UPDATE votes SET vote = ? WHERE user_id = ? AND comment_id = ?", voteValue, userID, commentID)
-			if err != nil {
-				http.Error(w, "Error updating vote", http.StatusInternalServerError)
-				log.Println("Database error (updating vote):", err)
-				return
-			}
-			//log.Printf("User %d changed vote to %d on comment %d\n", userID, voteValue, commentID)
-		}
-	} else {
-		http.Error(w, "Database error", http.StatusInternalServerError)
+		http.Error(w, "Error processing vote", http.StatusInternalServerError)
 		log.Println("Database error:", err)
 		return
 	}
 
+	app.PublishVote(result, commentID)
+	if result.Vote != 0 {
+		if err := app.Notifications.NotifyVote(userID, 0, commentID, voteValue); err != nil {
+			log.Println("Error sending vote notification:", err)
+		}
+	}
+
 	http.Redirect(w, r, r.Header.Get("Referer"), http.StatusSeeOther)
 }
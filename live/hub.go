@@ -0,0 +1,276 @@
+// Package live fans out events to open browser connections, so new
+// comments, vote counts and private messages show up without a reload.
+package live
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Event types. Comments and votes are published per post, messages per
+// user.
+const (
+	EventComment = "comment"
+	EventVote    = "vote"
+	EventMessage = "message"
+)
+
+// DefaultReplayTTL is how long a topic nobody is subscribed to keeps its
+// replay buffer after its last event.
+const DefaultReplayTTL = 10 * time.Minute
+
+// subscriberBuffer is how many events may queue up for one subscriber.
+// A subscriber that falls further behind is dropped and reconnects.
+const subscriberBuffer = 16
+
+// Event is one update on a topic. ID is unique within a Hub and is sent
+// to clients so they can resume with Last-Event-ID.
+type Event struct {
+	ID    string
+	Type  string
+	Topic int // post ID or user ID, depending on the hub
+	Data  interface{}
+}
+
+// NewComment is the Data of an EventComment.
+type NewComment struct {
+	CommentID int `json:"id"`
+	ParentID  int `json:"parent_id"`
+}
+
+// VoteUpdate is the Data of an EventVote. CommentID is 0 for a vote on the
+// post itself.
+type VoteUpdate struct {
+	CommentID int `json:"comment_id,omitempty"`
+	Upvotes   int `json:"upvotes"`
+	Downvotes int `json:"downvotes"`
+}
+
+// Hub is an in-process publish/subscribe hub with one topic per post or
+// user. It keeps the last few events of every topic so a client that lost
+// its connection can catch up. Topics without subscribers are reclaimed
+// once their last event is older than the replay TTL.
+type Hub struct {
+	mu     sync.Mutex
+	epoch  string // changes on every start, so IDs from a previous run are recognised
+	seq    uint64
+	replay int
+	ttl    time.Duration
+	swept  time.Time
+	topics map[int]*topic
+	closed bool
+}
+
+type topic struct {
+	subscribers map[*Subscription]struct{}
+	recent      []Event
+	evicted     uint64    // sequence number of the newest event dropped from recent
+	published   time.Time // when the last event was published
+}
+
+// Subscription receives the events of one post until it is closed.
+type Subscription struct {
+	// Events is closed when the subscription ends, either through Close or
+	// because the subscriber fell behind.
+	Events <-chan Event
+	// Missed holds the events published after the Last-Event-ID passed to
+	// Subscribe, oldest first.
+	Missed []Event
+	// Reset is set when the events after Last-Event-ID are no longer known,
+	// so the client has to load the page again instead of catching up.
+	Reset bool
+
+	hub     *Hub
+	topicID int
+	events  chan Event
+}
+
+// NewHub returns a hub that keeps the last replay events of each topic for
+// ttl after the topic's last event.
+func NewHub(replay int, ttl time.Duration) *Hub {
+	return &Hub{
+		epoch:  strconv.FormatInt(time.Now().UnixNano(), 36),
+		replay: replay,
+		ttl:    ttl,
+		swept:  time.Now(),
+		topics: make(map[int]*topic),
+	}
+}
+
+// Publish sends an event to every subscriber of a topic and returns it.
+func (h *Hub) Publish(topicID int, typ string, data interface{}) Event {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	now := time.Now()
+	h.sweep(now)
+	t := h.topic(topicID)
+
+	h.seq++
+	event := Event{
+		ID:    fmt.Sprintf("%s-%d", h.epoch, h.seq),
+		Type:  typ,
+		Topic: topicID,
+		Data:  data,
+	}
+
+	t.published = now
+	t.recent = append(t.recent, event)
+	if len(t.recent) > h.replay {
+		t.evicted = h.sequence(t.recent[0].ID)
+		t.recent = t.recent[1:]
+	}
+
+	for sub := range t.subscribers {
+		select {
+		case sub.events <- event:
+		default:
+			// Too slow to keep up; the client reconnects with its
+			// Last-Event-ID and catches up from the replay buffer
+			h.remove(sub)
+		}
+	}
+	return event
+}
+
+// Subscribe starts receiving the events of a topic. lastEventID is the ID of
+// the last event the client saw, or "" for a fresh connection.
+func (h *Hub) Subscribe(topicID int, lastEventID string) *Subscription {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	events := make(chan Event, subscriberBuffer)
+	sub := &Subscription{Events: events, hub: h, topicID: topicID, events: events}
+	if h.closed {
+		close(events)
+		return sub
+	}
+
+	h.sweep(time.Now())
+	t := h.topic(topicID)
+	if lastEventID != "" {
+		epoch, seq, ok := parseID(lastEventID)
+		switch {
+		case !ok || epoch != h.epoch || seq < t.evicted:
+			sub.Reset = true
+		default:
+			for _, e := range t.recent {
+				if h.sequence(e.ID) > seq {
+					sub.Missed = append(sub.Missed, e)
+				}
+			}
+		}
+	}
+
+	t.subscribers[sub] = struct{}{}
+	return sub
+}
+
+// Subscribers returns how many subscriptions topicID has.
+func (h *Hub) Subscribers(topicID int) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if t, ok := h.topics[topicID]; ok {
+		return len(t.subscribers)
+	}
+	return 0
+}
+
+// Topics returns how many topics the hub holds, with or without
+// subscribers.
+func (h *Hub) Topics() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.topics)
+}
+
+// Close ends every subscription, and makes new ones end straight away, so
+// open streams return when the server shuts down.
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for _, t := range h.topics {
+		for sub := range t.subscribers {
+			h.remove(sub)
+		}
+	}
+}
+
+// Close ends the subscription. It is safe to call more than once.
+func (s *Subscription) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	s.hub.remove(s)
+}
+
+// -- Non-Global Functions : Only happens in this package -- //
+
+// topic returns the topic of topicID, creating it if needed. h.mu is held.
+// A new topic may replace a reclaimed one, so any event issued before it
+// counts as evicted and older IDs reset.
+func (h *Hub) topic(topicID int) *topic {
+	t, ok := h.topics[topicID]
+	if !ok {
+		t = &topic{subscribers: make(map[*Subscription]struct{}), evicted: h.seq}
+		h.topics[topicID] = t
+	}
+	return t
+}
+
+// sweep reclaims the idle topics, at most once per TTL. h.mu is held.
+func (h *Hub) sweep(now time.Time) {
+	if now.Sub(h.swept) < h.ttl {
+		return
+	}
+	h.swept = now
+	for id, t := range h.topics {
+		if h.idle(t, now) {
+			delete(h.topics, id)
+		}
+	}
+}
+
+// idle reports whether t has no subscribers and nothing worth replaying.
+// h.mu is held.
+func (h *Hub) idle(t *topic, now time.Time) bool {
+	return len(t.subscribers) == 0 && now.Sub(t.published) >= h.ttl
+}
+
+// remove detaches sub and closes its channel. h.mu is held.
+func (h *Hub) remove(sub *Subscription) {
+	t, ok := h.topics[sub.topicID]
+	if !ok {
+		return
+	}
+	if _, ok := t.subscribers[sub]; !ok {
+		return
+	}
+	delete(t.subscribers, sub)
+	close(sub.events)
+	if h.idle(t, time.Now()) {
+		delete(h.topics, sub.topicID)
+	}
+}
+
+// sequence returns the sequence number of an ID issued by this hub.
+func (h *Hub) sequence(id string) uint64 {
+	_, seq, _ := parseID(id)
+	return seq
+}
+
+// parseID splits an event ID into its epoch and sequence number.
+func parseID(id string) (string, uint64, bool) {
+	epoch, seq, found := strings.Cut(id, "-")
+	if !found {
+		return "", 0, false
+	}
+	n, err := strconv.ParseUint(seq, 10, 64)
+	if err != nil {
+		return "", 0, false
+	}
+	return epoch, n, true
+}
@@ -0,0 +1,111 @@
+// Package mail sends the forum's emails: password resets and address
+// verification. The server hands the handlers an SMTPMailer in production
+// and a LogMailer during development.
+package mail
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Message is one plain-text email.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers messages.
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// defaultFrom is the sender when none is configured.
+const defaultFrom = "forum@localhost"
+
+// SMTPMailer sends through an SMTP server. Username and Password are
+// optional; when set, they are sent with PLAIN auth, which net/smtp only
+// allows over TLS or to localhost.
+type SMTPMailer struct {
+	Addr     string // host:port
+	From     string
+	Username string
+	Password string
+}
+
+func (m *SMTPMailer) Send(msg Message) error {
+	var a smtp.Auth
+	if m.Username != "" {
+		host, _, err := net.SplitHostPort(m.Addr)
+		if err != nil {
+			return fmt.Errorf("error parsing SMTP address: %w", err)
+		}
+		a = smtp.PlainAuth("", m.Username, m.Password, host)
+	}
+	from := m.From
+	if from == "" {
+		from = defaultFrom
+	}
+	if err := smtp.SendMail(m.Addr, a, from, []string{msg.To}, format(from, msg)); err != nil {
+		return fmt.Errorf("error sending mail to %s: %w", msg.To, err)
+	}
+	return nil
+}
+
+// LogMailer does not deliver anything: it writes each message to the log,
+// and to a file in Dir when Dir is set, so links can be followed in
+// development and read back in tests.
+type LogMailer struct {
+	Dir  string
+	From string
+
+	mu    sync.Mutex
+	count int
+}
+
+func (m *LogMailer) Send(msg Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.count++
+	if m.Dir == "" {
+		log.Printf("Mail to %s: %s\n%s", msg.To, msg.Subject, msg.Body)
+		return nil
+	}
+
+	name := fmt.Sprintf("%s-%03d.eml", time.Now().UTC().Format("20060102-150405"), m.count)
+	path := filepath.Join(m.Dir, name)
+	if err := os.WriteFile(path, format(m.From, msg), 0o600); err != nil {
+		return fmt.Errorf("error writing mail: %w", err)
+	}
+	log.Printf("Mail to %s saved in %s", msg.To, path)
+	return nil
+}
+
+// -- Non-Global Functions : Only happens in this package -- //
+
+// format renders msg with the headers an SMTP server expects.
+func format(from string, msg Message) []byte {
+	if from == "" {
+		from = defaultFrom
+	}
+	var b strings.Builder
+	// Line breaks in header values would start new headers
+	header := strings.NewReplacer("\r", "", "\n", "")
+	fmt.Fprintf(&b, "From: %s\r\n", header.Replace(from))
+	fmt.Fprintf(&b, "To: %s\r\n", header.Replace(msg.To))
+	fmt.Fprintf(&b, "Subject: %s\r\n", header.Replace(msg.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	return []byte(b.String())
+}
@@ -1,24 +1,173 @@
 package main
 
 import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"forum-go/auth"
+	"forum-go/config"
 	"forum-go/database"
+	"forum-go/database/postgres"
 	"forum-go/server"
 	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
 
 	_ "github.com/mattn/go-sqlite3"
 )
 
 func main() {
 
-	err := database.InitDB()
+	cfg, args, err := config.Load(os.Args[1:], os.LookupEnv)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if len(args) > 0 && args[0] == "migrate" {
+		if err := runMigrate(cfg.Database, args[1:]); err != nil {
+			log.Fatalf("migrate: %v", err)
+		}
+		return
+	}
+	if len(args) > 0 && args[0] == "promote" {
+		if err := runPromote(cfg.Database, args[1:]); err != nil {
+			log.Fatalf("promote: %v", err)
+		}
+		return
+	}
+	if len(args) > 0 {
+		log.Fatalf("unknown command %q (want migrate, promote, or no command to serve)", args[0])
+	}
+
+	store, err := initStore(cfg.Database)
 	if err != nil {
 		log.Fatalf("Failed to initialize database: %v", err)
 	}
-	 if database.DB == nil {
-		log.Fatal("Database connection is nil after initialization")
-	 }
-	defer database.DB.Close()
+	// Emailed links survive restarts only with a fixed token key
+	accounts := auth.NewStore(store.DB(), cfg.Secrets.Token)
+	if cfg.Database.Driver == "postgres" {
+		accounts = auth.NewPostgresStore(store.DB(), cfg.Secrets.Token)
+	}
 
-	server.Startserver(database.DB)
+	// SIGINT (Ctrl-C) and SIGTERM (docker stop) shut the server down
+	// gracefully
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
+	serveErr := server.Startserver(ctx, cfg, store, accounts)
+	if err := store.Close(); err != nil {
+		log.Printf("Error closing database: %v", err)
+	}
+	if serveErr != nil {
+		log.Fatal(serveErr)
+	}
+}
+
+// backend is a database the forum runs on, SQLite or PostgreSQL.
+type backend interface {
+	database.Stores
+	DB() *sql.DB
+	Close() error
+	MigrateUp() error
+	MigrateDown(steps int) error
+	MigrationStatuses() ([]database.MigrationStatus, error)
+	PromoteAdmin(username string) error
+}
+
+// initStore opens the configured database, brings its schema up to date
+// and seeds it.
+func initStore(cfg config.Database) (backend, error) {
+	if cfg.Driver == "postgres" {
+		store, err := postgres.Open(cfg.URL)
+		if err != nil {
+			return nil, err
+		}
+		if err := store.Init(); err != nil {
+			store.Close()
+			return nil, err
+		}
+		return store, nil
+	}
+	return database.InitDB(cfg.Path)
+}
+
+// openStore opens the configured database without touching its schema.
+func openStore(cfg config.Database) (backend, error) {
+	if cfg.Driver == "postgres" {
+		return postgres.Open(cfg.URL)
+	}
+	return database.OpenDB(cfg.Path)
+}
+
+// runMigrate handles `migrate up`, `migrate down [steps]` and `migrate status`
+// against the configured database without starting the web server.
+func runMigrate(cfg config.Database, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: %s migrate up|down [steps]|status", os.Args[0])
+	}
+
+	store, err := openStore(cfg)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	switch args[0] {
+	case "up":
+		return store.MigrateUp()
+
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil {
+				return fmt.Errorf("invalid step count %q", args[1])
+			}
+			steps = n
+		}
+		return store.MigrateDown(steps)
+
+	case "status":
+		statuses, err := store.MigrationStatuses()
+		if err != nil {
+			return err
+		}
+		for _, s := range statuses {
+			state := "pending"
+			if s.Applied {
+				state = "applied " + s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%4d  %-40s %s\n", s.Version, s.Name, state)
+		}
+		return nil
+
+	default:
+		return fmt.Errorf("unknown migrate command %q (want up, down or status)", args[0])
+	}
+}
+
+// runPromote handles `promote <username>`, which makes a registered account
+// an administrator. No account is an admin until this is run.
+func runPromote(cfg config.Database, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: %s promote <username>", os.Args[0])
+	}
+
+	store, err := initStore(cfg)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	if err := store.PromoteAdmin(args[0]); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("no account named %q", args[0])
+		}
+		return err
+	}
+	fmt.Printf("%s is now an admin\n", args[0])
+	return nil
 }
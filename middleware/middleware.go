@@ -2,15 +2,32 @@ package middleware
 
 import (
 	"context"
-	"database/sql"
+	"forum-go/auth"
+	"forum-go/csrf"
 	"forum-go/database"
+	"forum-go/handler"
+	"forum-go/model"
+	"log"
 	"net/http"
-	"time"
+	"strings"
 )
 
-var DB *sql.DB
+// Middleware wraps handlers with the checks that need to know who is
+// signed in.
+type Middleware struct {
+	Users    database.UserStore
+	Sessions database.SessionStore
+	// TwoFactorRole is the lowest role kept from the staff tools until it
+	// turns on two-factor login. Empty forces no one.
+	TwoFactorRole string
+}
+
+// New returns the middleware for the given stores.
+func New(users database.UserStore, sessions database.SessionStore) *Middleware {
+	return &Middleware{Users: users, Sessions: sessions}
+}
 
-func SessionMiddleware(next http.HandlerFunc) http.HandlerFunc {
+func (m *Middleware) SessionMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		cookie, err := r.Cookie("session_token")
 		if err != nil {
@@ -18,16 +35,9 @@ func SessionMiddleware(next http.HandlerFunc) http.HandlerFunc {
 			return
 		}
 
-		var userID int
-		var expiresAt time.Time
-
-		err = database.DB.QueryRow("SELECT user_id, session_expiry FROM sessions WHERE session_token = ?", cookie.Value).Scan(&userID, &expiresAt)
-		if err != nil || time.Now().After(expiresAt) {
-			http.SetCookie(w, &http.Cookie{
-				Name:    "session_token",
-				Value:   "",
-				Expires: time.Now(),
-			})
+		ok, userID := m.Sessions.SessionUserID(cookie.Value)
+		if !ok {
+			http.SetCookie(w, m.Sessions.SessionOptions().ExpiredCookie())
 			next.ServeHTTP(w, r)
 			return
 		}
@@ -38,16 +48,122 @@ func SessionMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	}
 }
 
-func EnableCORS(next http.Handler) http.Handler {
+// SlidingSessions renews the session of each request that has one, so
+// that active users stay signed in, and sends the browser the cookie with
+// its new expiry.
+func (m *Middleware) SlidingSessions(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		if c, err := r.Cookie("session_token"); err == nil {
+			cookie, err := m.Sessions.RenewSession(c.Value)
+			if err != nil {
+				log.Printf("Error renewing session: %v", err)
+			} else if cookie != nil {
+				http.SetCookie(w, cookie)
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequireRole only lets a request through when the session user has at
+// least the given role and is not banned. Guests get 401, everyone else
+// without the role 403. Like SessionMiddleware it stores "user_id" in the
+// request context, plus the user's role as "user_role".
+func (m *Middleware) RequireRole(role string, next http.HandlerFunc) http.HandlerFunc {
+	return m.SessionMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("user_id").(int)
+		if !ok {
+			handler.ErrorHandler(w, r, http.StatusUnauthorized)
+			return
+		}
+
+		user, err := m.Users.FetchUserById(userID)
+		if err != nil {
+			log.Printf("Error fetching user for role check: %v", err)
+			handler.ErrorHandler(w, r, http.StatusInternalServerError)
+			return
+		}
+		if user.Banned() || !user.HasRole(role) {
+			handler.ErrorHandler(w, r, http.StatusForbidden)
+			return
+		}
+		// Staff tools stay closed until a required second factor is set up
+		if model.RoleAtLeast(role, model.RoleModerator) && auth.MustEnrollTwoFactor(m.TwoFactorRole, user) {
+			if r.Method == http.MethodGet {
+				http.Redirect(w, r, "/settings?saved=two-factor-required#two-factor", http.StatusSeeOther)
+				return
+			}
+			handler.ErrorHandler(w, r, http.StatusForbidden)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), "user_role", user.Role)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// EnableCORS lets pages on the allowed origins call the server, which the
+// API's bearer-token clients need. Other origins get no CORS headers, so
+// browsers keep them from reading responses or sending preflighted requests.
+func EnableCORS(allowed []string, next http.Handler) http.Handler {
+	origins := make(map[string]bool, len(allowed))
+	for _, origin := range allowed {
+		origins[strings.TrimRight(origin, "/")] = true
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Origin")
+		if origin := r.Header.Get("Origin"); origins[origin] {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		}
 
 		if r.Method == "OPTIONS" {
+			w.WriteHeader(http.StatusNoContent)
 			return
 		}
 
 		next.ServeHTTP(w, r)
 	})
 }
+
+// CSRF rejects state-changing requests that do not carry the anti-forgery
+// token of their cookies. Requests with an "Authorization: Bearer" header
+// are exempt, but their session cookie is dropped first, so they are
+// authenticated by the bearer token alone and a site that gets a browser
+// to send such a header gains nothing from the browser's cookies.
+func CSRF(guard *csrf.Guard, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bearer := strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if bearer {
+			r = withoutCookie(r, "session_token")
+		}
+		r = csrf.Seed(w, r)
+
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+		default:
+			if !bearer && !guard.Valid(r) {
+				log.Printf("Rejected %s %s: missing or invalid CSRF token", r.Method, r.URL.Path)
+				handler.ErrorHandler(w, r, http.StatusForbidden)
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// withoutCookie returns a copy of r without the named cookie.
+func withoutCookie(r *http.Request, name string) *http.Request {
+	cookies := r.Cookies()
+	r = r.Clone(r.Context())
+	r.Header.Del("Cookie")
+	for _, c := range cookies {
+		if c.Name != name {
+			r.AddCookie(c)
+		}
+	}
+	return r
+}
@@ -0,0 +1,139 @@
+package middleware
+
+import (
+	"forum-go/auth"
+	"forum-go/handler"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RatePolicy is a token bucket: a client may make Burst requests at once,
+// and gets one more every Refill.
+type RatePolicy struct {
+	Burst  int
+	Refill time.Duration
+}
+
+// rateSweepInterval is how often idle buckets are dropped.
+const rateSweepInterval = time.Minute
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// RateLimiter keeps one token bucket per client for a RatePolicy.
+type RateLimiter struct {
+	Policy RatePolicy
+	// Now is the clock; tests replace it to move time forward.
+	Now func() time.Time
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+// NewRateLimiter returns a RateLimiter using the wall clock.
+func NewRateLimiter(policy RatePolicy) *RateLimiter {
+	return &RateLimiter{
+		Policy:  policy,
+		Now:     time.Now,
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// Allow takes a token from key's bucket. When it is empty it returns false
+// and how long until the next token.
+func (l *RateLimiter) Allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.Now()
+	if now.Sub(l.lastSweep) >= rateSweepInterval {
+		l.sweep(now)
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(l.Policy.Burst), last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = l.refill(b, now)
+	b.last = now
+
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) * float64(l.Policy.Refill))
+		return false, wait
+	}
+	b.tokens--
+	return true, 0
+}
+
+// RateLimit lets a write through only when the client's bucket in l has a
+// token, and answers 429 otherwise. Reads are not limited.
+func (m *Middleware) RateLimit(l *RateLimiter, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key := m.RateKey(r)
+		if ok, wait := l.Allow(key); !ok {
+			log.Printf("Rate limited %s %s for %s", r.Method, r.URL.Path, key)
+			w.Header().Set("Retry-After", RetryAfter(wait))
+			handler.ErrorHandler(w, r, http.StatusTooManyRequests)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}
+}
+
+// RetryAfter formats a wait for the Retry-After header, in whole seconds.
+func RetryAfter(wait time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(wait.Seconds())))
+}
+
+// RateKey identifies the client of a request for rate limiting: the
+// signed-in user, by session cookie or bearer token, or else the IP address.
+func (m *Middleware) RateKey(r *http.Request) string {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if cookie, err := r.Cookie("session_token"); err == nil && token == "" {
+		token = cookie.Value
+	}
+	if token != "" {
+		if ok, userID := m.Sessions.SessionUserID(token); ok {
+			return "user:" + strconv.Itoa(userID)
+		}
+	}
+	return "ip:" + auth.ClientIP(r)
+}
+
+// -- Non-Global Functions : Only happens in this package -- //
+
+// refill returns the tokens of b at now, capped at the burst size.
+func (l *RateLimiter) refill(b *bucket, now time.Time) float64 {
+	tokens := b.tokens
+	if l.Policy.Refill > 0 {
+		tokens += float64(now.Sub(b.last)) / float64(l.Policy.Refill)
+	}
+	return math.Min(tokens, float64(l.Policy.Burst))
+}
+
+// sweep drops buckets that have filled up again; a new bucket starts full,
+// so forgetting them changes nothing.
+func (l *RateLimiter) sweep(now time.Time) {
+	for key, b := range l.buckets {
+		if l.refill(b, now) >= float64(l.Policy.Burst) {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastSweep = now
+}
@@ -1,19 +1,29 @@
 package model
 
 import (
-	"database/sql"
+	"strings"
 	"time"
 )
 
-var DB *sql.DB
-
 type HomePageData struct {
-	Posts      []Post
-	Categories []Category
-	User       *User
-	Success    bool
-	IsLoggedIn bool
+	Posts       []Post
+	Categories  []Category
+	User        *User
+	Success     bool
+	IsLoggedIn  bool
+	Category    string
+	SortOptions []SortOption
+	PrevPage    string // URL of the previous page, empty on the first page
+	NextPage    string // URL of the next page, empty on the last page
+}
+
+// SortOption is one entry of the post sort menu on the index page.
+type SortOption struct {
+	Label  string
+	URL    string
+	Active bool
 }
+
 //todo: why pointer to user not to others?
 
 type SubmitPostData struct {
@@ -31,49 +41,133 @@ type ProfileData struct {
 	Error      string
 }
 
-
 type Category struct {
 	ID    string
 	Name  string
 	Emoji string
 }
 
+// Roles in ascending order of power. Guests have no account, so only the
+// other three are ever stored on a user.
+const (
+	RoleGuest     = "guest"
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+var roleRanks = map[string]int{
+	RoleGuest:     0,
+	RoleUser:      1,
+	RoleModerator: 2,
+	RoleAdmin:     3,
+}
+
+// ValidRole reports whether role can be assigned to an account.
+func ValidRole(role string) bool {
+	return role == RoleUser || role == RoleModerator || role == RoleAdmin
+}
+
+// RoleAtLeast reports whether role grants everything min does.
+func RoleAtLeast(role, min string) bool {
+	have, ok := roleRanks[role]
+	return ok && have >= roleRanks[min]
+}
+
 type User struct {
-	ID            int
-	Username      string
-	Email         string
-	Password      string
-	SessionToken  sql.NullString
-	SessionExpiry sql.NullTime
-	CreatedAt     time.Time
+	ID          int
+	Username    string
+	Email       string
+	Password    string
+	Role        string
+	BannedAt    time.Time // zero unless the account is banned
+	CreatedAt   time.Time
+	UnreadCount int // unread notifications, filled by database.FetchUserById
+	// UnreadMessages counts unread private messages, filled by
+	// database.FetchUserById
+	UnreadMessages int
+	// EmailVerifiedAt is when the user proved they own Email; zero until
+	// then
+	EmailVerifiedAt time.Time
+	// TwoFactorAt is when the user turned on two-factor login; zero while
+	// it is off
+	TwoFactorAt time.Time
+}
+
+// HasRole reports whether the user has at least the given role. A nil user
+// is a guest.
+func (u *User) HasRole(role string) bool {
+	if u == nil {
+		return RoleAtLeast(RoleGuest, role)
+	}
+	return RoleAtLeast(u.Role, role)
+}
+
+// Banned reports whether the account is banned.
+func (u *User) Banned() bool {
+	return u != nil && !u.BannedAt.IsZero()
 }
-// todo: why nullstring and nulltime?
 
 type Post struct {
-	ID         int
-	Author     string // Added field
-	Title      string
-	Content    string
-	UserID     int    // Fixed casing
-	Categories string // Added field
-	CreatedAt  time.Time
-	UpdatedAt  time.Time
-	Upvotes    int
-	Downvotes  int
-	Comments   []Comment
+	ID           int
+	Author       string // Added field
+	Title        string
+	Content      string
+	UserID       int        // Fixed casing
+	Categories   []Category // Linked through posts_categories
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+	EditedAt     time.Time // zero until the post is first edited
+	Hidden       bool      // hidden by a moderator
+	Locked       bool      // closed to new comments
+	Upvotes      int
+	Downvotes    int
+	CommentCount int
+	Comments     []Comment
 }
+
 //todo: why comments are slice of strings?
 
 type Comment struct {
+	ID          int
+	Content     string
+	Author      string // Username from users table
+	UserID      int
+	PostID      int
+	ParentID    int // 0 for top-level comments
+	CreatedAt   time.Time
+	Upvotes     int
+	Downvotes   int
+	TimeAgo     string
+	EditedAt    time.Time // zero until the comment is first edited
+	Deleted     bool      // soft-deleted; Content and Author are blanked
+	Hidden      bool      // hidden by a moderator
+	CanEdit     bool      // set by the handler when the viewer wrote it
+	CanReply    bool      // set by the handler unless the thread is locked
+	CanModerate bool      // set by the handler for moderators
+	CanReport   bool      // set by the handler for signed-in readers other than the author
+	Depth       int       // nesting level in a comment tree, 0 at the top
+	Replies     []Comment // filled by database.FetchCommentTree
+}
+
+// Revision is one earlier version of a post or comment, as it was before
+// the edit made at CreatedAt. Title is empty for comments.
+type Revision struct {
 	ID        int
+	Title     string
 	Content   string
-	Author    string // Username from users table
-	UserID    int
+	CreatedAt time.Time
+}
+
+// SearchResult is one hit from the full-text search. CommentID is zero when
+// the match is in the post itself.
+type SearchResult struct {
 	PostID    int
+	PostTitle string
+	CommentID int
+	Author    string
+	Snippet   string // matched terms wrapped in database.SnippetOpen/SnippetClose
 	CreatedAt time.Time
-	Upvotes   int
-	Downvotes int
-	TimeAgo   string
 }
 
 type Votes struct {
@@ -82,3 +176,127 @@ type Votes struct {
 	UserID int
 	PostID int
 }
+
+// ModerationEntry is one row of the moderation audit log.
+type ModerationEntry struct {
+	ID         int
+	Moderator  string
+	Action     string
+	TargetType string // "post", "comment" or "user"
+	TargetID   int
+	Reason     string
+	CreatedAt  time.Time
+}
+
+// Report is a reader's flag on a post or comment. PostID is the post the
+// target belongs to, so comment reports can link back to their thread.
+type Report struct {
+	ID         int
+	Reporter   string
+	TargetType string // "post" or "comment"
+	TargetID   int
+	PostID     int
+	Excerpt    string // post title or comment text
+	Reason     string
+	Details    string
+	Status     string // "open", "accepted" or "dismissed"
+	CreatedAt  time.Time
+	ResolvedAt time.Time // zero while the report is open
+}
+
+// Message is one private message between two users.
+type Message struct {
+	ID          int
+	SenderID    int
+	Sender      string
+	RecipientID int
+	Body        string
+	Read        bool
+	CreatedAt   time.Time
+}
+
+// Conversation is an inbox entry: the other user and the latest message.
+type Conversation struct {
+	ID          int
+	OtherID     int
+	Other       string
+	LastMessage Message
+	Unread      int
+	Blocked     bool // the inbox owner blocked the other user
+}
+
+// Notification tells a user about activity on their content. Actor is
+// empty when the account that caused it was removed, and CommentID is zero
+// for events on the post itself.
+type Notification struct {
+	ID        int
+	Kind      string
+	Actor     string
+	PostID    int
+	PostTitle string
+	CommentID int
+	Detail    string // vote direction or report outcome
+	Read      bool
+	CreatedAt time.Time
+}
+
+// Session is one signed-in browser or API client of a user.
+type Session struct {
+	ID        int
+	UserAgent string
+	IP        string
+	CreatedAt time.Time // zero for sessions from before devices were tracked
+	LastSeen  time.Time
+	Current   bool // the session of the request that listed it
+}
+
+// Device describes the session's browser and system for people, such as
+// "Firefox on Linux", from its user agent.
+func (s Session) Device() string {
+	browser, system := "Unknown browser", ""
+	ua := s.UserAgent
+	for _, b := range []struct{ token, name string }{
+		{"Edg/", "Edge"}, {"OPR/", "Opera"}, {"Firefox/", "Firefox"},
+		{"Chrome/", "Chrome"}, {"Safari/", "Safari"}, {"curl/", "curl"},
+	} {
+		if strings.Contains(ua, b.token) {
+			browser = b.name
+			break
+		}
+	}
+	for _, o := range []struct{ token, name string }{
+		{"Android", "Android"}, {"iPhone", "iOS"}, {"iPad", "iPadOS"},
+		{"Windows", "Windows"}, {"Mac OS X", "macOS"}, {"Linux", "Linux"},
+	} {
+		if strings.Contains(ua, o.token) {
+			system = o.name
+			break
+		}
+	}
+	if system == "" {
+		return browser
+	}
+	return browser + " on " + system
+}
+
+// LinkedIdentity is an account at a sign-in provider, such as GitHub,
+// that a user can log in with.
+type LinkedIdentity struct {
+	Provider   string
+	Email      string
+	CreatedAt  time.Time
+	LastUsedAt time.Time
+}
+
+// TwoFactor is the two-factor login state of an account, shown on its
+// settings page.
+type TwoFactor struct {
+	// EnabledAt is when two-factor login was turned on; zero while it is
+	// off
+	EnabledAt time.Time
+	// PendingSecret is the secret of a setup that has not been confirmed
+	// with a code yet
+	PendingSecret string
+	// RecoveryCodesLeft counts the unused recovery codes
+	RecoveryCodesLeft int
+}
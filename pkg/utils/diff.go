@@ -0,0 +1,69 @@
+package utils
+
+import "strings"
+
+// Kinds of DiffLine. They double as CSS class names in the history view.
+const (
+	DiffSame    = "same"
+	DiffAdded   = "added"
+	DiffRemoved = "removed"
+)
+
+type DiffLine struct {
+	Kind string
+	Text string
+}
+
+// DiffLines compares two texts line by line using their longest common
+// subsequence. Removed lines come before the lines that replaced them.
+func DiffLines(oldText, newText string) []DiffLine {
+	a := splitLines(oldText)
+	b := splitLines(newText)
+
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	diff := make([]DiffLine, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			diff = append(diff, DiffLine{Kind: DiffSame, Text: a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			diff = append(diff, DiffLine{Kind: DiffRemoved, Text: a[i]})
+			i++
+		default:
+			diff = append(diff, DiffLine{Kind: DiffAdded, Text: b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		diff = append(diff, DiffLine{Kind: DiffRemoved, Text: a[i]})
+	}
+	for ; j < len(b); j++ {
+		diff = append(diff, DiffLine{Kind: DiffAdded, Text: b[j]})
+	}
+	return diff
+}
+
+func splitLines(text string) []string {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	if text == "" {
+		return nil
+	}
+	return strings.Split(text, "\n")
+}
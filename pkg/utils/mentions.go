@@ -0,0 +1,21 @@
+package utils
+
+import "regexp"
+
+// mentionPattern matches @username where the @ does not follow a username
+// character, so e-mail addresses are not taken as mentions.
+var mentionPattern = regexp.MustCompile(`(?:^|[^A-Za-z0-9_-])@([A-Za-z0-9_-]+)`)
+
+// ExtractMentions returns the usernames mentioned in text, each once, in the
+// order they first appear.
+func ExtractMentions(text string) []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, m := range mentionPattern.FindAllStringSubmatch(text, -1) {
+		if !seen[m[1]] {
+			seen[m[1]] = true
+			names = append(names, m[1])
+		}
+	}
+	return names
+}
@@ -1,13 +1,19 @@
 package utils
 
 import (
-	"database/sql"
 	"errors"
 	"fmt"
 	"regexp"
 	"strings"
 )
 
+// Accounts tells whether a username or email address already belongs to
+// an account.
+type Accounts interface {
+	UsernameTaken(username string) (bool, error)
+	EmailTaken(email string) (bool, error)
+}
+
 type ValidationError struct {
 	Field   string
 	Message string
@@ -17,48 +23,97 @@ func (e ValidationError) Error() string {
 	return fmt.Sprintf("%s: %s", e.Field, e.Message)
 }
 
-func ValidateInputs(DB *sql.DB, username, email, password string) error {
+func ValidateInputs(accounts Accounts, username, email, password string) error {
 	username = strings.TrimSpace(username)
 	email = strings.TrimSpace(email)
 
-	emailRegex := regexp.MustCompile(`^[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}$`)
-
 	if username == "" || email == "" || password == "" {
 		return ValidationError{Field: "general", Message: "all fields are required"}
 	}
 
-	if !emailRegex.MatchString(email) {
-		return ValidationError{Field: "email", Message: "invalid email format"}
+	if err := checkEmailFormat(email); err != nil {
+		return err
 	}
 
-	if len(username) < 5 || len(username) > 15 {
-		return ValidationError{Field: "username", Message: "username must be between 5 and 30 characters long"}
+	if err := checkUsernameFormat(username); err != nil {
+		return err
 	}
 
 	if err := ValidatePassword(password); err != nil {
 		return ValidationError{Field: "password", Message: err.Error()}
 	}
 
+	if err := checkUsernameAvailable(accounts, username); err != nil {
+		return err
+	}
+
+	return checkEmailAvailable(accounts, email)
+}
+
+// ValidateUsername applies the registration rules for usernames to a new
+// username for an existing account.
+func ValidateUsername(accounts Accounts, username string) error {
+	username = strings.TrimSpace(username)
+	if username == "" {
+		return ValidationError{Field: "username", Message: "username is required"}
+	}
+	if err := checkUsernameFormat(username); err != nil {
+		return err
+	}
+	return checkUsernameAvailable(accounts, username)
+}
+
+// ValidateEmail applies the registration rules for email addresses to a
+// new address for an existing account.
+func ValidateEmail(accounts Accounts, email string) error {
+	email = strings.TrimSpace(email)
+	if email == "" {
+		return ValidationError{Field: "email", Message: "email is required"}
+	}
+	if err := checkEmailFormat(email); err != nil {
+		return err
+	}
+	return checkEmailAvailable(accounts, email)
+}
+
+var emailRegex = regexp.MustCompile(`^[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}$`)
+
+func checkEmailFormat(email string) error {
+	if !emailRegex.MatchString(email) {
+		return ValidationError{Field: "email", Message: "invalid email format"}
+	}
+	return nil
+}
+
+func checkUsernameFormat(username string) error {
+	if len(username) < 5 || len(username) > 15 {
+		return ValidationError{Field: "username", Message: "username must be between 5 and 30 characters long"}
+	}
 	if !isValidUsername(username) {
 		return ValidationError{Field: "username", Message: "username can only contain letters, numbers, underscores, and dashes"}
 	}
+	return nil
+}
 
-	usernameAvailable, err := UsernameNotTaken(DB, username)
+func checkUsernameAvailable(accounts Accounts, username string) error {
+	taken, err := accounts.UsernameTaken(username)
 	if err != nil {
 		return fmt.Errorf("error checking username availability: %w", err)
 	}
-	if !usernameAvailable {
+	if taken {
 		return ValidationError{Field: "username", Message: "username already taken"}
 	}
+	return nil
+}
 
-	emailAvailable, err := EmailNotTaken(DB, email)
+func checkEmailAvailable(accounts Accounts, email string) error {
+	taken, err := accounts.EmailTaken(email)
 	if err != nil {
 		return fmt.Errorf("error checking email availability: %w", err)
 	}
-	if !emailAvailable {
+	if taken {
 		return ValidationError{Field: "email", Message: "email already registered"}
 	}
-
 	return nil
 }
 
@@ -75,28 +130,6 @@ func isValidCharacter(char rune) bool {
 	return (char >= 'a' && char <= 'z') || (char >= 'A' && char <= 'Z') || (char >= '0' && char <= '9')
 }
 
-func EmailNotTaken(DB *sql.DB, email string) (bool, error) {
-	var count int
-	err := DB.QueryRow("SELECT COUNT(*) FROM users WHERE email = ?", email).Scan(&count)
-	if err != nil {
-		return false, fmt.Errorf("error checking email: %w", err)
-	}
-	return count == 0, nil
-}
-
-func UsernameNotTaken(DB *sql.DB, username string) (bool, error) {
-
-	if DB == nil {
-		return false, fmt.Errorf("database connection is nil123")
-	}
-	var count int
-	err := DB.QueryRow("SELECT COUNT(*) FROM users WHERE username = ?", username).Scan(&count)
-	if err != nil {
-		return false, fmt.Errorf("error checking username: %w", err)
-	}
-	return count == 0, nil
-}
-
 func ValidatePassword(password string) error {
 	if len(password) < 8 {
 		return errors.New("password must be at least 8 characters long")
@@ -1,24 +1,76 @@
 package render
 
 import (
+	"fmt"
+	"forum-go/auth"
+	"forum-go/csrf"
 	"html/template"
-	"log"
+	"io"
+	"net/http"
 )
 
-var Templates *template.Template
+// Templates holds every page. Render them with ExecuteTemplate rather than
+// directly: the set is cloned for each request, and html/template cannot
+// clone a set that has been executed.
+type Templates struct {
+	set *template.Template
+	// CSRF issues the anti-forgery tokens put in forms
+	CSRF *csrf.Guard
+	// Providers are offered as sign-in buttons on the login forms
+	Providers auth.Providers
+}
+
+// funcs are the request-independent stand-ins; ExecuteTemplate replaces
+// them with the values of the request.
+var funcs = template.FuncMap{
+	"csrfToken":      func() string { return "" },
+	"oauthProviders": func() []auth.Provider { return nil },
+}
 
-func InitTemplates() {
-	var err error
-	Templates, err = template.ParseFiles(
+// LoadTemplates parses the pages in ./templates.
+func LoadTemplates() (*Templates, error) {
+	set, err := template.New("").Funcs(funcs).ParseFiles(
 		"./templates/index.html",
 		"./templates/header.html",
 		"./templates/footer.html",
 		"./templates/error.html",
 		"./templates/newPost.html",
 		"./templates/viewPost.html",
+		"./templates/comment.html",
+		"./templates/editPost.html",
+		"./templates/history.html",
+		"./templates/moderation.html",
+		"./templates/reportQueue.html",
+		"./templates/notifications.html",
+		"./templates/messages.html",
+		"./templates/conversation.html",
 		"./templates/profile.html",
+		"./templates/search.html",
+		"./templates/account.html",
+		"./templates/settings.html",
 	)
 	if err != nil {
-		log.Fatalf("Error loading templates: %v", err)
+		return nil, fmt.Errorf("error loading templates: %w", err)
+	}
+	return &Templates{set: set}, nil
+}
+
+// ExecuteTemplate renders the named template for r. Templates get the
+// anti-forgery token of the request from csrfToken, and the sign-in
+// providers from oauthProviders.
+func (t *Templates) ExecuteTemplate(w io.Writer, r *http.Request, name string, data interface{}) error {
+	set, err := t.set.Clone()
+	if err != nil {
+		return err
 	}
+	set.Funcs(template.FuncMap{
+		"csrfToken": func() string {
+			if t.CSRF == nil {
+				return ""
+			}
+			return t.CSRF.Token(r)
+		},
+		"oauthProviders": t.Providers.List,
+	})
+	return set.ExecuteTemplate(w, name, data)
 }
@@ -1,50 +1,257 @@
 package server
 
 import (
-	"database/sql"
+	"context"
 	"fmt"
+	"forum-go/api"
+	"forum-go/auth"
+	"forum-go/config"
+	"forum-go/csrf"
+	"forum-go/database"
 	"forum-go/handler"
+	"forum-go/mail"
 	"forum-go/middleware"
+	"forum-go/model"
 	"forum-go/render"
 	"log"
+	"net"
 	"net/http"
-	"os"
+	"strconv"
+	"time"
 )
 
-func Startserver(db *sql.DB) {
+// Startserver serves the forum from store and accounts until ctx is done,
+// then shuts it down gracefully. It returns once open requests have
+// finished and background workers have stopped, so the caller can close
+// the store.
+func Startserver(ctx context.Context, cfg *config.Config, store database.Stores, accounts auth.AccountStore) error {
 
-	render.InitTemplates()
-	RegisterServer(db)
+	templates, err := render.LoadTemplates()
+	if err != nil {
+		return err
+	}
+	// Session lifetimes, and the cookie attributes HTTPS deployments want
+	store.ConfigureSessions(sessionOptions(cfg.Sessions))
+	app := handler.NewApp(store, accounts, templates)
+	srv := NewHTTPServer(cfg.Server, RegisterServer(cfg, app))
+
+	stopJanitor := app.Sessions.StartSessionJanitor(time.Hour)
+	defer stopJanitor()
+
+	// Live streams never end on their own, so they are closed when the
+	// shutdown starts and browsers reconnect to the next server
+	srv.RegisterOnShutdown(app.PostEvents.Close)
+	srv.RegisterOnShutdown(app.MessageEvents.Close)
 
+	ln, err := net.Listen("tcp", srv.Addr)
+	if err != nil {
+		return fmt.Errorf("error listening: %w", err)
+	}
+	scheme := "http"
+	if cfg.Server.TLSCert != "" {
+		scheme = "https"
+	}
+	fmt.Printf("Server running on %s://localhost%s\n", scheme, srv.Addr)
+	return Serve(ctx, srv, ln, cfg.Server)
 }
 
-func RegisterServer(db *sql.DB) {
+// NewHTTPServer returns a server for h on cfg's port with cfg's timeouts.
+func NewHTTPServer(cfg config.Server, h http.Handler) *http.Server {
+	return &http.Server{
+		Addr:         ":" + strconv.Itoa(cfg.Port),
+		Handler:      h,
+		ReadTimeout:  cfg.ReadTimeout.Duration,
+		WriteTimeout: cfg.WriteTimeout.Duration,
+		IdleTimeout:  cfg.IdleTimeout.Duration,
+	}
+}
+
+// Serve serves srv on ln, over TLS when cfg has a certificate, until ctx is
+// done. It then stops accepting connections and waits up to
+// cfg.ShutdownTimeout for open requests to finish; requests still running
+// after that are cut off and reported as an error.
+func Serve(ctx context.Context, srv *http.Server, ln net.Listener, cfg config.Server) error {
+	served := make(chan error, 1)
+	go func() {
+		if cfg.TLSCert != "" {
+			served <- srv.ServeTLS(ln, cfg.TLSCert, cfg.TLSKey)
+		} else {
+			served <- srv.Serve(ln)
+		}
+	}()
+
+	select {
+	case err := <-served:
+		return fmt.Errorf("error serving: %w", err)
+	case <-ctx.Done():
+	}
+
+	log.Println("Shutting down: waiting for open requests to finish")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout.Duration)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		srv.Close()
+		return fmt.Errorf("error shutting down: %w", err)
+	}
+	if err := <-served; err != http.ErrServerClosed {
+		return fmt.Errorf("error serving: %w", err)
+	}
+	log.Println("Server stopped")
+	return nil
+}
+
+// RegisterServer applies cfg to app, routes every page to it and returns
+// the handler to serve.
+func RegisterServer(cfg *config.Config, app *handler.App) http.Handler {
+
+	mux := http.NewServeMux()
+	mw := middleware.New(app.Users, app.Sessions)
 
 	fs := http.FileServer(http.Dir("assets"))
-	http.Handle("/assets/", http.StripPrefix("/assets/", fs))
+	mux.Handle("/assets/", http.StripPrefix("/assets/", fs))
+
+	// Write limits per client, as bursts and the time to earn back one
+	// request. Each limiter keeps its own buckets.
+	var (
+		posts    = middleware.NewRateLimiter(middleware.RatePolicy{Burst: 5, Refill: time.Minute})
+		comments = middleware.NewRateLimiter(middleware.RatePolicy{Burst: 10, Refill: 6 * time.Second})
+		votes    = middleware.NewRateLimiter(middleware.RatePolicy{Burst: 30, Refill: time.Second})
+		messages = middleware.NewRateLimiter(middleware.RatePolicy{Burst: 20, Refill: 3 * time.Second})
+		reports  = middleware.NewRateLimiter(middleware.RatePolicy{Burst: 5, Refill: time.Minute})
+		accounts = middleware.NewRateLimiter(middleware.RatePolicy{Burst: 10, Refill: 30 * time.Second})
+		writes   = middleware.NewRateLimiter(middleware.RatePolicy{Burst: 30, Refill: 2 * time.Second})
+	)
 
-	http.HandleFunc("/", handler.IndexHandler)
-	http.HandleFunc("/favicon.ico", handler.FaviconHandler)
-	http.HandleFunc("/viewpost", handler.ViewPostHandler)
-	http.HandleFunc("/newpost", handler.NewPostHandler)
+	mux.HandleFunc("/", app.IndexHandler)
+	mux.HandleFunc("/favicon.ico", app.FaviconHandler)
+	mux.HandleFunc("/viewpost", app.ViewPostHandler)
+	mux.HandleFunc("/newpost", app.NewPostHandler)
+	mux.HandleFunc("/search", app.SearchHandler)
 
-	http.HandleFunc("/login", handler.LoginHandler)
-	http.HandleFunc("/register", handler.RegisterHandler)
-	http.HandleFunc("/profile", handler.ProfileHandler)
+	mux.HandleFunc("/login", mw.RateLimit(accounts, app.LoginHandler))
+	mux.HandleFunc("/login/two-factor", mw.RateLimit(accounts, app.TwoFactorLoginHandler))
+	mux.HandleFunc("/register", mw.RateLimit(accounts, app.RegisterHandler))
+	mux.HandleFunc("/profile", app.ProfileHandler)
+	mux.HandleFunc("/forgot-password", mw.RateLimit(accounts, app.ForgotPasswordHandler))
+	mux.HandleFunc("/reset-password", mw.RateLimit(accounts, app.ResetPasswordHandler))
+	mux.HandleFunc("/verify-email", app.VerifyEmailHandler)
+	mux.HandleFunc("/verify-email/resend", mw.RateLimit(accounts, mw.RequireRole(model.RoleUser, app.ResendVerificationHandler)))
+	mux.HandleFunc("/settings", mw.RequireRole(model.RoleUser, app.SettingsHandler))
+	mux.HandleFunc("/settings/username", mw.RateLimit(accounts, mw.RequireRole(model.RoleUser, app.ChangeUsernameHandler)))
+	mux.HandleFunc("/settings/email", mw.RateLimit(accounts, mw.RequireRole(model.RoleUser, app.ChangeEmailHandler)))
+	mux.HandleFunc("/settings/password", mw.RateLimit(accounts, mw.RequireRole(model.RoleUser, app.ChangePasswordHandler)))
+	mux.HandleFunc("/settings/delete", mw.RateLimit(accounts, mw.RequireRole(model.RoleUser, app.DeleteAccountHandler)))
+	mux.HandleFunc("/settings/identities/unlink", mw.RateLimit(accounts, mw.RequireRole(model.RoleUser, app.UnlinkIdentityHandler)))
+	mux.HandleFunc("/settings/two-factor/setup", mw.RateLimit(accounts, mw.RequireRole(model.RoleUser, app.SetupTwoFactorHandler)))
+	mux.HandleFunc("/settings/two-factor/enable", mw.RateLimit(accounts, mw.RequireRole(model.RoleUser, app.EnableTwoFactorHandler)))
+	mux.HandleFunc("/settings/two-factor/disable", mw.RateLimit(accounts, mw.RequireRole(model.RoleUser, app.DisableTwoFactorHandler)))
+	mux.HandleFunc("/settings/two-factor/recovery", mw.RateLimit(accounts, mw.RequireRole(model.RoleUser, app.RecoveryCodesHandler)))
+	mux.HandleFunc("/oauth/{provider}/login", app.OAuthLoginHandler)
+	mux.HandleFunc("/oauth/{provider}/callback", app.OAuthCallbackHandler)
 
-	http.HandleFunc("/submit-post", middleware.SessionMiddleware(handler.SubmitPostHandler))
-	http.HandleFunc("/submitComment", middleware.SessionMiddleware(handler.SubmitCommentHandler))
+	mux.HandleFunc("/submit-post", mw.RateLimit(posts, mw.RequireRole(model.RoleUser, app.SubmitPostHandler)))
+	mux.HandleFunc("/submitComment", mw.RateLimit(comments, mw.RequireRole(model.RoleUser, app.SubmitCommentHandler)))
 
-	http.HandleFunc("/vote", handler.VoteHandler)
-	http.HandleFunc("/vote-comment", handler.VoteCommentHandler)
+	mux.HandleFunc("/editpost", mw.RateLimit(writes, mw.RequireRole(model.RoleUser, app.EditPostHandler)))
+	mux.HandleFunc("/deletepost", mw.RateLimit(writes, mw.RequireRole(model.RoleUser, app.DeletePostHandler)))
+	mux.HandleFunc("/editcomment", mw.RateLimit(writes, mw.RequireRole(model.RoleUser, app.EditCommentHandler)))
+	mux.HandleFunc("/deletecomment", mw.RateLimit(writes, mw.RequireRole(model.RoleUser, app.DeleteCommentHandler)))
+	mux.HandleFunc("/history", app.HistoryHandler)
+	mux.HandleFunc("/events/post", app.PostEventsHandler)
+	mux.HandleFunc("/report", mw.RateLimit(reports, mw.RequireRole(model.RoleUser, app.ReportHandler)))
+	mux.HandleFunc("/notifications", mw.RequireRole(model.RoleUser, app.NotificationsHandler))
+	mux.HandleFunc("/notifications/read", mw.RateLimit(writes, mw.RequireRole(model.RoleUser, app.MarkNotificationsReadHandler)))
+	mux.HandleFunc("/notifications/preferences", mw.RateLimit(writes, mw.RequireRole(model.RoleUser, app.NotificationPreferencesHandler)))
+	mux.HandleFunc("/messages", mw.RequireRole(model.RoleUser, app.MessagesHandler))
+	mux.HandleFunc("/messages/view", mw.RequireRole(model.RoleUser, app.ConversationHandler))
+	mux.HandleFunc("/messages/send", mw.RateLimit(messages, mw.RequireRole(model.RoleUser, app.SendMessageHandler)))
+	mux.HandleFunc("/messages/poll", mw.RequireRole(model.RoleUser, app.MessagesPollHandler))
+	mux.HandleFunc("/messages/read", mw.RateLimit(writes, mw.RequireRole(model.RoleUser, app.MarkMessagesReadHandler)))
+	mux.HandleFunc("/messages/block", mw.RateLimit(writes, mw.RequireRole(model.RoleUser, app.BlockUserHandler)))
+	mux.HandleFunc("/messages/ws", mw.RequireRole(model.RoleUser, app.MessagesSocketHandler))
 
-	http.HandleFunc("/logout", handler.LogoutHandler)
+	mux.HandleFunc("/moderation", mw.RequireRole(model.RoleModerator, app.ModerationHandler))
+	mux.HandleFunc("/moderate/post", mw.RateLimit(writes, mw.RequireRole(model.RoleModerator, app.ModeratePostHandler)))
+	mux.HandleFunc("/moderate/comment", mw.RateLimit(writes, mw.RequireRole(model.RoleModerator, app.ModerateCommentHandler)))
+	mux.HandleFunc("/moderation/reports", mw.RequireRole(model.RoleModerator, app.ReportQueueHandler))
+	mux.HandleFunc("/moderation/reports/resolve", mw.RateLimit(writes, mw.RequireRole(model.RoleModerator, app.ResolveReportHandler)))
+	mux.HandleFunc("/moderate/user", mw.RateLimit(writes, mw.RequireRole(model.RoleModerator, app.ModerateUserHandler)))
+	mux.HandleFunc("/admin/role", mw.RateLimit(writes, mw.RequireRole(model.RoleAdmin, app.SetRoleHandler)))
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8999"
+	mux.HandleFunc("/vote", mw.RateLimit(votes, app.VoteHandler))
+	mux.HandleFunc("/vote-comment", mw.RateLimit(votes, app.VoteCommentHandler))
+
+	mux.HandleFunc("/logout", app.LogoutHandler)
+	mux.HandleFunc("/logout/all", mw.RequireRole(model.RoleUser, app.LogoutEverywhereHandler))
+	mux.HandleFunc("/sessions/revoke", mw.RateLimit(writes, mw.RequireRole(model.RoleUser, app.RevokeSessionHandler)))
+
+	apiWrites := middleware.NewRateLimiter(middleware.RatePolicy{Burst: 60, Refill: time.Second})
+	mux.Handle(api.Prefix, api.Handler(app, apiWrites))
+
+	// Replies nested deeper than this are shown flat under the last level
+	app.MaxCommentDepth = cfg.Forum.CommentMaxDepth
+
+	// Failed logins: free attempts before backoff, failures before a
+	// lockout, and how long a lockout lasts
+	policy := auth.DefaultLockoutPolicy
+	policy.FreeAttempts = cfg.Login.FreeAttempts
+	policy.LockoutAfter = cfg.Login.LockoutAfter
+	policy.LockoutDuration = cfg.Login.LockoutDuration.Duration
+	app.Logins = auth.NewLimiter(policy)
+
+	// Moderators, or only admins, can be made to turn on two-factor login
+	// before they use their tools
+	app.TwoFactorRole = cfg.Login.TwoFactorRole
+	mw.TwoFactorRole = cfg.Login.TwoFactorRole
+
+	// Form tokens survive restarts only with a fixed key
+	guard := csrf.New(cfg.Secrets.CSRF)
+	app.Templates.CSRF = guard
+
+	// Emails go through the SMTP server when one is set. Otherwise they are
+	// logged, and saved in the mail directory if that is set.
+	app.BaseURL = cfg.Server.BaseURL
+	if cfg.Mail.SMTPAddr != "" {
+		app.Mailer = &mail.SMTPMailer{
+			Addr:     cfg.Mail.SMTPAddr,
+			From:     cfg.Mail.From,
+			Username: cfg.Mail.SMTPUsername,
+			Password: cfg.Mail.SMTPPassword,
+		}
+	} else {
+		app.Mailer = &mail.LogMailer{Dir: cfg.Mail.Dir, From: cfg.Mail.From}
+	}
+
+	// Sign-in with GitHub and Google is offered when their OAuth clients are
+	// configured. Their callback URLs are <base URL>/oauth/<name>/callback.
+	if github := cfg.OAuth.GitHub; github.ClientID != "" {
+		app.Providers.Add(auth.GitHub(github.ClientID, github.ClientSecret))
+	}
+	if google := cfg.OAuth.Google; google.ClientID != "" {
+		app.Providers.Add(auth.Google(google.ClientID, google.ClientSecret))
 	}
 
-	fmt.Printf("Server running on :%s\n", port)
-	log.Fatal(http.ListenAndServe(":"+port, middleware.EnableCORS(http.DefaultServeMux)))
+	app.Templates.Providers = app.Providers
+
+	return middleware.EnableCORS(cfg.Server.CORSOrigins, middleware.CSRF(guard, mw.SlidingSessions(mux)))
+}
+
+// -- Non-Global Functions : Only happens in this package -- //
+
+// sessionOptions turns the session settings of the config into the
+// store's options.
+func sessionOptions(cfg config.Sessions) database.SessionOptions {
+	opts := database.SessionOptions{
+		TTL:         cfg.TTL.Duration,
+		RememberTTL: cfg.RememberTTL.Duration,
+		Secure:      cfg.CookieSecure,
+		SameSite:    http.SameSiteLaxMode,
+	}
+	switch cfg.CookieSameSite {
+	case "strict":
+		opts.SameSite = http.SameSiteStrictMode
+	case "none":
+		opts.SameSite = http.SameSiteNoneMode
+	}
+	return opts
 }
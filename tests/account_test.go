@@ -0,0 +1,252 @@
+package tests
+
+import (
+	"bytes"
+	"errors"
+	"forum-go/auth"
+	"forum-go/handler"
+	"forum-go/mail"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testBaseURL is the forum's address in the links of test emails.
+const testBaseURL = "https://forum.example.com"
+
+// captureMail sends the emails of app to files in a temporary directory
+// and returns a function reading them back.
+func captureMail(t *testing.T, app *handler.App) func() []string {
+	t.Helper()
+	dir := t.TempDir()
+	app.Mailer = &mail.LogMailer{Dir: dir}
+	app.BaseURL = testBaseURL
+
+	return func() []string {
+		t.Helper()
+		files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		sort.Strings(files)
+		var mails []string
+		for _, f := range files {
+			b, err := os.ReadFile(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			mails = append(mails, string(b))
+		}
+		return mails
+	}
+}
+
+var mailLink = regexp.MustCompile(`https?://[^/\s]+(/\S+)`)
+
+// linkIn returns the path and query of the link in an email.
+func linkIn(t *testing.T, message string) string {
+	t.Helper()
+	m := mailLink.FindStringSubmatch(message)
+	if m == nil {
+		t.Fatalf("No link in %q", message)
+	}
+	return m[1]
+}
+
+func TestAccountTokens(t *testing.T) {
+	db := setupTestDB(t).DB()
+	accounts := auth.NewStore(db, "")
+	addLoginUser(t, db, "robin")
+	userID := userIDByName(t, db, "robin")
+
+	token, err := accounts.IssueToken(userID, auth.PurposePasswordReset, "robin@example.com", time.Hour)
+	if err != nil {
+		t.Fatalf("IssueToken failed: %v", err)
+	}
+	if id, err := accounts.CheckToken(token, auth.PurposePasswordReset); err != nil || id != userID {
+		t.Errorf("CheckToken: got %d %v", id, err)
+	}
+
+	// Tokens are bound to their purpose and signature
+	for name, tc := range map[string]struct{ token, purpose string }{
+		"other purpose": {token, auth.PurposeVerifyEmail},
+		"tampered":      {token[:len(token)-2] + "xx", auth.PurposePasswordReset},
+		"unsigned":      {strings.Split(token, ".")[0], auth.PurposePasswordReset},
+		"empty":         {"", auth.PurposePasswordReset},
+	} {
+		if _, err := accounts.CheckToken(tc.token, tc.purpose); !errors.Is(err, auth.ErrInvalidToken) {
+			t.Errorf("%s: got %v, want ErrInvalidToken", name, err)
+		}
+	}
+
+	// A newer link replaces the older one
+	newer, err := accounts.IssueToken(userID, auth.PurposePasswordReset, "robin@example.com", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := accounts.CheckToken(token, auth.PurposePasswordReset); !errors.Is(err, auth.ErrInvalidToken) {
+		t.Errorf("Replaced token: got %v", err)
+	}
+
+	// Single use
+	if _, err := accounts.ResetPassword(newer, "N3wPassw0rd!"); err != nil {
+		t.Fatalf("ResetPassword failed: %v", err)
+	}
+	if _, err := accounts.ResetPassword(newer, "An0therOne!"); !errors.Is(err, auth.ErrInvalidToken) {
+		t.Errorf("Reused token: got %v", err)
+	}
+
+	// Expired
+	expired, _ := accounts.IssueToken(userID, auth.PurposePasswordReset, "robin@example.com", time.Hour)
+	db.Exec("UPDATE user_tokens SET expires_at = ? WHERE used_at IS NULL", time.Now().UTC().Add(-time.Minute))
+	if _, err := accounts.ResetPassword(expired, "An0therOne!"); !errors.Is(err, auth.ErrInvalidToken) {
+		t.Errorf("Expired token: got %v", err)
+	}
+
+	// Verification links only confirm the address they were sent to
+	verify, _ := accounts.IssueToken(userID, auth.PurposeVerifyEmail, "robin@example.com", time.Hour)
+	db.Exec("UPDATE users SET email = 'new@example.com' WHERE id = ?", userID)
+	if _, err := accounts.VerifyEmail(verify); !errors.Is(err, auth.ErrInvalidToken) {
+		t.Errorf("Token for an old address: got %v", err)
+	}
+
+	var stored int
+	db.QueryRow("SELECT COUNT(*) FROM user_tokens WHERE token_hash LIKE ?", "%"+strings.Split(verify, ".")[0]+"%").Scan(&stored)
+	if stored != 0 {
+		t.Error("Tokens must not be stored in the clear")
+	}
+}
+
+func TestPasswordResetFlow(t *testing.T) {
+	app, store := setupTestApp(t)
+	db := store.DB()
+	addLoginUser(t, db, "robin")
+	userID := userIDByName(t, db, "robin")
+	session := sessionCookie(t, store, userID)
+	mails := captureMail(t, app)
+
+	// The Host header is the client's to choose, so links must not use it
+	post := func(h http.HandlerFunc, path string, form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+		req.Host = "attacker.example"
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		h(rr, req)
+		return rr
+	}
+
+	// Unknown addresses get the same answer and no mail
+	rr := post(app.ForgotPasswordHandler, "/forgot-password", url.Values{"email": {"nobody@example.com"}})
+	if rr.Code != http.StatusOK || len(mails()) != 0 {
+		t.Fatalf("Unknown address: got %v and %d mails", rr.Code, len(mails()))
+	}
+
+	rr = post(app.ForgotPasswordHandler, "/forgot-password", url.Values{"email": {"Robin@Example.com"}})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Forgot password: got %v", rr.Code)
+	}
+	sent := mails()
+	if len(sent) != 1 || !strings.Contains(sent[0], "To: robin@example.com") {
+		t.Fatalf("Expected one mail to robin, got %q", sent)
+	}
+	if !strings.Contains(sent[0], testBaseURL+"/reset-password?token=") {
+		t.Errorf("Expected the link on the configured address, got %q", sent[0])
+	}
+	link := linkIn(t, sent[0])
+	if !strings.HasPrefix(link, "/reset-password?token=") {
+		t.Fatalf("Unexpected link %q", link)
+	}
+	token, _ := url.QueryUnescape(strings.TrimPrefix(link, "/reset-password?token="))
+
+	rr = httptest.NewRecorder()
+	app.ResetPasswordHandler(rr, httptest.NewRequest(http.MethodGet, link, nil))
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `name="token" value="`+token+`"`) {
+		t.Fatalf("Reset form: got %v", rr.Code)
+	}
+
+	rr = post(app.ResetPasswordHandler, "/reset-password", url.Values{"token": {token}, "password": {"N3wPassw0rd!"}, "confirm": {"different"}})
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Mismatched passwords: got %v, want 400", rr.Code)
+	}
+	rr = post(app.ResetPasswordHandler, "/reset-password", url.Values{"token": {token}, "password": {"weak"}, "confirm": {"weak"}})
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Weak password: got %v, want 400", rr.Code)
+	}
+
+	rr = post(app.ResetPasswordHandler, "/reset-password", url.Values{"token": {token}, "password": {"N3wPassw0rd!"}, "confirm": {"N3wPassw0rd!"}})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Reset: got %v", rr.Code)
+	}
+	if _, err := app.Accounts.Authenticate("robin", "N3wPassw0rd!"); err != nil {
+		t.Errorf("New password does not work: %v", err)
+	}
+	if _, err := app.Accounts.Authenticate("robin", "Passw0rd!x"); !errors.Is(err, auth.ErrInvalidCredentials) {
+		t.Errorf("Old password still works: %v", err)
+	}
+	if ok, _ := store.SessionUserID(session.Value); ok {
+		t.Error("Resetting the password must end existing sessions")
+	}
+
+	rr = httptest.NewRecorder()
+	app.ResetPasswordHandler(rr, httptest.NewRequest(http.MethodGet, link, nil))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Used link: got %v, want 400", rr.Code)
+	}
+}
+
+func TestEmailVerificationFlow(t *testing.T) {
+	app, store := setupTestApp(t)
+	mails := captureMail(t, app)
+
+	var b bytes.Buffer
+	w := multipart.NewWriter(&b)
+	_ = w.WriteField("username", "robin")
+	_ = w.WriteField("email", "robin@example.com")
+	_ = w.WriteField("password", "Passw0rd!x")
+	w.Close()
+	req := httptest.NewRequest(http.MethodPost, "/register", &b)
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	rr := httptest.NewRecorder()
+	app.RegisterHandler(rr, req)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Register: got %v %q", rr.Code, rr.Body.String())
+	}
+
+	sent := mails()
+	if len(sent) != 1 {
+		t.Fatalf("Expected a verification mail, got %d", len(sent))
+	}
+	link := linkIn(t, sent[0])
+
+	user, err := store.FetchUserByEmail("robin@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !user.EmailVerifiedAt.IsZero() {
+		t.Error("New accounts start unverified")
+	}
+
+	rr = httptest.NewRecorder()
+	app.VerifyEmailHandler(rr, httptest.NewRequest(http.MethodGet, link, nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Verify: got %v", rr.Code)
+	}
+	user, _ = store.FetchUserById(user.ID)
+	if user.EmailVerifiedAt.IsZero() {
+		t.Error("The address was not marked verified")
+	}
+
+	rr = httptest.NewRecorder()
+	app.VerifyEmailHandler(rr, httptest.NewRequest(http.MethodGet, link, nil))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Second use: got %v, want 400", rr.Code)
+	}
+}
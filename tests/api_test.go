@@ -0,0 +1,233 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"forum-go/api"
+	"forum-go/handler"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+// apiCall sends one request to the API router and decodes the JSON reply.
+// token, when set, is sent as a bearer token.
+func apiCall(t *testing.T, app *handler.App, method, path, token string, body interface{}) (int, map[string]interface{}) {
+	t.Helper()
+	return apiRequest(t, api.Handler(app, nil), method, path, token, body)
+}
+
+// apiRequest sends one JSON request to an API handler.
+func apiRequest(t *testing.T, h http.Handler, method, path, token string, body interface{}) (int, map[string]interface{}) {
+	t.Helper()
+	var raw []byte
+	if body != nil {
+		var err error
+		if raw, err = json.Marshal(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	req := httptest.NewRequest(method, path, bytes.NewReader(raw))
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+
+	var out map[string]interface{}
+	if rr.Code != http.StatusNoContent {
+		if ct := rr.Header().Get("Content-Type"); ct != "application/json; charset=utf-8" {
+			t.Fatalf("%s %s: Content-Type %q", method, path, ct)
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &out); err != nil {
+			t.Fatalf("%s %s: invalid JSON %q: %v", method, path, rr.Body.String(), err)
+		}
+	}
+	return rr.Code, out
+}
+
+func errorCode(body map[string]interface{}) string {
+	e, _ := body["error"].(map[string]interface{})
+	code, _ := e["code"].(string)
+	return code
+}
+
+func apiLogin(t *testing.T, app *handler.App, username, password string) string {
+	t.Helper()
+	status, body := apiCall(t, app, http.MethodPost, "/api/v1/session", "", map[string]string{
+		"username": username,
+		"password": password,
+	})
+	if status != http.StatusCreated {
+		t.Fatalf("Login: got %v %v", status, body)
+	}
+	data := body["data"].(map[string]interface{})
+	return data["token"].(string)
+}
+
+func TestAPIListPosts(t *testing.T) {
+	app, _ := setupTestApp(t)
+
+	status, body := apiCall(t, app, http.MethodGet, "/api/v1/posts?limit=2&sort=oldest", "", nil)
+	if status != http.StatusOK {
+		t.Fatalf("Status: got %v, want %v", status, http.StatusOK)
+	}
+	data := body["data"].(map[string]interface{})
+	posts := data["posts"].([]interface{})
+	if len(posts) != 2 || data["next_cursor"] == nil {
+		t.Fatalf("Expected two posts and a next cursor, got %v", data)
+	}
+	first := posts[0].(map[string]interface{})
+	if first["title"] != `The Thrilling Ride of "Quantum Horizon"` || len(first["categories"].([]interface{})) != 3 {
+		t.Errorf("Unexpected first post %v", first)
+	}
+
+	status, body = apiCall(t, app, http.MethodGet, "/api/v1/posts?after="+data["next_cursor"].(string)+"&sort=oldest&limit=2", "", nil)
+	if status != http.StatusOK || len(body["data"].(map[string]interface{})["posts"].([]interface{})) != 2 {
+		t.Errorf("Second page: got %v %v", status, body)
+	}
+}
+
+func TestAPIErrors(t *testing.T) {
+	app, _ := setupTestApp(t)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   interface{}
+		status int
+		code   string
+	}{
+		{"Unknown sort", http.MethodGet, "/api/v1/posts?sort=random", nil, http.StatusBadRequest, "bad_request"},
+		{"Bad cursor", http.MethodGet, "/api/v1/posts?after=!!", nil, http.StatusBadRequest, "bad_request"},
+		{"Missing post", http.MethodGet, "/api/v1/posts/99999", nil, http.StatusNotFound, "not_found"},
+		{"Bad id", http.MethodGet, "/api/v1/posts/abc", nil, http.StatusBadRequest, "bad_request"},
+		{"Wrong method", http.MethodDelete, "/api/v1/posts", nil, http.StatusMethodNotAllowed, "method_not_allowed"},
+		{"Unknown endpoint", http.MethodGet, "/api/v1/nothing", nil, http.StatusNotFound, "not_found"},
+		{"Guest comment", http.MethodPost, "/api/v1/posts/1/comments", map[string]string{"content": "hi"}, http.StatusUnauthorized, "unauthorized"},
+		{"Wrong password", http.MethodPost, "/api/v1/session", map[string]string{"username": "admin", "password": "nope"}, http.StatusUnauthorized, "unauthorized"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, body := apiCall(t, app, tt.method, tt.path, "", tt.body)
+			if status != tt.status || errorCode(body) != tt.code {
+				t.Errorf("Got %v %v, want %v %q", status, body, tt.status, tt.code)
+			}
+		})
+	}
+}
+
+func TestAPISessionCommentsAndVotes(t *testing.T) {
+	app, store := setupTestApp(t)
+	if err := app.Accounts.AddUser("apiuser", "apiuser@example.com", "Passw0rd!x"); err != nil {
+		t.Fatalf("AddUser failed: %v", err)
+	}
+	token := apiLogin(t, app, "apiuser", "Passw0rd!x")
+
+	status, body := apiCall(t, app, http.MethodGet, "/api/v1/users/me", token, nil)
+	if status != http.StatusOK || body["data"].(map[string]interface{})["email"] != "apiuser@example.com" {
+		t.Fatalf("users/me: got %v %v", status, body)
+	}
+
+	postID := strconv.Itoa(firstPostID(t, store))
+	status, body = apiCall(t, app, http.MethodPost, "/api/v1/posts/"+postID+"/comments", token, map[string]string{"content": "from the API"})
+	if status != http.StatusCreated {
+		t.Fatalf("Create comment: got %v %v", status, body)
+	}
+	commentID := int(body["data"].(map[string]interface{})["id"].(float64))
+
+	status, body = apiCall(t, app, http.MethodPost, "/api/v1/posts/"+postID+"/comments", token, map[string]interface{}{
+		"content":   "a reply",
+		"parent_id": commentID,
+	})
+	if status != http.StatusCreated {
+		t.Fatalf("Create reply: got %v %v", status, body)
+	}
+
+	status, body = apiCall(t, app, http.MethodGet, "/api/v1/posts/"+postID+"/comments", "", nil)
+	if status != http.StatusOK {
+		t.Fatalf("List comments: got %v %v", status, body)
+	}
+	roots := body["data"].([]interface{})
+	root := roots[len(roots)-1].(map[string]interface{})
+	if root["content"] != "from the API" || len(root["replies"].([]interface{})) != 1 {
+		t.Errorf("Expected the reply nested under the new comment, got %v", root)
+	}
+
+	for i, want := range []float64{1, 0} {
+		status, body = apiCall(t, app, http.MethodPost, "/api/v1/posts/"+postID+"/vote", token, map[string]int{"vote": 1})
+		if status != http.StatusOK || body["data"].(map[string]interface{})["vote"] != want {
+			t.Errorf("Vote %d: got %v %v, want vote %v", i+1, status, body, want)
+		}
+	}
+	status, body = apiCall(t, app, http.MethodPost, "/api/v1/comments/"+strconv.Itoa(commentID)+"/vote", token, map[string]int{"vote": 2})
+	if status != http.StatusBadRequest {
+		t.Errorf("Invalid vote: got %v %v", status, body)
+	}
+
+	if status, _ = apiCall(t, app, http.MethodDelete, "/api/v1/session", token, nil); status != http.StatusNoContent {
+		t.Errorf("Logout: got %v, want %v", status, http.StatusNoContent)
+	}
+	if status, body = apiCall(t, app, http.MethodGet, "/api/v1/users/me", token, nil); status != http.StatusUnauthorized {
+		t.Errorf("users/me after logout: got %v %v", status, body)
+	}
+}
+
+func TestAPICommentTreeUsesConfiguredDepth(t *testing.T) {
+	app, store := setupTestApp(t)
+	app.MaxCommentDepth = 1
+	postID := firstPostID(t, store)
+	root := createComment(t, store, postID, 0, "root")
+	reply := createComment(t, store, postID, root, "reply")
+	createComment(t, store, postID, reply, "reply to reply")
+
+	status, body := apiCall(t, app, http.MethodGet, "/api/v1/posts/"+strconv.Itoa(postID)+"/comments", "", nil)
+	if status != http.StatusOK {
+		t.Fatalf("List comments: got %v %v", status, body)
+	}
+	roots := body["data"].([]interface{})
+	replies := roots[len(roots)-1].(map[string]interface{})["replies"].([]interface{})
+	if len(replies) != 2 {
+		t.Fatalf("Expected both replies flattened under the root at depth 1, got %v", replies)
+	}
+	for _, r := range replies {
+		if nested := r.(map[string]interface{})["replies"].([]interface{}); len(nested) != 0 {
+			t.Errorf("Expected no replies below depth 1, got %v", nested)
+		}
+	}
+}
+
+func TestTogglePostVote(t *testing.T) {
+	store := setupTestDB(t)
+	db := store.DB()
+	mamaID := userIDByName(t, db, "Mama")
+	postID := firstPostID(t, store)
+
+	steps := []struct {
+		vote, want, up, down int
+	}{
+		{1, 1, 1, 0},
+		{-1, -1, 0, 1},
+		{-1, 0, 0, 0},
+	}
+	for i, s := range steps {
+		result, err := store.TogglePostVote(mamaID, postID, s.vote)
+		if err != nil {
+			t.Fatalf("Step %d: TogglePostVote failed: %v", i+1, err)
+		}
+		if result.Vote != s.want || result.Upvotes != s.up || result.Downvotes != s.down {
+			t.Errorf("Step %d: got %+v, want vote %d with %d/%d", i+1, result, s.want, s.up, s.down)
+		}
+	}
+
+	if _, err := store.TogglePostVote(mamaID, 99999, 1); err == nil {
+		t.Error("Voting on a missing post should fail")
+	}
+}
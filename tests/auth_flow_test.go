@@ -2,7 +2,6 @@ package tests
 
 import (
 	"bytes"
-	"forum-go/handler"
 	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
@@ -16,7 +15,8 @@ func TestRegisterHandlerMethodNotAllowed(t *testing.T) {
 	}
 
 	rr := httptest.NewRecorder()
-	handler.RegisterHandler(rr, req)
+	app, _ := setupTestApp(t)
+	app.RegisterHandler(rr, req)
 
 	if rr.Code != http.StatusMethodNotAllowed {
 		t.Errorf("RegisterHandler GET status code: got %v, want %v", rr.Code, http.StatusMethodNotAllowed)
@@ -24,7 +24,7 @@ func TestRegisterHandlerMethodNotAllowed(t *testing.T) {
 }
 
 func TestLoginHandlerInvalidUser(t *testing.T) {
-	_ = setupTestDB(t)
+	app, _ := setupTestApp(t)
 
 	var b bytes.Buffer
 	w := multipart.NewWriter(&b)
@@ -39,7 +39,7 @@ func TestLoginHandlerInvalidUser(t *testing.T) {
 	req.Header.Set("Content-Type", w.FormDataContentType())
 
 	rr := httptest.NewRecorder()
-	handler.LoginHandler(rr, req)
+	app.LoginHandler(rr, req)
 
 	if rr.Code != http.StatusUnauthorized {
 		t.Errorf("LoginHandler invalid user status: got %v, want %v", rr.Code, http.StatusUnauthorized)
@@ -0,0 +1,102 @@
+package tests
+
+import (
+	"forum-go/database"
+	"testing"
+)
+
+func categoryNames(t *testing.T, store *database.Store, postID int) map[string]bool {
+	t.Helper()
+	post, err := store.FetchPostByID(postID)
+	if err != nil {
+		t.Fatalf("FetchPostByID(%d) failed: %v", postID, err)
+	}
+	names := make(map[string]bool)
+	for _, c := range post.Categories {
+		names[c.Name] = true
+	}
+	return names
+}
+
+func TestFetchPostsByCategoryExactMatch(t *testing.T) {
+	store := setupTestDB(t)
+	db := store.DB()
+
+	// A category whose name contains another one must not leak into its results
+	_, err := db.Exec("INSERT INTO categories (name, emoji) VALUES ('Action-Comedy', '🤸')")
+	if err != nil {
+		t.Fatalf("Inserting category failed: %v", err)
+	}
+	_, err = db.Exec(`
+        INSERT INTO posts (title, content, user_id) VALUES ('Stunts and Jokes', 'A pratfall-heavy action comedy.', 1)
+    `)
+	if err != nil {
+		t.Fatalf("Inserting post failed: %v", err)
+	}
+	_, err = db.Exec(`
+        INSERT INTO posts_categories (post_id, category_id)
+        SELECT p.id, c.id FROM posts p, categories c
+        WHERE p.title = 'Stunts and Jokes' AND c.name = 'Action-Comedy'
+    `)
+	if err != nil {
+		t.Fatalf("Linking post failed: %v", err)
+	}
+
+	posts, err := store.FetchPostsByCategory("Action")
+	if err != nil {
+		t.Fatalf("FetchPostsByCategory failed: %v", err)
+	}
+	if len(posts) != 1 {
+		t.Fatalf("Expected 1 Action post, got %d", len(posts))
+	}
+
+	found := false
+	for _, c := range posts[0].Categories {
+		if c.Name == "Action" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Expected Action in categories of %q, got %+v", posts[0].Title, posts[0].Categories)
+	}
+	if len(posts[0].Categories) != 3 {
+		t.Errorf("Expected all 3 categories of %q, got %d", posts[0].Title, len(posts[0].Categories))
+	}
+}
+
+func TestCategoryMigrationParsesLegacyStrings(t *testing.T) {
+	store := setupTestDB(t)
+	db := store.DB()
+
+	// Step back to the comma-joined column and write a post the way the old
+	// NewPostHandler did, with emoji prefixes and ", " separators.
+	migrateDownTo(t, store, 3)
+	result, err := db.Exec(`
+        INSERT INTO posts (title, content, user_id, categories)
+        VALUES ('Legacy Post', 'Written before categories were normalized.', 1, '💥 Action, 😂 Comedy')
+    `)
+	if err != nil {
+		t.Fatalf("Inserting legacy post failed: %v", err)
+	}
+	legacyID, _ := result.LastInsertId()
+
+	if err := store.MigrateUp(); err != nil {
+		t.Fatalf("MigrateUp failed: %v", err)
+	}
+
+	names := categoryNames(t, store, int(legacyID))
+	if !names["Action"] || !names["Comedy"] || len(names) != 2 {
+		t.Errorf("Legacy post categories: got %v, want Action and Comedy", names)
+	}
+
+	// Seeded posts used "," without emoji and must round-trip too
+	var seededID int
+	err = db.QueryRow("SELECT id FROM posts WHERE title LIKE 'Whispers of the West%'").Scan(&seededID)
+	if err != nil {
+		t.Fatalf("Finding seeded post failed: %v", err)
+	}
+	names = categoryNames(t, store, seededID)
+	if !names["Western"] || !names["Horror"] || !names["Mystery"] {
+		t.Errorf("Seeded post categories: got %v, want Western, Horror and Mystery", names)
+	}
+}
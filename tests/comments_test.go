@@ -0,0 +1,133 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"forum-go/database"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func firstPostID(t *testing.T, store *database.Store) int {
+	t.Helper()
+	var id int
+	if err := store.DB().QueryRow("SELECT id FROM posts ORDER BY id LIMIT 1").Scan(&id); err != nil {
+		t.Fatalf("Finding a post failed: %v", err)
+	}
+	return id
+}
+
+func createComment(t *testing.T, store *database.Store, postID, parentID int, content string) int {
+	t.Helper()
+	id, err := store.CreateComment(postID, 1, parentID, content)
+	if err != nil {
+		t.Fatalf("CreateComment(%q) failed: %v", content, err)
+	}
+	return int(id)
+}
+
+func TestFetchCommentTreeNestsReplies(t *testing.T) {
+	store := setupTestDB(t)
+	postID := firstPostID(t, store)
+
+	root := createComment(t, store, postID, 0, "root")
+	reply := createComment(t, store, postID, root, "reply")
+	createComment(t, store, postID, reply, "reply to reply")
+	createComment(t, store, postID, 0, "second root")
+
+	tree, err := store.FetchCommentTree(postID, database.DefaultMaxCommentDepth)
+	if err != nil {
+		t.Fatalf("FetchCommentTree failed: %v", err)
+	}
+	if len(tree) != 2 {
+		t.Fatalf("Expected 2 top-level comments, got %d", len(tree))
+	}
+	if len(tree[0].Replies) != 1 || tree[0].Replies[0].Content != "reply" {
+		t.Fatalf("Expected one reply under the first root, got %+v", tree[0].Replies)
+	}
+	nested := tree[0].Replies[0].Replies
+	if len(nested) != 1 || nested[0].Depth != 2 {
+		t.Errorf("Expected a depth-2 reply, got %+v", nested)
+	}
+}
+
+func TestFetchCommentTreeCapsDepth(t *testing.T) {
+	store := setupTestDB(t)
+	postID := firstPostID(t, store)
+
+	parent := 0
+	for i := 0; i < 5; i++ {
+		parent = createComment(t, store, postID, parent, "level")
+	}
+
+	tree, err := store.FetchCommentTree(postID, 2)
+	if err != nil {
+		t.Fatalf("FetchCommentTree failed: %v", err)
+	}
+
+	// Levels 3 and 4 stay at depth 2, next to the level 2 comment they answer
+	level1 := tree[0].Replies[0]
+	if len(level1.Replies) != 3 {
+		t.Fatalf("Expected 3 replies at the depth cap, got %d", len(level1.Replies))
+	}
+	for _, r := range level1.Replies {
+		if r.Depth != 2 || len(r.Replies) != 0 {
+			t.Errorf("Reply %d at the cap: depth %d with %d replies", r.ID, r.Depth, len(r.Replies))
+		}
+	}
+}
+
+func TestCreateCommentRejectsParentFromOtherPost(t *testing.T) {
+	store := setupTestDB(t)
+	db := store.DB()
+
+	var otherPostID int
+	if err := db.QueryRow("SELECT id FROM posts ORDER BY id DESC LIMIT 1").Scan(&otherPostID); err != nil {
+		t.Fatalf("Finding a second post failed: %v", err)
+	}
+	postID := firstPostID(t, store)
+	if postID == otherPostID {
+		t.Skip("Need at least two seeded posts")
+	}
+
+	parent := createComment(t, store, otherPostID, 0, "elsewhere")
+	if _, err := store.CreateComment(postID, 1, parent, "cross-post"); !errors.Is(err, database.ErrParentNotFound) {
+		t.Errorf("Expected ErrParentNotFound, got %v", err)
+	}
+	if _, err := store.CreateComment(postID, 1, 99999, "orphan"); !errors.Is(err, database.ErrParentNotFound) {
+		t.Errorf("Expected ErrParentNotFound for a missing parent, got %v", err)
+	}
+}
+
+func TestSubmitCommentHandlerReply(t *testing.T) {
+	app, store := setupTestApp(t)
+	postID := firstPostID(t, store)
+	parent := createComment(t, store, postID, 0, "root")
+
+	form := url.Values{
+		"post_id":   {strconv.Itoa(postID)},
+		"parent_id": {strconv.Itoa(parent)},
+		"content":   {"a reply"},
+	}
+	req := httptest.NewRequest(http.MethodPost, "/submitComment", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req = req.WithContext(context.WithValue(req.Context(), "user_id", 1))
+	rr := httptest.NewRecorder()
+	app.SubmitCommentHandler(rr, req)
+
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("SubmitCommentHandler status: got %v, want %v", rr.Code, http.StatusSeeOther)
+	}
+
+	tree, err := store.FetchCommentTree(postID, database.DefaultMaxCommentDepth)
+	if err != nil {
+		t.Fatalf("FetchCommentTree failed: %v", err)
+	}
+	if len(tree) != 1 || len(tree[0].Replies) != 1 {
+		t.Errorf("Expected the reply under its parent, got %+v", tree)
+	}
+}
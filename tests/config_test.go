@@ -0,0 +1,184 @@
+package tests
+
+import (
+	"forum-go/config"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// envOf is a lookup over a fixed set of environment variables.
+func envOf(vars map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		v, ok := vars[name]
+		return v, ok
+	}
+}
+
+func writeConfig(t *testing.T, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "forum.json")
+	if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+		t.Fatalf("Writing config file failed: %v", err)
+	}
+	return path
+}
+
+func TestConfigDefaults(t *testing.T) {
+	cfg, args, err := config.Load(nil, envOf(nil))
+	if err != nil {
+		t.Fatalf("Load with nothing set failed: %v", err)
+	}
+	if len(args) != 0 {
+		t.Errorf("Expected no arguments left, got %v", args)
+	}
+	want := config.Default()
+	want.Server.BaseURL = "http://localhost:8999"
+	if !reflect.DeepEqual(cfg, want) {
+		t.Errorf("Expected the defaults, got %+v", cfg)
+	}
+	if cfg.Server.Port != 8999 || cfg.Database.Path != "reeltalk.db" || cfg.Sessions.TTL.Duration != 24*time.Hour {
+		t.Errorf("Unexpected defaults: %+v", cfg)
+	}
+}
+
+func TestConfigPrecedence(t *testing.T) {
+	path := writeConfig(t, `{
+		"server": {"port": 8000, "base_url": "https://file.example.com"},
+		"database": {"path": "file.db"},
+		"sessions": {"ttl": "2h", "cookie_samesite": "strict"},
+		"login": {"lockout_duration": "30m"}
+	}`)
+	env := envOf(map[string]string{
+		"CONFIG_FILE":       path,
+		"PORT":              "8100",
+		"DB_PATH":           "env.db",
+		"SESSION_TTL_HOURS": "6",
+		"CORS_ORIGINS":      " https://a.example.com, ,https://b.example.com",
+	})
+
+	cfg, args, err := config.Load([]string{"-db", "flag.db", "migrate", "status"}, env)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	if cfg.Database.Path != "flag.db" {
+		t.Errorf("Flag should win over the environment, got database path %q", cfg.Database.Path)
+	}
+	if cfg.Server.Port != 8100 {
+		t.Errorf("Environment should win over the file, got port %d", cfg.Server.Port)
+	}
+	if cfg.Sessions.TTL.Duration != 6*time.Hour {
+		t.Errorf("SESSION_TTL_HOURS should count hours, got %v", cfg.Sessions.TTL)
+	}
+	if cfg.Server.BaseURL != "https://file.example.com" || cfg.Sessions.CookieSameSite != "strict" {
+		t.Errorf("File settings were not applied: %+v", cfg)
+	}
+	if cfg.Login.LockoutDuration.Duration != 30*time.Minute {
+		t.Errorf("Expected a 30m lockout from the file, got %v", cfg.Login.LockoutDuration)
+	}
+	if cfg.Sessions.RememberTTL.Duration != 30*24*time.Hour {
+		t.Errorf("Settings left out of the file should keep their defaults, got %v", cfg.Sessions.RememberTTL)
+	}
+	if want := []string{"https://a.example.com", "https://b.example.com"}; !reflect.DeepEqual(cfg.Server.CORSOrigins, want) {
+		t.Errorf("Expected origins %v, got %v", want, cfg.Server.CORSOrigins)
+	}
+	if want := []string{"migrate", "status"}; !reflect.DeepEqual(args, want) {
+		t.Errorf("Expected %v left after the flags, got %v", want, args)
+	}
+
+	// -config names the file even when CONFIG_FILE is set
+	other := writeConfig(t, `{"database": {"path": "other.db"}}`)
+	cfg, _, err = config.Load([]string{"-config", other}, envOf(map[string]string{"CONFIG_FILE": path}))
+	if err != nil {
+		t.Fatalf("Load with -config failed: %v", err)
+	}
+	if cfg.Database.Path != "other.db" {
+		t.Errorf("Expected the file given by -config, got database path %q", cfg.Database.Path)
+	}
+}
+
+func TestConfigValidation(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		want string
+	}{
+		{"port", map[string]string{"PORT": "70000"}, "server.port"},
+		{"not a number", map[string]string{"PORT": "eighty"}, "PORT"},
+		{"base url", map[string]string{"BASE_URL": "forum.example.com"}, "server.base_url"},
+		{"smtp without base url", map[string]string{"SMTP_ADDR": "smtp.example.com:587"}, "server.base_url"},
+		{"database driver", map[string]string{"DB_DRIVER": "mysql"}, "database.driver"},
+		{"postgres without url", map[string]string{"DB_DRIVER": "postgres"}, "database.url"},
+		{"samesite", map[string]string{"COOKIE_SAMESITE": "sometimes"}, "sessions.cookie_samesite"},
+		{"two-factor role", map[string]string{"TWO_FACTOR_ROLE": "user"}, "login.two_factor_role"},
+		{"comment depth", map[string]string{"COMMENT_MAX_DEPTH": "0"}, "forum.comment_max_depth"},
+		{"oauth secret", map[string]string{"GITHUB_CLIENT_ID": "id"}, "oauth.github"},
+		{"oauth without base url", map[string]string{"GOOGLE_CLIENT_ID": "id", "GOOGLE_CLIENT_SECRET": "secret"}, "server.base_url"},
+		{"tls key", map[string]string{"TLS_CERT_FILE": "cert.pem"}, "server.tls_key"},
+		{"tls files", map[string]string{"TLS_CERT_FILE": "missing-cert.pem", "TLS_KEY_FILE": "missing-key.pem"}, "missing-cert.pem"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := config.Load(nil, envOf(tt.env))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Expected an error about %s, got %v", tt.want, err)
+			}
+		})
+	}
+
+	// Every problem is reported at once
+	_, _, err := config.Load(nil, envOf(map[string]string{"PORT": "0", "TWO_FACTOR_ROLE": "owner"}))
+	if err == nil || !strings.Contains(err.Error(), "server.port") || !strings.Contains(err.Error(), "login.two_factor_role") {
+		t.Errorf("Expected both problems in one error, got %v", err)
+	}
+
+	path := writeConfig(t, `{"server": {"write_timeout": "-1s", "shutdown_timeout": "0s"}}`)
+	_, _, err = config.Load([]string{"-config", path}, envOf(nil))
+	if err == nil || !strings.Contains(err.Error(), "server.write_timeout") || !strings.Contains(err.Error(), "server.shutdown_timeout") {
+		t.Errorf("Expected errors about the timeouts, got %v", err)
+	}
+
+	// Serving HTTPS makes the session cookie Secure
+	cert, key := writeTestCertificate(t)
+	cfg, _, err := config.Load([]string{"-tls-cert", cert, "-tls-key", key}, envOf(nil))
+	if err != nil {
+		t.Fatalf("Load with TLS files failed: %v", err)
+	}
+	if cfg.Server.TLSCert != cert || !cfg.Sessions.CookieSecure {
+		t.Errorf("Expected HTTPS with secure cookies, got %+v", cfg.Server)
+	}
+
+	// SameSite=None cookies must be Secure, so it turns Secure on
+	cfg, _, err = config.Load(nil, envOf(map[string]string{"COOKIE_SAMESITE": "None"}))
+	if err != nil {
+		t.Fatalf("Load with SameSite none failed: %v", err)
+	}
+	if cfg.Sessions.CookieSameSite != "none" || !cfg.Sessions.CookieSecure {
+		t.Errorf("Expected secure SameSite=None cookies, got %+v", cfg.Sessions)
+	}
+}
+
+func TestConfigFileErrors(t *testing.T) {
+	files := map[string]string{
+		"unknown setting": `{"server": {"prot": 8000}}`,
+		"bad duration":    `{"sessions": {"ttl": "a day"}}`,
+		"number duration": `{"sessions": {"ttl": 3600}}`,
+		"not json":        `port = 8000`,
+	}
+	for name, body := range files {
+		t.Run(name, func(t *testing.T) {
+			path := writeConfig(t, body)
+			if _, _, err := config.Load([]string{"-config", path}, envOf(nil)); err == nil {
+				t.Error("Expected the file to be rejected")
+			}
+		})
+	}
+
+	if _, _, err := config.Load([]string{"-config", filepath.Join(t.TempDir(), "missing.json")}, envOf(nil)); err == nil {
+		t.Error("Expected a missing config file to be an error")
+	}
+}
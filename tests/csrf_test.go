@@ -0,0 +1,176 @@
+package tests
+
+import (
+	"bytes"
+	"forum-go/csrf"
+	"forum-go/middleware"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// seedCookie returns the CSRF seed cookie a first GET hands out.
+func seedCookie(t *testing.T, h http.Handler) *http.Cookie {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+	for _, c := range rr.Result().Cookies() {
+		if c.Name == "csrf_seed" {
+			return c
+		}
+	}
+	t.Fatal("GET did not set a CSRF seed cookie")
+	return nil
+}
+
+func TestCSRFMiddleware(t *testing.T) {
+	guard := csrf.New("test key")
+	h := middleware.CSRF(guard, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	seed := seedCookie(t, h)
+	session := &http.Cookie{Name: "session_token", Value: "session-1"}
+
+	post := func(token string, inHeader bool, cookies ...*http.Cookie) int {
+		form := url.Values{}
+		if token != "" && !inHeader {
+			form.Set(csrf.FieldName, token)
+		}
+		req := httptest.NewRequest(http.MethodPost, "/vote", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if inHeader {
+			req.Header.Set(csrf.HeaderName, token)
+		}
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	// The token the server would have put in the page
+	page := httptest.NewRequest(http.MethodGet, "/", nil)
+	page.AddCookie(seed)
+	page.AddCookie(session)
+	token := guard.Token(page)
+
+	if code := post("", false, seed, session); code != http.StatusForbidden {
+		t.Errorf("No token: got %v, want 403", code)
+	}
+	if code := post("forged", false, seed, session); code != http.StatusForbidden {
+		t.Errorf("Wrong token: got %v, want 403", code)
+	}
+	if code := post(token, false, seed, session); code != http.StatusOK {
+		t.Errorf("Form token: got %v, want 200", code)
+	}
+	if code := post(token, true, seed, session); code != http.StatusOK {
+		t.Errorf("Header token: got %v, want 200", code)
+	}
+
+	// Tokens are bound to the session and to the browser's seed
+	if code := post(token, false, seed, &http.Cookie{Name: "session_token", Value: "session-2"}); code != http.StatusForbidden {
+		t.Errorf("Token of another session: got %v, want 403", code)
+	}
+	if code := post(token, false, session); code != http.StatusForbidden {
+		t.Errorf("Token without its seed: got %v, want 403", code)
+	}
+	if code := post(csrf.New("other key").Token(page), false, seed, session); code != http.StatusForbidden {
+		t.Errorf("Token signed with another key: got %v, want 403", code)
+	}
+
+	// Bearer clients cannot be forged by another site
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/posts/1/vote", nil)
+	req.Header.Set("Authorization", "Bearer abc")
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Errorf("Bearer request: got %v, want 200", rr.Code)
+	}
+
+	// Only bearer tokens are exempt; other schemes still need the token
+	req = httptest.NewRequest(http.MethodPost, "/vote", nil)
+	req.Header.Set("Authorization", "Basic x")
+	req.AddCookie(seed)
+	req.AddCookie(session)
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("Cookie request with Basic authorization and no token: got %v, want 403", rr.Code)
+	}
+}
+
+func TestCSRFBearerRequestsDropSessionCookie(t *testing.T) {
+	var sawSession bool
+	h := middleware.CSRF(csrf.New("test key"), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err := r.Cookie("session_token")
+		sawSession = err == nil
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/posts/1/vote", nil)
+	req.Header.Set("Authorization", "Bearer abc")
+	req.AddCookie(&http.Cookie{Name: "session_token", Value: "session-1"})
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Bearer request: got %v, want 200", rr.Code)
+	}
+	if sawSession {
+		t.Error("Bearer request should not carry the session cookie past the CSRF check")
+	}
+}
+
+func TestRenderInjectsCSRFToken(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(&http.Cookie{Name: "csrf_seed", Value: "seed"})
+	want := `value="` + testTemplates.CSRF.Token(req) + `"`
+
+	// Rendering twice makes sure the shared set is never executed itself
+	for i := 0; i < 2; i++ {
+		var buf bytes.Buffer
+		if err := testTemplates.ExecuteTemplate(&buf, req, "csrf-field", nil); err != nil {
+			t.Fatalf("ExecuteTemplate failed: %v", err)
+		}
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("Expected %s in %q", want, buf.String())
+		}
+	}
+}
+
+func TestEnableCORSAllowlist(t *testing.T) {
+	h := middleware.EnableCORS([]string{"https://app.example.com/"}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for _, tc := range []struct {
+		origin string
+		want   string
+	}{
+		{"https://app.example.com", "https://app.example.com"},
+		{"https://evil.example.com", ""},
+		{"", ""},
+	} {
+		req := httptest.NewRequest(http.MethodOptions, "/api/v1/posts", nil)
+		if tc.origin != "" {
+			req.Header.Set("Origin", tc.origin)
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		if got := rr.Header().Get("Access-Control-Allow-Origin"); got != tc.want {
+			t.Errorf("Origin %q: Allow-Origin %q, want %q", tc.origin, got, tc.want)
+		}
+		if rr.Code != http.StatusNoContent {
+			t.Errorf("Preflight: got %v, want 204", rr.Code)
+		}
+	}
+}
+
+func TestLogoutRequiresPost(t *testing.T) {
+	app, _ := setupTestApp(t)
+	rr := httptest.NewRecorder()
+	app.LogoutHandler(rr, httptest.NewRequest(http.MethodGet, "/logout", nil))
+	if rr.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET /logout: got %v, want 405", rr.Code)
+	}
+}
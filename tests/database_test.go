@@ -1,34 +1,43 @@
 package tests
 
 import (
+	"context"
 	"database/sql"
 	"forum-go/auth"
 	"forum-go/database"
+	"forum-go/handler"
+	"path/filepath"
 	"testing"
 
 	_ "github.com/mattn/go-sqlite3"
 )
 
-func setupTestDB(t *testing.T) *sql.DB {
-	database.DB = nil
-	db, err := sql.Open("sqlite3", ":memory:")
+func setupTestDB(t *testing.T) *database.Store {
+	db, err := sql.Open("sqlite3", database.DSN(":memory:"))
 	if err != nil {
 		t.Fatalf("Failed to open in-memory sqlite database: %v", err)
 	}
+	t.Cleanup(func() { db.Close() })
 
-	database.DB = db
-
-	if err := database.InitDB(); err != nil {
+	store := database.NewStore(db)
+	if err := store.Init(); err != nil {
 		t.Fatalf("InitDB failed on memory DB: %v", err)
 	}
 
-	return db
+	return store
+}
+
+// setupTestApp is an App over a fresh in-memory database, which is
+// returned along with it for checking what the handlers stored.
+func setupTestApp(t *testing.T) (*handler.App, *database.Store) {
+	store := setupTestDB(t)
+	return handler.NewApp(store, auth.NewStore(store.DB(), ""), testTemplates), store
 }
 
 func TestDatabaseCategoriesAndPosts(t *testing.T) {
-	_ = setupTestDB(t)
+	store := setupTestDB(t)
 
-	categories, err := database.FetchCategories()
+	categories, err := store.FetchCategories()
 	if err != nil {
 		t.Fatalf("FetchCategories failed: %v", err)
 	}
@@ -36,7 +45,7 @@ func TestDatabaseCategoriesAndPosts(t *testing.T) {
 		t.Error("Expected categories to be populated, got 0")
 	}
 
-	posts, err := database.FetchPosts()
+	posts, err := store.FetchPosts()
 	if err != nil {
 		t.Fatalf("FetchPosts failed: %v", err)
 	}
@@ -46,18 +55,19 @@ func TestDatabaseCategoriesAndPosts(t *testing.T) {
 }
 
 func TestAuthAddUserAndGetInfo(t *testing.T) {
-	db := setupTestDB(t)
+	db := setupTestDB(t).DB()
+	accounts := auth.NewStore(db, "")
 
 	testUsername := "testuser99"
 	testEmail := "testuser99@example.com"
 	testPassword := "TestPass123!"
 
-	err := auth.AddUser(db, testUsername, testEmail, testPassword)
+	err := accounts.AddUser(testUsername, testEmail, testPassword)
 	if err != nil {
 		t.Fatalf("AddUser failed: %v", err)
 	}
 
-	user, err := auth.GetUserInfo(db, testUsername)
+	user, err := accounts.GetUserInfo(testUsername)
 	if err != nil {
 		t.Fatalf("GetUserInfo failed: %v", err)
 	}
@@ -69,3 +79,35 @@ func TestAuthAddUserAndGetInfo(t *testing.T) {
 		t.Errorf("GetUserInfo email mismatch: got %v, want %v", user.Email, testEmail)
 	}
 }
+
+func TestForeignKeysOnEveryConnection(t *testing.T) {
+	store, err := database.OpenDB(filepath.Join(t.TempDir(), "forum.db"))
+	if err != nil {
+		t.Fatalf("OpenDB failed: %v", err)
+	}
+	defer store.Close()
+	if err := store.Init(); err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+
+	// Hold several connections at once so the pool has to open new ones
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		conn, err := store.DB().Conn(ctx)
+		if err != nil {
+			t.Fatalf("Conn failed: %v", err)
+		}
+		defer conn.Close()
+
+		var enabled int
+		if err := conn.QueryRowContext(ctx, "PRAGMA foreign_keys").Scan(&enabled); err != nil {
+			t.Fatalf("Reading foreign_keys failed: %v", err)
+		}
+		if enabled != 1 {
+			t.Errorf("Connection %d has foreign keys off", i)
+		}
+		if _, err := conn.ExecContext(ctx, "INSERT INTO votes (user_id, post_id, vote) VALUES (9999, 9999, 1)"); err == nil {
+			t.Errorf("Connection %d accepted a vote for a missing user and post", i)
+		}
+	}
+}
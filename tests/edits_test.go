@@ -0,0 +1,227 @@
+package tests
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"forum-go/database"
+	"forum-go/pkg/utils"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestUpdatePostKeepsRevisions(t *testing.T) {
+	store := setupTestDB(t)
+	postID := firstPostID(t, store)
+
+	post, err := store.FetchPostByID(postID)
+	if err != nil {
+		t.Fatalf("FetchPostByID failed: %v", err)
+	}
+	if !post.EditedAt.IsZero() {
+		t.Fatal("A new post should not be marked as edited")
+	}
+	original := post.Content
+
+	if err := store.UpdatePost(postID, post.UserID, post.Title, "First edit"); err != nil {
+		t.Fatalf("UpdatePost failed: %v", err)
+	}
+	if err := store.UpdatePost(postID, post.UserID, "Renamed post", "Second edit"); err != nil {
+		t.Fatalf("UpdatePost failed: %v", err)
+	}
+
+	post, err = store.FetchPostByID(postID)
+	if err != nil {
+		t.Fatalf("FetchPostByID failed: %v", err)
+	}
+	if post.Title != "Renamed post" || post.Content != "Second edit" || post.EditedAt.IsZero() {
+		t.Errorf("Edited post: got %q / %q, edited at %v", post.Title, post.Content, post.EditedAt)
+	}
+
+	revisions, err := store.FetchPostRevisions(postID)
+	if err != nil {
+		t.Fatalf("FetchPostRevisions failed: %v", err)
+	}
+	if len(revisions) != 2 || revisions[0].Content != original || revisions[1].Content != "First edit" {
+		t.Errorf("Revisions should hold the replaced versions in order, got %+v", revisions)
+	}
+}
+
+func TestUpdatePostRejectsOthers(t *testing.T) {
+	store := setupTestDB(t)
+	db := store.DB()
+	postID := firstPostID(t, store)
+
+	var authorID int
+	if err := db.QueryRow("SELECT user_id FROM posts WHERE id = ?", postID).Scan(&authorID); err != nil {
+		t.Fatalf("Finding author failed: %v", err)
+	}
+
+	if err := store.UpdatePost(postID, authorID+1, "Hijacked", "Hijacked"); !errors.Is(err, database.ErrNotAuthor) {
+		t.Errorf("UpdatePost by another user: got %v, want ErrNotAuthor", err)
+	}
+	if err := store.DeletePost(postID, authorID+1); !errors.Is(err, database.ErrNotAuthor) {
+		t.Errorf("DeletePost by another user: got %v, want ErrNotAuthor", err)
+	}
+
+	var other string
+	if err := db.QueryRow("SELECT title FROM posts WHERE id != ? LIMIT 1", postID).Scan(&other); err != nil {
+		t.Fatalf("Finding another post failed: %v", err)
+	}
+	if err := store.UpdatePost(postID, authorID, other, "Same title"); !errors.Is(err, database.ErrDuplicateTitle) {
+		t.Errorf("UpdatePost to a taken title: got %v, want ErrDuplicateTitle", err)
+	}
+}
+
+func TestDeletePostHidesEverywhere(t *testing.T) {
+	store := setupTestDB(t)
+	db := store.DB()
+
+	var postID, authorID int
+	err := db.QueryRow("SELECT id, user_id FROM posts WHERE title LIKE 'Whispers%'").Scan(&postID, &authorID)
+	if err != nil {
+		t.Fatalf("Finding post failed: %v", err)
+	}
+
+	if err := store.DeletePost(postID, authorID); err != nil {
+		t.Fatalf("DeletePost failed: %v", err)
+	}
+
+	if _, err := store.FetchPostByID(postID); err != sql.ErrNoRows {
+		t.Errorf("FetchPostByID on a deleted post: got %v, want sql.ErrNoRows", err)
+	}
+	posts, err := store.FetchPosts()
+	if err != nil {
+		t.Fatalf("FetchPosts failed: %v", err)
+	}
+	for _, p := range posts {
+		if p.ID == postID {
+			t.Error("Deleted post should not be listed")
+		}
+	}
+	results, err := store.SearchPosts(database.SearchOptions{Query: "Whispers"})
+	if err != nil {
+		t.Fatalf("SearchPosts failed: %v", err)
+	}
+	if len(results) != 0 {
+		t.Errorf("Deleted post should not be searchable, got %+v", results)
+	}
+	if _, err := store.CreateComment(postID, 1, 0, "too late"); err != sql.ErrNoRows {
+		t.Errorf("Commenting on a deleted post: got %v, want sql.ErrNoRows", err)
+	}
+}
+
+func TestDeleteCommentKeepsThreadAndVotes(t *testing.T) {
+	store := setupTestDB(t)
+	db := store.DB()
+	postID := firstPostID(t, store)
+
+	parent := createComment(t, store, postID, 0, "soon gone")
+	createComment(t, store, postID, parent, "still here")
+	if _, err := db.Exec("INSERT INTO votes (user_id, comment_id, vote) VALUES (2, ?, 1)", parent); err != nil {
+		t.Fatalf("Voting failed: %v", err)
+	}
+
+	if err := store.UpdateComment(parent, 1, "edited first"); err != nil {
+		t.Fatalf("UpdateComment failed: %v", err)
+	}
+	if err := store.DeleteComment(parent, 1); err != nil {
+		t.Fatalf("DeleteComment failed: %v", err)
+	}
+
+	tree, err := store.FetchCommentTree(postID, database.DefaultMaxCommentDepth)
+	if err != nil {
+		t.Fatalf("FetchCommentTree failed: %v", err)
+	}
+	if len(tree) != 1 {
+		t.Fatalf("Expected the deleted comment to keep its place, got %d roots", len(tree))
+	}
+	root := tree[0]
+	if !root.Deleted || root.Content != "" || root.Author != "" {
+		t.Errorf("Deleted comment should be blanked, got %+v", root)
+	}
+	if root.Upvotes != 1 {
+		t.Errorf("Deleted comment votes: got %d, want 1", root.Upvotes)
+	}
+	if len(root.Replies) != 1 || root.Replies[0].Content != "still here" {
+		t.Errorf("Replies of a deleted comment should remain, got %+v", root.Replies)
+	}
+
+	if _, err := store.CreateComment(postID, 1, parent, "reply to deleted"); !errors.Is(err, database.ErrParentNotFound) {
+		t.Errorf("Replying to a deleted comment: got %v, want ErrParentNotFound", err)
+	}
+}
+
+func TestDiffLines(t *testing.T) {
+	tests := []struct {
+		name     string
+		old, new string
+		want     []utils.DiffLine
+	}{
+		{"Unchanged", "a\nb", "a\nb", []utils.DiffLine{
+			{Kind: utils.DiffSame, Text: "a"}, {Kind: utils.DiffSame, Text: "b"},
+		}},
+		{"Replaced Line", "a\nb\nc", "a\nx\nc", []utils.DiffLine{
+			{Kind: utils.DiffSame, Text: "a"},
+			{Kind: utils.DiffRemoved, Text: "b"},
+			{Kind: utils.DiffAdded, Text: "x"},
+			{Kind: utils.DiffSame, Text: "c"},
+		}},
+		{"From Empty", "", "a", []utils.DiffLine{{Kind: utils.DiffAdded, Text: "a"}}},
+		{"CRLF", "a\r\nb", "a\nb", []utils.DiffLine{
+			{Kind: utils.DiffSame, Text: "a"}, {Kind: utils.DiffSame, Text: "b"},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := utils.DiffLines(tt.old, tt.new)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("DiffLines() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEditCommentHandlerForbidsOthers(t *testing.T) {
+	app, store := setupTestApp(t)
+	postID := firstPostID(t, store)
+	commentID := createComment(t, store, postID, 0, "mine")
+
+	form := url.Values{"comment_id": {strconv.Itoa(commentID)}, "content": {"not yours"}}
+	req := httptest.NewRequest(http.MethodPost, "/editcomment", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req = req.WithContext(context.WithValue(req.Context(), "user_id", 2))
+	rr := httptest.NewRecorder()
+	app.EditCommentHandler(rr, req)
+
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("EditCommentHandler status: got %v, want %v", rr.Code, http.StatusForbidden)
+	}
+}
+
+func TestHistoryHandlerShowsDiff(t *testing.T) {
+	app, store := setupTestApp(t)
+	postID := firstPostID(t, store)
+	commentID := createComment(t, store, postID, 0, "before <b>")
+	if err := store.UpdateComment(commentID, 1, "after <b>"); err != nil {
+		t.Fatalf("UpdateComment failed: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/history?comment_id="+strconv.Itoa(commentID), nil)
+	rr := httptest.NewRecorder()
+	app.HistoryHandler(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("HistoryHandler status: got %v, want %v", rr.Code, http.StatusOK)
+	}
+	body := rr.Body.String()
+	if !strings.Contains(body, `diff-line removed">before &lt;b&gt;`) || !strings.Contains(body, `diff-line added">after &lt;b&gt;`) {
+		t.Errorf("Expected an escaped line diff in the history page:\n%s", body)
+	}
+}
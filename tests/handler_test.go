@@ -1,20 +1,47 @@
 package tests
 
 import (
+	"database/sql"
+	"errors"
+	"forum-go/csrf"
+	"forum-go/database"
 	"forum-go/handler"
+	"forum-go/live"
 	"forum-go/render"
+	"log"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
+	"strings"
 	"testing"
 )
 
+// testTemplates are parsed once for every test App.
+var testTemplates *render.Templates
+
 func TestMain(m *testing.M) {
 	// Ensure working directory is project root
 	if _, err := os.Stat("templates"); err != nil {
 		_ = os.Chdir("..")
 	}
-	render.InitTemplates()
+	templates, err := render.LoadTemplates()
+	if err != nil {
+		log.Fatalf("Loading templates failed: %v", err)
+	}
+	templates.CSRF = csrf.New("")
+	testTemplates = templates
+
+	// Every test database needs FTS5, so say so once instead of failing each test
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		log.Fatalf("Opening a test database failed: %v", err)
+	}
+	if err := database.NewStore(db).MigrateUp(); errors.Is(err, database.ErrNoFTS5) {
+		log.Fatalf("Run the tests with -tags sqlite_fts5: %v", err)
+	}
+	db.Close()
+
 	os.Exit(m.Run())
 }
 
@@ -25,7 +52,8 @@ func TestFaviconHandler(t *testing.T) {
 	}
 
 	rr := httptest.NewRecorder()
-	handler.FaviconHandler(rr, req)
+	app, _ := setupTestApp(t)
+	app.FaviconHandler(rr, req)
 
 	if rr.Code != http.StatusOK && rr.Code != http.StatusNotFound {
 		t.Errorf("FaviconHandler returned status code: got %v", rr.Code)
@@ -45,3 +73,61 @@ func TestErrorHandler(t *testing.T) {
 		t.Errorf("ErrorHandler returned status code: got %v, want %v", rr.Code, http.StatusNotFound)
 	}
 }
+
+// fakeSessions signs every request in as one user.
+type fakeSessions struct {
+	database.SessionStore
+	userID int
+}
+
+func (f fakeSessions) CheckUserLoggedIn(r *http.Request) (bool, int) {
+	return f.userID != 0, f.userID
+}
+
+// fakeVotes records votes instead of storing them.
+type fakeVotes struct {
+	database.VoteStore
+	votes map[int]int
+}
+
+func (f fakeVotes) TogglePostVote(userID, postID, vote int) (*database.VoteResult, error) {
+	if postID != 7 {
+		return nil, sql.ErrNoRows
+	}
+	if f.votes[userID] == vote {
+		vote = 0
+	}
+	f.votes[userID] = vote
+	return &database.VoteResult{Vote: vote, PostID: postID}, nil
+}
+
+func TestHandlersUseInjectedStores(t *testing.T) {
+	votes := fakeVotes{votes: map[int]int{3: 1}}
+	events := live.NewHub(0, live.DefaultReplayTTL)
+	app := &handler.App{Sessions: fakeSessions{userID: 3}, Votes: votes, Templates: testTemplates, PostEvents: events}
+	sub := events.Subscribe(7, "")
+	defer sub.Close()
+
+	vote := func(postID string) *httptest.ResponseRecorder {
+		form := url.Values{"post_id": {postID}, "vote": {"1"}}
+		req := httptest.NewRequest(http.MethodPost, "/vote", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		app.VoteHandler(rr, req)
+		return rr
+	}
+
+	// Voting again undoes the vote, which sends no notification
+	if rr := vote("7"); rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/viewpost?id=7" {
+		t.Errorf("Vote: got %v %q", rr.Code, rr.Header().Get("Location"))
+	}
+	if votes.votes[3] != 0 {
+		t.Errorf("Expected the vote to be undone, got %d", votes.votes[3])
+	}
+	if e := receive(t, sub); e.Type != live.EventVote {
+		t.Errorf("Expected the vote on the post's hub, got %+v", e)
+	}
+	if rr := vote("8"); rr.Code != http.StatusNotFound {
+		t.Errorf("Vote on a missing post: got %v, want 404", rr.Code)
+	}
+}
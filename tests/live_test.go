@@ -0,0 +1,251 @@
+package tests
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"forum-go/live"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func receive(t *testing.T, sub *live.Subscription) live.Event {
+	t.Helper()
+	select {
+	case e, ok := <-sub.Events:
+		if !ok {
+			t.Fatal("Subscription closed unexpectedly")
+		}
+		return e
+	case <-time.After(time.Second):
+		t.Fatal("No event received")
+	}
+	return live.Event{}
+}
+
+func TestHubPublishSubscribe(t *testing.T) {
+	hub := live.NewHub(10, live.DefaultReplayTTL)
+
+	sub := hub.Subscribe(1, "")
+	other := hub.Subscribe(2, "")
+	defer other.Close()
+
+	hub.Publish(1, live.EventVote, live.VoteUpdate{Upvotes: 3})
+	e := receive(t, sub)
+	if e.Type != live.EventVote || e.Topic != 1 || e.Data.(live.VoteUpdate).Upvotes != 3 {
+		t.Errorf("Unexpected event %+v", e)
+	}
+	select {
+	case e := <-other.Events:
+		t.Errorf("Subscriber of another post got %+v", e)
+	default:
+	}
+
+	sub.Close()
+	sub.Close()
+	if n := hub.Subscribers(1); n != 0 {
+		t.Errorf("Subscribers after Close: got %d, want 0", n)
+	}
+	if _, ok := <-sub.Events; ok {
+		t.Error("Events should be closed after Close")
+	}
+}
+
+func TestHubReplaysMissedEvents(t *testing.T) {
+	hub := live.NewHub(3, live.DefaultReplayTTL)
+
+	first := hub.Publish(1, live.EventComment, live.NewComment{CommentID: 1})
+	hub.Publish(1, live.EventComment, live.NewComment{CommentID: 2})
+	hub.Publish(2, live.EventComment, live.NewComment{CommentID: 3})
+	hub.Publish(1, live.EventComment, live.NewComment{CommentID: 4})
+
+	sub := hub.Subscribe(1, first.ID)
+	defer sub.Close()
+	if sub.Reset {
+		t.Fatal("Reset should not be set while the events are buffered")
+	}
+	var got []int
+	for _, e := range sub.Missed {
+		got = append(got, e.Data.(live.NewComment).CommentID)
+	}
+	if len(got) != 2 || got[0] != 2 || got[1] != 4 {
+		t.Errorf("Missed comments: got %v, want [2 4]", got)
+	}
+
+	// Events pushed out of the buffer cannot be replayed
+	for i := 5; i < 9; i++ {
+		hub.Publish(1, live.EventComment, live.NewComment{CommentID: i})
+	}
+	if late := hub.Subscribe(1, first.ID); !late.Reset || late.Missed != nil {
+		t.Errorf("Expected a reset for evicted events, got %+v", late)
+	}
+
+	// IDs from another run of the server or garbage also reset
+	for _, id := range []string{"otherrun-1", "nonsense"} {
+		if s := hub.Subscribe(1, id); !s.Reset {
+			t.Errorf("Subscribe(%q): expected Reset", id)
+		}
+	}
+}
+
+func TestHubDropsSlowSubscribers(t *testing.T) {
+	hub := live.NewHub(100, live.DefaultReplayTTL)
+	slow := hub.Subscribe(1, "")
+
+	for i := 0; i < 50; i++ {
+		hub.Publish(1, live.EventVote, live.VoteUpdate{Upvotes: i})
+	}
+
+	if n := hub.Subscribers(1); n != 0 {
+		t.Errorf("Slow subscriber should have been dropped, %d left", n)
+	}
+	count := 0
+	for range slow.Events {
+		count++
+	}
+	if count == 0 || count >= 50 {
+		t.Errorf("Expected the buffered events before the drop, got %d", count)
+	}
+	slow.Close()
+}
+
+func TestHubReclaimsIdleTopics(t *testing.T) {
+	ttl := 20 * time.Millisecond
+	hub := live.NewHub(100, ttl)
+
+	first := hub.Publish(1, live.EventComment, live.NewComment{CommentID: 1})
+	for i := 2; i <= 50; i++ {
+		hub.Publish(i, live.EventComment, live.NewComment{CommentID: i})
+	}
+	watched := hub.Subscribe(100, "")
+	defer watched.Close()
+
+	// A topic that never had an event goes as soon as its subscriber leaves
+	hub.Subscribe(101, "").Close()
+	if n := hub.Topics(); n != 51 {
+		t.Fatalf("Topics before the TTL: got %d, want 51", n)
+	}
+
+	time.Sleep(2 * ttl)
+	hub.Publish(100, live.EventVote, live.VoteUpdate{Upvotes: 1})
+	receive(t, watched)
+	if n := hub.Topics(); n != 1 {
+		t.Errorf("Topics after the TTL: got %d, want only the watched one", n)
+	}
+
+	// The replay buffer went with the topic, so the client has to reload
+	if sub := hub.Subscribe(1, first.ID); !sub.Reset {
+		t.Errorf("Resuming a reclaimed topic: expected Reset, got %+v", sub)
+	}
+}
+
+// readEvent reads lines from an event stream until the next event of type
+// typ and returns its data.
+func readEvent(t *testing.T, r *bufio.Reader, typ string) string {
+	t.Helper()
+	current := ""
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Reading event stream: %v", err)
+		}
+		line = strings.TrimRight(line, "\n")
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			current = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: ") && current == typ:
+			return strings.TrimPrefix(line, "data: ")
+		}
+	}
+}
+
+func TestPostEventsHandlerStreamsCommentsAndVotes(t *testing.T) {
+	app, store := setupTestApp(t)
+	db := store.DB()
+	db.SetMaxOpenConns(1)
+	postID := firstPostID(t, store)
+
+	srv := httptest.NewServer(http.HandlerFunc(app.PostEventsHandler))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/events/post?id="+strconv.Itoa(postID), nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type: got %q", ct)
+	}
+
+	stream := bufio.NewReader(resp.Body)
+	// The retry line is written after subscribing
+	if line, _ := stream.ReadString('\n'); !strings.HasPrefix(line, "retry: ") {
+		t.Fatalf("Expected a retry line first, got %q", line)
+	}
+
+	form := url.Values{"post_id": {strconv.Itoa(postID)}, "content": {"live comment"}}
+	req = httptest.NewRequest(http.MethodPost, "/submitComment", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req = req.WithContext(context.WithValue(req.Context(), "user_id", 1))
+	rr := httptest.NewRecorder()
+	app.SubmitCommentHandler(rr, req)
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("SubmitCommentHandler status: got %v", rr.Code)
+	}
+	var comment struct {
+		ID   int    `json:"id"`
+		HTML string `json:"html"`
+	}
+	if err := json.Unmarshal([]byte(readEvent(t, stream, live.EventComment)), &comment); err != nil {
+		t.Fatal(err)
+	}
+	commentID := comment.ID
+	if rr.Header().Get("Location") != fmt.Sprintf("/viewpost?id=%d#comment-%d", postID, commentID) || !strings.Contains(comment.HTML, `id="comment-`+strconv.Itoa(commentID)+`"`) ||
+		!strings.Contains(comment.HTML, "live comment") {
+		t.Errorf("Unexpected comment event %+v", comment)
+	}
+
+	form = url.Values{"post_id": {strconv.Itoa(postID)}, "vote": {"1"}}
+	req = httptest.NewRequest(http.MethodPost, "/vote", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(sessionCookie(t, store, 1))
+	rr = httptest.NewRecorder()
+	app.VoteHandler(rr, req)
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("VoteHandler status: got %v", rr.Code)
+	}
+	var update live.VoteUpdate
+	if err := json.Unmarshal([]byte(readEvent(t, stream, live.EventVote)), &update); err != nil {
+		t.Fatal(err)
+	}
+	if update.CommentID != 0 || update.Upvotes < 1 {
+		t.Errorf("Unexpected vote event %+v", update)
+	}
+}
+
+func TestPostEventsHandlerErrors(t *testing.T) {
+	app, _ := setupTestApp(t)
+
+	for _, tc := range []struct {
+		query string
+		want  int
+	}{
+		{"id=abc", http.StatusBadRequest},
+		{"id=999999", http.StatusNotFound},
+	} {
+		rr := httptest.NewRecorder()
+		app.PostEventsHandler(rr, httptest.NewRequest(http.MethodGet, "/events/post?"+tc.query, nil))
+		if rr.Code != tc.want {
+			t.Errorf("%s: got %v, want %v", tc.query, rr.Code, tc.want)
+		}
+	}
+}
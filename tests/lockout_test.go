@@ -0,0 +1,234 @@
+package tests
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"forum-go/auth"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a Limiter clock that only moves when told to.
+type fakeClock struct{ now time.Time }
+
+func (c *fakeClock) Now() time.Time          { return c.now }
+func (c *fakeClock) Advance(d time.Duration) { c.now = c.now.Add(d) }
+
+func testLimiter(policy auth.LockoutPolicy) (*auth.Limiter, *fakeClock) {
+	clock := &fakeClock{now: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)}
+	limiter := auth.NewLimiter(policy)
+	limiter.Now = clock.Now
+	return limiter, clock
+}
+
+func addLoginUser(t *testing.T, db *sql.DB, username string) {
+	t.Helper()
+	if err := auth.NewStore(db, "").AddUser(username, username+"@example.com", "Passw0rd!x"); err != nil {
+		t.Fatalf("AddUser failed: %v", err)
+	}
+}
+
+func lockedFor(err error) (time.Duration, bool, bool) {
+	var locked *auth.LockedError
+	if !errors.As(err, &locked) {
+		return 0, false, false
+	}
+	return locked.RetryAfter, locked.Locked, true
+}
+
+func TestLoginBackoffAndLockout(t *testing.T) {
+	db := setupTestDB(t).DB()
+	accounts := auth.NewStore(db, "")
+	addLoginUser(t, db, "robin")
+	limiter, clock := testLimiter(auth.LockoutPolicy{
+		Window:          time.Hour,
+		FreeAttempts:    2,
+		BaseDelay:       time.Second,
+		MaxDelay:        4 * time.Second,
+		LockoutAfter:    5,
+		LockoutDuration: 10 * time.Minute,
+	})
+
+	fail := func() error {
+		_, err := limiter.Authenticate(accounts, "robin", "wrong", "10.0.0.1")
+		return err
+	}
+
+	// The free attempts come back at once
+	for i := 0; i < 2; i++ {
+		if err := fail(); !errors.Is(err, auth.ErrInvalidCredentials) {
+			t.Fatalf("Attempt %d: got %v, want invalid credentials", i+1, err)
+		}
+	}
+
+	// Then every failure doubles the wait: 1s, 2s, 4s
+	for i, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second} {
+		wait, locked, ok := lockedFor(fail())
+		if !ok || locked || wait != want {
+			t.Fatalf("Backoff %d: got %v locked=%v ok=%v, want %v", i+1, wait, locked, ok, want)
+		}
+		// Attempts while waiting are refused without counting
+		clock.Advance(want / 2)
+		if _, _, ok := lockedFor(fail()); !ok {
+			t.Fatalf("Backoff %d: attempt during the wait was not refused", i+1)
+		}
+		clock.Advance(want / 2)
+		if err := fail(); !errors.Is(err, auth.ErrInvalidCredentials) {
+			t.Fatalf("Backoff %d: got %v after the wait", i+1, err)
+		}
+	}
+
+	// The fifth failure locked the account, even for the right password
+	_, err := limiter.Authenticate(accounts, "robin", "Passw0rd!x", "10.0.0.2")
+	wait, locked, ok := lockedFor(err)
+	if !ok || !locked || wait != 10*time.Minute {
+		t.Fatalf("Lockout: got %v locked=%v ok=%v", wait, locked, ok)
+	}
+	if err.Error() != "Too many failed login attempts. Login is locked for 10 minutes." {
+		t.Errorf("Unexpected message %q", err.Error())
+	}
+
+	clock.Advance(10 * time.Minute)
+	if _, err := limiter.Authenticate(accounts, "robin", "Passw0rd!x", "10.0.0.2"); err != nil {
+		t.Fatalf("Login after the lockout: %v", err)
+	}
+
+	// A successful login starts the count over
+	if err := fail(); !errors.Is(err, auth.ErrInvalidCredentials) {
+		t.Errorf("First failure after a success: got %v", err)
+	}
+
+	var failures, refused int
+	db.QueryRow("SELECT COUNT(*) FROM login_attempts WHERE username = 'robin' AND outcome = 'failure'").Scan(&failures)
+	db.QueryRow("SELECT COUNT(*) FROM login_attempts WHERE username = 'robin' AND outcome = 'locked'").Scan(&refused)
+	if failures != 6 || refused != 7 {
+		t.Errorf("Audit log: %d failures and %d refused, want 6 and 7", failures, refused)
+	}
+}
+
+func TestLoginFailuresExpireWithWindow(t *testing.T) {
+	db := setupTestDB(t).DB()
+	accounts := auth.NewStore(db, "")
+	addLoginUser(t, db, "robin")
+	limiter, clock := testLimiter(auth.LockoutPolicy{
+		Window:          time.Minute,
+		LockoutAfter:    2,
+		LockoutDuration: time.Hour,
+	})
+
+	limiter.Authenticate(accounts, "robin", "wrong", "10.0.0.1")
+	clock.Advance(2 * time.Minute)
+	limiter.Authenticate(accounts, "robin", "wrong", "10.0.0.1")
+	if _, err := limiter.Authenticate(accounts, "robin", "Passw0rd!x", "10.0.0.1"); err != nil {
+		t.Errorf("Failures outside the window must not count: %v", err)
+	}
+}
+
+func TestLoginIPLockout(t *testing.T) {
+	db := setupTestDB(t).DB()
+	accounts := auth.NewStore(db, "")
+	addLoginUser(t, db, "robin")
+	limiter, clock := testLimiter(auth.LockoutPolicy{
+		Window:          time.Hour,
+		LockoutDuration: 5 * time.Minute,
+		IPLockoutAfter:  3,
+	})
+
+	// Guessing across many accounts from one address
+	for _, name := range []string{"alice", "bob", "carol"} {
+		limiter.Authenticate(accounts, name, "guess", "10.0.0.9")
+	}
+	if _, _, ok := lockedFor(limiter.Check(accounts, "robin", "10.0.0.9")); !ok {
+		t.Error("Expected the address to be locked out")
+	}
+	if err := limiter.Check(accounts, "robin", "10.0.0.1"); err != nil {
+		t.Errorf("Other addresses are not affected, got %v", err)
+	}
+
+	clock.Advance(5 * time.Minute)
+	if err := limiter.Check(accounts, "robin", "10.0.0.9"); err != nil {
+		t.Errorf("The address lockout must expire, got %v", err)
+	}
+}
+
+func TestLoginLockoutUnderParallelGuesses(t *testing.T) {
+	db := setupTestDB(t).DB()
+	accounts := auth.NewStore(db, "")
+	db.SetMaxOpenConns(1)
+	addLoginUser(t, db, "robin")
+	limiter, _ := testLimiter(auth.LockoutPolicy{
+		Window:          time.Hour,
+		FreeAttempts:    10,
+		LockoutAfter:    3,
+		LockoutDuration: 10 * time.Minute,
+	})
+
+	// Every guess passing Check before the first failure is recorded would
+	// let all of them reach the password check
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			limiter.Authenticate(accounts, "robin", "guess", "10.0.0.1")
+		}()
+	}
+	wg.Wait()
+
+	var failures, locked int
+	db.QueryRow("SELECT COUNT(*) FROM login_attempts WHERE outcome = 'failure'").Scan(&failures)
+	db.QueryRow("SELECT COUNT(*) FROM login_attempts WHERE outcome = 'locked'").Scan(&locked)
+	if failures != 3 || locked != 17 {
+		t.Errorf("Expected 3 checked guesses and 17 refused, got %d and %d", failures, locked)
+	}
+}
+
+func TestLoginHandlerLockout(t *testing.T) {
+	app, store := setupTestApp(t)
+	db := store.DB()
+	addLoginUser(t, db, "robin")
+
+	app.Logins, _ = testLimiter(auth.LockoutPolicy{
+		Window:          time.Hour,
+		LockoutAfter:    1,
+		LockoutDuration: 90 * time.Second,
+	})
+
+	login := func(password string) *httptest.ResponseRecorder {
+		var b bytes.Buffer
+		w := multipart.NewWriter(&b)
+		_ = w.WriteField("username", "robin")
+		_ = w.WriteField("password", password)
+		w.Close()
+		req := httptest.NewRequest(http.MethodPost, "/login", &b)
+		req.Header.Set("Content-Type", w.FormDataContentType())
+		rr := httptest.NewRecorder()
+		app.LoginHandler(rr, req)
+		return rr
+	}
+
+	if rr := login("wrong"); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("Wrong password: got %v, want 401", rr.Code)
+	}
+	rr := login("Passw0rd!x")
+	if rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("Locked account: got %v, want 429", rr.Code)
+	}
+	if got := rr.Header().Get("Retry-After"); got != "90" {
+		t.Errorf("Retry-After: got %q, want 90", got)
+	}
+	if body := rr.Body.String(); body != "Too many failed login attempts. Login is locked for 2 minutes.\n" {
+		t.Errorf("Unexpected message %q", body)
+	}
+
+	var ip string
+	db.QueryRow("SELECT ip FROM login_attempts WHERE outcome = 'locked'").Scan(&ip)
+	if ip != "192.0.2.1" {
+		t.Errorf("Expected the client address in the audit log, got %q", ip)
+	}
+}
@@ -0,0 +1,260 @@
+package tests
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"forum-go/database"
+	"forum-go/middleware"
+	"forum-go/model"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func sendMessage(t *testing.T, store *database.Store, senderID, recipientID int, body string) int {
+	t.Helper()
+	msg, err := store.SendMessage(senderID, recipientID, body)
+	if err != nil {
+		t.Fatalf("SendMessage(%q) failed: %v", body, err)
+	}
+	return msg.ID
+}
+
+func TestSendMessageAndInbox(t *testing.T) {
+	store := setupTestDB(t)
+	db := store.DB()
+	mamaID := userIDByName(t, db, "Mama")
+	batmanID := userIDByName(t, db, "batman")
+	adminID := userIDByName(t, db, "admin")
+
+	first := sendMessage(t, store, mamaID, batmanID, "hi batman")
+	sendMessage(t, store, batmanID, mamaID, "hello Mama")
+	third := sendMessage(t, store, mamaID, batmanID, "how are you?")
+	sendMessage(t, store, adminID, batmanID, "welcome")
+
+	conversations, err := store.FetchConversations(batmanID)
+	if err != nil {
+		t.Fatalf("FetchConversations failed: %v", err)
+	}
+	if len(conversations) != 2 {
+		t.Fatalf("Expected 2 conversations, got %d", len(conversations))
+	}
+	if c := conversations[0]; c.Other != "admin" || c.Unread != 1 || c.LastMessage.Body != "welcome" {
+		t.Errorf("Newest conversation first, got %+v", c)
+	}
+	if c := conversations[1]; c.Other != "Mama" || c.Unread != 2 || c.LastMessage.ID != third {
+		t.Errorf("Unexpected conversation %+v", c)
+	}
+
+	user, err := store.FetchUserById(batmanID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.UnreadMessages != 3 {
+		t.Errorf("UnreadMessages: got %d, want 3", user.UnreadMessages)
+	}
+
+	messages, err := store.FetchMessages(batmanID, mamaID, 0, 2)
+	if err != nil {
+		t.Fatalf("FetchMessages failed: %v", err)
+	}
+	if len(messages) != 2 || messages[0].Body != "hello Mama" || messages[1].Body != "how are you?" {
+		t.Errorf("Expected the latest two messages oldest first, got %+v", messages)
+	}
+	messages, err = store.FetchMessages(batmanID, mamaID, first, 10)
+	if err != nil {
+		t.Fatalf("FetchMessages failed: %v", err)
+	}
+	if len(messages) != 2 || messages[0].Sender != "batman" {
+		t.Errorf("Expected the two messages after the first, got %+v", messages)
+	}
+
+	if err := store.MarkConversationRead(batmanID, mamaID); err != nil {
+		t.Fatalf("MarkConversationRead failed: %v", err)
+	}
+	if n, _ := store.UnreadMessageCount(batmanID); n != 1 {
+		t.Errorf("Unread after reading Mama's messages: got %d, want 1", n)
+	}
+	if n, _ := store.UnreadMessageCount(mamaID); n != 1 {
+		t.Errorf("Reading must not touch the other side's unread count, got %d", n)
+	}
+}
+
+func TestBlockedUsersCannotMessage(t *testing.T) {
+	store := setupTestDB(t)
+	db := store.DB()
+	mamaID := userIDByName(t, db, "Mama")
+	batmanID := userIDByName(t, db, "batman")
+
+	if _, err := store.SendMessage(mamaID, mamaID, "me"); !errors.Is(err, database.ErrSelfMessage) {
+		t.Errorf("Messaging yourself: got %v", err)
+	}
+	if _, err := store.SendMessage(mamaID, 9999, "anyone?"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("Messaging a missing user: got %v", err)
+	}
+
+	sendMessage(t, store, mamaID, batmanID, "spam")
+	if err := store.BlockUser(batmanID, mamaID); err != nil {
+		t.Fatalf("BlockUser failed: %v", err)
+	}
+	if _, err := store.SendMessage(mamaID, batmanID, "more spam"); !errors.Is(err, database.ErrBlocked) {
+		t.Errorf("Blocked sender: got %v, want ErrBlocked", err)
+	}
+	if _, err := store.SendMessage(batmanID, mamaID, "go away"); !errors.Is(err, database.ErrBlocked) {
+		t.Errorf("Blocker: got %v, want ErrBlocked", err)
+	}
+
+	blocked, blockedBy, err := store.BlockState(mamaID, batmanID)
+	if err != nil || blocked || !blockedBy {
+		t.Errorf("BlockState for Mama: got %v %v %v", blocked, blockedBy, err)
+	}
+	conversations, _ := store.FetchConversations(batmanID)
+	if len(conversations) != 1 || !conversations[0].Blocked {
+		t.Errorf("The blocked conversation stays in the inbox, marked, got %+v", conversations)
+	}
+
+	if err := store.UnblockUser(batmanID, mamaID); err != nil {
+		t.Fatalf("UnblockUser failed: %v", err)
+	}
+	sendMessage(t, store, mamaID, batmanID, "sorry")
+}
+
+func TestSendMessageHandler(t *testing.T) {
+	app, store := setupTestApp(t)
+	db := store.DB()
+	mw := middleware.New(app.Users, app.Sessions)
+	mamaID := userIDByName(t, db, "Mama")
+	batmanID := userIDByName(t, db, "batman")
+	cookie := sessionCookie(t, store, mamaID)
+	send := mw.RequireRole(model.RoleUser, app.SendMessageHandler)
+
+	post := func(form url.Values, accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/messages/send", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		req.AddCookie(cookie)
+		rr := httptest.NewRecorder()
+		send(rr, req)
+		return rr
+	}
+
+	rr := post(url.Values{"to": {"batman"}, "body": {"  hello  "}}, "application/json")
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("JSON send: got %v", rr.Code)
+	}
+	var msg struct {
+		ID   int    `json:"id"`
+		Body string `json:"body"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &msg); err != nil || msg.Body != "hello" {
+		t.Errorf("Unexpected reply %q: %v", rr.Body.String(), err)
+	}
+
+	rr = post(url.Values{"to": {"batman"}, "body": {"again"}}, "")
+	if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/messages/view?with=batman" {
+		t.Errorf("Form send: got %v %q", rr.Code, rr.Header().Get("Location"))
+	}
+
+	rr = post(url.Values{"to": {"nobody"}, "body": {"hi"}}, "")
+	if loc := rr.Header().Get("Location"); rr.Code != http.StatusSeeOther || !strings.HasPrefix(loc, "/messages?error=") {
+		t.Errorf("Unknown recipient: got %v %q", rr.Code, loc)
+	}
+
+	if rr := post(url.Values{"to": {"batman"}, "body": {"   "}}, ""); rr.Code != http.StatusBadRequest {
+		t.Errorf("Empty message: got %v, want 400", rr.Code)
+	}
+
+	if err := store.BlockUser(batmanID, mamaID); err != nil {
+		t.Fatal(err)
+	}
+	if rr := post(url.Values{"to": {"batman"}, "body": {"hi"}}, "application/json"); rr.Code != http.StatusForbidden {
+		t.Errorf("Blocked: got %v, want 403", rr.Code)
+	}
+
+	// The polling fallback returns what came after the given ID
+	poll := mw.RequireRole(model.RoleUser, app.MessagesPollHandler)
+	req := httptest.NewRequest(http.MethodGet, "/messages/poll?with=batman&after="+strconv.Itoa(msg.ID), nil)
+	req.AddCookie(cookie)
+	rr = httptest.NewRecorder()
+	poll(rr, req)
+	var page struct {
+		Messages []struct {
+			Body string `json:"body"`
+		} `json:"messages"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &page); err != nil {
+		t.Fatalf("Poll: %v %q", err, rr.Body.String())
+	}
+	if len(page.Messages) != 1 || page.Messages[0].Body != "again" {
+		t.Errorf("Poll after %d: got %+v", msg.ID, page.Messages)
+	}
+}
+
+func TestMessagesSocketDeliversMessages(t *testing.T) {
+	app, store := setupTestApp(t)
+	db := store.DB()
+	mw := middleware.New(app.Users, app.Sessions)
+	db.SetMaxOpenConns(1)
+	mamaID := userIDByName(t, db, "Mama")
+	batmanID := userIDByName(t, db, "batman")
+	cookie := sessionCookie(t, store, batmanID)
+
+	srv := httptest.NewServer(mw.RequireRole(model.RoleUser, app.MessagesSocketHandler))
+	defer srv.Close()
+	wsURL := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+	if _, resp, err := websocket.DefaultDialer.Dial(wsURL, nil); err == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Guests must not connect, got %v", err)
+	}
+
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, http.Header{"Cookie": {cookie.String()}})
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+
+	// The handler subscribes right after the upgrade; wait for it
+	for i := 0; i < 100 && app.MessageEvents.Subscribers(batmanID) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Sent through the handler, which publishes once the message is stored
+	form := url.Values{"to": {"batman"}, "body": {"are you there?"}}
+	req := httptest.NewRequest(http.MethodPost, "/messages/send", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.AddCookie(sessionCookie(t, store, mamaID))
+	rr := httptest.NewRecorder()
+	mw.RequireRole(model.RoleUser, app.SendMessageHandler)(rr, req)
+	var sentMsg struct {
+		ID int `json:"id"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &sentMsg); rr.Code != http.StatusCreated || err != nil {
+		t.Fatalf("Send: got %v %q", rr.Code, rr.Body.String())
+	}
+	sent := sentMsg.ID
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var event struct {
+		Type    string `json:"type"`
+		Message struct {
+			ID     int    `json:"id"`
+			Sender string `json:"sender"`
+			Body   string `json:"body"`
+		} `json:"message"`
+	}
+	if err := conn.ReadJSON(&event); err != nil {
+		t.Fatalf("ReadJSON failed: %v", err)
+	}
+	if event.Type != "message" || event.Message.ID != sent || event.Message.Sender != "Mama" || event.Message.Body != "are you there?" {
+		t.Errorf("Unexpected event %+v", event)
+	}
+}
@@ -0,0 +1,140 @@
+package tests
+
+import (
+	"database/sql"
+	"forum-go/database"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func columnExists(t *testing.T, db *sql.DB, table, column string) bool {
+	t.Helper()
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?", table, column).Scan(&count)
+	if err != nil {
+		t.Fatalf("Failed to inspect %s.%s: %v", table, column, err)
+	}
+	return count > 0
+}
+
+// migrateDownTo rolls back every migration newer than version.
+func migrateDownTo(t *testing.T, store *database.Store, version int) {
+	t.Helper()
+	statuses, err := store.MigrationStatuses()
+	if err != nil {
+		t.Fatalf("MigrationStatuses failed: %v", err)
+	}
+	steps := 0
+	for _, s := range statuses {
+		if s.Applied && s.Version > version {
+			steps++
+		}
+	}
+	if steps == 0 {
+		return
+	}
+	if err := store.MigrateDown(steps); err != nil {
+		t.Fatalf("MigrateDown(%d) failed: %v", steps, err)
+	}
+}
+
+func TestMigrationsApplyAll(t *testing.T) {
+	store := setupTestDB(t)
+	db := store.DB()
+
+	statuses, err := store.MigrationStatuses()
+	if err != nil {
+		t.Fatalf("MigrationStatuses failed: %v", err)
+	}
+	if len(statuses) == 0 {
+		t.Fatal("Expected at least one migration")
+	}
+	for _, s := range statuses {
+		if !s.Applied {
+			t.Errorf("Migration %d (%s) was not applied", s.Version, s.Name)
+		}
+	}
+
+	if columnExists(t, db, "users", "session_token") {
+		t.Error("users.session_token should have been dropped")
+	}
+	if !columnExists(t, db, "posts", "edited_at") {
+		t.Error("posts.edited_at should have been added")
+	}
+}
+
+func TestMigrationsDownAndUp(t *testing.T) {
+	store := setupTestDB(t)
+	db := store.DB()
+
+	if err := store.MigrateDown(1); err != nil {
+		t.Fatalf("MigrateDown failed: %v", err)
+	}
+
+	statuses, err := store.MigrationStatuses()
+	if err != nil {
+		t.Fatalf("MigrationStatuses failed: %v", err)
+	}
+	last := statuses[len(statuses)-1]
+	if last.Applied {
+		t.Errorf("Migration %d should be pending after rolling back", last.Version)
+	}
+
+	if err := store.MigrateUp(); err != nil {
+		t.Fatalf("MigrateUp after rollback failed: %v", err)
+	}
+
+	var users int
+	if err := db.QueryRow("SELECT COUNT(*) FROM users").Scan(&users); err != nil {
+		t.Fatalf("Counting users failed: %v", err)
+	}
+	if users == 0 {
+		t.Error("Expected seeded users to survive a down/up cycle")
+	}
+}
+
+func TestMigrationsUpgradeLegacyDatabase(t *testing.T) {
+	db, err := sql.Open("sqlite3", database.DSN(":memory:"))
+	if err != nil {
+		t.Fatalf("Failed to open in-memory sqlite database: %v", err)
+	}
+	defer db.Close()
+
+	// A database created before migrations existed has the old users
+	// columns and no schema_migrations table.
+	_, err = db.Exec(`
+        CREATE TABLE users (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            username TEXT UNIQUE NOT NULL,
+            email TEXT UNIQUE NOT NULL,
+            password_hash TEXT NOT NULL,
+            session_token TEXT UNIQUE,
+            session_expiry DATETIME,
+            created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+        )
+    `)
+	if err != nil {
+		t.Fatalf("Creating legacy users table failed: %v", err)
+	}
+	_, err = db.Exec("INSERT INTO users (username, email, password_hash) VALUES ('legacy', 'legacy@example.com', 'x')")
+	if err != nil {
+		t.Fatalf("Inserting legacy user failed: %v", err)
+	}
+
+	if err := database.NewStore(db).Init(); err != nil {
+		t.Fatalf("InitDB on legacy database failed: %v", err)
+	}
+
+	if columnExists(t, db, "users", "session_token") {
+		t.Error("users.session_token should have been dropped from the legacy table")
+	}
+
+	var email string
+	if err := db.QueryRow("SELECT email FROM users WHERE username = 'legacy'").Scan(&email); err != nil {
+		t.Fatalf("Legacy user lost during migration: %v", err)
+	}
+	if email != "legacy@example.com" {
+		t.Errorf("Legacy user email mismatch: got %v", email)
+	}
+}
@@ -0,0 +1,243 @@
+package tests
+
+import (
+	"database/sql"
+	"errors"
+	"forum-go/database"
+	"forum-go/middleware"
+	"forum-go/model"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+// sessionCookie logs userID in and returns the cookie a browser would send.
+func sessionCookie(t *testing.T, store *database.Store, userID int) *http.Cookie {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	if err := store.CreateSession(rr, userID, "Go-http-client/1.1", "192.0.2.1", false); err != nil {
+		t.Fatalf("CreateSession failed: %v", err)
+	}
+	cookies := rr.Result().Cookies()
+	if len(cookies) == 0 {
+		t.Fatal("CreateSession set no cookie")
+	}
+	return cookies[0]
+}
+
+func userIDByName(t *testing.T, db *sql.DB, username string) int {
+	t.Helper()
+	var id int
+	if err := db.QueryRow("SELECT id FROM users WHERE username = ?", username).Scan(&id); err != nil {
+		t.Fatalf("Finding user %s failed: %v", username, err)
+	}
+	return id
+}
+
+// promoteAdmin makes the seeded admin account an administrator, as the
+// promote command would, and returns its id.
+func promoteAdmin(t *testing.T, store *database.Store) int {
+	t.Helper()
+	if err := store.PromoteAdmin("admin"); err != nil {
+		t.Fatalf("PromoteAdmin failed: %v", err)
+	}
+	return userIDByName(t, store.DB(), "admin")
+}
+
+func TestFreshDatabaseHasNoAdmin(t *testing.T) {
+	store := setupTestDB(t)
+	db := store.DB()
+
+	var privileged int
+	if err := db.QueryRow("SELECT COUNT(*) FROM users WHERE role != ?", model.RoleUser).Scan(&privileged); err != nil {
+		t.Fatalf("Counting privileged users failed: %v", err)
+	}
+	if privileged != 0 {
+		t.Errorf("Fresh database has %d admins or moderators, want none", privileged)
+	}
+
+	// Every seeded account has a published password
+	for _, u := range database.SeedUsers {
+		if u.Role != model.RoleUser {
+			t.Errorf("Seed user %s has role %q, want %q", u.Username, u.Role, model.RoleUser)
+		}
+	}
+}
+
+func TestPromoteAdmin(t *testing.T) {
+	store := setupTestDB(t)
+
+	admin, err := store.FetchUserById(promoteAdmin(t, store))
+	if err != nil {
+		t.Fatalf("FetchUserById failed: %v", err)
+	}
+	if admin.Role != model.RoleAdmin {
+		t.Errorf("Promoted role: got %q, want %q", admin.Role, model.RoleAdmin)
+	}
+
+	if err := store.PromoteAdmin("nobody"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("Promoting a missing user: got %v, want sql.ErrNoRows", err)
+	}
+}
+
+func TestMigrationDemotesSeededAdmin(t *testing.T) {
+	store := setupTestDB(t)
+	db := store.DB()
+
+	// A database set up before the fix has the seeded admin promoted
+	migrateDownTo(t, store, 19)
+	if _, err := db.Exec("UPDATE users SET role = 'admin' WHERE username IN ('admin', 'Mama')"); err != nil {
+		t.Fatalf("Promoting users failed: %v", err)
+	}
+	if err := store.MigrateUp(); err != nil {
+		t.Fatalf("MigrateUp failed: %v", err)
+	}
+
+	for name, want := range map[string]string{"admin": model.RoleUser, "Mama": model.RoleAdmin} {
+		user, err := store.FetchUserById(userIDByName(t, db, name))
+		if err != nil {
+			t.Fatalf("FetchUserById failed: %v", err)
+		}
+		if user.Role != want {
+			t.Errorf("%s after migrating: got role %q, want %q", name, user.Role, want)
+		}
+	}
+}
+
+func TestRequireRole(t *testing.T) {
+	app, store := setupTestApp(t)
+	db := store.DB()
+	mw := middleware.New(app.Users, app.Sessions)
+	adminID := promoteAdmin(t, store)
+	mamaID := userIDByName(t, db, "Mama")
+	batmanID := userIDByName(t, db, "batman")
+
+	if err := store.SetUserRole(adminID, batmanID, model.RoleModerator); err != nil {
+		t.Fatalf("SetUserRole failed: %v", err)
+	}
+
+	protected := mw.RequireRole(model.RoleModerator, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	tests := []struct {
+		name   string
+		userID int
+		want   int
+	}{
+		{"Guest", 0, http.StatusUnauthorized},
+		{"User", mamaID, http.StatusForbidden},
+		{"Moderator", batmanID, http.StatusOK},
+		{"Admin", adminID, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/moderation", nil)
+			if tt.userID != 0 {
+				req.AddCookie(sessionCookie(t, store, tt.userID))
+			}
+			rr := httptest.NewRecorder()
+			protected(rr, req)
+			if rr.Code != tt.want {
+				t.Errorf("Status: got %v, want %v", rr.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestHideAndLockPost(t *testing.T) {
+	app, store := setupTestApp(t)
+	db := store.DB()
+	adminID := promoteAdmin(t, store)
+
+	var postID int
+	if err := db.QueryRow("SELECT id FROM posts WHERE title LIKE 'Whispers%'").Scan(&postID); err != nil {
+		t.Fatalf("Finding post failed: %v", err)
+	}
+
+	if err := store.SetPostLocked(adminID, postID, true, "heated"); err != nil {
+		t.Fatalf("SetPostLocked failed: %v", err)
+	}
+	if _, err := store.CreateComment(postID, 1, 0, "one more"); !errors.Is(err, database.ErrPostLocked) {
+		t.Errorf("Commenting on a locked post: got %v, want ErrPostLocked", err)
+	}
+
+	if err := store.SetPostHidden(adminID, postID, true, "spoilers"); err != nil {
+		t.Fatalf("SetPostHidden failed: %v", err)
+	}
+	results, err := store.SearchPosts(database.SearchOptions{Query: "Whispers"})
+	if err != nil {
+		t.Fatalf("SearchPosts failed: %v", err)
+	}
+	if len(results) != 0 {
+		t.Errorf("Hidden post should not be searchable, got %+v", results)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/viewpost?id="+strconv.Itoa(postID), nil)
+	rr := httptest.NewRecorder()
+	app.ViewPostHandler(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Guest viewing a hidden post: got %v, want %v", rr.Code, http.StatusNotFound)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/viewpost?id="+strconv.Itoa(postID), nil)
+	req.AddCookie(sessionCookie(t, store, adminID))
+	rr = httptest.NewRecorder()
+	app.ViewPostHandler(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Errorf("Admin viewing a hidden post: got %v, want %v", rr.Code, http.StatusOK)
+	}
+
+	entries, err := store.FetchModerationLog(10)
+	if err != nil {
+		t.Fatalf("FetchModerationLog failed: %v", err)
+	}
+	if len(entries) != 2 || entries[0].Action != database.ActionHidePost || entries[0].Reason != "spoilers" {
+		t.Errorf("Expected hide and lock in the log, newest first, got %+v", entries)
+	}
+}
+
+func TestBanUser(t *testing.T) {
+	app, store := setupTestApp(t)
+	db := store.DB()
+	mw := middleware.New(app.Users, app.Sessions)
+	adminID := promoteAdmin(t, store)
+	mamaID := userIDByName(t, db, "Mama")
+	batmanID := userIDByName(t, db, "batman")
+
+	if err := store.SetUserRole(adminID, batmanID, model.RoleModerator); err != nil {
+		t.Fatalf("SetUserRole failed: %v", err)
+	}
+	if err := store.SetUserBanned(batmanID, adminID, true, ""); !errors.Is(err, database.ErrInsufficientRole) {
+		t.Errorf("Moderator banning an admin: got %v, want ErrInsufficientRole", err)
+	}
+	if err := store.SetUserRole(batmanID, mamaID, model.RoleModerator); !errors.Is(err, database.ErrInsufficientRole) {
+		t.Errorf("Moderator assigning roles: got %v, want ErrInsufficientRole", err)
+	}
+
+	cookie := sessionCookie(t, store, mamaID)
+	if err := store.SetUserBanned(batmanID, mamaID, true, "spam"); err != nil {
+		t.Fatalf("SetUserBanned failed: %v", err)
+	}
+
+	var sessions int
+	if err := db.QueryRow("SELECT COUNT(*) FROM sessions WHERE user_id = ?", mamaID).Scan(&sessions); err != nil {
+		t.Fatalf("Counting sessions failed: %v", err)
+	}
+	if sessions != 0 {
+		t.Errorf("Banning should end all sessions, %d left", sessions)
+	}
+
+	protected := mw.RequireRole(model.RoleUser, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	req := httptest.NewRequest(http.MethodPost, "/submitComment", nil)
+	req.AddCookie(cookie)
+	rr := httptest.NewRecorder()
+	protected(rr, req)
+	if rr.Code == http.StatusOK {
+		t.Error("A banned user's old session must not pass RequireRole")
+	}
+}
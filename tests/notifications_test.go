@@ -0,0 +1,228 @@
+package tests
+
+import (
+	"forum-go/database"
+	"forum-go/middleware"
+	"forum-go/model"
+	"forum-go/pkg/utils"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// postByAuthor returns a post written by userID.
+func postByAuthor(t *testing.T, store *database.Store, userID int) int {
+	t.Helper()
+	var id int
+	if err := store.DB().QueryRow("SELECT id FROM posts WHERE user_id = ? ORDER BY id LIMIT 1", userID).Scan(&id); err != nil {
+		t.Fatalf("Finding a post by user %d failed: %v", userID, err)
+	}
+	return id
+}
+
+func notificationKinds(t *testing.T, store *database.Store, userID int) []string {
+	t.Helper()
+	notifications, err := store.FetchNotifications(userID, 50)
+	if err != nil {
+		t.Fatalf("FetchNotifications failed: %v", err)
+	}
+	var kinds []string
+	for _, n := range notifications {
+		kinds = append(kinds, n.Kind)
+	}
+	return kinds
+}
+
+func TestExtractMentions(t *testing.T) {
+	got := utils.ExtractMentions("@batman and @robin_1, not mail@example.com, again @batman")
+	want := []string{"batman", "robin_1"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ExtractMentions: got %v, want %v", got, want)
+	}
+}
+
+func TestNotifyNewComment(t *testing.T) {
+	store := setupTestDB(t)
+	db := store.DB()
+	adminID := userIDByName(t, db, "admin")
+	mamaID := userIDByName(t, db, "Mama")
+	batmanID := userIDByName(t, db, "batman")
+	postID := postByAuthor(t, store, adminID)
+
+	parentID, err := store.CreateComment(postID, mamaID, 0, "first!")
+	if err != nil {
+		t.Fatalf("CreateComment failed: %v", err)
+	}
+	if err := store.NotifyNewComment(int(parentID)); err != nil {
+		t.Fatalf("NotifyNewComment failed: %v", err)
+	}
+
+	replyID, err := store.CreateComment(postID, batmanID, int(parentID), "agreed, @Mama and @admin. Also @batman and @nobody")
+	if err != nil {
+		t.Fatalf("CreateComment failed: %v", err)
+	}
+	if err := store.NotifyNewComment(int(replyID)); err != nil {
+		t.Fatalf("NotifyNewComment failed: %v", err)
+	}
+
+	// Newest first; a mention never duplicates a reply or comment notification
+	if got, want := notificationKinds(t, store, adminID), []string{database.NotificationComment, database.NotificationComment}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Post author: got %v, want %v", got, want)
+	}
+	if got, want := notificationKinds(t, store, mamaID), []string{database.NotificationReply}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Parent author: got %v, want %v", got, want)
+	}
+	if got := notificationKinds(t, store, batmanID); len(got) != 0 {
+		t.Errorf("Nobody is notified about their own comment, got %v", got)
+	}
+
+	user, err := store.FetchUserById(adminID)
+	if err != nil {
+		t.Fatalf("FetchUserById failed: %v", err)
+	}
+	if user.UnreadCount != 2 {
+		t.Errorf("UnreadCount: got %d, want 2", user.UnreadCount)
+	}
+
+	if err := store.MarkAllNotificationsRead(adminID); err != nil {
+		t.Fatalf("MarkAllNotificationsRead failed: %v", err)
+	}
+	user, err = store.FetchUserById(adminID)
+	if err != nil {
+		t.Fatalf("FetchUserById failed: %v", err)
+	}
+	if user.UnreadCount != 0 {
+		t.Errorf("UnreadCount after marking read: got %d, want 0", user.UnreadCount)
+	}
+}
+
+func TestNotificationPreferences(t *testing.T) {
+	store := setupTestDB(t)
+	db := store.DB()
+	adminID := userIDByName(t, db, "admin")
+	mamaID := userIDByName(t, db, "Mama")
+	postID := postByAuthor(t, store, adminID)
+
+	prefs, err := store.FetchNotificationPreferences(adminID)
+	if err != nil {
+		t.Fatalf("FetchNotificationPreferences failed: %v", err)
+	}
+	for _, kind := range database.NotificationKinds {
+		if !prefs[kind] {
+			t.Errorf("%s should be on by default", kind)
+		}
+	}
+
+	err = store.SetNotificationPreferences(adminID, map[string]bool{database.NotificationComment: true})
+	if err != nil {
+		t.Fatalf("SetNotificationPreferences failed: %v", err)
+	}
+
+	if err := store.NotifyVote(mamaID, postID, 0, 1); err != nil {
+		t.Fatalf("NotifyVote failed: %v", err)
+	}
+	if got := notificationKinds(t, store, adminID); len(got) != 0 {
+		t.Errorf("Votes are switched off, got %v", got)
+	}
+
+	commentID, err := store.CreateComment(postID, mamaID, 0, "nice")
+	if err != nil {
+		t.Fatalf("CreateComment failed: %v", err)
+	}
+	if err := store.NotifyNewComment(int(commentID)); err != nil {
+		t.Fatalf("NotifyNewComment failed: %v", err)
+	}
+	if got, want := notificationKinds(t, store, adminID), []string{database.NotificationComment}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Comments are still on: got %v, want %v", got, want)
+	}
+}
+
+func TestNotifyVoteReplacesUnread(t *testing.T) {
+	store := setupTestDB(t)
+	db := store.DB()
+	adminID := userIDByName(t, db, "admin")
+	mamaID := userIDByName(t, db, "Mama")
+	postID := postByAuthor(t, store, adminID)
+
+	for _, vote := range []int{1, -1} {
+		if err := store.NotifyVote(mamaID, postID, 0, vote); err != nil {
+			t.Fatalf("NotifyVote failed: %v", err)
+		}
+	}
+	if err := store.NotifyVote(adminID, postID, 0, 1); err != nil {
+		t.Fatalf("NotifyVote failed: %v", err)
+	}
+
+	notifications, err := store.FetchNotifications(adminID, 50)
+	if err != nil {
+		t.Fatalf("FetchNotifications failed: %v", err)
+	}
+	if len(notifications) != 1 || notifications[0].Detail != "down" || notifications[0].Actor != "Mama" {
+		t.Errorf("Expected a single down vote from Mama, got %+v", notifications)
+	}
+}
+
+func TestReportResolutionNotifiesReporter(t *testing.T) {
+	store := setupTestDB(t)
+	db := store.DB()
+	adminID := userIDByName(t, db, "admin")
+	mamaID := userIDByName(t, db, "Mama")
+	postID := firstPostID(t, store)
+
+	if err := store.CreateReport(mamaID, "post", postID, "spam", ""); err != nil {
+		t.Fatalf("CreateReport failed: %v", err)
+	}
+	reports, err := store.FetchOpenReports()
+	if err != nil || len(reports) != 1 {
+		t.Fatalf("FetchOpenReports: got %v, %v", reports, err)
+	}
+	if err := store.ResolveReport(adminID, reports[0].ID, false); err != nil {
+		t.Fatalf("ResolveReport failed: %v", err)
+	}
+
+	notifications, err := store.FetchNotifications(mamaID, 50)
+	if err != nil {
+		t.Fatalf("FetchNotifications failed: %v", err)
+	}
+	if len(notifications) != 1 || notifications[0].Kind != database.NotificationReport || notifications[0].Detail != database.ReportDismissed {
+		t.Fatalf("Expected a dismissed report notification, got %+v", notifications)
+	}
+	if notifications[0].Actor != "" {
+		t.Errorf("Report decisions should not name the moderator, got %q", notifications[0].Actor)
+	}
+
+	if err := store.MarkNotificationRead(adminID, notifications[0].ID); err == nil {
+		t.Error("Marking someone else's notification read should fail")
+	}
+}
+
+func TestNotificationsHandler(t *testing.T) {
+	app, store := setupTestApp(t)
+	db := store.DB()
+	mw := middleware.New(app.Users, app.Sessions)
+	adminID := userIDByName(t, db, "admin")
+	mamaID := userIDByName(t, db, "Mama")
+	postID := postByAuthor(t, store, adminID)
+
+	if err := store.NotifyVote(mamaID, postID, 0, 1); err != nil {
+		t.Fatalf("NotifyVote failed: %v", err)
+	}
+
+	page := mw.RequireRole(model.RoleUser, app.NotificationsHandler)
+	req := httptest.NewRequest(http.MethodGet, "/notifications", nil)
+	req.AddCookie(sessionCookie(t, store, adminID))
+	rr := httptest.NewRecorder()
+	page(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Status: got %v, want %v", rr.Code, http.StatusOK)
+	}
+	body := rr.Body.String()
+	if !strings.Contains(body, "Mama liked your post") {
+		t.Error("Expected the vote notification on the page")
+	}
+	if !strings.Contains(body, `class="unread-count">1<`) {
+		t.Error("Expected the unread counter in the header")
+	}
+}
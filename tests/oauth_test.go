@@ -0,0 +1,371 @@
+package tests
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"forum-go/auth"
+	"forum-go/database"
+	"forum-go/handler"
+	"forum-go/middleware"
+	"forum-go/model"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeProfile is the person signed in at the fake provider.
+type fakeProfile struct {
+	Subject       string
+	Login         string
+	Email         string
+	EmailVerified bool
+}
+
+// fakeProvider is a local OAuth2 server that speaks both the OpenID
+// Connect userinfo and the GitHub API dialects. Whoever is in User signs
+// in at /authorize without being asked.
+type fakeProvider struct {
+	*httptest.Server
+	User fakeProfile
+
+	mu     sync.Mutex
+	codes  map[string]fakeGrant
+	tokens map[string]fakeProfile
+}
+
+type fakeGrant struct {
+	profile     fakeProfile
+	challenge   string
+	redirectURI string
+}
+
+func newFakeProvider(t *testing.T, app *handler.App) *fakeProvider {
+	t.Helper()
+	f := &fakeProvider{codes: map[string]fakeGrant{}, tokens: map[string]fakeProfile{}}
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/authorize", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("client_id") != "client" || q.Get("code_challenge_method") != "S256" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		code := auth.NewOAuthState()
+		f.mu.Lock()
+		f.codes[code] = fakeGrant{f.User, q.Get("code_challenge"), q.Get("redirect_uri")}
+		f.mu.Unlock()
+		http.Redirect(w, r, q.Get("redirect_uri")+"?"+url.Values{"code": {code}, "state": {q.Get("state")}}.Encode(), http.StatusFound)
+	})
+
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		grant, ok := f.codes[r.FormValue("code")]
+		delete(f.codes, r.FormValue("code"))
+		sum := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+		switch {
+		case r.FormValue("client_id") != "client" || r.FormValue("client_secret") != "secret":
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_client"})
+		case !ok || grant.redirectURI != r.FormValue("redirect_uri") ||
+			base64.RawURLEncoding.EncodeToString(sum[:]) != grant.challenge:
+			// GitHub style: an error with 200
+			json.NewEncoder(w).Encode(map[string]string{"error": "bad_verification_code"})
+		default:
+			token := auth.NewOAuthState()
+			f.tokens[token] = grant.profile
+			json.NewEncoder(w).Encode(map[string]string{"access_token": token, "token_type": "bearer"})
+		}
+	})
+
+	profile := func(r *http.Request) (fakeProfile, bool) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		p, ok := f.tokens[strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")]
+		return p, ok
+	}
+	mux.HandleFunc("/userinfo", func(w http.ResponseWriter, r *http.Request) {
+		p, ok := profile(r)
+		if !ok {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"sub": p.Subject, "email": p.Email, "email_verified": p.EmailVerified, "preferred_username": p.Login,
+		})
+	})
+	mux.HandleFunc("/user", func(w http.ResponseWriter, r *http.Request) {
+		p, ok := profile(r)
+		if !ok {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"id": 4242, "login": p.Login, "email": nil})
+	})
+	mux.HandleFunc("/user/emails", func(w http.ResponseWriter, r *http.Request) {
+		p, ok := profile(r)
+		if !ok {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode([]map[string]interface{}{
+			{"email": "old@example.com", "primary": false, "verified": true},
+			{"email": p.Email, "primary": true, "verified": p.EmailVerified},
+		})
+	})
+
+	f.Server = httptest.NewServer(mux)
+	t.Cleanup(f.Close)
+	app.BaseURL = testBaseURL
+	app.Providers.Add(auth.OIDC("fake", "Fake", "client", "secret",
+		f.URL+"/authorize", f.URL+"/token", f.URL+"/userinfo"))
+	return f
+}
+
+// signInWith runs the browser's side of a sign-in with provider: the login
+// redirect, the provider's authorize page and the callback. session is
+// sent along when set.
+func signInWith(t *testing.T, app *handler.App, provider string, session *http.Cookie) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/oauth/"+provider+"/login", nil)
+	req.Host = "attacker.example"
+	req.SetPathValue("provider", provider)
+	rr := httptest.NewRecorder()
+	app.OAuthLoginHandler(rr, req)
+	if rr.Code != http.StatusFound {
+		t.Fatalf("Login redirect: got %v", rr.Code)
+	}
+	var state *http.Cookie
+	for _, c := range rr.Result().Cookies() {
+		if c.Name == "oauth_state" {
+			state = c
+		}
+	}
+	if state == nil || !state.HttpOnly {
+		t.Fatal("Expected an HttpOnly oauth_state cookie")
+	}
+
+	noFollow := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := noFollow.Get(rr.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	// The callback is on the configured address, whatever Host was sent
+	if err != nil || callback.Scheme+"://"+callback.Host != testBaseURL || callback.Path != "/oauth/"+provider+"/callback" {
+		t.Fatalf("Provider sent the browser to %q", resp.Header.Get("Location"))
+	}
+
+	return oauthCallback(t, app, provider, callback.RequestURI(), state, session)
+}
+
+func oauthCallback(t *testing.T, app *handler.App, provider, target string, state, session *http.Cookie) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	req.SetPathValue("provider", provider)
+	if state != nil {
+		req.AddCookie(state)
+	}
+	if session != nil {
+		req.AddCookie(session)
+	}
+	rr := httptest.NewRecorder()
+	app.OAuthCallbackHandler(rr, req)
+	return rr
+}
+
+func newSession(t *testing.T, store *database.Store, rr *httptest.ResponseRecorder) int {
+	t.Helper()
+	for _, c := range rr.Result().Cookies() {
+		if c.Name == "session_token" && c.Value != "" {
+			ok, userID := store.SessionUserID(c.Value)
+			if !ok {
+				t.Fatal("The new session does not work")
+			}
+			return userID
+		}
+	}
+	t.Fatal("No session was started")
+	return 0
+}
+
+func TestOAuthCreatesAccount(t *testing.T) {
+	app, store := setupTestApp(t)
+	db := store.DB()
+	fake := newFakeProvider(t, app)
+	fake.User = fakeProfile{Subject: "u-1", Login: "ann", Email: "ann@example.com", EmailVerified: true}
+
+	rr := signInWith(t, app, "fake", nil)
+	if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/settings?saved=welcome#username" {
+		t.Fatalf("First sign-in: got %v %q %s", rr.Code, rr.Header().Get("Location"), rr.Body.String())
+	}
+	userID := newSession(t, store, rr)
+	user, _ := store.FetchUserById(userID)
+	// "ann" is too short for the username rules
+	if user.Username != "user_ann" || user.Email != "ann@example.com" || user.EmailVerifiedAt.IsZero() {
+		t.Errorf("New account: %+v", user)
+	}
+
+	// The same person comes back to the same account
+	rr = signInWith(t, app, "fake", nil)
+	if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/" {
+		t.Fatalf("Second sign-in: got %v %q", rr.Code, rr.Header().Get("Location"))
+	}
+	if newSession(t, store, rr) != userID {
+		t.Error("Signed in to a different account")
+	}
+	var users int
+	db.QueryRow("SELECT COUNT(*) FROM users WHERE email = 'ann@example.com'").Scan(&users)
+	if users != 1 {
+		t.Errorf("Expected one account, got %d", users)
+	}
+}
+
+func TestOAuthLinksByVerifiedEmail(t *testing.T) {
+	app, store := setupTestApp(t)
+	db := store.DB()
+	fake := newFakeProvider(t, app)
+	addLoginUser(t, db, "robin")
+	robinID := userIDByName(t, db, "robin")
+	fake.User = fakeProfile{Subject: "u-2", Login: "robin-gh", Email: "Robin@Example.com", EmailVerified: true}
+
+	// An unverified local address could belong to someone else
+	rr := signInWith(t, app, "fake", nil)
+	if rr.Code != http.StatusConflict {
+		t.Fatalf("Unverified local address: got %v", rr.Code)
+	}
+	if ids, _ := app.Accounts.FetchIdentities(robinID); len(ids) != 0 {
+		t.Fatal("The identity must not be linked")
+	}
+
+	db.Exec("UPDATE users SET email_verified_at = CURRENT_TIMESTAMP WHERE id = ?", robinID)
+	rr = signInWith(t, app, "fake", nil)
+	if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/" {
+		t.Fatalf("Verified address: got %v %q", rr.Code, rr.Header().Get("Location"))
+	}
+	if newSession(t, store, rr) != robinID {
+		t.Error("Expected to sign in to robin's account")
+	}
+	ids, _ := app.Accounts.FetchIdentities(robinID)
+	if len(ids) != 1 || ids[0].Provider != "fake" {
+		t.Errorf("Identities: %+v", ids)
+	}
+}
+
+func TestOAuthRefusals(t *testing.T) {
+	app, store := setupTestApp(t)
+	db := store.DB()
+	fake := newFakeProvider(t, app)
+
+	fake.User = fakeProfile{Subject: "u-3", Login: "carol", Email: "carol@example.com", EmailVerified: false}
+	if rr := signInWith(t, app, "fake", nil); rr.Code != http.StatusForbidden {
+		t.Errorf("Unverified provider address: got %v", rr.Code)
+	}
+
+	state := &http.Cookie{Name: "oauth_state", Value: "expected.verifier"}
+	for name, tc := range map[string]struct {
+		target string
+		state  *http.Cookie
+		want   int
+	}{
+		"no cookie":      {"/oauth/fake/callback?code=x&state=expected", nil, http.StatusBadRequest},
+		"wrong state":    {"/oauth/fake/callback?code=x&state=forged", state, http.StatusBadRequest},
+		"cancelled":      {"/oauth/fake/callback?error=access_denied&state=expected", state, http.StatusBadRequest},
+		"bad code":       {"/oauth/fake/callback?code=unknown&state=expected", state, http.StatusBadGateway},
+		"other provider": {"/oauth/nope/callback?code=x&state=expected", state, http.StatusNotFound},
+	} {
+		provider := strings.Split(tc.target, "/")[2]
+		if rr := oauthCallback(t, app, provider, tc.target, tc.state, nil); rr.Code != tc.want {
+			t.Errorf("%s: got %v, want %v", name, rr.Code, tc.want)
+		}
+	}
+
+	var sessions int
+	db.QueryRow("SELECT COUNT(*) FROM sessions").Scan(&sessions)
+	if sessions != 0 {
+		t.Errorf("Refused sign-ins started %d sessions", sessions)
+	}
+
+	// Banned accounts stay out
+	fake.User = fakeProfile{Subject: "u-4", Login: "dave_bad", Email: "dave@example.com", EmailVerified: true}
+	userID := newSession(t, store, signInWith(t, app, "fake", nil))
+	db.Exec("UPDATE users SET banned_at = CURRENT_TIMESTAMP WHERE id = ?", userID)
+	if rr := signInWith(t, app, "fake", nil); rr.Code != http.StatusForbidden {
+		t.Errorf("Banned account: got %v", rr.Code)
+	}
+}
+
+func TestOAuthConnectFromSettings(t *testing.T) {
+	app, store := setupTestApp(t)
+	db := store.DB()
+	mw := middleware.New(app.Users, app.Sessions)
+	fake := newFakeProvider(t, app)
+	mamaID := userIDByName(t, db, "Mama")
+	mama := sessionCookie(t, store, mamaID)
+	fake.User = fakeProfile{Subject: "u-5", Login: "mama-gh", Email: "elsewhere@example.com", EmailVerified: true}
+
+	rr := signInWith(t, app, "fake", mama)
+	if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/settings?saved=connected#connected" {
+		t.Fatalf("Connect: got %v %q", rr.Code, rr.Header().Get("Location"))
+	}
+
+	// The provider account now signs in to Mama
+	if newSession(t, store, signInWith(t, app, "fake", nil)) != mamaID {
+		t.Error("Expected the connected account to sign in to Mama")
+	}
+
+	// Nobody else can connect it
+	batman := sessionCookie(t, store, userIDByName(t, db, "batman"))
+	if rr := signInWith(t, app, "fake", batman); rr.Code != http.StatusConflict {
+		t.Errorf("Connecting someone else's account: got %v", rr.Code)
+	}
+
+	unlink := mw.RequireRole(model.RoleUser, app.UnlinkIdentityHandler)
+	post := func() int {
+		req := httptest.NewRequest(http.MethodPost, "/settings/identities/unlink", strings.NewReader("provider=fake"))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.AddCookie(mama)
+		rr := httptest.NewRecorder()
+		unlink(rr, req)
+		return rr.Code
+	}
+	if code := post(); code != http.StatusSeeOther {
+		t.Fatalf("Disconnect: got %v", code)
+	}
+	if code := post(); code != http.StatusNotFound {
+		t.Errorf("Disconnecting twice: got %v", code)
+	}
+	if ids, _ := app.Accounts.FetchIdentities(mamaID); len(ids) != 0 {
+		t.Errorf("Still connected: %+v", ids)
+	}
+}
+
+func TestGitHubProvider(t *testing.T) {
+	app, store := setupTestApp(t)
+	fake := newFakeProvider(t, app)
+	github := auth.GitHub("client", "secret")
+	github.AuthURL = fake.URL + "/authorize"
+	github.TokenURL = fake.URL + "/token"
+	github.UserInfoURL = fake.URL + "/user"
+	app.Providers.Add(github)
+	fake.User = fakeProfile{Login: "octo.cat", Email: "octo@example.com", EmailVerified: true}
+
+	rr := signInWith(t, app, "github", nil)
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("GitHub sign-in: got %v %s", rr.Code, rr.Body.String())
+	}
+	user, _ := store.FetchUserById(newSession(t, store, rr))
+	if user.Username != "octo_cat" || user.Email != "octo@example.com" {
+		t.Errorf("Expected the primary address and a cleaned-up login, got %+v", user)
+	}
+	var subject string
+	store.DB().QueryRow("SELECT subject FROM user_identities WHERE provider = 'github'").Scan(&subject)
+	if subject != "4242" {
+		t.Errorf("Subject is %q, want the GitHub user ID", subject)
+	}
+}
@@ -0,0 +1,120 @@
+package tests
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"forum-go/database"
+	"testing"
+	"time"
+)
+
+// seedManyPosts adds n posts one minute apart, all newer than the seed data.
+func seedManyPosts(t *testing.T, db *sql.DB, n int) {
+	t.Helper()
+	base := time.Now().Add(time.Minute)
+	for i := 0; i < n; i++ {
+		_, err := db.Exec(
+			"INSERT INTO posts (title, content, user_id, created_at) VALUES (?, ?, 1, ?)",
+			fmt.Sprintf("Paged post %02d", i), "Content for pagination tests.", base.Add(time.Duration(i)*time.Minute),
+		)
+		if err != nil {
+			t.Fatalf("Inserting post %d failed: %v", i, err)
+		}
+	}
+}
+
+func TestFetchPostPageWalksForwardAndBack(t *testing.T) {
+	store := setupTestDB(t)
+	db := store.DB()
+	seedManyPosts(t, db, 23)
+
+	var total int
+	if err := db.QueryRow("SELECT COUNT(*) FROM posts").Scan(&total); err != nil {
+		t.Fatalf("Counting posts failed: %v", err)
+	}
+
+	seen := make(map[int]bool)
+	var pages []*database.PostPage
+	opts := database.PostListOptions{Sort: database.SortNewest, Limit: 10}
+	for {
+		page, err := store.FetchPostPage(opts)
+		if err != nil {
+			t.Fatalf("FetchPostPage failed: %v", err)
+		}
+		pages = append(pages, page)
+		for _, p := range page.Posts {
+			if seen[p.ID] {
+				t.Fatalf("Post %d returned twice", p.ID)
+			}
+			seen[p.ID] = true
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		opts.After = page.NextCursor
+	}
+
+	if len(seen) != total {
+		t.Errorf("Walked %d posts, want %d", len(seen), total)
+	}
+	if pages[0].PrevCursor != "" {
+		t.Error("First page should not have a previous cursor")
+	}
+	if pages[0].Posts[0].Title != "Paged post 22" {
+		t.Errorf("Newest post first: got %q", pages[0].Posts[0].Title)
+	}
+
+	// Stepping back from the second page lands on the first page again
+	back, err := store.FetchPostPage(database.PostListOptions{
+		Sort:   database.SortNewest,
+		Limit:  10,
+		Before: pages[1].PrevCursor,
+	})
+	if err != nil {
+		t.Fatalf("FetchPostPage backwards failed: %v", err)
+	}
+	if len(back.Posts) != len(pages[0].Posts) {
+		t.Fatalf("Backward page size: got %d, want %d", len(back.Posts), len(pages[0].Posts))
+	}
+	for i := range back.Posts {
+		if back.Posts[i].ID != pages[0].Posts[i].ID {
+			t.Errorf("Backward page position %d: got post %d, want %d", i, back.Posts[i].ID, pages[0].Posts[i].ID)
+		}
+	}
+	if back.PrevCursor != "" {
+		t.Error("Backward walk to the first page should not offer a previous cursor")
+	}
+}
+
+func TestFetchPostPageSortsByScore(t *testing.T) {
+	store := setupTestDB(t)
+	db := store.DB()
+
+	var postID int
+	if err := db.QueryRow("SELECT id FROM posts WHERE title LIKE 'Whispers%'").Scan(&postID); err != nil {
+		t.Fatalf("Finding post failed: %v", err)
+	}
+	for userID := 1; userID <= 3; userID++ {
+		if _, err := db.Exec("INSERT INTO votes (user_id, post_id, vote) VALUES (?, ?, 1)", userID, postID); err != nil {
+			t.Fatalf("Inserting vote failed: %v", err)
+		}
+	}
+
+	page, err := store.FetchPostPage(database.PostListOptions{Sort: database.SortTop})
+	if err != nil {
+		t.Fatalf("FetchPostPage failed: %v", err)
+	}
+	if len(page.Posts) == 0 || page.Posts[0].ID != postID {
+		t.Errorf("Expected the upvoted post %d first", postID)
+	}
+}
+
+func TestFetchPostPageRejectsBadCursor(t *testing.T) {
+	store := setupTestDB(t)
+
+	_, err := store.FetchPostPage(database.PostListOptions{After: "not-a-cursor"})
+	if !errors.Is(err, database.ErrInvalidCursor) {
+		t.Errorf("Expected ErrInvalidCursor, got %v", err)
+	}
+}
@@ -0,0 +1,117 @@
+package tests
+
+import (
+	"forum-go/api"
+	"forum-go/middleware"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func testRateLimiter(policy middleware.RatePolicy) (*middleware.RateLimiter, *fakeClock) {
+	clock := &fakeClock{now: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)}
+	limiter := middleware.NewRateLimiter(policy)
+	limiter.Now = clock.Now
+	return limiter, clock
+}
+
+func TestRateLimiterTokenBucket(t *testing.T) {
+	limiter, clock := testRateLimiter(middleware.RatePolicy{Burst: 3, Refill: 2 * time.Second})
+
+	for i := 0; i < 3; i++ {
+		if ok, _ := limiter.Allow("a"); !ok {
+			t.Fatalf("Request %d of the burst was refused", i+1)
+		}
+	}
+	ok, wait := limiter.Allow("a")
+	if ok || wait != 2*time.Second {
+		t.Fatalf("Empty bucket: got ok=%v wait=%v, want a 2s wait", ok, wait)
+	}
+	if ok, _ := limiter.Allow("b"); !ok {
+		t.Error("Clients must not share buckets")
+	}
+
+	clock.Advance(time.Second)
+	if ok, wait := limiter.Allow("a"); ok || wait != time.Second {
+		t.Errorf("Half a token: got ok=%v wait=%v, want a 1s wait", ok, wait)
+	}
+	clock.Advance(time.Second)
+	if ok, _ := limiter.Allow("a"); !ok {
+		t.Error("Expected a token after the refill time")
+	}
+
+	// An idle client gets its whole burst back, and no more
+	clock.Advance(time.Hour)
+	for i := 0; i < 3; i++ {
+		if ok, _ := limiter.Allow("a"); !ok {
+			t.Fatalf("Request %d after idling was refused", i+1)
+		}
+	}
+	if ok, _ := limiter.Allow("a"); ok {
+		t.Error("The bucket must not grow past the burst")
+	}
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	app, store := setupTestApp(t)
+	db := store.DB()
+	mw := middleware.New(app.Users, app.Sessions)
+	limiter, _ := testRateLimiter(middleware.RatePolicy{Burst: 1, Refill: time.Minute})
+	h := mw.RateLimit(limiter, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	call := func(method, remoteAddr string, cookie *http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/vote", nil)
+		req.RemoteAddr = remoteAddr
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		rr := httptest.NewRecorder()
+		h(rr, req)
+		return rr
+	}
+
+	// Guests are limited by address
+	if rr := call(http.MethodPost, "10.0.0.1:1000", nil); rr.Code != http.StatusOK {
+		t.Fatalf("First guest write: got %v", rr.Code)
+	}
+	rr := call(http.MethodPost, "10.0.0.1:2000", nil)
+	if rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("Second guest write: got %v, want 429", rr.Code)
+	}
+	if got := rr.Header().Get("Retry-After"); got != "60" {
+		t.Errorf("Retry-After: got %q, want 60", got)
+	}
+	if rr := call(http.MethodGet, "10.0.0.1:3000", nil); rr.Code != http.StatusOK {
+		t.Errorf("Reads are not limited, got %v", rr.Code)
+	}
+
+	// Signed-in users are limited by account, wherever they connect from
+	cookie := sessionCookie(t, store, userIDByName(t, db, "Mama"))
+	if rr := call(http.MethodPost, "10.0.0.1:4000", cookie); rr.Code != http.StatusOK {
+		t.Errorf("User behind a limited address: got %v", rr.Code)
+	}
+	if rr := call(http.MethodPost, "10.0.0.2:1000", cookie); rr.Code != http.StatusTooManyRequests {
+		t.Errorf("Same user from another address: got %v, want 429", rr.Code)
+	}
+}
+
+func TestAPIRateLimit(t *testing.T) {
+	app, _ := setupTestApp(t)
+	limiter, _ := testRateLimiter(middleware.RatePolicy{Burst: 1, Refill: time.Minute})
+	h := api.Handler(app, limiter)
+
+	body := map[string]string{"username": "nobody", "password": "wrong"}
+	if status, _ := apiRequest(t, h, http.MethodPost, "/api/v1/session", "", body); status != http.StatusUnauthorized {
+		t.Fatalf("First login: got %v", status)
+	}
+	status, resp := apiRequest(t, h, http.MethodPost, "/api/v1/session", "", body)
+	if status != http.StatusTooManyRequests || errorCode(resp) != "too_many_requests" {
+		t.Errorf("Second login: got %v %v", status, resp)
+	}
+	if status, _ := apiRequest(t, h, http.MethodGet, "/api/v1/posts", "", nil); status != http.StatusOK {
+		t.Errorf("API reads are not limited, got %v", status)
+	}
+}
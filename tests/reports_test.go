@@ -0,0 +1,180 @@
+package tests
+
+import (
+	"database/sql"
+	"errors"
+	"forum-go/database"
+	"forum-go/middleware"
+	"forum-go/model"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCreateReport(t *testing.T) {
+	store := setupTestDB(t)
+	db := store.DB()
+	mamaID := userIDByName(t, db, "Mama")
+	postID := firstPostID(t, store)
+
+	if err := store.CreateReport(mamaID, "post", postID, "spoiler", "ending in the first line"); err != nil {
+		t.Fatalf("CreateReport failed: %v", err)
+	}
+	if err := store.CreateReport(mamaID, "post", postID, "spam", ""); !errors.Is(err, database.ErrAlreadyReported) {
+		t.Errorf("Second open report on the same post: got %v, want ErrAlreadyReported", err)
+	}
+	if err := store.CreateReport(mamaID, "post", postID, "rude", ""); err == nil {
+		t.Error("Expected an unknown reason to be rejected")
+	}
+	if err := store.CreateReport(mamaID, "comment", 99999, "spam", ""); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("Reporting a missing comment: got %v, want sql.ErrNoRows", err)
+	}
+
+	reports, err := store.FetchReportsByUser(mamaID)
+	if err != nil {
+		t.Fatalf("FetchReportsByUser failed: %v", err)
+	}
+	if len(reports) != 1 || reports[0].Status != database.ReportOpen || reports[0].PostID != postID {
+		t.Errorf("Expected one open report on post %d, got %+v", postID, reports)
+	}
+}
+
+func TestAcceptReport(t *testing.T) {
+	store := setupTestDB(t)
+	db := store.DB()
+	adminID := promoteAdmin(t, store)
+	mamaID := userIDByName(t, db, "Mama")
+	batmanID := userIDByName(t, db, "batman")
+	postID := firstPostID(t, store)
+	commentID := createComment(t, store, postID, 0, "buy cheap tickets here")
+
+	for _, id := range []int{mamaID, batmanID} {
+		if err := store.CreateReport(id, "comment", commentID, "spam", ""); err != nil {
+			t.Fatalf("CreateReport failed: %v", err)
+		}
+	}
+
+	queue, err := store.FetchOpenReports()
+	if err != nil {
+		t.Fatalf("FetchOpenReports failed: %v", err)
+	}
+	if len(queue) != 2 || queue[0].Excerpt != "buy cheap tickets here" {
+		t.Fatalf("Expected both reports in the queue, got %+v", queue)
+	}
+
+	if err := store.ResolveReport(adminID, queue[0].ID, true); err != nil {
+		t.Fatalf("ResolveReport failed: %v", err)
+	}
+
+	comment, err := store.FetchCommentByID(commentID)
+	if err != nil {
+		t.Fatalf("FetchCommentByID failed: %v", err)
+	}
+	if !comment.Hidden {
+		t.Error("Accepting a report should hide the comment")
+	}
+
+	queue, err = store.FetchOpenReports()
+	if err != nil {
+		t.Fatalf("FetchOpenReports failed: %v", err)
+	}
+	if len(queue) != 0 {
+		t.Errorf("Accepting should close every report on the comment, %d left", len(queue))
+	}
+
+	reports, err := store.FetchReportsByUser(batmanID)
+	if err != nil {
+		t.Fatalf("FetchReportsByUser failed: %v", err)
+	}
+	if len(reports) != 1 || reports[0].Status != database.ReportAccepted || reports[0].ResolvedAt.IsZero() {
+		t.Fatalf("Reporter should see the report accepted, got %+v", reports)
+	}
+
+	entries, err := store.FetchModerationLog(10)
+	if err != nil {
+		t.Fatalf("FetchModerationLog failed: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Action != database.ActionHideComment || !strings.Contains(entries[0].Reason, "spam") {
+		t.Errorf("Expected the hide in the moderation log, got %+v", entries)
+	}
+
+	if err := store.ResolveReport(adminID, reports[0].ID, false); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("Resolving a closed report: got %v, want sql.ErrNoRows", err)
+	}
+}
+
+func TestDismissReport(t *testing.T) {
+	store := setupTestDB(t)
+	db := store.DB()
+	adminID := promoteAdmin(t, store)
+	mamaID := userIDByName(t, db, "Mama")
+	postID := firstPostID(t, store)
+
+	if err := store.CreateReport(mamaID, "post", postID, "other", "I just don't like it"); err != nil {
+		t.Fatalf("CreateReport failed: %v", err)
+	}
+	reports, err := store.FetchReportsByUser(mamaID)
+	if err != nil || len(reports) != 1 {
+		t.Fatalf("FetchReportsByUser: got %v, %v", reports, err)
+	}
+
+	if err := store.ResolveReport(adminID, reports[0].ID, false); err != nil {
+		t.Fatalf("ResolveReport failed: %v", err)
+	}
+
+	post, err := store.FetchPostByID(postID)
+	if err != nil {
+		t.Fatalf("FetchPostByID failed: %v", err)
+	}
+	if post.Hidden {
+		t.Error("Dismissing a report must not hide the post")
+	}
+
+	reports, err = store.FetchReportsByUser(mamaID)
+	if err != nil {
+		t.Fatalf("FetchReportsByUser failed: %v", err)
+	}
+	if reports[0].Status != database.ReportDismissed {
+		t.Errorf("Status: got %q, want %q", reports[0].Status, database.ReportDismissed)
+	}
+
+	// Once dismissed, the same reader can report the post again
+	if err := store.CreateReport(mamaID, "post", postID, "spam", ""); err != nil {
+		t.Errorf("Reporting again after a dismissal failed: %v", err)
+	}
+}
+
+func TestReportQueueHandler(t *testing.T) {
+	app, store := setupTestApp(t)
+	db := store.DB()
+	mw := middleware.New(app.Users, app.Sessions)
+	adminID := promoteAdmin(t, store)
+	mamaID := userIDByName(t, db, "Mama")
+	postID := firstPostID(t, store)
+
+	if err := store.CreateReport(mamaID, "post", postID, "harassment", "name-calling"); err != nil {
+		t.Fatalf("CreateReport failed: %v", err)
+	}
+
+	queue := mw.RequireRole(model.RoleModerator, app.ReportQueueHandler)
+
+	req := httptest.NewRequest(http.MethodGet, "/moderation/reports", nil)
+	req.AddCookie(sessionCookie(t, store, mamaID))
+	rr := httptest.NewRecorder()
+	queue(rr, req)
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("User opening the queue: got %v, want %v", rr.Code, http.StatusForbidden)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/moderation/reports", nil)
+	req.AddCookie(sessionCookie(t, store, adminID))
+	rr = httptest.NewRecorder()
+	queue(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Admin opening the queue: got %v, want %v", rr.Code, http.StatusOK)
+	}
+	if !strings.Contains(rr.Body.String(), "name-calling") {
+		t.Error("Expected the report details on the queue page")
+	}
+}
@@ -0,0 +1,157 @@
+package tests
+
+import (
+	"forum-go/database"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSearchPostsFindsPostsAndComments(t *testing.T) {
+	store := setupTestDB(t)
+	db := store.DB()
+
+	var postID int
+	if err := db.QueryRow("SELECT id FROM posts WHERE title LIKE 'Whispers%'").Scan(&postID); err != nil {
+		t.Fatalf("Finding post failed: %v", err)
+	}
+	_, err := db.Exec("INSERT INTO comments (post_id, user_id, content) VALUES (?, 1, 'The saloon scene gave me chills')", postID)
+	if err != nil {
+		t.Fatalf("Inserting comment failed: %v", err)
+	}
+
+	results, err := store.SearchPosts(database.SearchOptions{Query: "saloon"})
+	if err != nil {
+		t.Fatalf("SearchPosts failed: %v", err)
+	}
+	if len(results) != 1 || results[0].CommentID == 0 || results[0].PostID != postID {
+		t.Fatalf("Expected one comment hit on post %d, got %+v", postID, results)
+	}
+	if !strings.Contains(results[0].Snippet, database.SnippetOpen+"saloon"+database.SnippetClose) {
+		t.Errorf("Snippet should highlight the match: %q", results[0].Snippet)
+	}
+
+	results, err = store.SearchPosts(database.SearchOptions{Query: "Horizon"})
+	if err != nil {
+		t.Fatalf("SearchPosts failed: %v", err)
+	}
+	if len(results) != 1 || results[0].CommentID != 0 {
+		t.Errorf("Expected one post hit for Horizon, got %+v", results)
+	}
+}
+
+func TestSearchPostsStaysInSyncWithEdits(t *testing.T) {
+	store := setupTestDB(t)
+	db := store.DB()
+
+	_, err := db.Exec("UPDATE posts SET content = 'Now about a submarine heist' WHERE title LIKE 'Laughing Through Time%'")
+	if err != nil {
+		t.Fatalf("Updating post failed: %v", err)
+	}
+
+	results, err := store.SearchPosts(database.SearchOptions{Query: "submarine"})
+	if err != nil {
+		t.Fatalf("SearchPosts failed: %v", err)
+	}
+	if len(results) != 1 {
+		t.Errorf("Expected the edited post to be searchable, got %d results", len(results))
+	}
+
+	results, err = store.SearchPosts(database.SearchOptions{Query: "stitches"})
+	if err != nil {
+		t.Fatalf("SearchPosts failed: %v", err)
+	}
+	if len(results) != 0 {
+		t.Errorf("Old content should no longer match, got %d results", len(results))
+	}
+}
+
+func TestSearchPostsFilters(t *testing.T) {
+	store := setupTestDB(t)
+
+	tests := []struct {
+		name string
+		opts database.SearchOptions
+		want int
+	}{
+		{"No Filter", database.SearchOptions{Query: "the"}, 2},
+		{"Category", database.SearchOptions{Query: "the", Category: "Western"}, 1},
+		{"Author", database.SearchOptions{Query: "the", Author: "Mama"}, 1},
+		{"Future Date", database.SearchOptions{Query: "the", From: time.Now().Add(24 * time.Hour)}, 0},
+		{"Operators Are Literal", database.SearchOptions{Query: `the OR "NEAR(`}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results, err := store.SearchPosts(tt.opts)
+			if err != nil {
+				t.Fatalf("SearchPosts failed: %v", err)
+			}
+			if len(results) != tt.want {
+				t.Errorf("Got %d results, want %d", len(results), tt.want)
+			}
+		})
+	}
+}
+
+func TestSearchHandlerEscapesSnippets(t *testing.T) {
+	app, store := setupTestApp(t)
+	db := store.DB()
+
+	_, err := db.Exec("INSERT INTO posts (title, content, user_id) VALUES ('Script test', '<script>alert(1)</script> popcorn', 1)")
+	if err != nil {
+		t.Fatalf("Inserting post failed: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/search?q=popcorn", nil)
+	rr := httptest.NewRecorder()
+	app.SearchHandler(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("SearchHandler status: got %v, want %v", rr.Code, http.StatusOK)
+	}
+	body := rr.Body.String()
+	if strings.Contains(body, "<script>alert(1)</script>") {
+		t.Error("Snippet content must be escaped")
+	}
+	if !strings.Contains(body, "<mark>popcorn</mark>") {
+		t.Error("Expected highlighted match in the response")
+	}
+}
+
+func TestSearchIndexRebuiltWithFTS5(t *testing.T) {
+	store := setupTestDB(t)
+	db := store.DB()
+
+	// Put back the FTS4 index that builds without the sqlite_fts5 tag made
+	migrateDownTo(t, store, 18)
+	for _, stmt := range []string{
+		`DROP TABLE posts_fts`,
+		`DROP TABLE comments_fts`,
+		`CREATE VIRTUAL TABLE posts_fts USING fts4(title, content)`,
+		`CREATE VIRTUAL TABLE comments_fts USING fts4(content)`,
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatalf("%s: %v", stmt, err)
+		}
+	}
+
+	if err := store.MigrateUp(); err != nil {
+		t.Fatalf("MigrateUp failed: %v", err)
+	}
+	var ddl string
+	if err := db.QueryRow("SELECT sql FROM sqlite_master WHERE name = 'posts_fts'").Scan(&ddl); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(strings.ToLower(ddl), "fts5") {
+		t.Errorf("Search index was not rebuilt with FTS5: %s", ddl)
+	}
+
+	// The rebuilt index holds the existing posts
+	results, err := store.SearchPosts(database.SearchOptions{Query: "Horizon"})
+	if err != nil || len(results) != 1 {
+		t.Errorf("Search after the rebuild: got %+v, %v", results, err)
+	}
+}
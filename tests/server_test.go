@@ -0,0 +1,256 @@
+package tests
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"forum-go/config"
+	"forum-go/live"
+	"forum-go/server"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// serveInBackground runs server.Serve for h on a free local port and
+// returns the server's address, the function that shuts it down, and where
+// Serve's result arrives.
+func serveInBackground(t *testing.T, cfg config.Server, h http.Handler, onShutdown ...func()) (string, context.CancelFunc, <-chan error) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	srv := server.NewHTTPServer(cfg, h)
+	for _, f := range onShutdown {
+		srv.RegisterOnShutdown(f)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- server.Serve(ctx, srv, ln, cfg) }()
+	t.Cleanup(cancel)
+	return ln.Addr().String(), cancel, done
+}
+
+func waitServed(t *testing.T, done <-chan error) error {
+	t.Helper()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(5 * time.Second):
+		t.Fatal("Serve did not return after the shutdown")
+	}
+	return nil
+}
+
+func TestNewHTTPServerTimeouts(t *testing.T) {
+	cfg := config.Default().Server
+	cfg.Port = 8123
+	cfg.ReadTimeout = config.Duration{Duration: 5 * time.Second}
+
+	srv := server.NewHTTPServer(cfg, http.NotFoundHandler())
+	if srv.Addr != ":8123" {
+		t.Errorf("Expected address :8123, got %q", srv.Addr)
+	}
+	if srv.ReadTimeout != 5*time.Second || srv.WriteTimeout != cfg.WriteTimeout.Duration || srv.IdleTimeout != cfg.IdleTimeout.Duration {
+		t.Errorf("Timeouts not applied: read %v, write %v, idle %v", srv.ReadTimeout, srv.WriteTimeout, srv.IdleTimeout)
+	}
+}
+
+func TestServeDrainsOpenRequests(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		io.WriteString(w, "finished")
+	})
+	addr, shutdown, done := serveInBackground(t, config.Default().Server, h)
+
+	type result struct {
+		body string
+		err  error
+	}
+	got := make(chan result, 1)
+	go func() {
+		resp, err := http.Get("http://" + addr + "/")
+		if err != nil {
+			got <- result{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		got <- result{string(body), err}
+	}()
+	<-started
+
+	shutdown()
+	select {
+	case err := <-done:
+		t.Fatalf("Serve returned while a request was open: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if conn, err := net.DialTimeout("tcp", addr, time.Second); err == nil {
+		conn.Close()
+		t.Error("New connections should be refused during the shutdown")
+	}
+
+	close(release)
+	res := <-got
+	if res.err != nil || res.body != "finished" {
+		t.Errorf("The open request should finish, got %q, %v", res.body, res.err)
+	}
+	if err := waitServed(t, done); err != nil {
+		t.Errorf("Expected a clean shutdown, got %v", err)
+	}
+}
+
+func TestServeShutdownTimeout(t *testing.T) {
+	cfg := config.Default().Server
+	cfg.ShutdownTimeout = config.Duration{Duration: 100 * time.Millisecond}
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	})
+	addr, shutdown, done := serveInBackground(t, cfg, h)
+
+	go func() {
+		if resp, err := http.Get("http://" + addr + "/"); err == nil {
+			resp.Body.Close()
+		}
+	}()
+	<-started
+
+	shutdown()
+	if err := waitServed(t, done); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the shutdown to time out, got %v", err)
+	}
+}
+
+func TestServeEndsLiveStreams(t *testing.T) {
+	hub := live.NewHub(0, live.DefaultReplayTTL)
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sub := hub.Subscribe(1, "")
+		defer sub.Close()
+		w.WriteHeader(http.StatusOK)
+		http.NewResponseController(w).Flush()
+		for range sub.Events {
+		}
+	})
+	addr, shutdown, done := serveInBackground(t, config.Default().Server, h, hub.Close)
+
+	resp, err := http.Get("http://" + addr + "/")
+	if err != nil {
+		t.Fatalf("Opening the stream failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	shutdown()
+	if err := waitServed(t, done); err != nil {
+		t.Errorf("Expected the stream to end and the server to stop, got %v", err)
+	}
+	if _, err := io.ReadAll(resp.Body); err != nil {
+		t.Errorf("The stream should end cleanly, got %v", err)
+	}
+
+	// Subscriptions made after the shutdown end straight away
+	if _, ok := <-hub.Subscribe(2, "").Events; ok {
+		t.Error("A closed hub should not deliver events")
+	}
+}
+
+func TestServeTLS(t *testing.T) {
+	cfg := config.Default().Server
+	cfg.TLSCert, cfg.TLSKey = writeTestCertificate(t)
+
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS == nil {
+			t.Error("Expected the request to arrive over TLS")
+		}
+		io.WriteString(w, "secure")
+	})
+	addr, shutdown, done := serveInBackground(t, cfg, h)
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+	resp, err := client.Get("https://" + addr + "/")
+	if err != nil {
+		t.Fatalf("HTTPS request failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "secure" {
+		t.Errorf("Unexpected body %q", body)
+	}
+	client.CloseIdleConnections()
+
+	shutdown()
+	if err := waitServed(t, done); err != nil {
+		t.Errorf("Expected a clean shutdown, got %v", err)
+	}
+}
+
+func TestServeBadCertificate(t *testing.T) {
+	cfg := config.Default().Server
+	dir := t.TempDir()
+	cfg.TLSCert, cfg.TLSKey = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	os.WriteFile(cfg.TLSCert, []byte("not a certificate"), 0o600)
+	os.WriteFile(cfg.TLSKey, []byte("not a key"), 0o600)
+
+	_, _, done := serveInBackground(t, cfg, http.NotFoundHandler())
+	if err := waitServed(t, done); err == nil {
+		t.Error("Expected Serve to fail with an unusable certificate")
+	}
+}
+
+// writeTestCertificate writes a self-signed certificate for 127.0.0.1 and
+// its key, and returns their paths.
+func writeTestCertificate(t *testing.T) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Generating key failed: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "forum test"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Creating certificate failed: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Encoding key failed: %v", err)
+	}
+
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatalf("Writing certificate failed: %v", err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatalf("Writing key failed: %v", err)
+	}
+	return certFile, keyFile
+}
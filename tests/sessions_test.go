@@ -0,0 +1,305 @@
+package tests
+
+import (
+	"database/sql"
+	"errors"
+	"forum-go/database"
+	"forum-go/middleware"
+	"forum-go/model"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSessionsOnSeveralDevices(t *testing.T) {
+	store := setupTestDB(t)
+	db := store.DB()
+	mamaID := userIDByName(t, db, "Mama")
+
+	laptop, err := store.NewSession(mamaID, "Mozilla/5.0 (X11; Linux x86_64) Firefox/128.0", "10.0.0.1", false)
+	if err != nil {
+		t.Fatalf("NewSession failed: %v", err)
+	}
+	phone, err := store.NewSession(mamaID, "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0) Safari/604.1", "10.0.0.2", false)
+	if err != nil {
+		t.Fatalf("NewSession failed: %v", err)
+	}
+
+	// Signing in on the phone keeps the laptop signed in
+	for _, token := range []string{laptop.Value, phone.Value} {
+		if ok, userID := store.SessionUserID(token); !ok || userID != mamaID {
+			t.Errorf("Session %s: got %v %d", token, ok, userID)
+		}
+	}
+
+	sessions, err := store.FetchSessions(mamaID, phone.Value)
+	if err != nil {
+		t.Fatalf("FetchSessions failed: %v", err)
+	}
+	if len(sessions) != 2 {
+		t.Fatalf("Expected 2 sessions, got %+v", sessions)
+	}
+	var current model.Session
+	for _, s := range sessions {
+		if s.Current {
+			current = s
+		}
+		if s.CreatedAt.IsZero() || s.LastSeen.IsZero() {
+			t.Errorf("Missing times on %+v", s)
+		}
+	}
+	if current.IP != "10.0.0.2" || current.Device() != "Safari on iOS" {
+		t.Errorf("Unexpected current session %+v (%s)", current, current.Device())
+	}
+
+	// Another user cannot revoke Mama's sessions
+	batmanID := userIDByName(t, db, "batman")
+	if err := store.EndUserSession(batmanID, current.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("Revoking someone else's session: got %v", err)
+	}
+
+	if err := store.EndUserSession(mamaID, current.ID); err != nil {
+		t.Fatalf("EndUserSession failed: %v", err)
+	}
+	if ok, _ := store.SessionUserID(phone.Value); ok {
+		t.Error("The revoked session still works")
+	}
+	if ok, _ := store.SessionUserID(laptop.Value); !ok {
+		t.Error("Revoking one session must keep the others")
+	}
+
+	if err := store.EndAllSessions(mamaID); err != nil {
+		t.Fatalf("EndAllSessions failed: %v", err)
+	}
+	if ok, _ := store.SessionUserID(laptop.Value); ok {
+		t.Error("Logging out everywhere left a session")
+	}
+}
+
+func TestSessionDevice(t *testing.T) {
+	for ua, want := range map[string]string{
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 Chrome/126.0 Safari/537.36 Edg/126.0": "Edge on Windows",
+		"Mozilla/5.0 (Linux; Android 14) AppleWebKit/537.36 Chrome/126.0 Mobile Safari/537.36":              "Chrome on Android",
+		"Mozilla/5.0 (Macintosh; Intel Mac OS X 14_5) AppleWebKit/605.1.15 Version/17.5 Safari/605.1.15":    "Safari on macOS",
+		"curl/8.5.0": "curl",
+		"":           "Unknown browser",
+	} {
+		if got := (model.Session{UserAgent: ua}).Device(); got != want {
+			t.Errorf("Device(%q): got %q, want %q", ua, got, want)
+		}
+	}
+}
+
+func TestRevokeSessionHandler(t *testing.T) {
+	app, store := setupTestApp(t)
+	db := store.DB()
+	mw := middleware.New(app.Users, app.Sessions)
+	mamaID := userIDByName(t, db, "Mama")
+	here := sessionCookie(t, store, mamaID)
+	other := sessionCookie(t, store, mamaID)
+
+	sessions, err := store.FetchSessions(mamaID, here.Value)
+	if err != nil || len(sessions) != 2 {
+		t.Fatalf("FetchSessions: %v %+v", err, sessions)
+	}
+	ids := map[bool]int{}
+	for _, s := range sessions {
+		ids[s.Current] = s.ID
+	}
+
+	revoke := mw.RequireRole(model.RoleUser, app.RevokeSessionHandler)
+	post := func(id int) *httptest.ResponseRecorder {
+		form := url.Values{"session_id": {strconv.Itoa(id)}}
+		req := httptest.NewRequest(http.MethodPost, "/sessions/revoke", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.AddCookie(here)
+		rr := httptest.NewRecorder()
+		revoke(rr, req)
+		return rr
+	}
+
+	if rr := post(9999); rr.Code != http.StatusNotFound {
+		t.Errorf("Unknown session: got %v, want 404", rr.Code)
+	}
+
+	rr := post(ids[false])
+	if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/profile#devices" {
+		t.Errorf("Revoking another device: got %v %q", rr.Code, rr.Header().Get("Location"))
+	}
+	if ok, _ := store.SessionUserID(other.Value); ok {
+		t.Error("The other device is still signed in")
+	}
+
+	// Revoking this browser's own session logs it out
+	rr = post(ids[true])
+	if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/" {
+		t.Errorf("Revoking the current session: got %v %q", rr.Code, rr.Header().Get("Location"))
+	}
+	if !strings.Contains(rr.Header().Get("Set-Cookie"), "session_token=;") {
+		t.Errorf("Expected the session cookie to be cleared, got %q", rr.Header().Get("Set-Cookie"))
+	}
+}
+
+func TestLogoutEverywhereHandler(t *testing.T) {
+	app, store := setupTestApp(t)
+	db := store.DB()
+	mw := middleware.New(app.Users, app.Sessions)
+	mamaID := userIDByName(t, db, "Mama")
+	here := sessionCookie(t, store, mamaID)
+	other := sessionCookie(t, store, mamaID)
+	batman := sessionCookie(t, store, userIDByName(t, db, "batman"))
+
+	req := httptest.NewRequest(http.MethodPost, "/logout/all", nil)
+	req.AddCookie(here)
+	rr := httptest.NewRecorder()
+	mw.RequireRole(model.RoleUser, app.LogoutEverywhereHandler)(rr, req)
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("Logout everywhere: got %v", rr.Code)
+	}
+
+	for _, c := range []*http.Cookie{here, other} {
+		if ok, _ := store.SessionUserID(c.Value); ok {
+			t.Errorf("Session %s survived logging out everywhere", c.Value)
+		}
+	}
+	if ok, _ := store.SessionUserID(batman.Value); !ok {
+		t.Error("Other users must stay signed in")
+	}
+}
+
+// ageSession makes a session look idle since lastSeen and due to end at
+// expires.
+func ageSession(t *testing.T, db *sql.DB, token string, lastSeen, expires time.Time) {
+	t.Helper()
+	_, err := db.Exec("UPDATE sessions SET last_seen = ?, session_expiry = ? WHERE session_token = ?",
+		lastSeen.UTC(), expires.UTC(), token)
+	if err != nil {
+		t.Fatalf("Aging session failed: %v", err)
+	}
+}
+
+func TestRememberMeSessions(t *testing.T) {
+	store := setupTestDB(t)
+	db := store.DB()
+	mamaID := userIDByName(t, db, "Mama")
+
+	opts := database.SessionOptions{
+		TTL:         2 * time.Hour,
+		RememberTTL: 72 * time.Hour,
+		Secure:      true,
+		SameSite:    http.SameSiteStrictMode,
+	}
+	store.ConfigureSessions(opts)
+
+	short, err := store.NewSession(mamaID, "", "", false)
+	if err != nil {
+		t.Fatalf("NewSession failed: %v", err)
+	}
+	long, err := store.NewSession(mamaID, "", "", true)
+	if err != nil {
+		t.Fatalf("NewSession failed: %v", err)
+	}
+
+	if d := time.Until(short.Expires); d < opts.TTL-time.Minute || d > opts.TTL {
+		t.Errorf("Session cookie lasts %v, want %v", d, opts.TTL)
+	}
+	if d := time.Until(long.Expires); d < opts.RememberTTL-time.Minute || d > opts.RememberTTL {
+		t.Errorf("Remembered cookie lasts %v, want %v", d, opts.RememberTTL)
+	}
+	if !short.Secure || short.SameSite != http.SameSiteStrictMode || !short.HttpOnly || short.Path != "/" {
+		t.Errorf("Cookie attributes do not follow the settings: %+v", short)
+	}
+
+	// Renewing keeps each session's own lifetime
+	ageSession(t, db, long.Value, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+	cookie, err := store.RenewSession(long.Value)
+	if err != nil || cookie == nil {
+		t.Fatalf("RenewSession: %v %v", cookie, err)
+	}
+	if d := time.Until(cookie.Expires); d < opts.RememberTTL-time.Minute {
+		t.Errorf("Renewed remembered session lasts %v, want %v", d, opts.RememberTTL)
+	}
+}
+
+func TestSlidingSessionExpiry(t *testing.T) {
+	app, store := setupTestApp(t)
+	db := store.DB()
+	mw := middleware.New(app.Users, app.Sessions)
+	mamaID := userIDByName(t, db, "Mama")
+	session, err := store.NewSession(mamaID, "", "", false)
+	if err != nil {
+		t.Fatalf("NewSession failed: %v", err)
+	}
+
+	// Renewals are skipped right after the last one
+	if cookie, err := store.RenewSession(session.Value); err != nil || cookie != nil {
+		t.Errorf("Fresh session: got %v %v, want no renewal", cookie, err)
+	}
+
+	// A session close to its end is pushed back on activity
+	ageSession(t, db, session.Value, time.Now().Add(-23*time.Hour), time.Now().Add(time.Hour))
+	h := mw.SlidingSessions(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(session)
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+
+	var renewed *http.Cookie
+	for _, c := range rr.Result().Cookies() {
+		if c.Name == "session_token" {
+			renewed = c
+		}
+	}
+	if renewed == nil || renewed.Value != session.Value {
+		t.Fatalf("Expected the renewed session cookie, got %v", rr.Result().Cookies())
+	}
+	if d := time.Until(renewed.Expires); d < database.DefaultSessionOptions.TTL-time.Minute {
+		t.Errorf("Renewed cookie lasts %v, want %v", d, database.DefaultSessionOptions.TTL)
+	}
+	var expiry time.Time
+	db.QueryRow("SELECT session_expiry FROM sessions WHERE session_token = ?", session.Value).Scan(&expiry)
+	if time.Until(expiry) < database.DefaultSessionOptions.TTL-time.Minute {
+		t.Errorf("The stored expiry was not extended: %v", expiry)
+	}
+
+	// Expired sessions are not brought back
+	ageSession(t, db, session.Value, time.Now().Add(-25*time.Hour), time.Now().Add(-time.Hour))
+	if cookie, err := store.RenewSession(session.Value); err != nil || cookie != nil {
+		t.Errorf("Expired session: got %v %v, want no renewal", cookie, err)
+	}
+	if ok, _ := store.SessionUserID(session.Value); ok {
+		t.Error("An expired session must not work")
+	}
+}
+
+func TestSessionJanitor(t *testing.T) {
+	store := setupTestDB(t)
+	db := store.DB()
+	db.SetMaxOpenConns(1)
+	mamaID := userIDByName(t, db, "Mama")
+	live, _ := store.NewSession(mamaID, "", "", false)
+	expired, _ := store.NewSession(mamaID, "", "", false)
+	ageSession(t, db, expired.Value, time.Now().Add(-2*time.Hour), time.Now().Add(-time.Hour))
+
+	stop := store.StartSessionJanitor(10 * time.Millisecond)
+	defer stop()
+
+	count := func(token string) int {
+		var n int
+		db.QueryRow("SELECT COUNT(*) FROM sessions WHERE session_token = ?", token).Scan(&n)
+		return n
+	}
+	for i := 0; i < 100 && count(expired.Value) > 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if count(expired.Value) != 0 {
+		t.Error("The janitor did not remove the expired session")
+	}
+	if count(live.Value) != 1 {
+		t.Error("The janitor removed a live session")
+	}
+}
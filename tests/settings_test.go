@@ -0,0 +1,273 @@
+package tests
+
+import (
+	"errors"
+	"fmt"
+	"forum-go/auth"
+	"forum-go/database"
+	"forum-go/handler"
+	"forum-go/middleware"
+	"forum-go/model"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// postSettings submits a settings form the way the server routes it.
+func postSettings(t *testing.T, app *handler.App, h http.HandlerFunc, cookie *http.Cookie, form url.Values) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/settings", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(cookie)
+	rr := httptest.NewRecorder()
+	middleware.New(app.Users, app.Sessions).RequireRole(model.RoleUser, h)(rr, req)
+	return rr
+}
+
+func TestSettingsPage(t *testing.T) {
+	app, store := setupTestApp(t)
+	db := store.DB()
+	mw := middleware.New(app.Users, app.Sessions)
+	cookie := sessionCookie(t, store, userIDByName(t, db, "Mama"))
+
+	req := httptest.NewRequest(http.MethodGet, "/settings", nil)
+	req.AddCookie(cookie)
+	rr := httptest.NewRecorder()
+	mw.RequireRole(model.RoleUser, app.SettingsHandler)(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Settings page: got %v", rr.Code)
+	}
+	for _, action := range []string{"/settings/username", "/settings/email", "/settings/password", "/settings/delete"} {
+		if !strings.Contains(rr.Body.String(), `action="`+action+`"`) {
+			t.Errorf("Settings page has no form for %s", action)
+		}
+	}
+}
+
+func TestChangeUsername(t *testing.T) {
+	app, store := setupTestApp(t)
+	db := store.DB()
+	mamaID := userIDByName(t, db, "Mama")
+	cookie := sessionCookie(t, store, mamaID)
+
+	for name, tc := range map[string]struct{ username, message string }{
+		"too short": {"mum", "between 5 and"},
+		"bad chars": {"mama bear", "can only contain"},
+		"taken":     {"batman", "already taken"},
+		"missing":   {"  ", "is required"},
+	} {
+		rr := postSettings(t, app, app.ChangeUsernameHandler, cookie, url.Values{"username": {tc.username}})
+		if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), tc.message) {
+			t.Errorf("%s: got %v, want 400 with %q", name, rr.Code, tc.message)
+		}
+	}
+
+	rr := postSettings(t, app, app.ChangeUsernameHandler, cookie, url.Values{"username": {"MamaBear"}})
+	if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/settings?saved=username#username" {
+		t.Fatalf("Rename: got %v %q", rr.Code, rr.Header().Get("Location"))
+	}
+	user, _ := store.FetchUserById(mamaID)
+	if user.Username != "MamaBear" {
+		t.Errorf("Username is %q", user.Username)
+	}
+	posts, err := store.FetchPostsByUserID(mamaID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range posts {
+		if p.Author != "MamaBear" {
+			t.Errorf("Post %d still shows %q", p.ID, p.Author)
+		}
+	}
+}
+
+func TestChangeEmail(t *testing.T) {
+	app, store := setupTestApp(t)
+	db := store.DB()
+	addLoginUser(t, db, "robin")
+	robinID := userIDByName(t, db, "robin")
+	cookie := sessionCookie(t, store, robinID)
+	mails := captureMail(t, app)
+	db.Exec("UPDATE users SET email_verified_at = CURRENT_TIMESTAMP WHERE id = ?", robinID)
+
+	rr := postSettings(t, app, app.ChangeEmailHandler, cookie, url.Values{"email": {"new@example.com"}, "password": {"wrong"}})
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "password is wrong") {
+		t.Errorf("Wrong password: got %v", rr.Code)
+	}
+	rr = postSettings(t, app, app.ChangeEmailHandler, cookie, url.Values{"email": {"not-an-address"}, "password": {"Passw0rd!x"}})
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "invalid email") {
+		t.Errorf("Bad address: got %v", rr.Code)
+	}
+	user, _ := store.FetchUserById(robinID)
+	if user.Email != "robin@example.com" || len(mails()) != 0 {
+		t.Fatalf("Refused changes must not touch the account: %q, %d mails", user.Email, len(mails()))
+	}
+
+	rr = postSettings(t, app, app.ChangeEmailHandler, cookie, url.Values{"email": {"new@example.com"}, "password": {"Passw0rd!x"}})
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("Change email: got %v %q", rr.Code, rr.Body.String())
+	}
+	user, _ = store.FetchUserById(robinID)
+	if user.Email != "new@example.com" || !user.EmailVerifiedAt.IsZero() {
+		t.Errorf("Expected an unverified new@example.com, got %q verified %v", user.Email, user.EmailVerifiedAt)
+	}
+	sent := mails()
+	if len(sent) != 1 || !strings.Contains(sent[0], "To: new@example.com") || !strings.Contains(sent[0], "/verify-email?token=") {
+		t.Fatalf("Expected a verification mail to the new address, got %q", sent)
+	}
+
+	rr = httptest.NewRecorder()
+	app.VerifyEmailHandler(rr, httptest.NewRequest(http.MethodGet, linkIn(t, sent[0]), nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("Verifying the new address: got %v", rr.Code)
+	}
+}
+
+func TestChangePassword(t *testing.T) {
+	app, store := setupTestApp(t)
+	db := store.DB()
+	addLoginUser(t, db, "robin")
+	robinID := userIDByName(t, db, "robin")
+	here := sessionCookie(t, store, robinID)
+	other := sessionCookie(t, store, robinID)
+
+	for name, form := range map[string]url.Values{
+		"mismatch":      {"current_password": {"Passw0rd!x"}, "password": {"N3wPassw0rd!"}, "confirm": {"N3wPassw0rd?"}},
+		"weak":          {"current_password": {"Passw0rd!x"}, "password": {"password"}, "confirm": {"password"}},
+		"wrong current": {"current_password": {"Passw0rd!y"}, "password": {"N3wPassw0rd!"}, "confirm": {"N3wPassw0rd!"}},
+	} {
+		if rr := postSettings(t, app, app.ChangePasswordHandler, here, form); rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: got %v, want 400", name, rr.Code)
+		}
+	}
+	if _, err := app.Accounts.Authenticate("robin", "Passw0rd!x"); err != nil {
+		t.Fatalf("Refused changes must keep the password: %v", err)
+	}
+
+	rr := postSettings(t, app, app.ChangePasswordHandler, here, url.Values{
+		"current_password": {"Passw0rd!x"}, "password": {"N3wPassw0rd!"}, "confirm": {"N3wPassw0rd!"},
+	})
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("Change password: got %v", rr.Code)
+	}
+	if _, err := app.Accounts.Authenticate("robin", "N3wPassw0rd!"); err != nil {
+		t.Errorf("New password does not work: %v", err)
+	}
+	if ok, _ := store.SessionUserID(here.Value); !ok {
+		t.Error("The browser that changed the password must stay signed in")
+	}
+	if ok, _ := store.SessionUserID(other.Value); ok {
+		t.Error("Other devices must be signed out")
+	}
+}
+
+// robinWithContent adds robin with a post, a comment on someone else's
+// post and a vote, and returns robin's ID and post ID.
+func robinWithContent(t *testing.T, store *database.Store) (int, int) {
+	t.Helper()
+	db := store.DB()
+	addLoginUser(t, db, "robin")
+	robinID := userIDByName(t, db, "robin")
+
+	res, err := db.Exec("INSERT INTO posts (title, content, user_id) VALUES ('Robin writes', 'Body text', ?)", robinID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	postID, _ := res.LastInsertId()
+
+	var otherPost int
+	db.QueryRow("SELECT id FROM posts WHERE user_id != ? LIMIT 1", robinID).Scan(&otherPost)
+	if _, err := store.CreateComment(otherPost, robinID, 0, "Robin's comment"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.TogglePostVote(robinID, otherPost, 1); err != nil {
+		t.Fatal(err)
+	}
+	return robinID, int(postID)
+}
+
+func TestDeleteAccountKeepsContent(t *testing.T) {
+	app, store := setupTestApp(t)
+	db := store.DB()
+	robinID, postID := robinWithContent(t, store)
+	cookie := sessionCookie(t, store, robinID)
+
+	rr := postSettings(t, app, app.DeleteAccountHandler, cookie, url.Values{"content": {"anonymize"}, "password": {"wrong"}})
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Wrong password: got %v, want 400", rr.Code)
+	}
+	rr = postSettings(t, app, app.DeleteAccountHandler, cookie, url.Values{"password": {"Passw0rd!x"}})
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("No content choice: got %v, want 400", rr.Code)
+	}
+
+	rr = postSettings(t, app, app.DeleteAccountHandler, cookie, url.Values{"content": {"anonymize"}, "password": {"Passw0rd!x"}})
+	if rr.Code != http.StatusSeeOther || !strings.Contains(rr.Header().Get("Set-Cookie"), "session_token=;") {
+		t.Fatalf("Delete: got %v %q", rr.Code, rr.Header().Get("Set-Cookie"))
+	}
+
+	if ok, _ := store.SessionUserID(cookie.Value); ok {
+		t.Error("The session survived the deletion")
+	}
+	if _, err := app.Accounts.Authenticate("robin", "Passw0rd!x"); !errors.Is(err, auth.ErrInvalidCredentials) {
+		t.Errorf("Deleted account can still log in: %v", err)
+	}
+	if taken, _ := store.UsernameTaken("robin"); taken {
+		t.Error("The username should be free again")
+	}
+
+	post, err := store.FetchPostByID(postID)
+	if err != nil {
+		t.Fatalf("The post should stay up: %v", err)
+	}
+	if post.Author != fmt.Sprintf("[deleted-%d]", robinID) || post.Content != "Body text" {
+		t.Errorf("Post now shows %q by %q", post.Content, post.Author)
+	}
+	var votes int
+	db.QueryRow("SELECT COUNT(*) FROM votes WHERE user_id = ?", robinID).Scan(&votes)
+	if votes != 1 {
+		t.Errorf("Votes should be kept, got %d", votes)
+	}
+}
+
+func TestDeleteAccountRemovesContent(t *testing.T) {
+	app, store := setupTestApp(t)
+	db := store.DB()
+	robinID, postID := robinWithContent(t, store)
+	cookie := sessionCookie(t, store, robinID)
+
+	rr := postSettings(t, app, app.DeleteAccountHandler, cookie, url.Values{"content": {"remove"}, "password": {"Passw0rd!x"}})
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("Delete: got %v", rr.Code)
+	}
+
+	if _, err := store.FetchPostByID(postID); err == nil {
+		t.Error("The post should be gone")
+	}
+	var leftover int
+	db.QueryRow(`SELECT COUNT(*) FROM posts WHERE user_id = ?1 AND (content != '' OR deleted_at IS NULL)`, robinID).Scan(&leftover)
+	if leftover != 0 {
+		t.Error("Removed posts must lose their text")
+	}
+	db.QueryRow(`SELECT COUNT(*) FROM comments WHERE user_id = ?1 AND (content != '' OR deleted_at IS NULL)`, robinID).Scan(&leftover)
+	if leftover != 0 {
+		t.Error("Removed comments must lose their text")
+	}
+	db.QueryRow("SELECT COUNT(*) FROM votes WHERE user_id = ?", robinID).Scan(&leftover)
+	if leftover != 0 {
+		t.Error("Votes should be withdrawn")
+	}
+
+	// The account is gone from the moderators' user list too
+	users, err := store.FetchUsers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, u := range users {
+		if u.ID == robinID {
+			t.Error("Deleted accounts should not be listed")
+		}
+	}
+}
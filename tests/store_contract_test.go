@@ -0,0 +1,831 @@
+package tests
+
+import (
+	"database/sql"
+	"errors"
+	"forum-go/auth"
+	"forum-go/database"
+	"forum-go/database/postgres"
+	"forum-go/model"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// contractStore is everything a storage backend has to provide.
+type contractStore interface {
+	database.Stores
+	DB() *sql.DB
+	PromoteAdmin(username string) error
+}
+
+// storeBackends opens a fresh, seeded store of each backend. PostgreSQL
+// runs when FORUM_TEST_POSTGRES holds the DSN of a throwaway database; its
+// public schema is dropped first.
+var storeBackends = []struct {
+	name string
+	open func(t *testing.T) contractStore
+}{
+	{"sqlite", func(t *testing.T) contractStore { return setupTestDB(t) }},
+	{"postgres", setupTestPostgres},
+}
+
+func setupTestPostgres(t *testing.T) contractStore {
+	dsn := os.Getenv("FORUM_TEST_POSTGRES")
+	if dsn == "" {
+		t.Skip("FORUM_TEST_POSTGRES is not set")
+	}
+
+	store, err := postgres.Open(dsn)
+	if err != nil {
+		t.Fatalf("Opening PostgreSQL failed: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	if _, err := store.DB().Exec("DROP SCHEMA public CASCADE; CREATE SCHEMA public"); err != nil {
+		t.Fatalf("Resetting the schema failed: %v", err)
+	}
+	if err := store.Init(); err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+	return store
+}
+
+// runContract runs test once against every backend.
+func runContract(t *testing.T, test func(t *testing.T, store contractStore)) {
+	for _, backend := range storeBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			test(t, backend.open(t))
+		})
+	}
+}
+
+// contractAccounts is the auth store over the same database as store.
+func contractAccounts(store contractStore) auth.AccountStore {
+	if _, ok := store.(*postgres.Store); ok {
+		return auth.NewPostgresStore(store.DB(), "")
+	}
+	return auth.NewStore(store.DB(), "")
+}
+
+// contractUser looks up a seeded account through the store.
+func contractUser(t *testing.T, store contractStore, username string) int {
+	t.Helper()
+	id, err := store.FetchUserIDByUsername(username)
+	if err != nil {
+		t.Fatalf("FetchUserIDByUsername(%q) failed: %v", username, err)
+	}
+	return id
+}
+
+// contractPost creates a post by userID in the named categories.
+func contractPost(t *testing.T, store contractStore, userID int, title string, createdAt time.Time, categories ...string) int {
+	t.Helper()
+	all, err := store.FetchCategories()
+	if err != nil {
+		t.Fatalf("FetchCategories failed: %v", err)
+	}
+	post := &model.Post{Title: title, Content: "Content of " + title, UserID: userID, CreatedAt: createdAt, UpdatedAt: createdAt}
+	for _, name := range categories {
+		for _, c := range all {
+			if c.Name == name {
+				post.Categories = append(post.Categories, c)
+			}
+		}
+	}
+	id, err := store.CreatePost(post)
+	if err != nil {
+		t.Fatalf("CreatePost(%q) failed: %v", title, err)
+	}
+	return int(id)
+}
+
+func TestStoreContractSeed(t *testing.T) {
+	runContract(t, func(t *testing.T, store contractStore) {
+		categories, err := store.FetchCategories()
+		if err != nil {
+			t.Fatalf("FetchCategories failed: %v", err)
+		}
+		if len(categories) != len(database.SeedCategories) || categories[0].Name != "Action" {
+			t.Errorf("Categories: got %+v", categories)
+		}
+
+		posts, err := store.FetchPosts()
+		if err != nil {
+			t.Fatalf("FetchPosts failed: %v", err)
+		}
+		if len(posts) != len(database.SeedPosts) {
+			t.Fatalf("Expected %d seeded posts, got %d", len(database.SeedPosts), len(posts))
+		}
+		for _, p := range posts {
+			if p.Author == "" || len(p.Categories) == 0 {
+				t.Errorf("Seeded post %q: author %q, categories %+v", p.Title, p.Author, p.Categories)
+			}
+		}
+
+		users, err := store.FetchUsers()
+		if err != nil {
+			t.Fatalf("FetchUsers failed: %v", err)
+		}
+		var names []string
+		for _, u := range users {
+			names = append(names, u.Username)
+			// The seeded passwords are published, so no seeded account
+			// may hold a privileged role
+			if u.Role != model.RoleUser {
+				t.Errorf("Seeded user %s has role %q, want %q", u.Username, u.Role, model.RoleUser)
+			}
+		}
+		if strings.Join(names, ",") != "Mama,admin,batman" {
+			t.Errorf("Users should be ordered by name, got %v", names)
+		}
+	})
+}
+
+func TestStoreContractPosts(t *testing.T) {
+	runContract(t, func(t *testing.T, store contractStore) {
+		mamaID := contractUser(t, store, "Mama")
+		batmanID := contractUser(t, store, "batman")
+		postID := contractPost(t, store, mamaID, "Contract post", time.Now().UTC(), "Drama", "Comedy")
+
+		post, err := store.FetchPostByID(postID)
+		if err != nil {
+			t.Fatalf("FetchPostByID failed: %v", err)
+		}
+		if post.Title != "Contract post" || post.Author != "Mama" || len(post.Categories) != 2 || post.Categories[0].Name != "Comedy" {
+			t.Errorf("Created post: got %+v", post)
+		}
+
+		if err := store.UpdatePost(postID, batmanID, "Hijacked", "Hijacked"); !errors.Is(err, database.ErrNotAuthor) {
+			t.Errorf("UpdatePost by another user: got %v, want ErrNotAuthor", err)
+		}
+		if err := store.UpdatePost(postID, mamaID, database.SeedPosts[0].Title, "Taken"); !errors.Is(err, database.ErrDuplicateTitle) {
+			t.Errorf("UpdatePost to a taken title: got %v, want ErrDuplicateTitle", err)
+		}
+		if err := store.UpdatePost(postID, mamaID, "Contract post, edited", "New content"); err != nil {
+			t.Fatalf("UpdatePost failed: %v", err)
+		}
+		revisions, err := store.FetchPostRevisions(postID)
+		if err != nil {
+			t.Fatalf("FetchPostRevisions failed: %v", err)
+		}
+		if len(revisions) != 1 || revisions[0].Title != "Contract post" {
+			t.Errorf("Revisions: got %+v", revisions)
+		}
+		if post, _ = store.FetchPostByID(postID); post == nil || post.EditedAt.IsZero() {
+			t.Error("An edited post should have EditedAt set")
+		}
+
+		mine, err := store.FetchPostsByUserID(mamaID)
+		if err != nil {
+			t.Fatalf("FetchPostsByUserID failed: %v", err)
+		}
+		if len(mine) != 3 || mine[0].ID != postID {
+			t.Errorf("Mama's posts should start with the newest, got %d posts", len(mine))
+		}
+
+		drama, err := store.FetchPostsByCategory("Drama")
+		if err != nil {
+			t.Fatalf("FetchPostsByCategory failed: %v", err)
+		}
+		if len(drama) != 1 || drama[0].ID != postID {
+			t.Errorf("Drama posts: got %+v", drama)
+		}
+
+		if err := store.DeletePost(postID, batmanID); !errors.Is(err, database.ErrNotAuthor) {
+			t.Errorf("DeletePost by another user: got %v, want ErrNotAuthor", err)
+		}
+		if err := store.DeletePost(postID, mamaID); err != nil {
+			t.Fatalf("DeletePost failed: %v", err)
+		}
+		if _, err := store.FetchPostByID(postID); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("Deleted post: got %v, want sql.ErrNoRows", err)
+		}
+	})
+}
+
+func TestStoreContractPostPages(t *testing.T) {
+	runContract(t, func(t *testing.T, store contractStore) {
+		adminID := contractUser(t, store, "admin")
+		start := time.Now().UTC().Add(time.Hour)
+		var created []int
+		for i := 0; i < 5; i++ {
+			created = append(created, contractPost(t, store, adminID, "Paged post "+string(rune('A'+i)), start.Add(time.Duration(i)*time.Minute), "Horror"))
+		}
+
+		// Walk forward through the Horror posts two at a time
+		var seen []int
+		opts := database.PostListOptions{Category: "Horror", Sort: database.SortNewest, Limit: 2}
+		var pages []*database.PostPage
+		for {
+			page, err := store.FetchPostPage(opts)
+			if err != nil {
+				t.Fatalf("FetchPostPage failed: %v", err)
+			}
+			pages = append(pages, page)
+			for _, p := range page.Posts {
+				seen = append(seen, p.ID)
+			}
+			if page.NextCursor == "" {
+				break
+			}
+			opts.After = page.NextCursor
+		}
+
+		// One seeded Horror post, older than ours
+		want := []int{created[4], created[3], created[2], created[1], created[0]}
+		if len(seen) != 6 || !reflect.DeepEqual(seen[:5], want) {
+			t.Fatalf("Newest first: got %v, want %v then the seeded post", seen, want)
+		}
+
+		back, err := store.FetchPostPage(database.PostListOptions{Category: "Horror", Sort: database.SortNewest, Limit: 2, Before: pages[1].PrevCursor})
+		if err != nil {
+			t.Fatalf("FetchPostPage backwards failed: %v", err)
+		}
+		if len(back.Posts) != 2 || back.Posts[0].ID != created[4] || back.PrevCursor != "" {
+			t.Errorf("Going back should return the first page, got %+v", back)
+		}
+
+		oldest, err := store.FetchPostPage(database.PostListOptions{Category: "Horror", Sort: database.SortOldest, Limit: 1, After: pages[0].NextCursor})
+		if err != nil {
+			t.Fatalf("FetchPostPage oldest failed: %v", err)
+		}
+		if len(oldest.Posts) != 1 || oldest.Posts[0].ID != created[4] {
+			t.Errorf("Oldest after a cursor: got %+v", oldest.Posts)
+		}
+
+		for _, sort := range []string{database.SortTop, database.SortComments, database.SortActive} {
+			if page, err := store.FetchPostPage(database.PostListOptions{Sort: sort}); err != nil || len(page.Posts) == 0 {
+				t.Errorf("Sort %s: got %v", sort, err)
+			}
+		}
+		if _, err := store.FetchPostPage(database.PostListOptions{Sort: "random"}); err == nil {
+			t.Error("An unknown sort order should fail")
+		}
+		if _, err := store.FetchPostPage(database.PostListOptions{After: "not a cursor"}); !errors.Is(err, database.ErrInvalidCursor) {
+			t.Errorf("Bad cursor: got %v, want ErrInvalidCursor", err)
+		}
+	})
+}
+
+func TestStoreContractComments(t *testing.T) {
+	runContract(t, func(t *testing.T, store contractStore) {
+		mamaID := contractUser(t, store, "Mama")
+		batmanID := contractUser(t, store, "batman")
+		postID := contractPost(t, store, mamaID, "Commented post", time.Now().UTC())
+		otherID := contractPost(t, store, mamaID, "Other post", time.Now().UTC())
+
+		rootID, err := store.CreateComment(postID, batmanID, 0, "First!")
+		if err != nil {
+			t.Fatalf("CreateComment failed: %v", err)
+		}
+		replyID, err := store.CreateComment(postID, mamaID, int(rootID), "Welcome")
+		if err != nil {
+			t.Fatalf("CreateComment reply failed: %v", err)
+		}
+		if _, err := store.CreateComment(otherID, mamaID, int(rootID), "Wrong thread"); !errors.Is(err, database.ErrParentNotFound) {
+			t.Errorf("Reply across posts: got %v, want ErrParentNotFound", err)
+		}
+		if _, err := store.CreateComment(postID+1000, mamaID, 0, "Nowhere"); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("Comment on a missing post: got %v, want sql.ErrNoRows", err)
+		}
+
+		tree, err := store.FetchCommentTree(postID, database.DefaultMaxCommentDepth)
+		if err != nil {
+			t.Fatalf("FetchCommentTree failed: %v", err)
+		}
+		if len(tree) != 1 || len(tree[0].Replies) != 1 || tree[0].Replies[0].ID != int(replyID) || tree[0].Replies[0].Depth != 1 {
+			t.Errorf("Comment tree: got %+v", tree)
+		}
+
+		if err := store.UpdateComment(int(rootID), mamaID, "Edited"); !errors.Is(err, database.ErrNotAuthor) {
+			t.Errorf("UpdateComment by another user: got %v, want ErrNotAuthor", err)
+		}
+		if err := store.UpdateComment(int(rootID), batmanID, "First, edited"); err != nil {
+			t.Fatalf("UpdateComment failed: %v", err)
+		}
+		revisions, err := store.FetchCommentRevisions(int(rootID))
+		if err != nil || len(revisions) != 1 || revisions[0].Content != "First!" {
+			t.Errorf("Comment revisions: got %+v, %v", revisions, err)
+		}
+		comment, err := store.FetchCommentByID(int(rootID))
+		if err != nil || comment.Content != "First, edited" || comment.Author != "batman" || comment.EditedAt.IsZero() {
+			t.Errorf("Edited comment: got %+v, %v", comment, err)
+		}
+
+		if err := store.DeleteComment(int(rootID), batmanID); err != nil {
+			t.Fatalf("DeleteComment failed: %v", err)
+		}
+		if _, err := store.FetchCommentByID(int(rootID)); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("Deleted comment: got %v, want sql.ErrNoRows", err)
+		}
+		comments, err := store.FetchCommentsByPostID(postID)
+		if err != nil {
+			t.Fatalf("FetchCommentsByPostID failed: %v", err)
+		}
+		if len(comments) != 2 || !comments[0].Deleted || comments[0].Content != "" || comments[0].Author != "" {
+			t.Errorf("A deleted comment should keep its place blanked, got %+v", comments)
+		}
+	})
+}
+
+func TestStoreContractVotes(t *testing.T) {
+	runContract(t, func(t *testing.T, store contractStore) {
+		mamaID := contractUser(t, store, "Mama")
+		batmanID := contractUser(t, store, "batman")
+		postID := contractPost(t, store, mamaID, "Voted post", time.Now().UTC())
+
+		steps := []struct {
+			userID, vote int
+			want         database.VoteResult
+		}{
+			{batmanID, 1, database.VoteResult{Vote: 1, Upvotes: 1}},
+			{mamaID, -1, database.VoteResult{Vote: -1, Upvotes: 1, Downvotes: 1}},
+			{batmanID, -1, database.VoteResult{Vote: -1, Downvotes: 2}},
+			{batmanID, -1, database.VoteResult{Vote: 0, Downvotes: 1}},
+		}
+		for i, step := range steps {
+			got, err := store.TogglePostVote(step.userID, postID, step.vote)
+			if err != nil {
+				t.Fatalf("Step %d: TogglePostVote failed: %v", i, err)
+			}
+			step.want.PostID = postID
+			if *got != step.want {
+				t.Errorf("Step %d: got %+v, want %+v", i, *got, step.want)
+			}
+		}
+
+		if _, err := store.TogglePostVote(batmanID, postID, 2); !errors.Is(err, database.ErrInvalidVote) {
+			t.Errorf("Vote of 2: got %v, want ErrInvalidVote", err)
+		}
+		if _, err := store.TogglePostVote(batmanID, postID+1000, 1); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("Vote on a missing post: got %v, want sql.ErrNoRows", err)
+		}
+
+		commentID, err := store.CreateComment(postID, batmanID, 0, "Vote on me")
+		if err != nil {
+			t.Fatalf("CreateComment failed: %v", err)
+		}
+		got, err := store.ToggleCommentVote(mamaID, int(commentID), 1)
+		if err != nil || got.Upvotes != 1 || got.PostID != postID {
+			t.Errorf("ToggleCommentVote: got %+v, %v", got, err)
+		}
+
+		if err := store.UpdateVote(batmanID, postID, 1); err != nil {
+			t.Fatalf("UpdateVote failed: %v", err)
+		}
+		if err := store.UpdateVote(batmanID, postID, 1); err != nil {
+			t.Fatalf("Repeated UpdateVote failed: %v", err)
+		}
+		liked, err := store.FetchLikedPostsByUserID(batmanID)
+		if err != nil || len(liked) != 1 || liked[0].ID != postID {
+			t.Errorf("Liked posts: got %+v, %v", liked, err)
+		}
+		disliked, err := store.FetchDislikedPostsByUserID(mamaID)
+		if err != nil || len(disliked) != 1 || disliked[0].ID != postID {
+			t.Errorf("Disliked posts: got %+v, %v", disliked, err)
+		}
+	})
+}
+
+func TestStoreContractUsers(t *testing.T) {
+	runContract(t, func(t *testing.T, store contractStore) {
+		mamaID := contractUser(t, store, "Mama")
+
+		user, err := store.FetchUserByEmail("MAMA@yahoo.com")
+		if err != nil || user.ID != mamaID || user.Role != model.RoleUser {
+			t.Fatalf("FetchUserByEmail should ignore case, got %+v, %v", user, err)
+		}
+
+		if err := store.UpdateUsername(mamaID, "batman"); !errors.Is(err, database.ErrUsernameTaken) {
+			t.Errorf("Taken username: got %v, want ErrUsernameTaken", err)
+		}
+		if err := store.UpdateEmail(mamaID, "batman@batman.com"); !errors.Is(err, database.ErrEmailTaken) {
+			t.Errorf("Taken email: got %v, want ErrEmailTaken", err)
+		}
+		if err := store.UpdateUsername(mamaID, "Mother"); err != nil {
+			t.Fatalf("UpdateUsername failed: %v", err)
+		}
+		if err := store.UpdateEmail(mamaID, "mother@example.com"); err != nil {
+			t.Fatalf("UpdateEmail failed: %v", err)
+		}
+		if user, err = store.FetchUserById(mamaID); err != nil || user.Username != "Mother" || user.Email != "mother@example.com" {
+			t.Errorf("Updated user: got %+v, %v", user, err)
+		}
+
+		if _, err := store.NewSession(mamaID, "test", "127.0.0.1", false); err != nil {
+			t.Fatalf("NewSession failed: %v", err)
+		}
+		if err := store.DeleteAccount(mamaID, true); err != nil {
+			t.Fatalf("DeleteAccount failed: %v", err)
+		}
+		if _, err := store.FetchUserIDByUsername("Mother"); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("The old name should be free, got %v", err)
+		}
+		if posts, err := store.FetchPostsByUserID(mamaID); err != nil || len(posts) != 0 {
+			t.Errorf("Posts of a removed account: got %d, %v", len(posts), err)
+		}
+		if sessions, err := store.FetchSessions(mamaID, ""); err != nil || len(sessions) != 0 {
+			t.Errorf("Sessions of a removed account: got %d, %v", len(sessions), err)
+		}
+		if err := store.DeleteAccount(mamaID, false); err == nil {
+			t.Error("Deleting an account twice should fail")
+		}
+	})
+}
+
+func TestStoreContractSessions(t *testing.T) {
+	runContract(t, func(t *testing.T, store contractStore) {
+		batmanID := contractUser(t, store, "batman")
+
+		laptop, err := store.NewSession(batmanID, "laptop", "10.0.0.1", false)
+		if err != nil {
+			t.Fatalf("NewSession failed: %v", err)
+		}
+		phone, err := store.NewSession(batmanID, "phone", "10.0.0.2", true)
+		if err != nil {
+			t.Fatalf("NewSession failed: %v", err)
+		}
+		if !phone.Expires.After(laptop.Expires) {
+			t.Error("A remembered session should outlive a normal one")
+		}
+		if ok, userID := store.SessionUserID(laptop.Value); !ok || userID != batmanID {
+			t.Errorf("SessionUserID: got %v %d", ok, userID)
+		}
+		if cookie, err := store.RenewSession(laptop.Value); err != nil || cookie != nil {
+			t.Errorf("A session used just now should not be renewed again, got %v, %v", cookie, err)
+		}
+
+		sessions, err := store.FetchSessions(batmanID, phone.Value)
+		if err != nil || len(sessions) != 2 {
+			t.Fatalf("FetchSessions: got %+v, %v", sessions, err)
+		}
+		var laptopID int
+		for _, s := range sessions {
+			if s.Current != (s.UserAgent == "phone") {
+				t.Errorf("Only the phone is current, got %+v", s)
+			}
+			if s.UserAgent == "laptop" {
+				laptopID = s.ID
+			}
+		}
+
+		if err := store.EndUserSession(contractUser(t, store, "Mama"), laptopID); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("Ending someone else's session: got %v, want sql.ErrNoRows", err)
+		}
+		if err := store.EndOtherSessions(batmanID, phone.Value); err != nil {
+			t.Fatalf("EndOtherSessions failed: %v", err)
+		}
+		if ok, _ := store.SessionUserID(laptop.Value); ok {
+			t.Error("The laptop should be signed out")
+		}
+		if err := store.EndSession(phone.Value); err != nil {
+			t.Fatalf("EndSession failed: %v", err)
+		}
+		if ok, _ := store.SessionUserID(phone.Value); ok {
+			t.Error("The phone should be signed out")
+		}
+		if _, err := store.PurgeExpiredSessions(); err != nil {
+			t.Errorf("PurgeExpiredSessions failed: %v", err)
+		}
+	})
+}
+
+func TestStoreContractSearch(t *testing.T) {
+	runContract(t, func(t *testing.T, store contractStore) {
+		mamaID := contractUser(t, store, "Mama")
+		postID := contractPost(t, store, mamaID, "Wolves of the tundra", time.Now().UTC(), "Documentary")
+		if _, err := store.CreateComment(postID, contractUser(t, store, "batman"), 0, "The wolves steal the show"); err != nil {
+			t.Fatalf("CreateComment failed: %v", err)
+		}
+
+		results, err := store.SearchPosts(database.SearchOptions{Query: "WOLVES"})
+		if err != nil {
+			t.Fatalf("SearchPosts failed: %v", err)
+		}
+		if len(results) != 2 {
+			t.Fatalf("Expected the post and its comment, got %+v", results)
+		}
+		for _, r := range results {
+			if r.PostID != postID || !strings.Contains(r.Snippet, database.SnippetOpen) {
+				t.Errorf("Search hit: got %+v", r)
+			}
+		}
+
+		results, err = store.SearchPosts(database.SearchOptions{Query: "wolves", Author: "batman"})
+		if err != nil || len(results) != 1 || results[0].CommentID == 0 {
+			t.Errorf("Author filter: got %+v, %v", results, err)
+		}
+		results, err = store.SearchPosts(database.SearchOptions{Query: "wolves", Category: "Drama"})
+		if err != nil || len(results) != 0 {
+			t.Errorf("Category filter: got %+v, %v", results, err)
+		}
+		results, err = store.SearchPosts(database.SearchOptions{Query: "wolves", From: time.Now().Add(time.Hour)})
+		if err != nil || len(results) != 0 {
+			t.Errorf("Date filter: got %+v, %v", results, err)
+		}
+		if results, err = store.SearchPosts(database.SearchOptions{Query: " -- "}); err != nil || results != nil {
+			t.Errorf("A query without words: got %+v, %v", results, err)
+		}
+	})
+}
+
+func TestStoreContractNotifications(t *testing.T) {
+	runContract(t, func(t *testing.T, store contractStore) {
+		mamaID := contractUser(t, store, "Mama")
+		batmanID := contractUser(t, store, "batman")
+		adminID := contractUser(t, store, "admin")
+		postID := contractPost(t, store, mamaID, "Notified post", time.Now())
+
+		kinds := func(userID int) []string {
+			t.Helper()
+			notifications, err := store.FetchNotifications(userID, 10)
+			if err != nil {
+				t.Fatalf("FetchNotifications failed: %v", err)
+			}
+			var got []string
+			for _, n := range notifications {
+				got = append(got, n.Kind+":"+n.Detail)
+			}
+			return got
+		}
+
+		comment, err := store.CreateComment(postID, batmanID, 0, "Nice one @admin")
+		if err != nil {
+			t.Fatalf("CreateComment failed: %v", err)
+		}
+		if err := store.NotifyNewComment(int(comment)); err != nil {
+			t.Fatalf("NotifyNewComment failed: %v", err)
+		}
+		reply, err := store.CreateComment(postID, mamaID, int(comment), "Thanks")
+		if err != nil {
+			t.Fatalf("CreateComment failed: %v", err)
+		}
+		if err := store.NotifyNewComment(int(reply)); err != nil {
+			t.Fatalf("NotifyNewComment failed: %v", err)
+		}
+
+		// Flipping a vote replaces the unread notification
+		for _, vote := range []int{1, -1} {
+			if err := store.NotifyVote(batmanID, postID, 0, vote); err != nil {
+				t.Fatalf("NotifyVote failed: %v", err)
+			}
+		}
+		if got := kinds(mamaID); !reflect.DeepEqual(got, []string{"vote:down", "comment:"}) {
+			t.Errorf("Post author: got %v", got)
+		}
+		if got := kinds(batmanID); !reflect.DeepEqual(got, []string{"reply:"}) {
+			t.Errorf("Comment author: got %v", got)
+		}
+		if got := kinds(adminID); !reflect.DeepEqual(got, []string{"mention:"}) {
+			t.Errorf("Mentioned user: got %v", got)
+		}
+
+		prefs := map[string]bool{}
+		for _, kind := range database.NotificationKinds {
+			prefs[kind] = kind != database.NotificationVote
+		}
+		if err := store.SetNotificationPreferences(mamaID, prefs); err != nil {
+			t.Fatalf("SetNotificationPreferences failed: %v", err)
+		}
+		if got, err := store.FetchNotificationPreferences(mamaID); err != nil || !reflect.DeepEqual(got, prefs) {
+			t.Errorf("FetchNotificationPreferences: got %v, %v", got, err)
+		}
+		if err := store.NotifyVote(adminID, postID, 0, 1); err != nil {
+			t.Fatalf("NotifyVote failed: %v", err)
+		}
+		if got := kinds(mamaID); len(got) != 2 {
+			t.Errorf("A switched off kind should not be stored, got %v", got)
+		}
+
+		notifications, _ := store.FetchNotifications(batmanID, 10)
+		if err := store.MarkNotificationRead(mamaID, notifications[0].ID); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("Marking someone else's notification: got %v, want sql.ErrNoRows", err)
+		}
+		if err := store.MarkAllNotificationsRead(mamaID); err != nil {
+			t.Fatalf("MarkAllNotificationsRead failed: %v", err)
+		}
+		if user, err := store.FetchUserById(mamaID); err != nil || user.UnreadCount != 0 {
+			t.Errorf("Unread after marking all read: got %+v, %v", user, err)
+		}
+	})
+}
+
+func TestStoreContractModeration(t *testing.T) {
+	runContract(t, func(t *testing.T, store contractStore) {
+		adminID := contractUser(t, store, "admin")
+		mamaID := contractUser(t, store, "Mama")
+		batmanID := contractUser(t, store, "batman")
+		postID := contractPost(t, store, adminID, "Reported post", time.Now())
+
+		if err := store.PromoteAdmin("admin"); err != nil {
+			t.Fatalf("PromoteAdmin failed: %v", err)
+		}
+		if err := store.SetUserRole(adminID, batmanID, model.RoleModerator); err != nil {
+			t.Fatalf("SetUserRole failed: %v", err)
+		}
+		if err := store.SetUserBanned(batmanID, adminID, true, "coup"); !errors.Is(err, database.ErrInsufficientRole) {
+			t.Errorf("Banning an admin: got %v, want ErrInsufficientRole", err)
+		}
+
+		if err := store.CreateReport(mamaID, "post", postID, database.ReasonSpam, "ads"); err != nil {
+			t.Fatalf("CreateReport failed: %v", err)
+		}
+		if err := store.CreateReport(mamaID, "post", postID, database.ReasonOther, ""); !errors.Is(err, database.ErrAlreadyReported) {
+			t.Errorf("Second open report: got %v, want ErrAlreadyReported", err)
+		}
+		if err := store.CreateReport(batmanID, "post", postID, database.ReasonSpoiler, ""); err != nil {
+			t.Fatalf("CreateReport failed: %v", err)
+		}
+		open, err := store.FetchOpenReports()
+		if err != nil || len(open) != 2 || open[0].Reporter != "Mama" || open[0].PostID != postID {
+			t.Fatalf("FetchOpenReports: got %+v, %v", open, err)
+		}
+
+		// Accepting one report closes the other and hides the post
+		if err := store.ResolveReport(batmanID, open[0].ID, true); err != nil {
+			t.Fatalf("ResolveReport failed: %v", err)
+		}
+		if open, err := store.FetchOpenReports(); err != nil || len(open) != 0 {
+			t.Errorf("Open reports after accepting: got %+v, %v", open, err)
+		}
+		if mine, err := store.FetchReportsByUser(mamaID); err != nil || len(mine) != 1 || mine[0].Status != database.ReportAccepted {
+			t.Errorf("FetchReportsByUser: got %+v, %v", mine, err)
+		}
+		if err := store.CreateReport(mamaID, "post", postID, database.ReasonSpam, ""); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("Reporting a hidden post: got %v, want sql.ErrNoRows", err)
+		}
+		if notifications, err := store.FetchNotifications(mamaID, 10); err != nil || len(notifications) != 1 || notifications[0].Detail != database.ReportAccepted {
+			t.Errorf("Reporter notification: got %+v, %v", notifications, err)
+		}
+
+		if err := store.SetPostLocked(batmanID, postID, true, "closed"); err != nil {
+			t.Fatalf("SetPostLocked failed: %v", err)
+		}
+		if err := store.SetUserBanned(batmanID, mamaID, true, "spam"); err != nil {
+			t.Fatalf("SetUserBanned failed: %v", err)
+		}
+		if user, err := store.FetchUserById(mamaID); err != nil || !user.Banned() {
+			t.Errorf("Banned user: got %+v, %v", user, err)
+		}
+
+		log, err := store.FetchModerationLog(10)
+		if err != nil {
+			t.Fatalf("FetchModerationLog failed: %v", err)
+		}
+		var actions []string
+		for _, e := range log {
+			actions = append(actions, e.Action)
+		}
+		want := []string{database.ActionBanUser, database.ActionLockPost, database.ActionHidePost, database.ActionSetRole}
+		if !reflect.DeepEqual(actions, want) {
+			t.Errorf("Moderation log: got %v, want %v", actions, want)
+		}
+	})
+}
+
+func TestStoreContractMessages(t *testing.T) {
+	runContract(t, func(t *testing.T, store contractStore) {
+		mamaID := contractUser(t, store, "Mama")
+		batmanID := contractUser(t, store, "batman")
+
+		for _, m := range []struct {
+			from, to int
+			body     string
+		}{{mamaID, batmanID, "hi"}, {batmanID, mamaID, "hello"}, {mamaID, batmanID, "again"}} {
+			if _, err := store.SendMessage(m.from, m.to, m.body); err != nil {
+				t.Fatalf("SendMessage(%q) failed: %v", m.body, err)
+			}
+		}
+		if _, err := store.SendMessage(mamaID, mamaID, "me"); !errors.Is(err, database.ErrSelfMessage) {
+			t.Errorf("Messaging yourself: got %v, want ErrSelfMessage", err)
+		}
+		if _, err := store.SendMessage(mamaID, 99999, "anyone?"); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("Missing recipient: got %v, want sql.ErrNoRows", err)
+		}
+
+		if n, err := store.UnreadMessageCount(batmanID); err != nil || n != 2 {
+			t.Errorf("UnreadMessageCount: got %d, %v", n, err)
+		}
+		inbox, err := store.FetchConversations(batmanID)
+		if err != nil || len(inbox) != 1 || inbox[0].Other != "Mama" || inbox[0].Unread != 2 || inbox[0].LastMessage.Body != "again" {
+			t.Fatalf("FetchConversations: got %+v, %v", inbox, err)
+		}
+
+		latest, err := store.FetchMessages(batmanID, mamaID, 0, 2)
+		if err != nil || len(latest) != 2 || latest[0].Body != "hello" || latest[1].Body != "again" {
+			t.Fatalf("Latest messages: got %+v, %v", latest, err)
+		}
+		if newer, err := store.FetchMessages(batmanID, mamaID, latest[0].ID, 10); err != nil || len(newer) != 1 || newer[0].Body != "again" {
+			t.Errorf("Messages after an ID: got %+v, %v", newer, err)
+		}
+
+		if err := store.MarkConversationRead(batmanID, mamaID); err != nil {
+			t.Fatalf("MarkConversationRead failed: %v", err)
+		}
+		if n, err := store.UnreadMessageCount(batmanID); err != nil || n != 0 {
+			t.Errorf("UnreadMessageCount after reading: got %d, %v", n, err)
+		}
+
+		for i := 0; i < 2; i++ {
+			if err := store.BlockUser(batmanID, mamaID); err != nil {
+				t.Fatalf("BlockUser failed: %v", err)
+			}
+		}
+		if blocked, blockedBy, err := store.BlockState(mamaID, batmanID); err != nil || blocked || !blockedBy {
+			t.Errorf("BlockState: got %v %v, %v", blocked, blockedBy, err)
+		}
+		if _, err := store.SendMessage(mamaID, batmanID, "hello?"); !errors.Is(err, database.ErrBlocked) {
+			t.Errorf("Messaging a blocker: got %v, want ErrBlocked", err)
+		}
+		if err := store.UnblockUser(batmanID, mamaID); err != nil {
+			t.Fatalf("UnblockUser failed: %v", err)
+		}
+		if _, err := store.SendMessage(mamaID, batmanID, "hello?"); err != nil {
+			t.Errorf("SendMessage after unblocking failed: %v", err)
+		}
+	})
+}
+
+func TestStoreContractAccounts(t *testing.T) {
+	runContract(t, func(t *testing.T, store contractStore) {
+		accounts := contractAccounts(store)
+
+		if err := accounts.AddUser("robin", "Robin@example.com", "Passw0rd!x"); err != nil {
+			t.Fatalf("AddUser failed: %v", err)
+		}
+		user, err := accounts.Authenticate("robin", "Passw0rd!x")
+		if err != nil {
+			t.Fatalf("Authenticate failed: %v", err)
+		}
+		if _, err := accounts.Authenticate("robin", "wrong"); !errors.Is(err, auth.ErrInvalidCredentials) {
+			t.Errorf("Wrong password: got %v, want ErrInvalidCredentials", err)
+		}
+
+		token, err := accounts.IssueToken(user.ID, auth.PurposeVerifyEmail, "Robin@example.com", time.Hour)
+		if err != nil {
+			t.Fatalf("IssueToken failed: %v", err)
+		}
+		if id, err := accounts.VerifyEmail(token); err != nil || id != user.ID {
+			t.Fatalf("VerifyEmail: got %d, %v", id, err)
+		}
+		if _, err := accounts.VerifyEmail(token); !errors.Is(err, auth.ErrInvalidToken) {
+			t.Errorf("Reused token: got %v, want ErrInvalidToken", err)
+		}
+
+		// The verified address links the provider whatever its case
+		github := &auth.Identity{Provider: "github", Subject: "42", Email: "ROBIN@example.com", EmailVerified: true}
+		if signedIn, created, err := accounts.SignInWithIdentity(github); err != nil || created || signedIn.ID != user.ID {
+			t.Fatalf("SignInWithIdentity by email: got %+v %v, %v", signedIn, created, err)
+		}
+		newcomer := &auth.Identity{Provider: "github", Subject: "43", Email: "newcomer@example.com", EmailVerified: true, Username: "robin"}
+		signedIn, created, err := accounts.SignInWithIdentity(newcomer)
+		if err != nil || !created || signedIn.ID == user.ID || signedIn.Username != "robin2" {
+			t.Fatalf("SignInWithIdentity of a new person: got %+v %v, %v", signedIn, created, err)
+		}
+		if err := accounts.LinkIdentity(signedIn.ID, github); !errors.Is(err, auth.ErrIdentityTaken) {
+			t.Errorf("Linking a taken identity: got %v, want ErrIdentityTaken", err)
+		}
+		if identities, err := accounts.FetchIdentities(user.ID); err != nil || len(identities) != 1 {
+			t.Errorf("FetchIdentities: got %+v, %v", identities, err)
+		}
+
+		secret, err := accounts.BeginTwoFactor(user.ID)
+		if err != nil {
+			t.Fatalf("BeginTwoFactor failed: %v", err)
+		}
+		code, _ := auth.TOTPCode(secret, time.Now())
+		codes, err := accounts.EnableTwoFactor(user.ID, code)
+		if err != nil {
+			t.Fatalf("EnableTwoFactor failed: %v", err)
+		}
+		if err := accounts.VerifySecondFactor(user.ID, codes[0], time.Now()); err != nil {
+			t.Errorf("Recovery code: %v", err)
+		}
+		if err := accounts.VerifySecondFactor(user.ID, codes[0], time.Now()); !errors.Is(err, auth.ErrInvalidCode) {
+			t.Errorf("Reused recovery code: got %v, want ErrInvalidCode", err)
+		}
+		if tf, err := accounts.FetchTwoFactor(user.ID); err != nil || tf.EnabledAt.IsZero() || tf.RecoveryCodesLeft != auth.RecoveryCodeCount-1 {
+			t.Errorf("FetchTwoFactor: got %+v, %v", tf, err)
+		}
+
+		now := time.Now().UTC()
+		for _, ago := range []time.Duration{2 * time.Hour, 2 * time.Minute, time.Minute} {
+			if err := accounts.RecordLoginAttempt("robin", "10.0.0.9", auth.OutcomeFailure, now.Add(-ago)); err != nil {
+				t.Fatalf("RecordLoginAttempt failed: %v", err)
+			}
+		}
+		count, last, err := accounts.LoginFailures("robin", now.Add(-time.Hour))
+		if err != nil || count != 2 || last.Sub(now.Add(-time.Minute)).Abs() > time.Second {
+			t.Errorf("LoginFailures: got %d at %v, %v", count, last, err)
+		}
+		if count, _, err := accounts.AddressFailures("10.0.0.9", now.Add(-3*time.Hour)); err != nil || count != 3 {
+			t.Errorf("AddressFailures: got %d, %v", count, err)
+		}
+		if at, err := accounts.LastLoginAttempt("robin", auth.OutcomeSuccess); err != nil || !at.IsZero() {
+			t.Errorf("LastLoginAttempt without one: got %v, %v", at, err)
+		}
+	})
+}
@@ -0,0 +1,307 @@
+package tests
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"forum-go/auth"
+	"forum-go/handler"
+	"forum-go/middleware"
+	"forum-go/model"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// enrollTwoFactor turns on two-factor login for a user, confirming the
+// setup with the previous step's code so that the current and next ones
+// are still unused.
+func enrollTwoFactor(t *testing.T, db *sql.DB, userID int) (string, []string) {
+	t.Helper()
+	accounts := auth.NewStore(db, "")
+	secret, err := accounts.BeginTwoFactor(userID)
+	if err != nil {
+		t.Fatalf("BeginTwoFactor failed: %v", err)
+	}
+	codes, err := accounts.EnableTwoFactor(userID, totp(t, secret, -30*time.Second))
+	if err != nil {
+		t.Fatalf("EnableTwoFactor failed: %v", err)
+	}
+	return secret, codes
+}
+
+func totp(t *testing.T, secret string, offset time.Duration) string {
+	t.Helper()
+	code, err := auth.TOTPCode(secret, time.Now().Add(offset))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+// passwordLogin posts the login modal's form and returns the response.
+func passwordLogin(t *testing.T, app *handler.App, username string) *httptest.ResponseRecorder {
+	t.Helper()
+	var b bytes.Buffer
+	w := multipart.NewWriter(&b)
+	w.WriteField("username", username)
+	w.WriteField("password", "Passw0rd!x")
+	w.WriteField("remember", "on")
+	w.Close()
+	req := httptest.NewRequest(http.MethodPost, "/login", &b)
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	rr := httptest.NewRecorder()
+	app.LoginHandler(rr, req)
+	return rr
+}
+
+func cookieNamed(rr *httptest.ResponseRecorder, name string) *http.Cookie {
+	for _, c := range rr.Result().Cookies() {
+		if c.Name == name && c.Value != "" {
+			return c
+		}
+	}
+	return nil
+}
+
+func secondStep(t *testing.T, app *handler.App, pending *http.Cookie, code string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/login/two-factor", strings.NewReader(url.Values{"code": {code}}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if pending != nil {
+		req.AddCookie(pending)
+	}
+	rr := httptest.NewRecorder()
+	app.TwoFactorLoginHandler(rr, req)
+	return rr
+}
+
+func TestTOTPCode(t *testing.T) {
+	// RFC 6238 test vectors for the SHA-1 key "12345678901234567890"
+	secret := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+	for unix, want := range map[int64]string{59: "287082", 1111111109: "081804", 2000000000: "279037"} {
+		got, err := auth.TOTPCode(secret, time.Unix(unix, 0))
+		if err != nil || got != want {
+			t.Errorf("At %d: got %q %v, want %q", unix, got, err, want)
+		}
+	}
+
+	uri := auth.TOTPURI("Reel Movie Talk", "robin", secret)
+	if !strings.HasPrefix(uri, "otpauth://totp/Reel%20Movie%20Talk:robin?") || !strings.Contains(uri, "secret="+secret) {
+		t.Errorf("URI: %s", uri)
+	}
+}
+
+func TestTwoFactorSetup(t *testing.T) {
+	app, store := setupTestApp(t)
+	db := store.DB()
+	mw := middleware.New(app.Users, app.Sessions)
+	mamaID := userIDByName(t, db, "Mama")
+	cookie := sessionCookie(t, store, mamaID)
+
+	if rr := postSettings(t, app, app.SetupTwoFactorHandler, cookie, nil); rr.Code != http.StatusSeeOther {
+		t.Fatalf("Setup: got %v", rr.Code)
+	}
+	tf, _ := app.Accounts.FetchTwoFactor(mamaID)
+	if tf.PendingSecret == "" || !tf.EnabledAt.IsZero() {
+		t.Fatalf("Expected a pending secret, got %+v", tf)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/settings", nil)
+	req.AddCookie(cookie)
+	rr := httptest.NewRecorder()
+	mw.RequireRole(model.RoleUser, app.SettingsHandler)(rr, req)
+	if !strings.Contains(rr.Body.String(), "data:image/png;base64,") || !strings.Contains(rr.Body.String(), `href="otpauth://totp/`) {
+		t.Error("The settings page should show the QR code and otpauth link")
+	}
+
+	rr = postSettings(t, app, app.EnableTwoFactorHandler, cookie, url.Values{"code": {"000000"}})
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Wrong code: got %v", rr.Code)
+	}
+	rr = postSettings(t, app, app.EnableTwoFactorHandler, cookie, url.Values{"code": {totp(t, tf.PendingSecret, 0)}})
+	if rr.Code != http.StatusOK || strings.Count(rr.Body.String(), "<code>") < auth.RecoveryCodeCount {
+		t.Fatalf("Enable: got %v, expected the recovery codes", rr.Code)
+	}
+	tf, _ = app.Accounts.FetchTwoFactor(mamaID)
+	if tf.EnabledAt.IsZero() || tf.PendingSecret != "" || tf.RecoveryCodesLeft != auth.RecoveryCodeCount {
+		t.Errorf("After enabling: %+v", tf)
+	}
+
+	rr = postSettings(t, app, app.RecoveryCodesHandler, cookie, url.Values{"password": {"wrong"}})
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("New codes with a wrong password: got %v", rr.Code)
+	}
+
+	rr = postSettings(t, app, app.DisableTwoFactorHandler, cookie, url.Values{"password": {"wrong"}})
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Disable with a wrong password: got %v", rr.Code)
+	}
+	var count int
+	db.QueryRow("SELECT COUNT(*) FROM recovery_codes WHERE user_id = ?", mamaID).Scan(&count)
+	if count != auth.RecoveryCodeCount {
+		t.Errorf("Expected %d stored recovery codes, got %d", auth.RecoveryCodeCount, count)
+	}
+}
+
+func TestTwoFactorLogin(t *testing.T) {
+	app, store := setupTestApp(t)
+	db := store.DB()
+	addLoginUser(t, db, "robin")
+	robinID := userIDByName(t, db, "robin")
+	secret, recovery := enrollTwoFactor(t, db, robinID)
+
+	rr := passwordLogin(t, app, "robin")
+	if rr.Code != http.StatusAccepted || rr.Header().Get("Location") != "/login/two-factor" {
+		t.Fatalf("Password step: got %v %q", rr.Code, rr.Header().Get("Location"))
+	}
+	if cookieNamed(rr, "session_token") != nil {
+		t.Fatal("No session before the second step")
+	}
+	pending := cookieNamed(rr, "two_factor")
+	if pending == nil || !pending.HttpOnly {
+		t.Fatal("Expected an HttpOnly two_factor cookie")
+	}
+
+	if rr := secondStep(t, app, nil, totp(t, secret, 0)); rr.Code != http.StatusBadRequest {
+		t.Errorf("Without the password step: got %v", rr.Code)
+	}
+	if rr := secondStep(t, app, pending, "123456"); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Wrong code: got %v", rr.Code)
+	}
+
+	code := totp(t, secret, 0)
+	rr = secondStep(t, app, pending, code)
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("Right code: got %v %s", rr.Code, rr.Body.String())
+	}
+	if s := cookieNamed(rr, "session_token"); s == nil || s.Expires.Before(time.Now().Add(48*time.Hour)) {
+		t.Errorf("Expected a remembered session, got %+v", s)
+	}
+	if rr := secondStep(t, app, pending, totp(t, secret, 30*time.Second)); rr.Code != http.StatusBadRequest {
+		t.Errorf("Reusing a finished login: got %v", rr.Code)
+	}
+
+	// A code works once, even with a new password step
+	pending = cookieNamed(passwordLogin(t, app, "robin"), "two_factor")
+	if rr := secondStep(t, app, pending, code); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Replayed code: got %v", rr.Code)
+	}
+	// So does each recovery code, in any format
+	typed := strings.ToUpper(strings.ReplaceAll(recovery[0], "-", " "))
+	if rr := secondStep(t, app, pending, typed); rr.Code != http.StatusSeeOther {
+		t.Fatalf("Recovery code: got %v", rr.Code)
+	}
+	pending = cookieNamed(passwordLogin(t, app, "robin"), "two_factor")
+	if rr := secondStep(t, app, pending, recovery[0]); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Used recovery code: got %v", rr.Code)
+	}
+	tf, _ := app.Accounts.FetchTwoFactor(robinID)
+	if tf.RecoveryCodesLeft != auth.RecoveryCodeCount-1 {
+		t.Errorf("Recovery codes left: %d", tf.RecoveryCodesLeft)
+	}
+}
+
+func TestTwoFactorLockout(t *testing.T) {
+	db := setupTestDB(t).DB()
+	accounts := auth.NewStore(db, "")
+	addLoginUser(t, db, "robin")
+	secret, _ := enrollTwoFactor(t, db, userIDByName(t, db, "robin"))
+	user, err := accounts.Authenticate("robin", "Passw0rd!x")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	limiter, clock := testLimiter(auth.LockoutPolicy{Window: time.Hour, FreeAttempts: 3, BaseDelay: time.Minute, MaxDelay: time.Hour})
+	clock.now = time.Now()
+	if _, err := limiter.Authenticate(accounts, "robin", "Passw0rd!x", "192.0.2.1"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := limiter.SecondFactor(accounts, user, "192.0.2.1", "000000"); !errors.Is(err, auth.ErrInvalidCode) {
+			t.Fatalf("Attempt %d: got %v", i+1, err)
+		}
+	}
+	// A right password does not start the count over
+	if _, err := limiter.Authenticate(accounts, "robin", "Passw0rd!x", "192.0.2.1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := limiter.SecondFactor(accounts, user, "192.0.2.1", "000000"); !errors.Is(err, auth.ErrInvalidCode) {
+		t.Fatalf("Third attempt: got %v", err)
+	}
+	if _, _, ok := lockedFor(limiter.SecondFactor(accounts, user, "192.0.2.1", totp(t, secret, 0))); !ok {
+		t.Fatal("Expected a wait after three wrong codes")
+	}
+
+	// The limiter's clock also decides which code is current
+	clock.Advance(time.Minute)
+	if err := limiter.SecondFactor(accounts, user, "192.0.2.1", totp(t, secret, time.Minute)); err != nil {
+		t.Errorf("After the wait: %v", err)
+	}
+}
+
+func TestTwoFactorAPILogin(t *testing.T) {
+	app, store := setupTestApp(t)
+	db := store.DB()
+	addLoginUser(t, db, "robin")
+	secret, _ := enrollTwoFactor(t, db, userIDByName(t, db, "robin"))
+
+	status, _ := apiCall(t, app, http.MethodPost, "/api/v1/session", "", map[string]string{"username": "robin", "password": "Passw0rd!x"})
+	if status != http.StatusUnauthorized {
+		t.Errorf("Without a code: got %v", status)
+	}
+	status, _ = apiCall(t, app, http.MethodPost, "/api/v1/session", "", map[string]string{
+		"username": "robin", "password": "Passw0rd!x", "code": totp(t, secret, 0),
+	})
+	if status != http.StatusCreated {
+		t.Errorf("With a code: got %v", status)
+	}
+}
+
+func TestTwoFactorRequiredRole(t *testing.T) {
+	app, store := setupTestApp(t)
+	db := store.DB()
+	app.TwoFactorRole = model.RoleModerator
+	mw := middleware.New(app.Users, app.Sessions)
+	mw.TwoFactorRole = app.TwoFactorRole
+
+	adminID := promoteAdmin(t, store)
+	cookie := sessionCookie(t, store, adminID)
+	moderation := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/moderation", nil)
+		req.AddCookie(cookie)
+		rr := httptest.NewRecorder()
+		mw.RequireRole(model.RoleModerator, app.ModerationHandler)(rr, req)
+		return rr
+	}
+
+	rr := moderation()
+	if rr.Code != http.StatusSeeOther || !strings.HasPrefix(rr.Header().Get("Location"), "/settings") {
+		t.Fatalf("Before enrolling: got %v %q", rr.Code, rr.Header().Get("Location"))
+	}
+	enrollTwoFactor(t, db, adminID)
+	if rr := moderation(); rr.Code != http.StatusOK {
+		t.Errorf("After enrolling: got %v", rr.Code)
+	}
+
+	hashed, _ := auth.HashPassword("Passw0rd!x")
+	db.Exec("UPDATE users SET password_hash = ? WHERE id = ?", hashed, adminID)
+	rr = postSettings(t, app, app.DisableTwoFactorHandler, cookie, url.Values{"password": {"Passw0rd!x"}})
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Turning it off: got %v", rr.Code)
+	}
+
+	// Ordinary users are not affected
+	mama := sessionCookie(t, store, userIDByName(t, db, "Mama"))
+	req := httptest.NewRequest(http.MethodGet, "/settings", nil)
+	req.AddCookie(mama)
+	rr = httptest.NewRecorder()
+	mw.RequireRole(model.RoleUser, app.SettingsHandler)(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Errorf("Settings for a user: got %v", rr.Code)
+	}
+}